@@ -5,6 +5,7 @@ go 1.18
 require (
 	github.com/go-chi/chi v4.1.2+incompatible
 	github.com/google/wire v0.5.0
+	go.etcd.io/bbolt v1.3.7
 	openappsec.io/configuration v0.5.5
 	openappsec.io/ctxutils v0.5.0
 	openappsec.io/errors v0.7.0
@@ -33,12 +34,12 @@ require (
 	github.com/uber/jaeger-client-go v2.30.0+incompatible // indirect
 	github.com/uber/jaeger-lib v2.4.1+incompatible // indirect
 	go.uber.org/atomic v1.9.0 // indirect
-	golang.org/x/sys v0.0.0-20220513210249-45d2b4557a2a // indirect
+	golang.org/x/sys v0.4.0 // indirect
 	golang.org/x/text v0.3.7 // indirect
 	golang.org/x/xerrors v0.0.0-20220411194840-2f41105eb62f // indirect
 	gopkg.in/ini.v1 v1.66.4 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
-	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 replace (
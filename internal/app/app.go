@@ -74,14 +74,16 @@ type App struct {
 	httpDriver RestAdapter
 	conf       Configuration
 	health     HealthService
+	fs         FileSystemDriven
 }
 
 // NewApp returns a new instance of the App.
-func NewApp(adapter RestAdapter, conf Configuration, healthSvc HealthService) *App {
+func NewApp(adapter RestAdapter, conf Configuration, healthSvc HealthService, fs FileSystemDriven) *App {
 	return &App{
 		httpDriver: adapter,
 		conf:       conf,
 		health:     healthSvc,
+		fs:         fs,
 	}
 }
 
@@ -134,6 +136,9 @@ func (a *App) Stop(ctx context.Context) error {
 	if err := a.httpDriver.Stop(ctx); err != nil {
 		errorsArr = append(errorsArr, errors.Wrap(err, "Failed to gracefully shutdown server"))
 	}
+	if err := a.fs.TearDown(ctx); err != nil {
+		errorsArr = append(errorsArr, errors.Wrap(err, "Failed to tear down file system"))
+	}
 	if len(errorsArr) == 0 {
 		return nil
 	}
@@ -143,7 +148,7 @@ func (a *App) Stop(ctx context.Context) error {
 func (a *App) healthInit() {
 	// add readiness checks for all external services that supposed to implement AddReadinessChecker
 	// for example: a.health.AddReadinessChecker(a.dbRepo)
-	return
+	a.health.AddReadinessChecker(a.fs)
 }
 
 func (a *App) loggerInit() error {
@@ -0,0 +1,80 @@
+package rest
+
+import (
+	"net/http"
+	"strings"
+
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+const (
+	userMetadataPrefix = "X-Amz-Meta-"
+	// maxUserMetadataSize caps the size of the user metadata of a file, names and values, as in S3
+	maxUserMetadataSize = 2 << 10
+	// defaultContentType is served for files stored without a content type, as they were before it was stored
+	defaultContentType = "application/json; charset=UTF-8"
+	// awsChunkedEncoding is the content coding of a request body sent in signed chunks
+	awsChunkedEncoding = "aws-chunked"
+)
+
+// objectAttributes parses the representation headers and the x-amz-meta-* headers a file is stored with
+func objectAttributes(r *http.Request) (models.ObjectAttributes, error) {
+	attributes := models.ObjectAttributes{
+		ContentType:     r.Header.Get("Content-Type"),
+		ContentEncoding: storedContentEncoding(r.Header.Values("Content-Encoding")),
+		CacheControl:    r.Header.Get("Cache-Control"),
+	}
+	size := 0
+	for header, values := range r.Header {
+		if !strings.HasPrefix(header, userMetadataPrefix) || len(header) == len(userMetadataPrefix) {
+			continue
+		}
+		if attributes.UserMetadata == nil {
+			attributes.UserMetadata = make(map[string]string)
+		}
+		name := strings.ToLower(header[len(userMetadataPrefix):])
+		value := strings.Join(values, ",")
+		attributes.UserMetadata[name] = value
+		size += len(name) + len(value)
+	}
+	if size > maxUserMetadataSize {
+		return models.ObjectAttributes{}, invalidArgumentError(
+			"user metadata of %v bytes exceeds the maximum of %v bytes", size, maxUserMetadataSize,
+		)
+	}
+	return attributes, nil
+}
+
+// storedContentEncoding returns the content codings of a request without aws-chunked, which frames the request
+// body in transit and isn't a coding of the stored content
+func storedContentEncoding(values []string) string {
+	var codings []string
+	for _, value := range values {
+		for _, coding := range strings.Split(value, ",") {
+			coding = strings.TrimSpace(coding)
+			if coding == "" || strings.EqualFold(coding, awsChunkedEncoding) {
+				continue
+			}
+			codings = append(codings, coding)
+		}
+	}
+	return strings.Join(codings, ",")
+}
+
+// setAttributeHeaders sets the response headers a file was stored with
+func setAttributeHeaders(w http.ResponseWriter, attributes models.ObjectAttributes) {
+	contentType := attributes.ContentType
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+	w.Header().Set("Content-Type", contentType)
+	if attributes.ContentEncoding != "" {
+		w.Header().Set("Content-Encoding", attributes.ContentEncoding)
+	}
+	if attributes.CacheControl != "" {
+		w.Header().Set("Cache-Control", attributes.CacheControl)
+	}
+	for name, value := range attributes.UserMetadata {
+		w.Header().Set(userMetadataPrefix+name, value)
+	}
+}
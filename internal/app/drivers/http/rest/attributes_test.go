@@ -0,0 +1,55 @@
+package rest
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAttributesRoundTrip(t *testing.T) {
+	a := newTestAdapter(t, nil)
+	const path = "/api/ag/remote/report.csv.gz"
+	w := serve(a.PutFile, http.MethodPut, path, "compressed by the client", http.Header{
+		"Content-Type":      {"text/csv"},
+		"Content-Encoding":  {"gzip, aws-chunked"},
+		"Cache-Control":     {"max-age=60"},
+		"X-Amz-Meta-Owner":  {"agent"},
+		"X-Amz-Meta-Labels": {"a", "b"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT = %v, body: %s", w.Code, w.Body.String())
+	}
+	want := map[string]string{
+		"Content-Type":      "text/csv",
+		"Content-Encoding":  "gzip",
+		"Cache-Control":     "max-age=60",
+		"X-Amz-Meta-Owner":  "agent",
+		"X-Amz-Meta-Labels": "a,b",
+	}
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		handler := a.GetFile
+		if method == http.MethodHead {
+			handler = a.HeadFile
+		}
+		w := serve(handler, method, path, "", nil)
+		for header, value := range want {
+			if got := w.Header().Get(header); got != value {
+				t.Fatalf("%v has the %v header %q, want %q", method, header, got, value)
+			}
+		}
+	}
+
+	// a file stored again without attributes loses them
+	serve(a.PutFile, http.MethodPut, path, "replaced", nil)
+	w = serve(a.HeadFile, http.MethodHead, path, "", nil)
+	if w.Header().Get("Content-Type") != defaultContentType || w.Header().Get("Content-Encoding") != "" ||
+		w.Header().Get("Cache-Control") != "" || w.Header().Get("X-Amz-Meta-Owner") != "" {
+		t.Fatalf("HEAD after a PUT without attributes has the headers %v", w.Header())
+	}
+
+	w = serve(a.PutFile, http.MethodPut, "/api/ag/remote/large-metadata", "content",
+		withXMLErrors(http.Header{"X-Amz-Meta-Note": {strings.Repeat("n", maxUserMetadataSize)}}))
+	if w.Code != http.StatusBadRequest || errorCode(t, w) != "InvalidArgument" {
+		t.Fatalf("PUT with too large user metadata = %v %s, want 400 InvalidArgument", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,67 @@
+package rest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"openappsec.io/errors"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/sigv4"
+)
+
+const (
+	contentMD5Header     = "Content-MD5"
+	checksumSHA256Header = "x-amz-checksum-sha256"
+	// contentSHA256Header is sent by SigV4 clients, holding the hex encoded SHA-256 digest of the payload
+	// or a placeholder when the payload isn't signed
+	contentSHA256Header = "x-amz-content-sha256"
+	// streamingPayloadPrefix starts the x-amz-content-sha256 placeholders of aws-chunked bodies, whose chunks are
+	// framed with their sizes, signatures and trailing checksums
+	streamingPayloadPrefix = "STREAMING-"
+)
+
+// contentDigests parses the digests the content of a write is verified with into options.
+// when several SHA-256 digests are sent they must agree, otherwise the content can't match them all.
+func contentDigests(r *http.Request, options *models.PutOptions) error {
+	if value := r.Header.Get(contentMD5Header); value != "" {
+		digest, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(digest) != md5.Size {
+			return invalidDigestError(contentMD5Header, value)
+		}
+		options.ContentMD5 = digest
+	}
+	if value := r.Header.Get(checksumSHA256Header); value != "" {
+		digest, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(digest) != sha256.Size {
+			return invalidDigestError(checksumSHA256Header, value)
+		}
+		options.ChecksumSHA256 = digest
+	}
+	value := r.Header.Get(contentSHA256Header)
+	if strings.HasPrefix(value, streamingPayloadPrefix) {
+		// the body would be stored with the chunk signatures framing it, whether the request is authenticated or not
+		return invalidArgumentError("streaming payload %v is not supported", value)
+	}
+	if value != "" && value != sigv4.UnsignedPayload {
+		digest, err := hex.DecodeString(value)
+		if err != nil || len(digest) != sha256.Size {
+			return invalidDigestError(contentSHA256Header, value)
+		}
+		if options.ChecksumSHA256 != nil && !bytes.Equal(options.ChecksumSHA256, digest) {
+			return errors.Errorf("conflicting SHA-256 digests %v and %v", checksumSHA256Header, contentSHA256Header).
+				SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelBadDigest)
+		}
+		options.ChecksumSHA256 = digest
+	}
+	return nil
+}
+
+func invalidDigestError(header string, value string) error {
+	return errors.Errorf("invalid %v %q", header, value).
+		SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidArgument)
+}
@@ -0,0 +1,76 @@
+package rest
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"testing"
+
+	"openappsec.io/smartsync-shared-files/internal/pkg/sigv4"
+)
+
+func TestContentDigests(t *testing.T) {
+	a := newTestAdapter(t, nil)
+	const content = "verified content"
+	md5Sum := md5.Sum([]byte(content))
+	sha256Sum := sha256.Sum256([]byte(content))
+	otherMD5 := md5.Sum([]byte("other content"))
+	otherSHA256 := sha256.Sum256([]byte("other content"))
+	checksum := base64.StdEncoding.EncodeToString(sha256Sum[:])
+
+	tests := []struct {
+		name   string
+		header http.Header
+		// code is the S3 error code the put is rejected with, empty when it is stored
+		code string
+	}{
+		{name: "no digest"},
+		{name: "content md5", header: http.Header{contentMD5Header: {base64.StdEncoding.EncodeToString(md5Sum[:])}}},
+		{name: "content md5 mismatch", header: http.Header{contentMD5Header: {base64.StdEncoding.EncodeToString(otherMD5[:])}}, code: "BadDigest"},
+		{name: "invalid content md5", header: http.Header{contentMD5Header: {"not base64"}}, code: "InvalidArgument"},
+		{name: "checksum", header: http.Header{checksumSHA256Header: {checksum}}},
+		{name: "checksum mismatch", header: http.Header{checksumSHA256Header: {base64.StdEncoding.EncodeToString(otherSHA256[:])}}, code: "BadDigest"},
+		{name: "payload digest", header: http.Header{contentSHA256Header: {hex.EncodeToString(sha256Sum[:])}}},
+		{name: "payload digest mismatch", header: http.Header{contentSHA256Header: {hex.EncodeToString(otherSHA256[:])}}, code: "BadDigest"},
+		{
+			name: "conflicting checksum and payload digest",
+			header: http.Header{
+				checksumSHA256Header: {checksum},
+				contentSHA256Header:  {hex.EncodeToString(otherSHA256[:])},
+			},
+			code: "BadDigest",
+		},
+		{name: "unsigned payload", header: http.Header{contentSHA256Header: {sigv4.UnsignedPayload}}},
+		{name: "streaming payload", header: http.Header{contentSHA256Header: {"STREAMING-UNSIGNED-PAYLOAD-TRAILER"}}, code: "InvalidArgument"},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := "/api/ag/remote/file" + string(rune('a'+i))
+			w := serve(a.PutFile, http.MethodPut, path, content, withXMLErrors(test.header))
+			if test.code != "" {
+				if w.Code != http.StatusBadRequest || errorCode(t, w) != test.code {
+					t.Fatalf("PUT = %v %q, want 400 %v", w.Code, w.Body.String(), test.code)
+				}
+				if w := serve(a.HeadFile, http.MethodHead, path, "", nil); w.Code != http.StatusNotFound {
+					t.Fatalf("HEAD after a rejected PUT = %v, want 404", w.Code)
+				}
+				return
+			}
+			if w.Code != http.StatusOK || w.Header().Get(checksumSHA256Header) != checksum {
+				t.Fatalf("PUT = %v with checksum %q, want 200 with %v", w.Code, w.Header().Get(checksumSHA256Header), checksum)
+			}
+			// the checksum of the stored content is returned with it
+			for _, method := range []string{http.MethodGet, http.MethodHead} {
+				handler := a.GetFile
+				if method == http.MethodHead {
+					handler = a.HeadFile
+				}
+				if w := serve(handler, method, path, "", nil); w.Header().Get(checksumSHA256Header) != checksum {
+					t.Fatalf("%v = %v with checksum %q, want %v", method, w.Code, w.Header().Get(checksumSHA256Header), checksum)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package rest
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"openappsec.io/errors"
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+// checkPreconditions evaluates the conditional request headers against the metadata of the file, in the order
+// defined in RFC 7232 section 6. it returns the status code answering the request instead of the file content,
+// or zero when the file should be served.
+func checkPreconditions(r *http.Request, metadata models.FileMetadata) int {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !models.ETagListMatches(ifMatch, metadata.ETag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if since, ok := headerTime(r, "If-Unmodified-Since"); ok && modifiedSince(metadata.LastModified, since) {
+		return http.StatusPreconditionFailed
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if models.ETagListMatches(ifNoneMatch, metadata.ETag, true) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if since, ok := headerTime(r, "If-Modified-Since"); ok && !modifiedSince(metadata.LastModified, since) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// headerTime parses an HTTP date header, ok is false when it is missing or malformed and should be ignored
+func headerTime(r *http.Request, header string) (time.Time, bool) {
+	value := r.Header.Get(header)
+	if value == "" {
+		return time.Time{}, false
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// modifiedSince compares the last modified time at the one second precision of HTTP dates
+func modifiedSince(lastModified time.Time, since time.Time) bool {
+	return lastModified.Truncate(time.Second).After(since)
+}
+
+// notModified answers a conditional request with the validators of the unchanged file and no body
+func notModified(w http.ResponseWriter, metadata models.FileMetadata) {
+	w.Header().Set("Last-Modified", metadata.LastModified.UTC().Format(http.TimeFormat))
+	w.Header().Set("ETag", metadata.ETag)
+	if metadata.Attributes.CacheControl != "" {
+		w.Header().Set("Cache-Control", metadata.Attributes.CacheControl)
+	}
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// writePreconditions parses the preconditions of a conditional write into options. only If-None-Match: * is
+// supported on writes, as in S3, any other entity tag list is rejected
+func writePreconditions(r *http.Request, options *models.PutOptions) error {
+	options.IfMatch = strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifNoneMatch := strings.TrimSpace(r.Header.Get("If-None-Match")); ifNoneMatch != "" {
+		if ifNoneMatch != "*" {
+			return errors.Errorf("unsupported If-None-Match %q on write", ifNoneMatch).
+				SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidArgument)
+		}
+		options.IfNoneMatch = true
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package rest
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestConditionalGet(t *testing.T) {
+	a := newTestAdapter(t, nil)
+	const path = "/api/ag/remote/file"
+	const content = "content"
+	serve(a.PutFile, http.MethodPut, path, content, http.Header{"Cache-Control": {"max-age=60"}})
+	head := serve(a.HeadFile, http.MethodHead, path, "", nil)
+	etag := head.Header().Get("ETag")
+	lastModified, err := http.ParseTime(head.Header().Get("Last-Modified"))
+	if err != nil {
+		t.Fatalf("invalid Last-Modified %q: %v", head.Header().Get("Last-Modified"), err)
+	}
+	before := lastModified.Add(-time.Hour).Format(http.TimeFormat)
+	after := lastModified.Add(time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		code   int
+	}{
+		{"if-match", http.Header{"If-Match": {etag}}, http.StatusOK},
+		{"if-match any", http.Header{"If-Match": {"*"}}, http.StatusOK},
+		{"if-match list", http.Header{"If-Match": {`"other", ` + etag}}, http.StatusOK},
+		{"if-match mismatch", http.Header{"If-Match": {`"other"`}}, http.StatusPreconditionFailed},
+		{"if-match weak", http.Header{"If-Match": {"W/" + etag}}, http.StatusPreconditionFailed},
+		{"if-none-match", http.Header{"If-None-Match": {etag}}, http.StatusNotModified},
+		{"if-none-match weak", http.Header{"If-None-Match": {"W/" + etag}}, http.StatusNotModified},
+		{"if-none-match any", http.Header{"If-None-Match": {"*"}}, http.StatusNotModified},
+		{"if-none-match mismatch", http.Header{"If-None-Match": {`"other"`}}, http.StatusOK},
+		{"if-modified-since earlier", http.Header{"If-Modified-Since": {before}}, http.StatusOK},
+		{"if-modified-since later", http.Header{"If-Modified-Since": {after}}, http.StatusNotModified},
+		{"if-modified-since last modified", http.Header{"If-Modified-Since": {lastModified.Format(http.TimeFormat)}}, http.StatusNotModified},
+		{"if-modified-since malformed", http.Header{"If-Modified-Since": {"yesterday"}}, http.StatusOK},
+		{"if-unmodified-since earlier", http.Header{"If-Unmodified-Since": {before}}, http.StatusPreconditionFailed},
+		{"if-unmodified-since later", http.Header{"If-Unmodified-Since": {after}}, http.StatusOK},
+		// If-Match overrides If-Unmodified-Since, and If-None-Match overrides If-Modified-Since
+		{"if-match over if-unmodified-since", http.Header{"If-Match": {etag}, "If-Unmodified-Since": {before}}, http.StatusOK},
+		{"if-none-match over if-modified-since", http.Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {after}}, http.StatusOK},
+		// a failed If-Match is answered before If-None-Match
+		{"if-match before if-none-match", http.Header{"If-Match": {`"other"`}, "If-None-Match": {etag}}, http.StatusPreconditionFailed},
+		// preconditions are evaluated before the range
+		{"range with if-match mismatch", http.Header{"Range": {"bytes=0-1"}, "If-Match": {`"other"`}}, http.StatusPreconditionFailed},
+		{"range with if-none-match", http.Header{"Range": {"bytes=0-1"}, "If-None-Match": {etag}}, http.StatusNotModified},
+		{"unsatisfiable range with if-match mismatch", http.Header{"Range": {"bytes=100-"}, "If-Match": {`"other"`}}, http.StatusPreconditionFailed},
+		{"range with if-match", http.Header{"Range": {"bytes=0-1"}, "If-Match": {etag}}, http.StatusPartialContent},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, method := range []string{http.MethodGet, http.MethodHead} {
+				handler := a.GetFile
+				if method == http.MethodHead {
+					handler = a.HeadFile
+				}
+				w := serve(handler, method, path, "", withXMLErrors(test.header))
+				code := test.code
+				if method == http.MethodHead && code == http.StatusPartialContent {
+					// HEAD ignores the range
+					code = http.StatusOK
+				}
+				if w.Code != code {
+					t.Fatalf("%v = %v %s, want %v", method, w.Code, w.Body.String(), code)
+				}
+				switch {
+				case code == http.StatusNotModified:
+					if w.Body.Len() != 0 || w.Header().Get("ETag") != etag || w.Header().Get("Last-Modified") == "" ||
+						w.Header().Get("Cache-Control") != "max-age=60" {
+						t.Fatalf("%v 304 = %q with the headers %v", method, w.Body.String(), w.Header())
+					}
+				case code == http.StatusPreconditionFailed && method == http.MethodGet:
+					if errorCode(t, w) != "PreconditionFailed" {
+						t.Fatalf("GET 412 = %s, want PreconditionFailed", w.Body.String())
+					}
+				case code == http.StatusOK && method == http.MethodGet:
+					if w.Body.String() != content {
+						t.Fatalf("GET 200 = %q, want the content", w.Body.String())
+					}
+				}
+			}
+		})
+	}
+
+	w := serve(a.GetFile, http.MethodGet, "/api/ag/remote/missing", "", withXMLErrors(http.Header{"If-None-Match": {"*"}}))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("conditional GET of a missing file = %v, want 404", w.Code)
+	}
+}
+
+func TestConditionalPut(t *testing.T) {
+	a := newTestAdapter(t, nil)
+	const path = "/api/ag/remote/file"
+	content := func() string { return serve(a.GetFile, http.MethodGet, path, "", nil).Body.String() }
+
+	if w := serve(a.PutFile, http.MethodPut, path, "first", http.Header{"If-Match": {`"any"`}}); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("PUT If-Match of a missing file = %v, want 412", w.Code)
+	}
+	if w := serve(a.PutFile, http.MethodPut, path, "first", http.Header{"If-None-Match": {"*"}}); w.Code != http.StatusOK {
+		t.Fatalf("PUT If-None-Match: * of a missing file = %v %s, want 200", w.Code, w.Body.String())
+	}
+	etag := serve(a.HeadFile, http.MethodHead, path, "", nil).Header().Get("ETag")
+
+	tests := []struct {
+		name   string
+		header http.Header
+		code   int
+		errors string
+	}{
+		{"if-none-match any of an existing file", http.Header{"If-None-Match": {"*"}}, http.StatusPreconditionFailed, "PreconditionFailed"},
+		{"if-match mismatch", http.Header{"If-Match": {`"other"`}}, http.StatusPreconditionFailed, "PreconditionFailed"},
+		{"if-none-match entity tag", http.Header{"If-None-Match": {etag}}, http.StatusBadRequest, "InvalidArgument"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := serve(a.PutFile, http.MethodPut, path, "rejected", withXMLErrors(test.header))
+			if w.Code != test.code || errorCode(t, w) != test.errors {
+				t.Fatalf("PUT = %v %s, want %v %v", w.Code, w.Body.String(), test.code, test.errors)
+			}
+			if got := content(); got != "first" {
+				t.Fatalf("a rejected PUT replaced the content with %q", got)
+			}
+		})
+	}
+
+	w := serve(a.PutFile, http.MethodPut, path, "second", http.Header{"If-Match": {`"other", ` + etag}})
+	if w.Code != http.StatusOK || content() != "second" {
+		t.Fatalf("PUT If-Match of the current entity tag = %v, content %q", w.Code, content())
+	}
+	// the entity tag changed with the content
+	if w := serve(a.PutFile, http.MethodPut, path, "third", http.Header{"If-Match": {etag}}); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("PUT If-Match of a replaced entity tag = %v, want 412", w.Code)
+	}
+}
+
+func TestConditionalPutRace(t *testing.T) {
+	a := newTestAdapter(t, nil)
+	const writers = 10
+	codes := make(chan int, writers)
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			codes <- serve(a.PutFile, http.MethodPut, "/api/ag/remote/file", strconv.Itoa(i), http.Header{"If-None-Match": {"*"}}).Code
+		}(i)
+	}
+	created := 0
+	for i := 0; i < writers; i++ {
+		switch code := <-codes; code {
+		case http.StatusOK:
+			created++
+		case http.StatusPreconditionFailed:
+		default:
+			t.Fatalf("concurrent PUT = %v", code)
+		}
+	}
+	if created != 1 {
+		t.Fatalf("%v concurrent writes created the file, want exactly one", created)
+	}
+}
+
+func TestConditionalCompleteMultipartUpload(t *testing.T) {
+	a := newTestAdapter(t, nil)
+	const path = "/api/ag/remote/file"
+	upload := func(header http.Header) *httptest.ResponseRecorder {
+		w := serve(a.PostFile, http.MethodPost, path+"?uploads", "", nil)
+		var initiated initiateMultipartUploadResult
+		if err := xml.Unmarshal(w.Body.Bytes(), &initiated); err != nil {
+			t.Fatalf("failed to create an upload, %v %s: %v", w.Code, w.Body.String(), err)
+		}
+		query := "?uploadId=" + url.QueryEscape(initiated.UploadID)
+		w = serve(a.PutFile, http.MethodPut, path+query+"&partNumber=1", "part", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("failed to upload a part, %v %s", w.Code, w.Body.String())
+		}
+		body, _ := xml.Marshal(completeMultipartUpload{Parts: []completedPart{{PartNumber: 1, ETag: w.Header().Get("ETag")}}})
+		return serve(a.PostFile, http.MethodPost, path+query, string(body), withXMLErrors(header))
+	}
+
+	if w := upload(http.Header{"If-None-Match": {"*"}}); w.Code != http.StatusOK {
+		t.Fatalf("completion with If-None-Match: * of a missing file = %v %s", w.Code, w.Body.String())
+	}
+	etag := serve(a.HeadFile, http.MethodHead, path, "", nil).Header().Get("ETag")
+	if w := upload(http.Header{"If-None-Match": {"*"}}); w.Code != http.StatusPreconditionFailed || errorCode(t, w) != "PreconditionFailed" {
+		t.Fatalf("completion with If-None-Match: * of an existing file = %v %s, want 412", w.Code, w.Body.String())
+	}
+	if w := upload(http.Header{"If-Match": {`"other"`}}); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("completion with a mismatched If-Match = %v %s, want 412", w.Code, w.Body.String())
+	}
+	if w := upload(http.Header{"If-Match": {etag}}); w.Code != http.StatusOK {
+		t.Fatalf("completion with the current If-Match = %v %s, want 200", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,101 @@
+package rest
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"openappsec.io/errors"
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+const (
+	copySourceHeader        = "x-amz-copy-source"
+	metadataDirectiveHeader = "x-amz-metadata-directive"
+	// copySourceVersionIDHeader reports the version of the source which was copied
+	copySourceVersionIDHeader = "x-amz-copy-source-version-id"
+	// sourceBucket is the bucket of the copy source, to S3 clients the /api path prefix is the bucket
+	sourceBucket = "api/"
+)
+
+type copyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	LastModified string
+	ETag         string
+}
+
+// copySource parses the x-amz-copy-source header, the url encoded bucket and key of the source optionally
+// followed by a version id, into the path of the source file and the version to copy
+func copySource(header string) (string, string, error) {
+	source, versionID, _ := strings.Cut(header, "?versionId=")
+	source, err := url.PathUnescape(source)
+	if err != nil || strings.Contains(versionID, "&") {
+		return "", "", invalidArgumentError("invalid %v %q", copySourceHeader, header)
+	}
+	source = strings.TrimPrefix(source, "/")
+	if !strings.HasPrefix(source, sourceBucket) || len(source) == len(sourceBucket) {
+		return "", "", invalidArgumentError("invalid %v %q, expecting %v<key>", copySourceHeader, header, sourceBucket)
+	}
+	return source[len(sourceBucket):], versionID, nil
+}
+
+// copyOptions parses the x-amz-metadata-directive header, and the attributes of the copy when they are replaced
+func copyOptions(r *http.Request) (models.CopyOptions, error) {
+	switch directive := r.Header.Get(metadataDirectiveHeader); directive {
+	case "", "COPY":
+		return models.CopyOptions{}, nil
+	case "REPLACE":
+		attributes, err := objectAttributes(r)
+		if err != nil {
+			return models.CopyOptions{}, err
+		}
+		return models.CopyOptions{ReplaceMetadata: true, Attributes: attributes}, nil
+	default:
+		return models.CopyOptions{}, invalidArgumentError("invalid %v %q", metadataDirectiveHeader, directive)
+	}
+}
+
+// CopyFile copies the file given in the x-amz-copy-source header to the path in uri, as in the S3 CopyObject.
+// the content is copied on the server side, without going through the client
+func (a *Adapter) CopyFile(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	path := strings.TrimPrefix(r.URL.Path, "/api/")
+	srcPath, srcVersionID, err := copySource(r.Header.Get(copySourceHeader))
+	var options models.CopyOptions
+	if err == nil {
+		options, err = copyOptions(r)
+		options.SourceVersionID = srcVersionID
+	}
+	if err == nil && srcPath == path && srcVersionID == "" && !options.ReplaceMetadata {
+		err = invalidArgumentError("copying file %v to itself without replacing its metadata", path)
+	}
+	if err != nil {
+		log.WithContextAndEventID(ctx, "0c861d9e-e19f-48e7-a6ff-d4fe09a03b1c").Warnf("invalid copy file request. err: %v", err)
+		errorReturn(w, r, err)
+		return
+	}
+	log.WithContextAndEventID(ctx, "eb0b1466-3cc0-4642-a5dd-e56ce623bd05").Infof("copy file: %v to %v", srcPath, path)
+	metadata, err := a.svc.CopyFile(ctx, srcPath, path, options)
+	if err != nil {
+		if errors.IsClass(err, errors.ClassNotFound) {
+			log.WithContextAndEventID(ctx, "eb17f2c8-d8d7-4e1a-9fd4-a06216cb154c").Infof("file %v not found", srcPath)
+			errorReturn(w, r, err)
+			return
+		}
+		log.WithContextAndEventID(ctx, "4187e118-656f-4212-a7c6-ac774c2762f1").Errorf("failed to copy file. err: %v", err)
+		errorReturn(w, r, err)
+		return
+	}
+	log.WithContextAndEventID(ctx, "1075eded-89c1-43e1-8399-e72dbb4d04a5").Infof("copy file %v to %v success", srcPath, path)
+	if srcVersionID != "" {
+		w.Header().Set(copySourceVersionIDHeader, srcVersionID)
+	}
+	setVersionHeader(w, metadata)
+	xmlReturn(w, r, http.StatusOK, copyObjectResult{
+		LastModified: metadata.LastModified.UTC().Format(time.RFC3339),
+		ETag:         metadata.ETag,
+	})
+}
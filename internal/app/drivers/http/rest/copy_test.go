@@ -0,0 +1,142 @@
+package rest
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"openappsec.io/smartsync-shared-files/internal/pkg/testutil"
+)
+
+// copyFile copies source to the destination path with the headers, and returns the response
+func copyFile(a *Adapter, source string, path string, header http.Header) *httptest.ResponseRecorder {
+	copyHeader := withXMLErrors(header)
+	copyHeader.Set(copySourceHeader, source)
+	return serve(a.PutFile, http.MethodPut, path, "", copyHeader)
+}
+
+func TestCopyFile(t *testing.T) {
+	a := newTestAdapter(t, nil)
+	const content = "copied content"
+	w := serve(a.PutFile, http.MethodPut, "/api/ag/remote/source%20file", content, http.Header{
+		"Content-Type":      {"text/plain"},
+		"X-Amz-Meta-Origin": {"agent"},
+	})
+	etag := w.Header().Get("ETag")
+
+	tests := []struct {
+		name   string
+		source string
+		path   string
+		header http.Header
+		status int
+		// contentType and origin are the attributes the copy has
+		contentType string
+		origin      string
+		code        string
+	}{
+		{
+			name:        "copy",
+			source:      "/api/ag/remote/source%20file",
+			path:        "/api/ag/remote/copy",
+			status:      http.StatusOK,
+			contentType: "text/plain",
+			origin:      "agent",
+		},
+		{
+			name:        "copy directive ignores the request attributes",
+			source:      "api/ag/remote/source%20file",
+			path:        "/api/ag/remote/copied",
+			header:      http.Header{metadataDirectiveHeader: {"COPY"}, "Content-Type": {"text/csv"}, "X-Amz-Meta-Origin": {"copy"}},
+			status:      http.StatusOK,
+			contentType: "text/plain",
+			origin:      "agent",
+		},
+		{
+			name:        "replace directive",
+			source:      "/api/ag/remote/source%20file",
+			path:        "/api/ag/remote/replaced",
+			header:      http.Header{metadataDirectiveHeader: {"REPLACE"}, "Content-Type": {"text/csv"}, "X-Amz-Meta-Origin": {"copy"}},
+			status:      http.StatusOK,
+			contentType: "text/csv",
+			origin:      "copy",
+		},
+		{
+			name:   "invalid directive",
+			source: "/api/ag/remote/source%20file",
+			path:   "/api/ag/remote/invalid",
+			header: http.Header{metadataDirectiveHeader: {"MERGE"}},
+			status: http.StatusBadRequest,
+			code:   "InvalidArgument",
+		},
+		{name: "missing source", source: "/api/ag/remote/missing", path: "/api/ag/remote/copy", status: http.StatusNotFound, code: "NoSuchKey"},
+		{name: "source in another bucket", source: "/bucket/ag/remote/source%20file", path: "/api/ag/remote/copy", status: http.StatusBadRequest, code: "InvalidArgument"},
+		{name: "to itself", source: "/api/ag/remote/source%20file", path: "/api/ag/remote/source%20file", status: http.StatusBadRequest, code: "InvalidArgument"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := copyFile(a, test.source, test.path, test.header)
+			if w.Code != test.status {
+				t.Fatalf("copy = %v, want %v", w.Code, test.status)
+			}
+			if test.code != "" {
+				if code := errorCode(t, w); code != test.code {
+					t.Fatalf("copy failed with %v, want %v", code, test.code)
+				}
+				return
+			}
+			var result copyObjectResult
+			if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil || result.ETag != etag {
+				t.Fatalf("copy result %s, err: %v, want the entity tag %v", w.Body.String(), err, etag)
+			}
+			w = serve(a.GetFile, http.MethodGet, test.path, "", nil)
+			if w.Body.String() != content || w.Header().Get("ETag") != etag {
+				t.Fatalf("copy has the content %q and entity tag %v, want the ones of the source", w.Body.String(), w.Header().Get("ETag"))
+			}
+			if w.Header().Get("Content-Type") != test.contentType || w.Header().Get("X-Amz-Meta-Origin") != test.origin {
+				t.Fatalf("copy has the headers %v, want the content type %v and origin %v", w.Header(), test.contentType, test.origin)
+			}
+		})
+	}
+
+	// a file is copied to itself to replace its attributes
+	w = copyFile(a, "/api/ag/remote/source%20file", "/api/ag/remote/source%20file",
+		http.Header{metadataDirectiveHeader: {"REPLACE"}, "Content-Type": {"application/xml"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("copy to itself = %v, want 200", w.Code)
+	}
+	w = serve(a.HeadFile, http.MethodHead, "/api/ag/remote/source%20file", "", nil)
+	if w.Header().Get("Content-Type") != "application/xml" || w.Header().Get("ETag") != etag {
+		t.Fatalf("file copied to itself has the headers %v", w.Header())
+	}
+}
+
+func TestCopyReclassifies(t *testing.T) {
+	a := newTestAdapter(t, testutil.Configuration{
+		"filesystem_db.ttl":            300 * time.Millisecond,
+		"filesystem_db.sweep_interval": 10 * time.Millisecond,
+	})
+	serve(a.PutFile, http.MethodPut, "/api/ag/tmp/source", "temp", nil)
+	serve(a.PutFile, http.MethodPut, "/api/ag/remote/persistent", "persistent", nil)
+	if w := copyFile(a, "/api/ag/tmp/source", "/api/ag/processed/source", nil); w.Code != http.StatusOK {
+		t.Fatalf("copy of a temp file = %v", w.Code)
+	}
+	if w := copyFile(a, "/api/ag/remote/persistent", "/api/ag/tmp/persistent", nil); w.Code != http.StatusOK {
+		t.Fatalf("copy of a persistent file = %v", w.Code)
+	}
+
+	exists := func(path string) bool {
+		return serve(a.HeadFile, http.MethodHead, path, "", nil).Code == http.StatusOK
+	}
+	eventually(t, "the expiration of the temp files", func() bool {
+		return !exists("/api/ag/tmp/source") && !exists("/api/ag/tmp/persistent")
+	})
+	if !exists("/api/ag/processed/source") {
+		t.Fatalf("the copy of a temp file to /processed/ expired along with its source")
+	}
+	if !exists("/api/ag/remote/persistent") {
+		t.Fatalf("a persistent file expired after it was copied to a temp file")
+	}
+}
@@ -0,0 +1,235 @@
+package rest
+
+import (
+	"context"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"openappsec.io/errors"
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+const (
+	// serverIOTimeoutConfKey bounds each read of a request body and each write of a response, the server timeout
+	// is used when it isn't set
+	serverIOTimeoutConfKey = serverConfBaseKey + ".io_timeout"
+	// serverMaxObjectSizeConfKey caps the size of a request body, the content of a file included
+	serverMaxObjectSizeConfKey = serverConfBaseKey + ".max_object_size"
+
+	// defaultMaxObjectSize is the maximum size of an object uploaded in a single PUT, as in S3
+	defaultMaxObjectSize = 5 << 30
+	// sendChunkSize is the most a connection sends from a reader under a single write deadline, as much as
+	// io.Copy writes at once
+	sendChunkSize = 32 << 10
+)
+
+// connKey is the context key of the connection a request was received on
+type connKey struct{}
+
+// withConn stores the connection of a request in its context, so the request body reads can be bound
+func withConn(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, conn)
+}
+
+// deadlineServer is an http server whose connection writes time out when they make no progress for ioTimeout,
+// so a client which stops reading a response doesn't hold it forever
+type deadlineServer struct {
+	*http.Server
+	ioTimeout time.Duration
+}
+
+// ListenAndServe listens on the server address and serves the connections, with their writes bound
+func (s *deadlineServer) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(deadlineListener{Listener: l, timeout: s.ioTimeout})
+}
+
+// deadlineListener accepts connections whose writes time out
+type deadlineListener struct {
+	net.Listener
+	timeout time.Duration
+}
+
+func (l deadlineListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return deadlineConn{Conn: conn, timeout: l.timeout}, nil
+}
+
+// deadlineConn sets a write deadline before every write. the server never waits on a write, unlike a read,
+// a keep-alive connection waits for the next request and a streamed body is read by the handler at its own pace
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c deadlineConn) Write(p []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
+
+// ReadFrom keeps the fast path the http server takes for a response body streamed from a reader: a *net.TCPConn
+// sends a file with sendfile. the reader is sent in chunks, each under its own write deadline, and sendfile only
+// looks through a single io.LimitedReader for the file, so a limited reader is unwrapped into the chunks
+func (c deadlineConn) ReadFrom(r io.Reader) (int64, error) {
+	rf, ok := c.Conn.(io.ReaderFrom)
+	if !ok {
+		// hides ReadFrom, so io.Copy writes to the connection
+		return io.Copy(struct{ io.Writer }{c}, r)
+	}
+	limit := int64(math.MaxInt64)
+	lr, limited := r.(*io.LimitedReader)
+	if limited {
+		r, limit = lr.R, lr.N
+	}
+	var written int64
+	for written < limit {
+		chunk := &io.LimitedReader{R: r, N: sendChunkSize}
+		if limit-written < chunk.N {
+			chunk.N = limit - written
+		}
+		size := chunk.N
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+			return written, err
+		}
+		n, err := rf.ReadFrom(chunk)
+		written += n
+		if limited {
+			lr.N -= n
+		}
+		// a chunk sent short without an error is the end of the reader
+		if err != nil || n < size {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// WriteTo keeps the fast path of the connection reads, when the connection has one
+func (c deadlineConn) WriteTo(w io.Writer) (int64, error) {
+	if wt, ok := c.Conn.(io.WriterTo); ok {
+		return wt.WriteTo(w)
+	}
+	// hides WriteTo, so io.Copy reads from the connection
+	return io.Copy(w, struct{ io.Reader }{c})
+}
+
+// boundRequestBody is a middleware bounding the request body: each read must make progress within the io
+// timeout, and the body must not exceed the maximum object size. the whole request isn't bound, a large file
+// takes as long as it needs as long as it keeps moving
+func (a *Adapter) boundRequestBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > a.maxObjectSize {
+			w.Header().Set("Connection", "close")
+			errorReturn(w, r, entityTooLargeError(a.maxObjectSize))
+			return
+		}
+		conn, _ := r.Context().Value(connKey{}).(net.Conn)
+		body := &boundBody{
+			ReadCloser: r.Body,
+			header:     w.Header(),
+			conn:       conn,
+			timeout:    a.ioTimeout,
+			max:        a.maxObjectSize,
+			remaining:  a.maxObjectSize,
+		}
+		r.Body = body
+		// closing the body reads what the handler left of it, closed here it is bound as well
+		defer body.Close()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// boundBody is a request body whose reads time out and whose size is capped. once it fails the connection is
+// closed after the response, otherwise the server would wait for the rest of the body before responding
+type boundBody struct {
+	io.ReadCloser
+	header    http.Header
+	conn      net.Conn
+	timeout   time.Duration
+	max       int64
+	remaining int64
+	failed    bool
+	// ended is set once the body returned an error or its end, it doesn't read the connection anymore
+	ended bool
+}
+
+func (b *boundBody) Read(p []byte) (int, error) {
+	// one more byte than allowed is read, to tell a body of exactly the maximum size from a larger one
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	if b.conn != nil && !b.ended {
+		// the deadline only bounds this read. once the body is read to its end, the server reads the connection
+		// in the background to notice the client going away, and a deadline left behind would cut that read and
+		// cancel the request
+		if err := b.conn.SetReadDeadline(time.Now().Add(b.timeout)); err != nil {
+			return 0, err
+		}
+		defer b.conn.SetReadDeadline(time.Time{})
+	}
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.ended = true
+	}
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.remaining = 0
+		b.fail()
+		return n, entityTooLargeError(b.max)
+	}
+	b.remaining -= int64(n)
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		b.fail()
+		return n, errors.Wrapf(err, "request body wasn't sent within %v", b.timeout).
+			SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelRequestTimeout)
+	}
+	return n, err
+}
+
+// fail closes the connection after the response, the rest of the body is never read
+func (b *boundBody) fail() {
+	b.failed = true
+	b.ended = true
+	b.header.Set("Connection", "close")
+}
+
+// Close reads the rest of the body, so the connection can be reused, unless the body failed.
+// the server closes the body after the handler as well, it is then closed already
+func (b *boundBody) Close() error {
+	if b.conn == nil || (b.ended && !b.failed) {
+		return b.ReadCloser.Close()
+	}
+	b.ended = true
+	// a failed body isn't read any further, the deadline in the past stops the read of its rest at once
+	deadline := time.Now().Add(b.timeout)
+	if b.failed {
+		deadline = time.Now()
+	}
+	if err := b.conn.SetReadDeadline(deadline); err != nil {
+		return err
+	}
+	defer b.conn.SetReadDeadline(time.Time{})
+	return b.ReadCloser.Close()
+}
+
+// entityTooLargeError returns the error of a request body larger than max
+func entityTooLargeError(max int64) error {
+	return errors.Errorf("request body exceeds the maximum object size of %v bytes", strconv.FormatInt(max, 10)).
+		SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelEntityTooLarge)
+}
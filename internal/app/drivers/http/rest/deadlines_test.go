@@ -0,0 +1,173 @@
+package rest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"openappsec.io/errors"
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+// sendingConn records the readers it is asked to send from, as a *net.TCPConn hands them to sendfile
+type sendingConn struct {
+	net.Conn
+	sent      bytes.Buffer
+	readers   []io.Reader
+	deadlines int
+}
+
+func (c *sendingConn) SetWriteDeadline(time.Time) error {
+	c.deadlines++
+	return nil
+}
+
+func (c *sendingConn) ReadFrom(r io.Reader) (int64, error) {
+	c.readers = append(c.readers, r)
+	return c.sent.ReadFrom(r)
+}
+
+// tempFile returns a file holding content, open for reading
+func tempFile(t *testing.T, content []byte) *os.File {
+	t.Helper()
+	path := t.TempDir() + "/content"
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatalf("failed to write %v: %v", path, err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open %v: %v", path, err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestDeadlineConnReadFrom(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 3*sendChunkSize/16)
+	tests := []struct {
+		name  string
+		limit int64
+	}{
+		{name: "whole file", limit: -1},
+		{name: "limited", limit: int64(len(content)) - 5},
+		{name: "limited within a chunk", limit: 100},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := tempFile(t, content)
+			conn := &sendingConn{}
+			want := content
+			var r io.Reader = f
+			lr := &io.LimitedReader{R: f, N: test.limit}
+			if test.limit >= 0 {
+				r, want = lr, content[:test.limit]
+			}
+			n, err := deadlineConn{Conn: conn, timeout: time.Second}.ReadFrom(r)
+			if err != nil || n != int64(len(want)) || !bytes.Equal(conn.sent.Bytes(), want) {
+				t.Fatalf("ReadFrom() = %v, %v and sent %v bytes, want %v", n, err, conn.sent.Len(), len(want))
+			}
+			if test.limit >= 0 && lr.N != 0 {
+				t.Fatalf("limited reader has %v bytes left, want 0", lr.N)
+			}
+			// every chunk is the file itself under a single limit, the reader sendfile takes, with its own deadline
+			for i, sent := range conn.readers {
+				chunk, ok := sent.(*io.LimitedReader)
+				if !ok || chunk.R != f {
+					t.Fatalf("chunk %v is sent from %T, want a limited reader of the file", i, sent)
+				}
+			}
+			if conn.deadlines != len(conn.readers) || len(conn.readers) < len(want)/sendChunkSize {
+				t.Fatalf("sent %v chunks under %v deadlines", len(conn.readers), conn.deadlines)
+			}
+		})
+	}
+}
+
+// startDeadlineServer serves handler with bound request bodies and connection writes, and returns its address
+func startDeadlineServer(t *testing.T, timeout time.Duration, handler http.HandlerFunc) string {
+	t.Helper()
+	a := &Adapter{ioTimeout: timeout, maxObjectSize: defaultMaxObjectSize}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	s := &deadlineServer{
+		Server:    &http.Server{Handler: a.boundRequestBody(handler), ConnContext: withConn},
+		ioTimeout: timeout,
+	}
+	go s.Serve(deadlineListener{Listener: l, timeout: timeout})
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+func TestDeadlineServerSendsFile(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	f := tempFile(t, content)
+	addr := startDeadlineServer(t, time.Second, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+		io.Copy(w, f)
+	})
+	resp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || !bytes.Equal(body, content) {
+		t.Fatalf("GET returned %v bytes, err: %v, want the %v bytes of the file", len(body), err, len(content))
+	}
+}
+
+func TestBoundBodyRead(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	addr := startDeadlineServer(t, timeout, func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			if !errors.IsLabel(err, models.ErrLabelRequestTimeout) {
+				t.Errorf("body read failed with %v, want a request timeout", err)
+			}
+			errorReturn(w, r, err)
+			return
+		}
+		// the read of the body set no deadline on the connection the server reads in the background meanwhile
+		time.Sleep(3 * timeout)
+		if err := r.Context().Err(); err != nil {
+			t.Errorf("request was canceled after its body was read: %v", err)
+		}
+		w.Write(body)
+	})
+
+	resp, err := http.Post("http://"+addr+"/", "text/plain", strings.NewReader("sent at once"))
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "sent at once" {
+		t.Fatalf("POST = %v %q, want 200 with the body", resp.StatusCode, body)
+	}
+
+	// a client which stops sending the body is answered once a read makes no progress for the timeout
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: %v\r\nContent-Length: 10\r\n\r\nhalf", addr)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("failed to read the response to a stalled body: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || !resp.Close {
+		t.Fatalf("stalled body = %v, closing %v, want 400 closing the connection", resp.StatusCode, resp.Close)
+	}
+}
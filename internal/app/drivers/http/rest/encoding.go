@@ -0,0 +1,57 @@
+package rest
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+const (
+	// storedSizeHeader reports the size of a file at rest, smaller than its Content-Length when stored compressed
+	storedSizeHeader = "X-Stored-Size"
+)
+
+// acceptEncodings parses the content codings the client accepts from the Accept-Encoding header,
+// the codings with a zero quality value are left out
+func acceptEncodings(r *http.Request) []string {
+	var encodings []string
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" || rejected(params) {
+				continue
+			}
+			encodings = append(encodings, name)
+		}
+	}
+	return encodings
+}
+
+// rejected returns true if the parameters of a content coding give it a zero quality value
+func rejected(params string) bool {
+	for _, param := range strings.Split(params, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		return err == nil && q == 0
+	}
+	return false
+}
+
+// encodedRepresentation returns the metadata of a file returned as stored, compressed with its stored encoding.
+// its entity tag tells it apart from the decompressed content, so preconditions and caches never mix them up
+func encodedRepresentation(metadata models.FileMetadata) models.FileMetadata {
+	metadata.ETag = models.EncodedETag(metadata.ETag, metadata.StoredEncoding)
+	return metadata
+}
+
+// setEncodingHeaders sets the response headers of a file returned as stored, compressed with its stored encoding
+func setEncodingHeaders(w http.ResponseWriter, metadata models.FileMetadata) {
+	w.Header().Set("Content-Encoding", metadata.StoredEncoding)
+	w.Header().Set("Content-Length", strconv.FormatInt(metadata.StoredSize, 10))
+}
@@ -0,0 +1,142 @@
+package rest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"openappsec.io/smartsync-shared-files/internal/pkg/testutil"
+)
+
+// newCompressingAdapter returns an adapter serving a filesystem backend which compresses the remote files
+func newCompressingAdapter(t *testing.T) *Adapter {
+	return newTestAdapter(t, testutil.Configuration{"filesystem_db.compression.prefixes": "*/remote/"})
+}
+
+func gunzip(t *testing.T, data []byte) string {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("response body isn't gzip compressed: %v", err)
+	}
+	content, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("failed to decompress response body: %v", err)
+	}
+	return string(content)
+}
+
+func TestCompressedRoundTrip(t *testing.T) {
+	a := newCompressingAdapter(t)
+	path := "/api/ag/remote/data.json"
+	content := strings.Repeat(`{"key": "value"} `, 256)
+	sum := md5.Sum([]byte(content))
+	etag := "\"" + hex.EncodeToString(sum[:]) + "\""
+	encodedETag := "\"" + hex.EncodeToString(sum[:]) + "-gzip\""
+	gzipAccepted := http.Header{"Accept-Encoding": {"gzip, br"}}
+
+	if w := serve(a.PutFile, http.MethodPut, path, content, nil); w.Code != http.StatusOK || w.Header().Get("ETag") != etag {
+		t.Fatalf("PUT = %v with entity tag %v, want 200 with %v", w.Code, w.Header().Get("ETag"), etag)
+	}
+
+	w := serve(a.GetFile, http.MethodGet, path, "", nil)
+	if w.Code != http.StatusOK || w.Body.String() != content || w.Header().Get("Content-Encoding") != "" ||
+		w.Header().Get("ETag") != etag {
+		t.Fatalf("GET without Accept-Encoding = %v, encoding %q, entity tag %v", w.Code,
+			w.Header().Get("Content-Encoding"), w.Header().Get("ETag"))
+	}
+
+	w = serve(a.GetFile, http.MethodGet, path, "", gzipAccepted)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("ETag") != encodedETag {
+		t.Fatalf("GET accepting gzip = %v, encoding %q, entity tag %v, want 200, gzip, %v", w.Code,
+			w.Header().Get("Content-Encoding"), w.Header().Get("ETag"), encodedETag)
+	}
+	if w.Header().Get("Content-Length") != w.Header().Get(storedSizeHeader) || w.Body.Len() >= len(content) ||
+		w.Header().Get("Vary") != "Accept-Encoding" || w.Header().Get(checksumSHA256Header) != "" {
+		t.Fatalf("GET accepting gzip has the headers %v and a body of %v bytes", w.Header(), w.Body.Len())
+	}
+	if decompressed := gunzip(t, w.Body.Bytes()); decompressed != content {
+		t.Fatalf("GET accepting gzip returned %q decompressed, want the content", decompressed)
+	}
+	if w := serve(a.HeadFile, http.MethodHead, path, "", gzipAccepted); w.Header().Get("ETag") != encodedETag {
+		t.Fatalf("HEAD accepting gzip has the entity tag %v, want %v", w.Header().Get("ETag"), encodedETag)
+	}
+
+	// a range is taken from the decompressed content
+	w = serve(a.GetFile, http.MethodGet, path, "", http.Header{"Accept-Encoding": {"gzip"}, "Range": {"bytes=17-33"}})
+	if w.Code != http.StatusPartialContent || w.Body.String() != content[17:34] || w.Header().Get("Content-Encoding") != "" ||
+		w.Header().Get("ETag") != etag {
+		t.Fatalf("GET of a range = %v, %q, encoding %q, entity tag %v", w.Code, w.Body.String(),
+			w.Header().Get("Content-Encoding"), w.Header().Get("ETag"))
+	}
+
+	// the preconditions are evaluated against the entity tag of the representation returned
+	preconditions := []struct {
+		name   string
+		header http.Header
+		status int
+	}{
+		{name: "compressed not modified", header: http.Header{"Accept-Encoding": {"gzip"}, "If-None-Match": {encodedETag}}, status: http.StatusNotModified},
+		{name: "compressed against the content tag", header: http.Header{"Accept-Encoding": {"gzip"}, "If-None-Match": {etag}}, status: http.StatusOK},
+		{name: "content not modified", header: http.Header{"If-None-Match": {etag}}, status: http.StatusNotModified},
+		{name: "content against the compressed tag", header: http.Header{"If-None-Match": {encodedETag}}, status: http.StatusOK},
+		{name: "compressed if match", header: http.Header{"Accept-Encoding": {"gzip"}, "If-Match": {encodedETag}}, status: http.StatusOK},
+		{name: "compressed if match the content tag", header: http.Header{"Accept-Encoding": {"gzip"}, "If-Match": {etag}}, status: http.StatusPreconditionFailed},
+	}
+	for _, test := range preconditions {
+		t.Run(test.name, func(t *testing.T) {
+			w := serve(a.GetFile, http.MethodGet, path, "", test.header)
+			if w.Code != test.status {
+				t.Fatalf("conditional GET = %v, want %v", w.Code, test.status)
+			}
+			if test.status == http.StatusNotModified && w.Header().Get("ETag") != test.header.Get("If-None-Match") {
+				t.Fatalf("304 has the entity tag %v, want %v", w.Header().Get("ETag"), test.header.Get("If-None-Match"))
+			}
+		})
+	}
+}
+
+func TestListingSizes(t *testing.T) {
+	a := newCompressingAdapter(t)
+	compressed := strings.Repeat(`{"key": "value"} `, 256)
+	plain := "stored as is"
+	serve(a.PutFile, http.MethodPut, "/api/ag/remote/data.json", compressed, nil)
+	serve(a.PutFile, http.MethodPut, "/api/ag/tmp/plain", plain, nil)
+	header := serve(a.HeadFile, http.MethodHead, "/api/ag/remote/data.json", "", nil).Header()
+	storedSize, err := strconv.ParseInt(header.Get(storedSizeHeader), 10, 64)
+	if err != nil || storedSize >= int64(len(compressed)) {
+		t.Fatalf("compressed file is stored in %q bytes, want less than %v", header.Get(storedSizeHeader), len(compressed))
+	}
+
+	w := serve(a.GetFilesList, http.MethodGet, "/api/?prefix=ag/", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET listing = %v, body: %s", w.Code, w.Body.String())
+	}
+	var list filesList
+	if err := xml.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("failed to parse listing %s: %v", w.Body.String(), err)
+	}
+	want := []contents{
+		{Key: "ag/remote/data.json", Size: int64(len(compressed)), StoredSize: storedSize},
+		{Key: "ag/tmp/plain", Size: int64(len(plain)), StoredSize: int64(len(plain))},
+	}
+	if len(list.Contents) != len(want) {
+		t.Fatalf("listing has the entries %+v, want %v", list.Contents, len(want))
+	}
+	for i, entry := range list.Contents {
+		if entry.Key != want[i].Key || entry.Size != want[i].Size || entry.StoredSize != want[i].StoredSize {
+			t.Fatalf("listing entry %+v, want the key %v, size %v and stored size %v", entry, want[i].Key,
+				want[i].Size, want[i].StoredSize)
+		}
+	}
+	if !strings.Contains(w.Body.String(), "<Size>"+strconv.Itoa(len(plain))+"</Size>") {
+		t.Fatalf("listing %s has no Size element", w.Body.String())
+	}
+}
@@ -0,0 +1,226 @@
+package rest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"strings"
+
+	"openappsec.io/ctxutils"
+	"openappsec.io/errors"
+	"openappsec.io/httputils/responses"
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/app/utils"
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+// errorFormat is the format of error response bodies
+type errorFormat string
+
+const (
+	// jsonErrors are errorloader JSON bodies, loaded from configs/error-responses.json
+	jsonErrors errorFormat = "json"
+	// xmlErrors are S3 XML error bodies, which S3 SDK clients decode into their error codes
+	xmlErrors errorFormat = "xml"
+
+	// errorFormatHeader lets a client choose the format of the error responses it gets
+	errorFormatHeader = "X-Error-Format"
+	requestIDHeader   = "x-amz-request-id"
+)
+
+// errorFormatKey is the context key of the error format of a route
+type errorFormatKey struct{}
+
+// parseErrorFormat validates an error format from the configuration or a request header
+func parseErrorFormat(value string) (errorFormat, bool) {
+	switch format := errorFormat(strings.ToLower(strings.TrimSpace(value))); format {
+	case jsonErrors, xmlErrors:
+		return format, true
+	default:
+		return "", false
+	}
+}
+
+// withErrorFormat is a middleware setting the error format of the routes it wraps
+func withErrorFormat(format errorFormat) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), errorFormatKey{}, format)))
+		})
+	}
+}
+
+// requestErrorFormat returns the error format of a request. the client chooses it explicitly with the
+// X-Error-Format or the Accept header, S3 SDK clients are recognized by their signed payload header and get XML
+// errors, other clients get the format of the route
+func requestErrorFormat(r *http.Request) errorFormat {
+	if format, ok := parseErrorFormat(r.Header.Get(errorFormatHeader)); ok {
+		return format
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(accept)
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "application/xml", "text/xml":
+			return xmlErrors
+		case "application/json":
+			return jsonErrors
+		}
+	}
+	if r.Header.Get(contentSHA256Header) != "" || strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256") {
+		return xmlErrors
+	}
+	if format, ok := r.Context().Value(errorFormatKey{}).(errorFormat); ok {
+		return format
+	}
+	return jsonErrors
+}
+
+// apiError describes how an error is answered, in both error formats
+type apiError struct {
+	status int
+	// bodyKey is the key of the JSON body in configs/error-responses.json, not found errors have an empty body
+	bodyKey string
+	// code is the S3 error code
+	code string
+}
+
+// classifyError maps an error returned by the service to its response, by its label or else by its class
+func classifyError(err error) apiError {
+	switch {
+	case errors.IsLabel(err, models.ErrLabelMissingTenant):
+		return apiError{http.StatusBadRequest, noTenantIDErrorBodyKey, "InvalidArgument"}
+	case errors.IsLabel(err, models.ErrLabelServerTimeout):
+		return apiError{http.StatusServiceUnavailable, timeoutErrorBodyKey, "ServiceUnavailable"}
+	case errors.IsLabel(err, models.ErrLabelInvalidTenant):
+		return apiError{http.StatusBadRequest, invalidTenantIDErrorBodyKey, "InvalidArgument"}
+	case errors.IsLabel(err, models.ErrLabelInvalidPath):
+		return apiError{http.StatusBadRequest, invalidPathErrorBodyKey, "InvalidArgument"}
+	case errors.IsLabel(err, models.ErrLabelInvalidArgument):
+		return apiError{http.StatusBadRequest, invalidArgumentErrorBodyKey, "InvalidArgument"}
+	case errors.IsLabel(err, models.ErrLabelInvalidRange):
+		return apiError{http.StatusRequestedRangeNotSatisfiable, invalidRangeErrorBodyKey, "InvalidRange"}
+	case errors.IsLabel(err, models.ErrLabelBadDigest):
+		return apiError{http.StatusBadRequest, badDigestErrorBodyKey, "BadDigest"}
+	case errors.IsLabel(err, models.ErrLabelNoSuchUpload):
+		return apiError{http.StatusNotFound, noSuchUploadErrorBodyKey, "NoSuchUpload"}
+	case errors.IsLabel(err, models.ErrLabelInvalidPart):
+		return apiError{http.StatusBadRequest, invalidPartErrorBodyKey, "InvalidPart"}
+	case errors.IsLabel(err, models.ErrLabelInvalidPartOrder):
+		return apiError{http.StatusBadRequest, invalidPartOrderErrorBodyKey, "InvalidPartOrder"}
+	case errors.IsLabel(err, models.ErrLabelInvalidTag):
+		return apiError{http.StatusBadRequest, invalidTagErrorBodyKey, "InvalidTag"}
+	case errors.IsLabel(err, models.ErrLabelEntityTooLarge):
+		return apiError{http.StatusBadRequest, entityTooLargeErrorBodyKey, "EntityTooLarge"}
+	case errors.IsLabel(err, models.ErrLabelEntityTooSmall):
+		return apiError{http.StatusBadRequest, entityTooSmallErrorBodyKey, "EntityTooSmall"}
+	case errors.IsLabel(err, models.ErrLabelRequestTimeout):
+		return apiError{http.StatusBadRequest, requestTimeoutErrorBodyKey, "RequestTimeout"}
+	case errors.IsLabel(err, models.ErrLabelPreconditionFailed):
+		return apiError{http.StatusPreconditionFailed, preconditionFailedBodyKey, "PreconditionFailed"}
+	case errors.IsLabel(err, models.ErrLabelInvalidAccessKey):
+		return apiError{http.StatusForbidden, accessDeniedErrorBodyKey, "InvalidAccessKeyId"}
+	case errors.IsLabel(err, models.ErrLabelSignatureMismatch):
+		return apiError{http.StatusForbidden, accessDeniedErrorBodyKey, "SignatureDoesNotMatch"}
+	case errors.IsLabel(err, models.ErrLabelRequestTimeSkewed):
+		return apiError{http.StatusForbidden, accessDeniedErrorBodyKey, "RequestTimeTooSkewed"}
+	case errors.IsClass(err, errors.ClassNotFound):
+		return apiError{http.StatusNotFound, "", "NoSuchKey"}
+	case errors.IsClass(err, errors.ClassUnauthorized), errors.IsClass(err, errors.ClassForbidden):
+		return apiError{http.StatusForbidden, accessDeniedErrorBodyKey, "AccessDenied"}
+	case errors.IsClass(err, errors.ClassBadInput):
+		return apiError{http.StatusBadRequest, invalidArgumentErrorBodyKey, "InvalidArgument"}
+	case errors.IsClass(err, errors.ClassBadGateway):
+		// a remote storage backend failed, the client may retry
+		return apiError{http.StatusServiceUnavailable, upstreamErrorBodyKey, "ServiceUnavailable"}
+	default:
+		return apiError{http.StatusInternalServerError, internalErrorBodyKey, "InternalError"}
+	}
+}
+
+// s3ErrorMessages are the messages S3 returns along with its error codes
+var s3ErrorMessages = map[string]string{
+	"InvalidArgument":       "Invalid Argument",
+	"InvalidRange":          "The requested range is not satisfiable",
+	"BadDigest":             "The Content-MD5 or checksum you specified did not match what was received.",
+	"NoSuchUpload":          "The specified multipart upload does not exist.",
+	"InvalidPart":           "One or more of the specified parts could not be found.",
+	"InvalidPartOrder":      "The list of parts was not in ascending order.",
+	"InvalidTag":            "The tag provided was not a valid tag.",
+	"EntityTooLarge":        "Your proposed upload exceeds the maximum allowed object size.",
+	"EntityTooSmall":        "Your proposed upload is smaller than the minimum allowed object size.",
+	"RequestTimeout":        "Your socket connection to the server was not read from or written to within the timeout period.",
+	"PreconditionFailed":    "At least one of the pre-conditions you specified did not hold",
+	"NoSuchKey":             "The specified key does not exist.",
+	"AccessDenied":          "Access Denied",
+	"InvalidAccessKeyId":    "The AWS Access Key Id you provided does not exist in our records.",
+	"SignatureDoesNotMatch": "The request signature we calculated does not match the signature you provided.",
+	"RequestTimeTooSkewed":  "The difference between the request time and the current time is too large.",
+	"InternalError":         "We encountered an internal error. Please try again.",
+	"ServiceUnavailable":    "Service is unable to handle request.",
+}
+
+type s3Error struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string
+	Message   string
+	Resource  string
+	RequestID string `xml:"RequestId"`
+}
+
+// errorReturn writes the error response of err, in the error format of the request
+func errorReturn(w http.ResponseWriter, r *http.Request, err error) {
+	ctx := r.Context()
+	apiErr := classifyError(err)
+	if requestErrorFormat(r) == xmlErrors {
+		s3ErrorReturn(w, r, apiErr)
+		return
+	}
+	var body []byte
+	if apiErr.bodyKey != "" && r.Method != http.MethodHead {
+		body = []byte(utils.CreateErrorBody(ctx, apiErr.bodyKey))
+	}
+	responses.HTTPReturn(ctx, w, apiErr.status, body, true)
+}
+
+// s3ErrorReturn writes an S3 XML error response, a response to a HEAD request has no body
+func s3ErrorReturn(w http.ResponseWriter, r *http.Request, apiErr apiError) {
+	ctx := r.Context()
+	requestID := requestID(ctx)
+	w.Header().Set(requestIDHeader, requestID)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(apiErr.status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	body, err := xml.Marshal(s3Error{
+		Code:      apiErr.code,
+		Message:   s3ErrorMessages[apiErr.code],
+		Resource:  r.URL.Path,
+		RequestID: requestID,
+	})
+	if err != nil {
+		log.WithContextAndEventID(ctx, "edfef011-f834-4a25-aff5-31e8c5b7e7ef").Errorf("failed to marshal error %v. err: %v", apiErr.code, err)
+		return
+	}
+	if _, err := w.Write(append([]byte(xml.Header), body...)); err != nil {
+		log.WithContextAndEventID(ctx, "e3d24633-647b-4001-b8df-752e3a5e828e").Warnf("failed to write error response. err: %v", err)
+	}
+}
+
+// requestID returns the trace id of the request, or a random id when the client didn't send one
+func requestID(ctx context.Context) string {
+	if traceID := ctxutils.ExtractString(ctx, ctxutils.ContextKeyEventTraceID); traceID != "" {
+		return traceID
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return strings.ToUpper(hex.EncodeToString(id))
+}
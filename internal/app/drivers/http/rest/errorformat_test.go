@@ -0,0 +1,188 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"openappsec.io/ctxutils"
+	"openappsec.io/errors"
+	"openappsec.io/errors/errorloader"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/sigv4"
+)
+
+// errorCases are errors as the service returns them, with the response each is classified into
+var errorCases = []struct {
+	name string
+	err  error
+	want apiError
+}{
+	{"not found", errors.New("no file").SetClass(errors.ClassNotFound), apiError{http.StatusNotFound, "", "NoSuchKey"}},
+	{"wrapped not found", errors.Wrap(errors.New("no file").SetClass(errors.ClassNotFound), "failed to get file"),
+		apiError{http.StatusNotFound, "", "NoSuchKey"}},
+	{"unauthorized", errors.New("unsigned").SetClass(errors.ClassUnauthorized),
+		apiError{http.StatusForbidden, accessDeniedErrorBodyKey, "AccessDenied"}},
+	{"forbidden", errors.New("denied").SetClass(errors.ClassForbidden),
+		apiError{http.StatusForbidden, accessDeniedErrorBodyKey, "AccessDenied"}},
+	{"invalid access key", errors.New("unknown key").SetClass(errors.ClassUnauthorized).SetLabel(models.ErrLabelInvalidAccessKey),
+		apiError{http.StatusForbidden, accessDeniedErrorBodyKey, "InvalidAccessKeyId"}},
+	{"signature mismatch", errors.New("bad signature").SetClass(errors.ClassUnauthorized).SetLabel(models.ErrLabelSignatureMismatch),
+		apiError{http.StatusForbidden, accessDeniedErrorBodyKey, "SignatureDoesNotMatch"}},
+	{"bad input", errors.New("bad").SetClass(errors.ClassBadInput),
+		apiError{http.StatusBadRequest, invalidArgumentErrorBodyKey, "InvalidArgument"}},
+	{"invalid argument", invalidArgumentError("bad max-keys"),
+		apiError{http.StatusBadRequest, invalidArgumentErrorBodyKey, "InvalidArgument"}},
+	{"missing tenant", errors.New("no tenant").SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelMissingTenant),
+		apiError{http.StatusBadRequest, noTenantIDErrorBodyKey, "InvalidArgument"}},
+	{"invalid path", errors.New("bad key").SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidPath),
+		apiError{http.StatusBadRequest, invalidPathErrorBodyKey, "InvalidArgument"}},
+	{"invalid range", errors.New("bad range").SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidRange),
+		apiError{http.StatusRequestedRangeNotSatisfiable, invalidRangeErrorBodyKey, "InvalidRange"}},
+	{"bad digest", errors.New("mismatch").SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelBadDigest),
+		apiError{http.StatusBadRequest, badDigestErrorBodyKey, "BadDigest"}},
+	{"precondition failed", errors.New("etag changed").SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelPreconditionFailed),
+		apiError{http.StatusPreconditionFailed, preconditionFailedBodyKey, "PreconditionFailed"}},
+	{"no such upload", errors.New("no upload").SetClass(errors.ClassNotFound).SetLabel(models.ErrLabelNoSuchUpload),
+		apiError{http.StatusNotFound, noSuchUploadErrorBodyKey, "NoSuchUpload"}},
+	{"invalid tag", invalidTagError("too many tags"), apiError{http.StatusBadRequest, invalidTagErrorBodyKey, "InvalidTag"}},
+	{"server timeout", errors.New("timeout").SetLabel(models.ErrLabelServerTimeout),
+		apiError{http.StatusServiceUnavailable, timeoutErrorBodyKey, "ServiceUnavailable"}},
+	{"bad gateway", errors.New("remote failed").SetClass(errors.ClassBadGateway),
+		apiError{http.StatusServiceUnavailable, upstreamErrorBodyKey, "ServiceUnavailable"}},
+	{"unclassified", errors.New("disk failed"), apiError{http.StatusInternalServerError, internalErrorBodyKey, "InternalError"}},
+}
+
+func TestClassifyError(t *testing.T) {
+	for _, test := range errorCases {
+		t.Run(test.name, func(t *testing.T) {
+			if got := classifyError(test.err); got != test.want {
+				t.Fatalf("classifyError = %+v, want %+v", got, test.want)
+			}
+			if _, ok := s3ErrorMessages[test.want.code]; !ok {
+				t.Fatalf("S3 code %v has no message", test.want.code)
+			}
+		})
+	}
+}
+
+func TestRequestErrorFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		route  errorFormat
+		want   errorFormat
+	}{
+		{"default", nil, "", jsonErrors},
+		{"route format", nil, xmlErrors, xmlErrors},
+		{"explicit xml", http.Header{errorFormatHeader: {"XML"}}, jsonErrors, xmlErrors},
+		{"explicit json", http.Header{errorFormatHeader: {"json"}, "Authorization": {"AWS4-HMAC-SHA256 Credential=k"}}, xmlErrors, jsonErrors},
+		{"unknown explicit format", http.Header{errorFormatHeader: {"yaml"}}, xmlErrors, xmlErrors},
+		{"accept xml", http.Header{"Accept": {"text/html, application/xml;q=0.9"}}, jsonErrors, xmlErrors},
+		{"accept json", http.Header{"Accept": {"application/json"}}, xmlErrors, jsonErrors},
+		{"accept any", http.Header{"Accept": {"*/*"}}, xmlErrors, xmlErrors},
+		{"signed request", http.Header{"Authorization": {"AWS4-HMAC-SHA256 Credential=k"}}, jsonErrors, xmlErrors},
+		{"signed payload", http.Header{contentSHA256Header: {sigv4.UnsignedPayload}}, "", xmlErrors},
+		{"other authorization", http.Header{"Authorization": {"Bearer token"}}, "", jsonErrors},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/file", nil)
+			for name, values := range test.header {
+				r.Header[http.CanonicalHeaderKey(name)] = values
+			}
+			if test.route != "" {
+				r = r.WithContext(context.WithValue(r.Context(), errorFormatKey{}, test.route))
+			}
+			if got := requestErrorFormat(r); got != test.want {
+				t.Fatalf("requestErrorFormat = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// returnError answers a request for path with err, in the error format chosen by header
+func returnError(method string, path string, header http.Header, err error) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	for name, values := range header {
+		r.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	r = r.WithContext(ctxutils.Insert(r.Context(), ctxutils.ContextKeyEventTraceID, "trace-id"))
+	w := httptest.NewRecorder()
+	errorReturn(w, r, err)
+	return w
+}
+
+func TestXMLErrorReturn(t *testing.T) {
+	for _, test := range errorCases {
+		t.Run(test.name, func(t *testing.T) {
+			w := returnError(http.MethodGet, "/api/ag/remote/file", withXMLErrors(nil), test.err)
+			if w.Code != test.want.status || w.Header().Get("Content-Type") != "application/xml" {
+				t.Fatalf("response = %v %v, want %v application/xml", w.Code, w.Header().Get("Content-Type"), test.want.status)
+			}
+			var body s3Error
+			if err := xml.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body %q isn't an S3 error: %v", w.Body.String(), err)
+			}
+			want := s3Error{XMLName: xml.Name{Local: "Error"}, Code: test.want.code, Message: s3ErrorMessages[test.want.code],
+				Resource: "/api/ag/remote/file", RequestID: "trace-id"}
+			if body != want {
+				t.Fatalf("body = %+v, want %+v", body, want)
+			}
+			if got := w.Header().Get(requestIDHeader); got != "trace-id" {
+				t.Fatalf("%v header = %q, want the trace id", requestIDHeader, got)
+			}
+		})
+	}
+
+	// a response to HEAD has the status and headers only
+	w := returnError(http.MethodHead, "/api/ag/remote/file", withXMLErrors(nil), errorCases[0].err)
+	if w.Code != http.StatusNotFound || w.Body.Len() != 0 || w.Header().Get(requestIDHeader) == "" {
+		t.Fatalf("HEAD error = %v %q, request id %q", w.Code, w.Body.String(), w.Header().Get(requestIDHeader))
+	}
+
+	// a request without a trace id gets a random request id
+	r := httptest.NewRequest(http.MethodGet, "/api/ag/remote/file", nil)
+	r.Header.Set(errorFormatHeader, string(xmlErrors))
+	w = httptest.NewRecorder()
+	errorReturn(w, r, errorCases[0].err)
+	if id := w.Header().Get(requestIDHeader); len(id) != 16 {
+		t.Fatalf("generated request id = %q", id)
+	}
+}
+
+func TestJSONErrorReturn(t *testing.T) {
+	if err := errorloader.Configure("../../../../../configs/error-responses.json", "100"); err != nil {
+		t.Fatalf("failed to load the error responses: %v", err)
+	}
+	for _, test := range errorCases {
+		t.Run(test.name, func(t *testing.T) {
+			w := returnError(http.MethodGet, "/api/ag/remote/file", nil, test.err)
+			if w.Code != test.want.status {
+				t.Fatalf("status = %v, want %v", w.Code, test.want.status)
+			}
+			if test.want.bodyKey == "" {
+				if w.Body.Len() != 0 {
+					t.Fatalf("body = %q, want none", w.Body.String())
+				}
+				return
+			}
+			want, err := errorloader.GetError(ctxutils.Insert(context.Background(), ctxutils.ContextKeyEventTraceID, "trace-id"), test.want.bodyKey)
+			if err != nil {
+				t.Fatalf("body %v isn't in the error responses: %v", test.want.bodyKey, err)
+			}
+			var body errorloader.ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body != want {
+				t.Fatalf("body = %s, want %+v", w.Body.String(), want)
+			}
+		})
+	}
+
+	w := returnError(http.MethodHead, "/api/ag/remote/file", nil, errorCases[2].err)
+	if w.Code != http.StatusForbidden || w.Body.Len() != 0 {
+		t.Fatalf("HEAD error = %v %q, want 403 without a body", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,286 @@
+package rest
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"openappsec.io/errors"
+	"openappsec.io/httputils/responses"
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+const (
+	// maxPartNumber is the highest part number of a multipart upload, as in S3
+	maxPartNumber = 10000
+	// maxListParts caps the number of parts returned in a single listing page, as in S3
+	maxListParts = 1000
+	// maxCompleteBodySize caps the size of a complete multipart upload request body
+	maxCompleteBodySize = 2 << 20
+)
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Key      string
+	UploadID string `xml:"UploadId"`
+}
+
+type part struct {
+	PartNumber   int
+	LastModified string
+	ETag         string
+	Size         int64
+}
+
+type listPartsResult struct {
+	XMLName              xml.Name `xml:"ListPartsResult"`
+	Key                  string
+	UploadID             string `xml:"UploadId"`
+	PartNumberMarker     int
+	NextPartNumberMarker int `xml:",omitempty"`
+	MaxParts             int
+	IsTruncated          bool
+	Parts                []part `xml:"Part"`
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+	Key     string
+	ETag    string
+}
+
+func invalidArgumentError(format string, args ...interface{}) error {
+	return errors.Errorf(format, args...).SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidArgument)
+}
+
+// xmlReturn writes v encoded as XML in the response body
+func xmlReturn(w http.ResponseWriter, r *http.Request, code int, v interface{}) {
+	ctx := r.Context()
+	response, err := xml.Marshal(v)
+	if err != nil {
+		log.WithContextAndEventID(ctx, "ddb0d9b3-c9bc-48b9-a849-e60cf567c5db").Errorf(
+			"failed to marshal response %+v. err: %v", v, err,
+		)
+		errorReturn(w, r, err)
+		return
+	}
+	responses.HTTPReturn(ctx, w, code, response, true)
+}
+
+// PostFile handles the multipart upload requests posted to a file path,
+// creating an upload (POST ?uploads) or completing it (POST ?uploadId=)
+func (a *Adapter) PostFile(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	switch {
+	case query.Has("uploads"):
+		a.CreateMultipartUpload(w, r)
+	case query.Has("uploadId"):
+		a.CompleteMultipartUpload(w, r)
+	default:
+		log.WithContextAndEventID(r.Context(), "3912c950-88fc-41fd-aeed-bf53c4169521").Warnf(
+			"unsupported post request: %v", r.URL.RawQuery,
+		)
+		errorReturn(w, r, invalidArgumentError("unsupported post request %q", r.URL.RawQuery))
+	}
+}
+
+// CreateMultipartUpload starts a multipart upload of the file with given path in uri
+func (a *Adapter) CreateMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	path := strings.TrimPrefix(r.URL.Path, "/api/")
+	log.WithContextAndEventID(ctx, "0e71d774-2441-440c-858c-95188a7ff74d").Infof("create multipart upload: %v", path)
+	var uploadID string
+	attributes, err := objectAttributes(r)
+	if err == nil {
+		uploadID, err = a.svc.CreateMultipartUpload(ctx, path, attributes)
+	}
+	if err != nil {
+		log.WithContextAndEventID(ctx, "808fb9dd-80b4-4864-bcd3-c79e8dd8de91").Errorf(
+			"failed to create multipart upload. err: %v", err,
+		)
+		errorReturn(w, r, err)
+		return
+	}
+	xmlReturn(w, r, http.StatusOK, initiateMultipartUploadResult{Key: path, UploadID: uploadID})
+}
+
+// UploadPart stores the body as a part of a multipart upload, the body is streamed to the storage
+func (a *Adapter) UploadPart(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	path := strings.TrimPrefix(r.URL.Path, "/api/")
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+	defer r.Body.Close()
+	partNumber, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		err = invalidArgumentError("invalid part number %q", query.Get("partNumber"))
+	}
+	var options models.PutOptions
+	if err == nil {
+		err = contentDigests(r, &options)
+	}
+	if err != nil {
+		log.WithContextAndEventID(ctx, "c310cd68-f9c2-44fa-844b-32347c1142d9").Warnf(
+			"invalid upload part request. err: %v", err,
+		)
+		errorReturn(w, r, err)
+		return
+	}
+	log.WithContextAndEventID(ctx, "f1599009-3bd3-4e03-8747-aeb274fe6ebb").Infof(
+		"upload part %v of multipart upload %v of file %v", partNumber, uploadID, path,
+	)
+	uploaded, err := a.svc.UploadPart(ctx, path, uploadID, partNumber, r.Body, options)
+	if err != nil {
+		log.WithContextAndEventID(ctx, "357e8681-51f0-417c-9d35-232fce3f8942").Errorf(
+			"failed to upload part. err: %v", err,
+		)
+		errorReturn(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", uploaded.ETag)
+	responses.HTTPReturn(ctx, w, http.StatusOK, nil, true)
+}
+
+// listPartsOptions parses the ListParts query parameters
+func listPartsOptions(query url.Values) (models.ListPartsOptions, error) {
+	options := models.ListPartsOptions{MaxParts: maxListParts}
+	if maxParts := query.Get("max-parts"); maxParts != "" {
+		value, err := strconv.Atoi(maxParts)
+		if err != nil || value < 1 {
+			return models.ListPartsOptions{}, invalidArgumentError("invalid max-parts %q", maxParts)
+		}
+		if value < maxListParts {
+			options.MaxParts = value
+		}
+	}
+	if marker := query.Get("part-number-marker"); marker != "" {
+		value, err := strconv.Atoi(marker)
+		if err != nil || value < 0 {
+			return models.ListPartsOptions{}, invalidArgumentError("invalid part-number-marker %q", marker)
+		}
+		options.PartNumberMarker = value
+	}
+	return options, nil
+}
+
+// ListParts lists the parts uploaded to a multipart upload
+func (a *Adapter) ListParts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	path := strings.TrimPrefix(r.URL.Path, "/api/")
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+	log.WithContextAndEventID(ctx, "32e90af0-ea93-4f41-855f-c5203fd13997").Infof(
+		"list parts of multipart upload %v of file %v", uploadID, path,
+	)
+	options, err := listPartsOptions(query)
+	if err != nil {
+		log.WithContextAndEventID(ctx, "a95e0df7-3244-4da4-8c23-7464f16c069c").Warnf(
+			"invalid list parts request. err: %v", err,
+		)
+		errorReturn(w, r, err)
+		return
+	}
+	list, err := a.svc.ListParts(ctx, path, uploadID, options)
+	if err != nil {
+		log.WithContextAndEventID(ctx, "9c7e6740-6c7f-4f4c-ab47-19a4dbc60b5f").Errorf(
+			"failed to list parts. err: %v", err,
+		)
+		errorReturn(w, r, err)
+		return
+	}
+	result := listPartsResult{
+		Key:              path,
+		UploadID:         uploadID,
+		PartNumberMarker: options.PartNumberMarker,
+		MaxParts:         options.MaxParts,
+		IsTruncated:      list.IsTruncated,
+		Parts:            make([]part, len(list.Parts)),
+	}
+	for i, uploaded := range list.Parts {
+		result.Parts[i] = part{
+			PartNumber:   uploaded.PartNumber,
+			LastModified: uploaded.LastModified.UTC().Format(time.RFC3339),
+			ETag:         uploaded.ETag,
+			Size:         uploaded.Size,
+		}
+	}
+	if list.IsTruncated {
+		result.NextPartNumberMarker = list.Parts[len(list.Parts)-1].PartNumber
+	}
+	xmlReturn(w, r, http.StatusOK, result)
+}
+
+// CompleteMultipartUpload assembles the file with given path in uri from the parts listed in the body
+func (a *Adapter) CompleteMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	path := strings.TrimPrefix(r.URL.Path, "/api/")
+	uploadID := r.URL.Query().Get("uploadId")
+	defer r.Body.Close()
+	var request completeMultipartUpload
+	err := xml.NewDecoder(io.LimitReader(r.Body, maxCompleteBodySize)).Decode(&request)
+	if err != nil || len(request.Parts) == 0 || len(request.Parts) > maxPartNumber {
+		err = invalidArgumentError("invalid complete multipart upload request, parts: %v, err: %v", len(request.Parts), err)
+	}
+	var options models.PutOptions
+	if err == nil {
+		err = writePreconditions(r, &options)
+	}
+	if err != nil {
+		log.WithContextAndEventID(ctx, "ad43469e-d44e-4aba-8fb7-43c686d29eae").Warnf(
+			"invalid complete multipart upload request. err: %v", err,
+		)
+		errorReturn(w, r, err)
+		return
+	}
+	parts := make([]models.CompletedPart, len(request.Parts))
+	for i, completed := range request.Parts {
+		parts[i] = models.CompletedPart{PartNumber: completed.PartNumber, ETag: completed.ETag}
+	}
+	log.WithContextAndEventID(ctx, "59d94851-1480-4b19-9788-f2b70b53a627").Infof(
+		"complete multipart upload %v of file %v with %v parts", uploadID, path, len(parts),
+	)
+	metadata, err := a.svc.CompleteMultipartUpload(ctx, path, uploadID, parts, options)
+	if err != nil {
+		log.WithContextAndEventID(ctx, "4b2364d8-42ca-405f-87e5-47a956ef4454").Errorf(
+			"failed to complete multipart upload. err: %v", err,
+		)
+		errorReturn(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", metadata.ETag)
+	setVersionHeader(w, metadata)
+	xmlReturn(w, r, http.StatusOK, completeMultipartUploadResult{Key: path, ETag: metadata.ETag})
+}
+
+// AbortMultipartUpload removes a multipart upload along with its parts
+func (a *Adapter) AbortMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	path := strings.TrimPrefix(r.URL.Path, "/api/")
+	uploadID := r.URL.Query().Get("uploadId")
+	log.WithContextAndEventID(ctx, "b6970801-f06d-4e85-90dd-569ca3c9b436").Infof(
+		"abort multipart upload %v of file %v", uploadID, path,
+	)
+	if err := a.svc.AbortMultipartUpload(ctx, path, uploadID); err != nil {
+		log.WithContextAndEventID(ctx, "9aa0f3c9-3833-40b2-8692-966df3400d33").Errorf(
+			"failed to abort multipart upload. err: %v", err,
+		)
+		errorReturn(w, r, err)
+		return
+	}
+	responses.HTTPReturn(ctx, w, http.StatusNoContent, nil, true)
+}
@@ -0,0 +1,59 @@
+package rest
+
+import (
+	"strconv"
+	"strings"
+
+	"openappsec.io/errors"
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+const rangeUnitPrefix = "bytes="
+
+// parseRange parses a Range header holding a single byte range or suffix range.
+// a missing header, a unit other than bytes, or a set of several ranges are ignored, and the whole file is
+// returned. a malformed byte range returns an error.
+func parseRange(header string) (*models.ByteRange, error) {
+	if !strings.HasPrefix(header, rangeUnitPrefix) {
+		return nil, nil
+	}
+	spec := strings.TrimSpace(header[len(rangeUnitPrefix):])
+	if strings.Contains(spec, ",") {
+		return nil, nil
+	}
+	first, last, found := strings.Cut(spec, "-")
+	if !found {
+		return nil, invalidRangeError(header)
+	}
+	first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+	if first == "" {
+		suffixLength, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffixLength <= 0 {
+			return nil, invalidRangeError(header)
+		}
+		return &models.ByteRange{SuffixLength: suffixLength}, nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil, invalidRangeError(header)
+	}
+	if last == "" {
+		return &models.ByteRange{Start: start, End: -1}, nil
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return nil, invalidRangeError(header)
+	}
+	return &models.ByteRange{Start: start, End: end}, nil
+}
+
+func invalidRangeError(header string) error {
+	return errors.Errorf("invalid range %q", header).
+		SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidRange)
+}
+
+// contentRange returns the Content-Range header value of a partial response
+func contentRange(offset int64, length int64, size int64) string {
+	return "bytes " + strconv.FormatInt(offset, 10) + "-" + strconv.FormatInt(offset+length-1, 10) + "/" +
+		strconv.FormatInt(size, 10)
+}
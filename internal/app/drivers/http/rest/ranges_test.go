@@ -0,0 +1,126 @@
+package rest
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"openappsec.io/errors"
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header  string
+		want    *models.ByteRange
+		invalid bool
+	}{
+		{"", nil, false},
+		{"bytes=2-5", &models.ByteRange{Start: 2, End: 5}, false},
+		{"bytes= 2 - 5 ", &models.ByteRange{Start: 2, End: 5}, false},
+		{"bytes=7-", &models.ByteRange{Start: 7, End: -1}, false},
+		{"bytes=-3", &models.ByteRange{SuffixLength: 3}, false},
+		{"items=0-1", nil, false},
+		{"bytes=0-1,4-5", nil, false},
+		{"bytes=5-2", nil, true},
+		{"bytes=-0", nil, true},
+		{"bytes=-", nil, true},
+		{"bytes=5", nil, true},
+		{"bytes=a-b", nil, true},
+		{"bytes=-1-2", nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			got, err := parseRange(test.header)
+			if test.invalid {
+				if !errors.IsLabel(err, models.ErrLabelInvalidRange) {
+					t.Fatalf("parseRange = %+v, %v, want an invalid range error", got, err)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("parseRange = %+v, %v, want %+v", got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestRangeRequests(t *testing.T) {
+	a := newTestAdapter(t, nil)
+	const path = "/api/ag/remote/file"
+	const content = "0123456789"
+	serve(a.PutFile, http.MethodPut, path, content, nil)
+
+	tests := []struct {
+		header       string
+		code         int
+		body         string
+		contentRange string
+	}{
+		{"bytes=2-5", http.StatusPartialContent, "2345", "bytes 2-5/10"},
+		{"bytes=7-", http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"bytes=5-100", http.StatusPartialContent, "56789", "bytes 5-9/10"},
+		{"bytes=9-9", http.StatusPartialContent, "9", "bytes 9-9/10"},
+		{"bytes=-3", http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"bytes=-20", http.StatusPartialContent, content, "bytes 0-9/10"},
+		{"bytes=0-1,4-5", http.StatusOK, content, ""},
+		{"items=0-1", http.StatusOK, content, ""},
+		{"bytes=10-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+		{"bytes=10-12", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+		{"bytes=5-2", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+		{"bytes=-0", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+	}
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			w := serve(a.GetFile, http.MethodGet, path, "", withXMLErrors(http.Header{"Range": {test.header}}))
+			if w.Code != test.code || w.Header().Get("Content-Range") != test.contentRange {
+				t.Fatalf("GET = %v, Content-Range %q, want %v %q", w.Code, w.Header().Get("Content-Range"), test.code, test.contentRange)
+			}
+			if test.code == http.StatusRequestedRangeNotSatisfiable {
+				if code := errorCode(t, w); code != "InvalidRange" {
+					t.Fatalf("error code = %v, want InvalidRange", code)
+				}
+				return
+			}
+			if w.Body.String() != test.body || w.Header().Get("Content-Length") != strconv.Itoa(len(test.body)) {
+				t.Fatalf("body = %q, Content-Length %v, want %q", w.Body.String(), w.Header().Get("Content-Length"), test.body)
+			}
+			// the checksum covers the whole content only
+			if hasChecksum := w.Header().Get(checksumSHA256Header) != ""; hasChecksum != (test.code == http.StatusOK) {
+				t.Fatalf("%v response has the checksum header %q", test.code, w.Header().Get(checksumSHA256Header))
+			}
+		})
+	}
+
+	w := serve(a.GetFile, http.MethodGet, "/api/ag/remote/missing", "", withXMLErrors(http.Header{"Range": {"bytes=0-1"}}))
+	if w.Code != http.StatusNotFound || errorCode(t, w) != "NoSuchKey" {
+		t.Fatalf("range of a missing file = %v %s, want 404 NoSuchKey", w.Code, w.Body.String())
+	}
+	w = serve(a.GetFile, http.MethodGet, "/api/ag/remote/missing", "", withXMLErrors(http.Header{"Range": {"bytes=5-2"}}))
+	if w.Code != http.StatusNotFound || errorCode(t, w) != "NoSuchKey" {
+		t.Fatalf("invalid range of a missing file = %v %s, want 404 NoSuchKey", w.Code, w.Body.String())
+	}
+
+	serve(a.PutFile, http.MethodPut, "/api/ag/remote/empty", "", nil)
+	w = serve(a.GetFile, http.MethodGet, "/api/ag/remote/empty", "", withXMLErrors(http.Header{"Range": {"bytes=0-"}}))
+	if w.Code != http.StatusRequestedRangeNotSatisfiable || w.Header().Get("Content-Range") != "bytes */0" {
+		t.Fatalf("range of an empty file = %v, Content-Range %q, want 416", w.Code, w.Header().Get("Content-Range"))
+	}
+}
+
+func TestRangeOfCompressedFile(t *testing.T) {
+	a := newCompressingAdapter(t)
+	const path = "/api/ag/remote/file"
+	content := strings.Repeat("compressible content ", 100)
+	serve(a.PutFile, http.MethodPut, path, content, nil)
+
+	// a range is taken from the decompressed content, even when the client accepts the compressed one
+	w := serve(a.GetFile, http.MethodGet, path, "", http.Header{"Range": {"bytes=21-41"}, "Accept-Encoding": {"gzip"}})
+	if w.Code != http.StatusPartialContent || w.Body.String() != content[21:42] || w.Header().Get("Content-Encoding") != "" ||
+		w.Header().Get("Content-Range") != "bytes 21-41/"+strconv.Itoa(len(content)) {
+		t.Fatalf("GET = %v %q, Content-Encoding %q, Content-Range %q", w.Code, w.Body.String(),
+			w.Header().Get("Content-Encoding"), w.Header().Get("Content-Range"))
+	}
+}
@@ -1,13 +1,18 @@
 package rest
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"net/http"
+	"time"
 
 	"openappsec.io/smartsync-shared-files/internal/app/utils"
+	"openappsec.io/smartsync-shared-files/internal/models"
 
 	"github.com/go-chi/chi"
 	"openappsec.io/ctxutils"
+	"openappsec.io/errors"
 	healthhandlers "openappsec.io/health/http/rest"
 	"openappsec.io/httputils/middleware"
 	"openappsec.io/log"
@@ -31,15 +36,15 @@ func (a *Adapter) newRouter(ctx context.Context) *chi.Mux {
 	errorBodyTimeout := utils.CreateErrorBody(ctx, timeoutErrorBodyKey)
 	errorBodyAgentID := utils.CreateErrorBody(ctx, noAgentIDErrorBodyKey)
 	errorBodyProfileID := utils.CreateErrorBody(ctx, noProfileIDErrorBodyKey)
-	errorTenantBodyID := utils.CreateErrorBody(ctx, noTenantIDErrorBodyKey)
 
 	// set server timeout on requests
 	// in this project it is set to 15 seconds
 	// look at the NewHTTPAdapter function in server.go which loads
 	// the timeout as an environment variable
-	router.Use(middleware.Timeout(a.wait, errorBodyTimeout))
+	timeout := middleware.Timeout(a.wait, errorBodyTimeout)
 
 	router.Group(func(router chi.Router) {
+		router.Use(timeout)
 
 		// k8s automatically does a "health check" for us upon deploying a service to the cluster
 		// this check's purpose is to avoid deploying a service which failed to initialize it's code
@@ -52,34 +57,166 @@ func (a *Adapter) newRouter(ctx context.Context) *chi.Mux {
 	})
 
 	defaultErrorBody := utils.CreateErrorBody(ctx, "default-error")
-	router.Group(func(router chi.Router) {
 
-		router.Route("/api", func(r chi.Router) {
+	// create middlewares that will parse the headers (in this case x-tenant-id, x-profile-id and x-agent-id)
+	// and save them to the context. To extract them (usually done in the handler) - use ExtractString function from ctxutils package
+	// you can remove all/some of them and create your own middlewares.
+	// the middlewares which may fail the request come first, so the errors are answered in the error format of
+	// the request rather than with the errorloader bodies the httputils middlewares write
+	requestContext := []func(http.Handler) http.Handler{
+		middleware.Tracing,
+		tenantID, // stores the tenant id in the context, returns error if the request has none
+		func(next http.Handler) http.Handler {
+			return middleware.HeaderToContext(next, "X-Agent-Id", ctxutils.ContextKeyAgentID, false,
+				errorBodyAgentID)
+		},
+		func(next http.Handler) http.Handler {
+			return middleware.HeaderToContext(next, "X-Profile-Id", ctxutils.ContextKeyProfileID, false,
+				errorBodyProfileID)
+		},
+		middleware.CorrelationID(defaultErrorBody),  // search for header "x-trace-id"
+		middleware.CallingService(defaultErrorBody), // search for optional header "X-Calling-Service"
+	}
+
+	router.Route("/api", func(router chi.Router) {
+		router.Group(func(r chi.Router) {
+			r.Use(withErrorFormat(a.apiErrorFormat))
+			r.Use(a.boundRequestBody)
+			r.Use(a.timeout)
 			// Logs "new incoming request" upon receiving the request
 			// Logs the request duration after returning a response
-			r.Use(middleware.Logging(defaultErrorBody))
-			r.Use(middleware.Tracing)
-
-			// create middlewares that will parse the headers (in this case x-tenant-id, x-profile-id and x-agent-id)
-			// and save them to the context. To extract them (usually done in the handler) - use ExtractString function from ctxutils package
-			// you can remove all/some of them and create your own middlewares
-			r.Use(middleware.TenantID(errorTenantBodyID)) // returns error if the request doesn't include "x-tenant-id" as header
-			r.Use(func(next http.Handler) http.Handler {
-				return middleware.HeaderToContext(next, "X-Agent-Id", ctxutils.ContextKeyAgentID, false,
-					errorBodyAgentID)
-			})
-			r.Use(func(next http.Handler) http.Handler {
-				return middleware.HeaderToContext(next, "X-Profile-Id", ctxutils.ContextKeyProfileID, false,
-					errorBodyProfileID)
-			})
-			r.Use(middleware.CorrelationID(defaultErrorBody))  // search for header "x-trace-id"
-			r.Use(middleware.CallingService(defaultErrorBody)) // search for optional header "X-Calling-Service"
+			r.Use(requestLogging)
+			r.Use(a.authenticate)
+			r.Use(requestContext...)
 
 			r.Get("/", a.GetFilesList)
+			r.Post("/", a.DeleteFiles)
+			r.Head("/*", a.HeadFile)
+			r.Delete("/*", a.DeleteFile)
+		})
+
+		// file content is streamed between the socket and the storage, so these routes are neither wrapped by
+		// the timeout handler nor by the logging middleware, both of which buffer the whole body in memory.
+		// they are bound by the io timeout instead, which a transfer that keeps moving never hits
+		router.Group(func(r chi.Router) {
+			r.Use(withErrorFormat(a.apiErrorFormat))
+			r.Use(a.boundRequestBody)
+			r.Use(streamLogging)
+			r.Use(a.authenticate)
+			r.Use(requestContext...)
+
 			r.Get("/*", a.GetFile)
 			r.Put("/*", a.PutFile)
+			r.Post("/*", a.PostFile)
 		})
 	})
 
 	return router
 }
+
+// tenantID is a middleware storing the tenant id of a request in its context. the tenant of an authenticated request
+// is the one authenticate found, otherwise it is taken from the X-Tenant-Id header, and requests without one are
+// rejected in their error format
+func tenantID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, ok := authenticatedTenant(ctx)
+		if !ok {
+			id = r.Header.Get(tenantIDHeader)
+		}
+		if id == "" {
+			log.WithContextAndEventID(ctx, "6a0c2f53-7d8e-4b61-9a44-2f1e0c9d7b35").Warnf(
+				"invalid request headers. missing %v request header", tenantIDHeader,
+			)
+			errorReturn(w, r, errors.Errorf("missing %v request header", tenantIDHeader).
+				SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelMissingTenant))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ctxutils.Insert(ctx, ctxutils.ContextKeyTenantID, id)))
+	})
+}
+
+// timeout is a middleware bounding the time a request is handled in, as middleware.Timeout does, a request which
+// runs out of time is answered in its error format
+func (a *Adapter) timeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the timeout handler derives its context from this one, so it is done once the handler timed out
+		ctx, cancel := context.WithTimeout(r.Context(), a.wait)
+		defer cancel()
+		r = r.WithContext(ctx)
+		http.TimeoutHandler(next, a.wait, "").ServeHTTP(&timeoutWriter{ResponseWriter: w, r: r, wait: a.wait}, r)
+	})
+}
+
+// timeoutWriter replaces the response http.TimeoutHandler writes when the handler times out
+type timeoutWriter struct {
+	http.ResponseWriter
+	r        *http.Request
+	wait     time.Duration
+	timedOut bool
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	if code == http.StatusServiceUnavailable && w.r.Context().Err() != nil {
+		w.timedOut = true
+		errorReturn(w.ResponseWriter, w.r, errors.Errorf("request wasn't handled within %v", w.wait).
+			SetClass(errors.ClassInternal).SetLabel(models.ErrLabelServerTimeout))
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timeoutWriter) Write(p []byte) (int, error) {
+	if w.timedOut {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// requestLogging logs the incoming request and its duration, as middleware.Logging does, with the request body
+// when debug logs are enabled. a body which fails to be read is answered in the error format of the request
+func requestLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx := r.Context()
+		fields := log.Fields{
+			"method": r.Method,
+			"path":   r.URL.Path,
+			"query":  "?" + r.URL.RawQuery,
+		}
+		if log.GetLevel() >= log.DebugLevel {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				log.WithContextAndEventID(ctx, "c7d1e8a2-35b9-4f06-8e1d-94a6b2f3c580").Errorf(
+					"failed to read request body. err: %v", err,
+				)
+				errorReturn(w, r, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			fields["body"] = string(body)
+		}
+		log.WithContextAndFields(ctx, fields).Infoln("new incoming request")
+
+		next.ServeHTTP(w, r)
+
+		log.WithContext(ctx).Infoln("Request duration:", time.Since(start))
+	})
+}
+
+// streamLogging logs the incoming request and its duration, without reading the request body
+func streamLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx := r.Context()
+		log.WithContextAndFields(ctx, log.Fields{
+			"method": r.Method,
+			"path":   r.URL.Path,
+			"query":  "?" + r.URL.RawQuery,
+		}).Infoln("new incoming request")
+
+		next.ServeHTTP(w, r)
+
+		log.WithContext(ctx).Infoln("Request duration:", time.Since(start))
+	})
+}
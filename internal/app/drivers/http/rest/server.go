@@ -2,6 +2,7 @@ package rest
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"strconv"
 	"time"
@@ -22,6 +23,8 @@ const (
 	errorsConfBaseKey = "errors"
 	errorsFilePathKey = errorsConfBaseKey + ".filepath"
 	errorsCodeKey     = errorsConfBaseKey + ".code"
+	// errorsAPIFormatKey is the error format of the /api routes, json or S3 xml, clients may override it
+	errorsAPIFormatKey = errorsConfBaseKey + ".api_format"
 )
 
 // Configuration exposes an interface of configuration related actions
@@ -54,9 +57,26 @@ type HealthService interface {
 // To create mock for unittest please use this command
 // mockgen -destination mocks/mock_sharedFilesService.go -package mocks -mock_names SharedFilesService=MockDemoService openappsec.io/smartsync-shared-files/internal/app/drivers/http/rest SharedFilesService
 type SharedFilesService interface {
-	GetFilesList(ctx context.Context, pathPrefix string) ([]models.FileMetadata, error)
-	GetFile(ctx context.Context, pathPrefix string) ([]byte, error)
-	PutFile(ctx context.Context, pathPrefix string, content []byte) error
+	GetFilesList(ctx context.Context, options models.ListOptions) (models.FilesList, error)
+	GetFile(ctx context.Context, pathPrefix string, options models.GetOptions) (io.ReadCloser, models.FileMetadata, error)
+	StatFile(ctx context.Context, path string, versionID string) (models.FileMetadata, error)
+	PutFile(ctx context.Context, pathPrefix string, content io.Reader, options models.PutOptions) (models.FileMetadata, error)
+	CopyFile(ctx context.Context, srcPath string, dstPath string, options models.CopyOptions) (models.FileMetadata, error)
+	DeleteFile(ctx context.Context, path string, versionID string) error
+	DeleteFiles(ctx context.Context, paths []string) ([]models.DeleteResult, error)
+	CreateMultipartUpload(ctx context.Context, path string, attributes models.ObjectAttributes) (string, error)
+	UploadPart(ctx context.Context, path string, uploadID string, partNumber int, content io.Reader, options models.PutOptions) (models.Part, error)
+	ListParts(ctx context.Context, path string, uploadID string, options models.ListPartsOptions) (models.PartsList, error)
+	CompleteMultipartUpload(ctx context.Context, path string, uploadID string, parts []models.CompletedPart, options models.PutOptions) (models.FileMetadata, error)
+	AbortMultipartUpload(ctx context.Context, path string, uploadID string) error
+	ListVersions(ctx context.Context, options models.ListVersionsOptions) (models.VersionsList, error)
+	SetFileTags(ctx context.Context, path string, versionID string, tags map[string]string) (models.FileMetadata, error)
+}
+
+// CredentialStore exposes an interface for looking up the credentials requests are signed with
+type CredentialStore interface {
+	Lookup(accessKeyID string) (models.Credential, bool)
+	Len() int
 }
 
 // Server http server interface
@@ -72,18 +92,29 @@ type Adapter struct {
 	server    Server
 	altServer Server
 	wait      time.Duration
-	conf      Configuration
+	// ioTimeout bounds each read of a request body and each write of a response
+	ioTimeout time.Duration
+	// maxObjectSize caps the size of a request body
+	maxObjectSize int64
+	conf          Configuration
+	// apiErrorFormat is the error format of the /api routes
+	apiErrorFormat errorFormat
+	// authMode defines which requests to the /api routes are authenticated, with the credentials of the store
+	authMode    authMode
+	authRegion  string
+	credentials CredentialStore
 
 	healthSvc HealthService
 	svc       SharedFilesService
 }
 
 // NewHTTPAdapter is a rest adapter provider
-func NewHTTPAdapter(ctx context.Context, cs Configuration, hs HealthService, ds SharedFilesService) (*Adapter, error) {
+func NewHTTPAdapter(ctx context.Context, cs Configuration, hs HealthService, ds SharedFilesService, creds CredentialStore) (*Adapter, error) {
 	ra := Adapter{
-		conf:      cs,
-		healthSvc: hs,
-		svc:       ds,
+		conf:        cs,
+		healthSvc:   hs,
+		svc:         ds,
+		credentials: creds,
 	}
 
 	serverTimeout, err := cs.GetDuration(serverTimeoutConfKey)
@@ -92,13 +123,75 @@ func NewHTTPAdapter(ctx context.Context, cs Configuration, hs HealthService, ds
 	}
 
 	ra.wait = serverTimeout
+	ra.ioTimeout = serverTimeout
+	if ioTimeout, err := cs.GetDuration(serverIOTimeoutConfKey); err == nil {
+		if ioTimeout <= 0 {
+			return nil, errors.Errorf("invalid %v %v, must be positive", serverIOTimeoutConfKey, ioTimeout)
+		}
+		ra.ioTimeout = ioTimeout
+	} else if !errors.IsClass(err, errors.ClassNotFound) {
+		return nil, err
+	}
+	ra.maxObjectSize = defaultMaxObjectSize
+	if maxObjectSize, err := cs.GetInt(serverMaxObjectSizeConfKey); err == nil {
+		if maxObjectSize <= 0 {
+			return nil, errors.Errorf("invalid %v %v, must be positive", serverMaxObjectSizeConfKey, maxObjectSize)
+		}
+		ra.maxObjectSize = int64(maxObjectSize)
+	} else if !errors.IsClass(err, errors.ClassNotFound) {
+		return nil, err
+	}
+	ra.apiErrorFormat = jsonErrors
+	if value, err := cs.GetString(errorsAPIFormatKey); err == nil {
+		format, ok := parseErrorFormat(value)
+		if !ok {
+			return nil, errors.Errorf("invalid %v %q, expecting json or xml", errorsAPIFormatKey, value)
+		}
+		ra.apiErrorFormat = format
+	} else if !errors.IsClass(err, errors.ClassNotFound) {
+		return nil, err
+	}
+	ra.authMode = authRequired
+	if value, err := cs.GetString(authModeConfKey); err == nil {
+		mode, ok := parseAuthMode(value)
+		if !ok {
+			return nil, errors.Errorf("invalid %v %q, expecting off or required", authModeConfKey, value)
+		}
+		ra.authMode = mode
+	} else if !errors.IsClass(err, errors.ClassNotFound) {
+		return nil, err
+	}
+	if ra.authMode == authRequired && creds.Len() == 0 {
+		// every request would be denied, authentication is only turned off explicitly
+		return nil, errors.Errorf(
+			"%v is required but no credentials are configured, set it to off to serve without authentication",
+			authModeConfKey,
+		)
+	}
+	if ra.authRegion, err = cs.GetString(authRegionConfKey); err != nil && !errors.IsClass(err, errors.ClassNotFound) {
+		return nil, err
+	}
 	r := ra.newRouter(ctx)
-	server := &http.Server{
-		Handler: r,
+	// file content routes stream without a handler timeout, so the headers are bound by the server timeout,
+	// and the body reads and response writes by the io timeout
+	server := &deadlineServer{
+		Server: &http.Server{
+			Handler:           r,
+			ReadHeaderTimeout: serverTimeout,
+			IdleTimeout:       ra.ioTimeout,
+			ConnContext:       withConn,
+		},
+		ioTimeout: ra.ioTimeout,
 	}
 
-	altServer := &http.Server{
-		Handler: r,
+	altServer := &deadlineServer{
+		Server: &http.Server{
+			Handler:           r,
+			ReadHeaderTimeout: serverTimeout,
+			IdleTimeout:       ra.ioTimeout,
+			ConnContext:       withConn,
+		},
+		ioTimeout: ra.ioTimeout,
 	}
 
 	errorsPath, err := cs.GetString(errorsFilePathKey)
@@ -1,119 +1,574 @@
 package rest
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/xml"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
-	"openappsec.io/smartsync-shared-files/internal/app/utils"
 	"openappsec.io/errors"
 	"openappsec.io/httputils/responses"
 	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
 )
 
 const (
-	internalErrorBodyKey = "internal-error"
+	internalErrorBodyKey         = "internal-error"
+	invalidTenantIDErrorBodyKey  = "invalid-tenant-id-error"
+	invalidPathErrorBodyKey      = "invalid-path-error"
+	invalidArgumentErrorBodyKey  = "invalid-argument-error"
+	invalidRangeErrorBodyKey     = "invalid-range-error"
+	preconditionFailedBodyKey    = "precondition-failed-error"
+	badDigestErrorBodyKey        = "bad-digest-error"
+	noSuchUploadErrorBodyKey     = "no-such-upload-error"
+	invalidPartErrorBodyKey      = "invalid-part-error"
+	invalidPartOrderErrorBodyKey = "invalid-part-order-error"
+	invalidTagErrorBodyKey       = "invalid-tag-error"
+	accessDeniedErrorBodyKey     = "access-denied-error"
+	upstreamErrorBodyKey         = "upstream-unavailable-error"
+	entityTooLargeErrorBodyKey   = "entity-too-large-error"
+	requestTimeoutErrorBodyKey   = "request-timeout-error"
+	entityTooSmallErrorBodyKey   = "entity-too-small-error"
 )
 
-// PutFile stores the body in file with given path in uri
+// putOptions parses the preconditions, content digests and attributes of a write
+func putOptions(r *http.Request) (models.PutOptions, error) {
+	var options models.PutOptions
+	if err := writePreconditions(r, &options); err != nil {
+		return models.PutOptions{}, err
+	}
+	if err := contentDigests(r, &options); err != nil {
+		return models.PutOptions{}, err
+	}
+	attributes, err := objectAttributes(r)
+	if err != nil {
+		return models.PutOptions{}, err
+	}
+	options.Attributes = attributes
+	return options, nil
+}
+
+// PutFile stores the body in file with given path in uri, the body is streamed to the storage.
+// a request with an upload id uploads a part of a multipart upload instead, a request with a copy source
+// copies it, and a tagging request replaces its tags
 func (a *Adapter) PutFile(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("uploadId") {
+		a.UploadPart(w, r)
+		return
+	}
+	if r.URL.Query().Has("tagging") {
+		a.PutFileTagging(w, r)
+		return
+	}
+	if r.Header.Get(copySourceHeader) != "" {
+		a.CopyFile(w, r)
+		return
+	}
 	ctx := r.Context()
 	path := strings.TrimPrefix(r.URL.Path, "/api/")
 	log.WithContextAndEventID(ctx, "67305fca-e3cb-4c3c-8537-fc633cc4742d").Infof("put file: %v", path)
 	defer r.Body.Close()
-	content, err := io.ReadAll(r.Body)
+	options, err := putOptions(r)
 	if err != nil {
-		log.WithContextAndEventID(
-			ctx, "5efd2539-ebce-441f-a372-d801f354ca1b",
-		).Errorf("failed to read request body. err: %v", err)
-		errString := utils.CreateErrorBody(ctx, internalErrorBodyKey)
-		responses.HTTPReturn(ctx, w, http.StatusInternalServerError, []byte(errString), true)
+		log.WithContextAndEventID(ctx, "610be13a-31ba-4efc-8a5a-82bf1d9c8df0").Warnf(
+			"invalid put file request. err: %v", err,
+		)
+		errorReturn(w, r, err)
 		return
 	}
-	err = a.svc.PutFile(ctx, path, content)
+	metadata, err := a.svc.PutFile(ctx, path, r.Body, options)
 	if err != nil {
 		log.WithContextAndEventID(ctx, "9de9ba8b-7e94-4ddb-befb-7cb02bdb5bf4").Errorf(
 			"failed to put file. err: %v", err,
 		)
-		errString := utils.CreateErrorBody(ctx, internalErrorBodyKey)
-		responses.HTTPReturn(ctx, w, http.StatusInternalServerError, []byte(errString), true)
+		errorReturn(w, r, err)
 		return
 	}
 	log.WithContextAndEventID(ctx, "f5ab58b3-0722-4525-a661-e819af8eb12f").Infof("put file %v success", path)
+	w.Header().Set("ETag", metadata.ETag)
+	w.Header().Set(checksumSHA256Header, metadata.ChecksumSHA256)
+	setVersionHeader(w, metadata)
 	responses.HTTPReturn(ctx, w, http.StatusOK, nil, true)
 }
 
-// GetFile returns the file content
+// GetFile returns the file content, or the requested range of it, of the current version of the file or of the
+// version given in the query. a request with an upload id lists the parts of a multipart upload instead, and
+// a tagging request returns its tags
 func (a *Adapter) GetFile(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("uploadId") {
+		a.ListParts(w, r)
+		return
+	}
+	if r.URL.Query().Has("tagging") {
+		a.GetFileTagging(w, r)
+		return
+	}
 	ctx := r.Context()
 	path := strings.TrimPrefix(r.URL.Path, "/api/")
 	log.WithContextAndEventID(ctx, "e2e5e899-bd6e-41d1-9ae6-8ee2c96e3a14").Infof("get file: %v", path)
-	fileContent, err := a.svc.GetFile(ctx, path)
+	byteRange, err := parseRange(r.Header.Get("Range"))
+	if err != nil {
+		log.WithContextAndEventID(ctx, "83044e4d-a8df-4d4a-b212-53818952dee2").Infof(
+			"invalid range for file %v. err: %v", path, err,
+		)
+		a.rangeNotSatisfiable(w, r, path)
+		return
+	}
+	options := models.GetOptions{
+		Range:           byteRange,
+		VersionID:       r.URL.Query().Get("versionId"),
+		AcceptEncodings: acceptEncodings(r),
+	}
+	content, metadata, err := a.svc.GetFile(ctx, path, options)
 	if err != nil {
 		if errors.IsClass(err, errors.ClassNotFound) {
 			log.WithContextAndEventID(ctx, "12f72909-a816-444b-80a1-f48bfb286be7").Infof("file %v not found", path)
-			responses.HTTPReturn(ctx, w, http.StatusNotFound, []byte{}, true)
+			errorReturn(w, r, err)
+			return
+		}
+		if errors.IsLabel(err, models.ErrLabelInvalidRange) {
+			log.WithContextAndEventID(ctx, "06c759b8-5691-40b1-8851-bb9fd161bc19").Infof(
+				"range not satisfiable for file %v. err: %v", path, err,
+			)
+			a.rangeNotSatisfiable(w, r, path)
 			return
 		}
 		log.WithContextAndEventID(
 			ctx, "4c7190fb-61fa-434f-80d1-cf00eb4a3595",
 		).Errorf("unexpected error on get file: %v, err: %v", path, err)
-		errString := utils.CreateErrorBody(ctx, internalErrorBodyKey)
-		responses.HTTPReturn(ctx, w, http.StatusInternalServerError, []byte(errString), true)
+		errorReturn(w, r, err)
+		return
+	}
+	defer content.Close()
+	encoded := options.ReturnsEncoded(metadata.StoredEncoding)
+	if encoded {
+		metadata = encodedRepresentation(metadata)
+	}
+	if answerPreconditions(w, r, metadata) {
 		return
 	}
 	log.WithContextAndEventID(ctx, "56a4d207-3993-4282-9f83-d946c36a4afb").Infof(
-		"got file ok, file length: %v", len(fileContent),
+		"got file ok, file length: %v", metadata.Size,
 	)
-	responses.HTTPReturn(ctx, w, http.StatusOK, fileContent, false)
+	setMetadataHeaders(w, metadata)
+	if byteRange != nil {
+		offset, length, _ := byteRange.Resolve(metadata.Size)
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+		w.Header().Set("Content-Range", contentRange(offset, length, metadata.Size))
+		streamReturn(ctx, w, http.StatusPartialContent, content)
+		return
+	}
+	if encoded {
+		// the checksum covers the decompressed content, so it isn't returned along with the compressed one
+		setEncodingHeaders(w, metadata)
+		streamReturn(ctx, w, http.StatusOK, content)
+		return
+	}
+	// the checksum covers the whole content, so it is only returned along with it
+	w.Header().Set(checksumSHA256Header, metadata.ChecksumSHA256)
+	streamReturn(ctx, w, http.StatusOK, content)
+}
+
+// streamReturn writes the response headers and streams the body from content, without buffering it
+func streamReturn(ctx context.Context, w http.ResponseWriter, code int, content io.Reader) {
+	w.WriteHeader(code)
+	if _, err := io.Copy(w, content); err != nil {
+		log.WithContextAndEventID(ctx, "5754b34a-f666-4932-9450-42e2096f0492").Warnf(
+			"failed to stream response body. err: %v", err,
+		)
+	}
+}
+
+// rangeNotSatisfiable returns 416 with the size of the file, when it exists, in the Content-Range header
+func (a *Adapter) rangeNotSatisfiable(w http.ResponseWriter, r *http.Request, path string) {
+	ctx := r.Context()
+	metadata, err := a.svc.StatFile(ctx, path, r.URL.Query().Get("versionId"))
+	if err != nil {
+		if errors.IsClass(err, errors.ClassNotFound) {
+			errorReturn(w, r, err)
+			return
+		}
+		log.WithContextAndEventID(ctx, "9489a7b1-fb9f-4ecb-8de4-f4fdf765cbe5").Errorf(
+			"unexpected error on stat file: %v, err: %v", path, err,
+		)
+		errorReturn(w, r, err)
+		return
+	}
+	// preconditions are evaluated before the range, as in RFC 7232 section 6
+	if answerPreconditions(w, r, metadata) {
+		return
+	}
+	w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(metadata.Size, 10))
+	errorReturn(w, r, errors.Errorf("range not satisfiable for file %v", path).
+		SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidRange))
+}
+
+// HeadFile returns the file metadata headers without its content
+func (a *Adapter) HeadFile(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	path := strings.TrimPrefix(r.URL.Path, "/api/")
+	log.WithContextAndEventID(ctx, "1b5b5ae2-5ad2-4e10-9a48-84b341ac2524").Infof("head file: %v", path)
+	metadata, err := a.svc.StatFile(ctx, path, r.URL.Query().Get("versionId"))
+	if err != nil {
+		if errors.IsClass(err, errors.ClassNotFound) {
+			log.WithContextAndEventID(ctx, "90972f54-9b4c-451f-9807-1bcfdec0318f").Infof("file %v not found", path)
+			errorReturn(w, r, err)
+			return
+		}
+		log.WithContextAndEventID(
+			ctx, "0a9200fc-354e-4b93-a4ae-21bac042f6e2",
+		).Errorf("unexpected error on head file: %v, err: %v", path, err)
+		errorReturn(w, r, err)
+		return
+	}
+	// the headers are the ones of the content a GET would return
+	encoded := (models.GetOptions{AcceptEncodings: acceptEncodings(r)}).ReturnsEncoded(metadata.StoredEncoding)
+	if encoded {
+		metadata = encodedRepresentation(metadata)
+	}
+	if answerPreconditions(w, r, metadata) {
+		return
+	}
+	setMetadataHeaders(w, metadata)
+	if encoded {
+		setEncodingHeaders(w, metadata)
+	} else {
+		w.Header().Set(checksumSHA256Header, metadata.ChecksumSHA256)
+	}
+	// written directly, responses.HTTPReturn would override the content type of the file
+	w.WriteHeader(http.StatusOK)
+}
+
+// answerPreconditions answers a conditional request whose preconditions aren't met with 304 or 412,
+// it returns false when the file should be served
+func answerPreconditions(w http.ResponseWriter, r *http.Request, metadata models.FileMetadata) bool {
+	ctx := r.Context()
+	switch checkPreconditions(r, metadata) {
+	case http.StatusNotModified:
+		log.WithContextAndEventID(ctx, "6aa6b863-547c-4e84-91bd-864143eec9ec").Infof(
+			"file %v not modified", metadata.Path,
+		)
+		notModified(w, metadata)
+		return true
+	case http.StatusPreconditionFailed:
+		log.WithContextAndEventID(ctx, "0c1d5d95-0615-4507-b289-615b50f569f2").Infof(
+			"precondition failed for file %v", metadata.Path,
+		)
+		errorReturn(w, r, errors.Errorf("precondition failed for file %v", metadata.Path).
+			SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelPreconditionFailed))
+		return true
+	}
+	return false
+}
+
+// setMetadataHeaders sets the response headers describing a stored file
+func setMetadataHeaders(w http.ResponseWriter, metadata models.FileMetadata) {
+	w.Header().Set("Content-Length", strconv.FormatInt(metadata.Size, 10))
+	w.Header().Set("Last-Modified", metadata.LastModified.UTC().Format(http.TimeFormat))
+	w.Header().Set("ETag", metadata.ETag)
+	w.Header().Set("Accept-Ranges", "bytes")
+	setAttributeHeaders(w, metadata.Attributes)
+	setVersionHeader(w, metadata)
+	if len(metadata.Tags) > 0 {
+		w.Header().Set(taggingCountHeader, strconv.Itoa(len(metadata.Tags)))
+	}
+	if metadata.StoredSize > 0 {
+		w.Header().Set(storedSizeHeader, strconv.FormatInt(metadata.StoredSize, 10))
+	}
+	if metadata.StoredEncoding != "" {
+		// the file is returned compressed or not depending on the Accept-Encoding header
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
 }
 
+const (
+	// maxListKeys caps the number of keys returned in a single listing page, as in S3
+	maxListKeys = 1000
+)
+
 type contents struct {
 	Key          string
 	LastModified string
+	ETag         string
+	Size         int64
+	// StoredSize is the size of the content at rest, omitted when the storage doesn't report it
+	StoredSize int64 `xml:",omitempty"`
+}
+
+type commonPrefix struct {
+	Prefix string
 }
 
 type filesList struct {
-	XMLName  xml.Name `xml:"ListBucketResult"`
-	KeyCount int
-	Contents []contents
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Prefix                string
+	Delimiter             string `xml:",omitempty"`
+	KeyCount              int
+	MaxKeys               int `xml:",omitempty"`
+	IsTruncated           bool
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	StartAfter            string `xml:",omitempty"`
+	Contents              []contents
+	CommonPrefixes        []commonPrefix
 }
 
-// GetFilesList lists the files with given prefix
+// encodeContinuationToken returns an opaque token continuing a listing after key
+func encodeContinuationToken(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeContinuationToken(token string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid continuation token %q", token).
+			SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidArgument)
+	}
+	return string(key), nil
+}
+
+// listOptions parses the ListObjectsV2 query parameters, and the tag filter extending them.
+// a page holds up to max-keys keys, 1000 at most as in S3, and 1000 when max-keys isn't set.
+// max-keys=0 is reported back as a request for an empty page
+func listOptions(query url.Values) (models.ListOptions, bool, error) {
+	options := models.ListOptions{
+		Prefix:     query.Get("prefix"),
+		StartAfter: query.Get("start-after"),
+		Delimiter:  query.Get("delimiter"),
+		MaxKeys:    maxListKeys,
+	}
+	tags, err := tagFilter(query)
+	if err != nil {
+		return models.ListOptions{}, false, err
+	}
+	// common prefixes roll up keys without reading their tags, they can't be filtered
+	if tags != nil && options.Delimiter != "" {
+		return models.ListOptions{}, false, errors.Errorf("tag filter can't be combined with a delimiter").
+			SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidArgument)
+	}
+	options.Tags = tags
+	if maxKeys := query.Get("max-keys"); maxKeys != "" {
+		value, err := strconv.Atoi(maxKeys)
+		if err != nil || value < 0 {
+			return models.ListOptions{}, false, errors.Errorf("invalid max-keys %q", maxKeys).
+				SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidArgument)
+		}
+		if value == 0 {
+			return options, true, nil
+		}
+		if value < maxListKeys {
+			options.MaxKeys = value
+		}
+	}
+	// a continuation token takes precedence over start-after
+	if token := query.Get("continuation-token"); token != "" {
+		key, err := decodeContinuationToken(token)
+		if err != nil {
+			return models.ListOptions{}, false, err
+		}
+		options.StartAfter = key
+	}
+	return options, false, nil
+}
+
+// GetFilesList lists the files with given prefix.
+// a request with the versions query parameter lists their versions instead
 func (a *Adapter) GetFilesList(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	path := r.URL.Query().Get("prefix")
+	query := r.URL.Query()
+	if query.Has("versions") {
+		a.ListVersions(w, r)
+		return
+	}
 	log.WithContextAndEventID(ctx, "3120a134-d0a9-4ce6-9317-b25631376d54").Infof(
-		"listing files with prefix: %v", path,
+		"listing files with query: %v", query,
 	)
-	files, err := a.svc.GetFilesList(ctx, path)
+	options, emptyPage, err := listOptions(query)
+	if err != nil {
+		log.WithContextAndEventID(ctx, "4e1f7c0a-3b57-4a8e-9d55-0f6c2a9b1e73").Warnf(
+			"invalid list files request. err: %v", err,
+		)
+		errorReturn(w, r, err)
+		return
+	}
+	list := models.FilesList{}
+	if !emptyPage {
+		list, err = a.svc.GetFilesList(ctx, options)
+	}
 	if err != nil {
 		log.WithContextAndEventID(ctx, "0954d824-c7e1-40b2-9005-b32015ffe7a8").Errorf(
 			"failed to list files. err: %v", err,
 		)
-		errString := utils.CreateErrorBody(ctx, internalErrorBodyKey)
-		responses.HTTPReturn(ctx, w, http.StatusInternalServerError, []byte(errString), true)
+		errorReturn(w, r, err)
 		return
 	}
 	filesListRes := filesList{
-		KeyCount: len(files),
-		Contents: make([]contents, len(files)),
+		Prefix:            options.Prefix,
+		Delimiter:         options.Delimiter,
+		KeyCount:          len(list.Files) + len(list.CommonPrefixes),
+		MaxKeys:           options.MaxKeys,
+		IsTruncated:       list.IsTruncated,
+		ContinuationToken: query.Get("continuation-token"),
+		StartAfter:        query.Get("start-after"),
+		Contents:          make([]contents, len(list.Files)),
 	}
-	for i, file := range files {
+	for i, file := range list.Files {
 		filesListRes.Contents[i] = contents{
 			Key:          file.Path,
 			LastModified: file.LastModified.Format(time.RFC3339),
+			ETag:         file.ETag,
+			Size:         file.Size,
+			StoredSize:   file.StoredSize,
 		}
 	}
+	for _, prefix := range list.CommonPrefixes {
+		filesListRes.CommonPrefixes = append(filesListRes.CommonPrefixes, commonPrefix{Prefix: prefix})
+	}
+	if list.IsTruncated {
+		filesListRes.NextContinuationToken = encodeContinuationToken(list.LastKey())
+	}
 	response, err := xml.Marshal(filesListRes)
 	if err != nil {
 		log.WithContextAndEventID(
 			ctx, "de79b434-b6b1-4626-85d9-11935b900756",
 		).Errorf("failed to marshal list files %+v. err: %v", filesListRes, err)
-		errString := utils.CreateErrorBody(ctx, internalErrorBodyKey)
-		responses.HTTPReturn(ctx, w, http.StatusInternalServerError, []byte(errString), true)
+		errorReturn(w, r, err)
+		return
+	}
+	responses.HTTPReturn(ctx, w, http.StatusOK, response, true)
+}
+
+// DeleteFile removes the file with given path in uri, or the version of it given in the query.
+// a request with an upload id aborts a multipart upload instead, and a tagging request removes its tags
+func (a *Adapter) DeleteFile(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("uploadId") {
+		a.AbortMultipartUpload(w, r)
+		return
+	}
+	if r.URL.Query().Has("tagging") {
+		a.DeleteFileTagging(w, r)
+		return
+	}
+	ctx := r.Context()
+	path := strings.TrimPrefix(r.URL.Path, "/api/")
+	versionID := r.URL.Query().Get("versionId")
+	log.WithContextAndEventID(ctx, "3fa8688c-e1f7-4952-993b-d03b93a01563").Infof(
+		"delete file: %v, version: %v", path, versionID,
+	)
+	err := a.svc.DeleteFile(ctx, path, versionID)
+	if err != nil {
+		log.WithContextAndEventID(ctx, "11342796-bdb7-47a0-ae5e-f6cb8579a5fd").Errorf(
+			"failed to delete file. err: %v", err,
+		)
+		errorReturn(w, r, err)
+		return
+	}
+	log.WithContextAndEventID(ctx, "f2182754-7b24-4d32-a396-dbc71039b1ae").Infof("delete file %v success", path)
+	if versionID != "" {
+		w.Header().Set(versionIDHeader, versionID)
+	}
+	responses.HTTPReturn(ctx, w, http.StatusNoContent, nil, true)
+}
+
+const (
+	// maxDeleteKeys caps the number of keys deleted in a single batch, as in S3
+	maxDeleteKeys = 1000
+	// maxDeleteBodySize caps the size of a batch delete request body
+	maxDeleteBodySize = 2 << 20
+)
+
+type deleteObject struct {
+	Key string
+}
+
+type deleteRequest struct {
+	XMLName xml.Name `xml:"Delete"`
+	Quiet   bool
+	Objects []deleteObject `xml:"Object"`
+}
+
+type deletedObject struct {
+	Key string
+}
+
+type deleteError struct {
+	Key     string
+	Code    string
+	Message string
+}
+
+type deleteResult struct {
+	XMLName xml.Name        `xml:"DeleteResult"`
+	Deleted []deletedObject `xml:"Deleted"`
+	Errors  []deleteError   `xml:"Error"`
+}
+
+// newDeleteError returns the S3 error reported for a key which failed to be deleted
+func newDeleteError(path string, err error) deleteError {
+	if errors.IsClass(err, errors.ClassBadInput) {
+		return deleteError{Key: path, Code: "InvalidArgument", Message: "Invalid key"}
+	}
+	return deleteError{Key: path, Code: "InternalError", Message: "Internal Server Error"}
+}
+
+// DeleteFiles removes a batch of files, as in the S3 multi-object delete (POST /?delete)
+func (a *Adapter) DeleteFiles(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if _, ok := r.URL.Query()["delete"]; !ok {
+		log.WithContextAndEventID(ctx, "85759d4e-6f50-4569-ac7c-74e1cb3a5eee").Warnf(
+			"unsupported post request: %v", r.URL.RawQuery,
+		)
+		errorReturn(w, r, invalidArgumentError("unsupported post request %q", r.URL.RawQuery))
+		return
+	}
+	defer r.Body.Close()
+	var request deleteRequest
+	err := xml.NewDecoder(io.LimitReader(r.Body, maxDeleteBodySize)).Decode(&request)
+	if err != nil || len(request.Objects) == 0 || len(request.Objects) > maxDeleteKeys {
+		log.WithContextAndEventID(ctx, "245e2289-481b-4b03-8c3e-35dcdd749c2d").Warnf(
+			"invalid delete files request, keys: %v, err: %v", len(request.Objects), err,
+		)
+		errorReturn(w, r, invalidArgumentError("invalid delete files request, keys: %v, err: %v", len(request.Objects), err))
+		return
+	}
+	paths := make([]string, len(request.Objects))
+	for i, object := range request.Objects {
+		paths[i] = object.Key
+	}
+	log.WithContextAndEventID(ctx, "cd40e8b1-b3ee-48b3-8f50-7d25315f6ba2").Infof("delete files: %v", paths)
+	results, err := a.svc.DeleteFiles(ctx, paths)
+	if err != nil {
+		log.WithContextAndEventID(ctx, "0e788949-4607-40a8-9aa3-44ba92607aa4").Errorf(
+			"failed to delete files. err: %v", err,
+		)
+		errorReturn(w, r, err)
+		return
+	}
+	deleteRes := deleteResult{}
+	for _, result := range results {
+		if result.Err != nil {
+			log.WithContextAndEventID(ctx, "dd56aac0-306d-4173-a4ce-181739489af0").Warnf(
+				"failed to delete file %v. err: %v", result.Path, result.Err,
+			)
+			deleteRes.Errors = append(deleteRes.Errors, newDeleteError(result.Path, result.Err))
+			continue
+		}
+		// quiet mode reports only the keys which failed to be deleted
+		if !request.Quiet {
+			deleteRes.Deleted = append(deleteRes.Deleted, deletedObject{Key: result.Path})
+		}
+	}
+	response, err := xml.Marshal(deleteRes)
+	if err != nil {
+		log.WithContextAndEventID(
+			ctx, "8153de85-32c4-4b1d-a11b-dea2f3eb399b",
+		).Errorf("failed to marshal delete result %+v. err: %v", deleteRes, err)
+		errorReturn(w, r, err)
 		return
 	}
 	responses.HTTPReturn(ctx, w, http.StatusOK, response, true)
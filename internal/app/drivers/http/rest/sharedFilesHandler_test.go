@@ -0,0 +1,373 @@
+package rest
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"openappsec.io/ctxutils"
+	"openappsec.io/errors"
+	"openappsec.io/smartsync-shared-files/internal/app/sharedfiles"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/filesystem"
+	"openappsec.io/smartsync-shared-files/internal/pkg/testutil"
+)
+
+// xmlErrorsHeader asks for S3 XML error responses, whose codes the tests check
+var xmlErrorsHeader = http.Header{errorFormatHeader: {"xml"}}
+
+// newTestAdapter returns an adapter serving a filesystem backend under a new root, whose temp files expire in an
+// hour unless conf sets otherwise
+func newTestAdapter(t *testing.T, conf testutil.Configuration) *Adapter {
+	t.Helper()
+	fsConf := testutil.Configuration{
+		"filesystem_db.root": t.TempDir() + "/",
+		"filesystem_db.ttl":  time.Hour,
+	}
+	for key, value := range conf {
+		fsConf[key] = value
+	}
+	fs, err := filesystem.NewAdapter(fsConf)
+	if err != nil {
+		t.Fatalf("filesystem.NewAdapter() failed: %v", err)
+	}
+	t.Cleanup(func() { fs.TearDown(context.Background()) })
+	svc, err := sharedfiles.NewSharedFilesService(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Adapter{svc: svc}
+}
+
+// serve sends a request of tenant t1 to a handler of the adapter
+func serve(handler http.HandlerFunc, method string, path string, body string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	for name, values := range header {
+		r.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	r = r.WithContext(ctxutils.Insert(r.Context(), ctxutils.ContextKeyTenantID, "t1"))
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// eventually polls condition until it holds, or fails the test after a few seconds
+func eventually(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !condition(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%v didn't happen", description)
+		}
+	}
+}
+
+// withXMLErrors returns header along with the S3 XML error format
+func withXMLErrors(header http.Header) http.Header {
+	merged := xmlErrorsHeader.Clone()
+	for name, values := range header {
+		merged[name] = values
+	}
+	return merged
+}
+
+// errorCode returns the S3 error code of an XML error response
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body s3Error
+	if err := xml.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response %v isn't an S3 error: %q", w.Code, w.Body.String())
+	}
+	return body.Code
+}
+
+// listFiles returns the listing page of the query
+func listFiles(t *testing.T, a *Adapter, query url.Values) filesList {
+	t.Helper()
+	w := serve(a.GetFilesList, http.MethodGet, "/api/?"+query.Encode(), "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("listing %v = %v, body: %s", query, w.Code, w.Body.String())
+	}
+	var list filesList
+	if err := xml.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("failed to parse listing %s: %v", w.Body.String(), err)
+	}
+	return list
+}
+
+func keys(list filesList) []string {
+	keys := []string{}
+	for _, entry := range list.Contents {
+		keys = append(keys, entry.Key)
+	}
+	return keys
+}
+
+func TestListOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     url.Values
+		maxKeys   int
+		emptyPage bool
+		invalid   bool
+	}{
+		{name: "default page", query: url.Values{}, maxKeys: maxListKeys},
+		{name: "smaller page", query: url.Values{"max-keys": {"10"}}, maxKeys: 10},
+		{name: "page over the cap", query: url.Values{"max-keys": {"5000"}}, maxKeys: maxListKeys},
+		{name: "empty page", query: url.Values{"max-keys": {"0"}}, emptyPage: true},
+		{name: "negative page", query: url.Values{"max-keys": {"-1"}}, invalid: true},
+		{name: "not a number", query: url.Values{"max-keys": {"ten"}}, invalid: true},
+		{name: "invalid continuation token", query: url.Values{"continuation-token": {"not base64!"}}, invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options, emptyPage, err := listOptions(test.query)
+			if test.invalid {
+				if !errors.IsLabel(err, models.ErrLabelInvalidArgument) {
+					t.Fatalf("listOptions() failed with %v, want an invalid argument", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("listOptions() failed: %v", err)
+			}
+			if emptyPage != test.emptyPage || (!emptyPage && options.MaxKeys != test.maxKeys) {
+				t.Fatalf("listOptions() = max keys %v, empty page %v, want %v, %v", options.MaxKeys, emptyPage,
+					test.maxKeys, test.emptyPage)
+			}
+		})
+	}
+}
+
+func TestListingPages(t *testing.T) {
+	a := newTestAdapter(t, nil)
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		serve(a.PutFile, http.MethodPut, "/api/ag/remote/"+name, name, nil)
+	}
+
+	page := listFiles(t, a, url.Values{"prefix": {"ag/"}, "max-keys": {"2"}})
+	if got := keys(page); !reflect.DeepEqual(got, []string{"ag/remote/a", "ag/remote/b"}) || !page.IsTruncated ||
+		page.KeyCount != 2 || page.MaxKeys != 2 || page.NextContinuationToken == "" {
+		t.Fatalf("first page = %+v", page)
+	}
+	token := page.NextContinuationToken
+	page = listFiles(t, a, url.Values{"prefix": {"ag/"}, "max-keys": {"2"}, "continuation-token": {token}})
+	if got := keys(page); !reflect.DeepEqual(got, []string{"ag/remote/c", "ag/remote/d"}) || !page.IsTruncated ||
+		page.ContinuationToken != token {
+		t.Fatalf("second page = %+v", page)
+	}
+	page = listFiles(t, a, url.Values{"prefix": {"ag/"}, "max-keys": {"2"}, "continuation-token": {page.NextContinuationToken}})
+	if got := keys(page); !reflect.DeepEqual(got, []string{"ag/remote/e"}) || page.IsTruncated || page.NextContinuationToken != "" {
+		t.Fatalf("last page = %+v", page)
+	}
+
+	page = listFiles(t, a, url.Values{"prefix": {"ag/"}, "start-after": {"ag/remote/b"}})
+	if got := keys(page); !reflect.DeepEqual(got, []string{"ag/remote/c", "ag/remote/d", "ag/remote/e"}) ||
+		page.IsTruncated || page.StartAfter != "ag/remote/b" || page.MaxKeys != maxListKeys {
+		t.Fatalf("page after ag/remote/b = %+v", page)
+	}
+	// the continuation token takes precedence over start-after
+	page = listFiles(t, a, url.Values{"prefix": {"ag/"}, "start-after": {"ag/remote/a"}, "continuation-token": {token}})
+	if got := keys(page); !reflect.DeepEqual(got, []string{"ag/remote/c", "ag/remote/d", "ag/remote/e"}) {
+		t.Fatalf("page continued after ag/remote/b and started after ag/remote/a = %v", got)
+	}
+	page = listFiles(t, a, url.Values{"prefix": {"ag/"}, "max-keys": {"0"}})
+	if len(page.Contents) != 0 || page.IsTruncated || page.KeyCount != 0 {
+		t.Fatalf("empty page = %+v", page)
+	}
+
+	w := serve(a.GetFilesList, http.MethodGet, "/api/?max-keys=-1", "", xmlErrorsHeader)
+	if w.Code != http.StatusBadRequest || errorCode(t, w) != "InvalidArgument" {
+		t.Fatalf("listing with invalid max-keys = %v %s, want 400 InvalidArgument", w.Code, w.Body.String())
+	}
+}
+
+func prefixes(list filesList) []string {
+	prefixes := []string{}
+	for _, prefix := range list.CommonPrefixes {
+		prefixes = append(prefixes, prefix.Prefix)
+	}
+	return prefixes
+}
+
+func TestListingDelimiter(t *testing.T) {
+	a := newTestAdapter(t, nil)
+	for _, name := range []string{"a", "b", "dir1/x", "dir1/y", "dir2/z"} {
+		serve(a.PutFile, http.MethodPut, "/api/ag/remote/"+name, name, nil)
+	}
+
+	w := serve(a.GetFilesList, http.MethodGet, "/api/?prefix=ag/remote/&delimiter=/", "", nil)
+	if !strings.Contains(w.Body.String(), "<Delimiter>/</Delimiter>") ||
+		!strings.Contains(w.Body.String(), "<CommonPrefixes><Prefix>ag/remote/dir1/</Prefix></CommonPrefixes>") {
+		t.Fatalf("listing with a delimiter = %v %s, want the common prefixes", w.Code, w.Body.String())
+	}
+	page := listFiles(t, a, url.Values{"prefix": {"ag/remote/"}, "delimiter": {"/"}})
+	if got := keys(page); !reflect.DeepEqual(got, []string{"ag/remote/a", "ag/remote/b"}) {
+		t.Fatalf("keys = %v, want the files directly under the prefix", got)
+	}
+	if got := prefixes(page); !reflect.DeepEqual(got, []string{"ag/remote/dir1/", "ag/remote/dir2/"}) || page.KeyCount != 4 {
+		t.Fatalf("common prefixes = %v, key count %v", got, page.KeyCount)
+	}
+
+	// a common prefix counts as a single key of the page, and isn't listed again on the next page
+	query := url.Values{"prefix": {"ag/remote/"}, "delimiter": {"/"}, "max-keys": {"3"}}
+	page = listFiles(t, a, query)
+	if got := append(keys(page), prefixes(page)...); !reflect.DeepEqual(got, []string{"ag/remote/a", "ag/remote/b", "ag/remote/dir1/"}) ||
+		!page.IsTruncated {
+		t.Fatalf("first page = %v, truncated %v", got, page.IsTruncated)
+	}
+	query.Set("continuation-token", page.NextContinuationToken)
+	page = listFiles(t, a, query)
+	if got := append(keys(page), prefixes(page)...); !reflect.DeepEqual(got, []string{"ag/remote/dir2/"}) || page.IsTruncated {
+		t.Fatalf("second page = %v, truncated %v", got, page.IsTruncated)
+	}
+
+	// the keys under a common prefix are listed with it as the prefix
+	page = listFiles(t, a, url.Values{"prefix": {"ag/remote/dir1/"}, "delimiter": {"/"}})
+	if got := keys(page); !reflect.DeepEqual(got, []string{"ag/remote/dir1/x", "ag/remote/dir1/y"}) || len(page.CommonPrefixes) != 0 {
+		t.Fatalf("listing of ag/remote/dir1/ = %v, common prefixes %v", got, prefixes(page))
+	}
+}
+
+func TestDeleteFile(t *testing.T) {
+	a := newTestAdapter(t, nil)
+	const path = "/api/ag/remote/file"
+	serve(a.PutFile, http.MethodPut, path, "content", nil)
+
+	for i := 0; i < 2; i++ {
+		// as in S3, deleting a missing file succeeds
+		if w := serve(a.DeleteFile, http.MethodDelete, path, "", nil); w.Code != http.StatusNoContent {
+			t.Fatalf("DELETE #%v = %v, want 204", i, w.Code)
+		}
+		if w := serve(a.GetFile, http.MethodGet, path, "", withXMLErrors(nil)); w.Code != http.StatusNotFound || errorCode(t, w) != "NoSuchKey" {
+			t.Fatalf("GET of a deleted file = %v %s, want 404 NoSuchKey", w.Code, w.Body.String())
+		}
+	}
+	if got := keys(listFiles(t, a, url.Values{"prefix": {"ag/"}})); len(got) != 0 {
+		t.Fatalf("listing after delete = %v", got)
+	}
+	if w := serve(a.DeleteFile, http.MethodDelete, "/api/ag/../../escape", "", withXMLErrors(nil)); w.Code != http.StatusBadRequest ||
+		errorCode(t, w) != "InvalidArgument" {
+		t.Fatalf("DELETE of an invalid key = %v %s, want 400 InvalidArgument", w.Code, w.Body.String())
+	}
+}
+
+// deleteFiles posts a batch delete of keys and returns its result
+func deleteFiles(t *testing.T, a *Adapter, quiet bool, keys ...string) deleteResult {
+	t.Helper()
+	request := deleteRequest{Quiet: quiet}
+	for _, key := range keys {
+		request.Objects = append(request.Objects, deleteObject{Key: key})
+	}
+	body, err := xml.Marshal(request)
+	if err != nil {
+		t.Fatalf("failed to marshal delete request: %v", err)
+	}
+	w := serve(a.DeleteFiles, http.MethodPost, "/api/?delete", string(body), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST ?delete = %v, body: %s", w.Code, w.Body.String())
+	}
+	var result deleteResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("failed to parse delete result %s: %v", w.Body.String(), err)
+	}
+	return result
+}
+
+func TestDeleteFiles(t *testing.T) {
+	a := newTestAdapter(t, nil)
+	for _, name := range []string{"a", "b", "c"} {
+		serve(a.PutFile, http.MethodPut, "/api/ag/remote/"+name, name, nil)
+	}
+
+	result := deleteFiles(t, a, false, "ag/remote/a", "ag/remote/missing", "ag/../../escape", "ag/remote/b")
+	if !reflect.DeepEqual(result.Deleted, []deletedObject{{"ag/remote/a"}, {"ag/remote/missing"}, {"ag/remote/b"}}) {
+		t.Fatalf("deleted = %v, want every valid key, existing or not", result.Deleted)
+	}
+	if !reflect.DeepEqual(result.Errors, []deleteError{{Key: "ag/../../escape", Code: "InvalidArgument", Message: "Invalid key"}}) {
+		t.Fatalf("errors = %v, want the invalid key", result.Errors)
+	}
+	if got := keys(listFiles(t, a, url.Values{"prefix": {"ag/"}})); !reflect.DeepEqual(got, []string{"ag/remote/c"}) {
+		t.Fatalf("keys after delete = %v", got)
+	}
+
+	// quiet mode reports only the failures
+	result = deleteFiles(t, a, true, "ag/remote/c", "ag/../../escape")
+	if len(result.Deleted) != 0 || len(result.Errors) != 1 {
+		t.Fatalf("quiet result = %+v, want the single error", result)
+	}
+	if got := keys(listFiles(t, a, url.Values{"prefix": {"ag/"}})); len(got) != 0 {
+		t.Fatalf("keys after quiet delete = %v", got)
+	}
+
+	tooMany := "<Delete>" + strings.Repeat("<Object><Key>k</Key></Object>", maxDeleteKeys+1) + "</Delete>"
+	for name, request := range map[string]struct{ path, body string }{
+		"no delete query": {"/api/?other", "<Delete><Object><Key>k</Key></Object></Delete>"},
+		"malformed":       {"/api/?delete", "<Delete><Object>"},
+		"no keys":         {"/api/?delete", "<Delete></Delete>"},
+		"too many keys":   {"/api/?delete", tooMany},
+	} {
+		w := serve(a.DeleteFiles, http.MethodPost, request.path, request.body, withXMLErrors(nil))
+		if w.Code != http.StatusBadRequest || errorCode(t, w) != "InvalidArgument" {
+			t.Fatalf("%v delete request = %v %s, want 400 InvalidArgument", name, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestHeadFile(t *testing.T) {
+	a := newTestAdapter(t, nil)
+	const path = "/api/ag/remote/file"
+	const content = "some content"
+	before := time.Now().Add(-time.Second)
+	serve(a.PutFile, http.MethodPut, path, content, http.Header{"X-Amz-Meta-Owner": {"agent"}})
+
+	w := serve(a.HeadFile, http.MethodHead, path, "", nil)
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Fatalf("HEAD = %v %q, want 200 without a body", w.Code, w.Body.String())
+	}
+	digest := md5.Sum([]byte(content))
+	want := map[string]string{
+		"Content-Length":   strconv.Itoa(len(content)),
+		"Content-Type":     defaultContentType,
+		"Etag":             `"` + hex.EncodeToString(digest[:]) + `"`,
+		"Accept-Ranges":    "bytes",
+		"X-Amz-Meta-Owner": "agent",
+	}
+	for header, value := range want {
+		if got := w.Header().Get(header); got != value {
+			t.Fatalf("%v header = %q, want %q", header, got, value)
+		}
+	}
+	modified, err := http.ParseTime(w.Header().Get("Last-Modified"))
+	if err != nil || modified.Before(before.Truncate(time.Second)) || modified.After(time.Now()) {
+		t.Fatalf("Last-Modified = %q, err: %v", w.Header().Get("Last-Modified"), err)
+	}
+	if w.Header().Get(checksumSHA256Header) == "" {
+		t.Fatalf("HEAD has no %v header", checksumSHA256Header)
+	}
+
+	// the headers are the ones of GET
+	get := serve(a.GetFile, http.MethodGet, path, "", nil)
+	for _, header := range []string{"Content-Length", "Content-Type", "Etag", "Last-Modified", checksumSHA256Header, "X-Amz-Meta-Owner"} {
+		if get.Header().Get(header) != w.Header().Get(header) {
+			t.Fatalf("%v header of GET = %q, of HEAD %q", header, get.Header().Get(header), w.Header().Get(header))
+		}
+	}
+
+	for _, missing := range []string{"/api/ag/remote/missing", "/api/ag/remote"} {
+		for _, header := range []http.Header{nil, withXMLErrors(nil)} {
+			w := serve(a.HeadFile, http.MethodHead, missing, "", header)
+			if w.Code != http.StatusNotFound || w.Body.Len() != 0 {
+				t.Fatalf("HEAD %v = %v %q, want 404 without a body", missing, w.Code, w.Body.String())
+			}
+		}
+	}
+}
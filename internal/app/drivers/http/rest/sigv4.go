@@ -0,0 +1,318 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"openappsec.io/errors"
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/sigv4"
+)
+
+const (
+	authConfBaseKey   = "auth"
+	authModeConfKey   = authConfBaseKey + ".mode"
+	authRegionConfKey = authConfBaseKey + ".region"
+
+	sigV4Algorithm = sigv4.Algorithm
+	sigV4Service   = "s3"
+	sigV4Terminal  = "aws4_request"
+	amzDateHeader  = "X-Amz-Date"
+	amzDateFormat  = sigv4.DateFormat
+	tenantIDHeader = "X-Tenant-Id"
+
+	// maxRequestSkew is how far the time a request was signed at may be from the server time, as in S3
+	maxRequestSkew = 15 * time.Minute
+	// maxPresignedExpiry is the longest validity of a presigned request, as in S3
+	maxPresignedExpiry = 7 * 24 * time.Hour
+)
+
+// authMode defines which requests are authenticated
+type authMode string
+
+const (
+	// authOff ignores request signatures, the tenant is taken from the X-Tenant-Id header. it is only meant for
+	// deployments whose network lets nothing but trusted services reach the /api routes
+	authOff authMode = "off"
+	// authRequired rejects unsigned requests, the tenant is the tenant of the access key a request is signed with.
+	// there is no mode serving both, an unsigned request could claim any tenant with its X-Tenant-Id header
+	authRequired authMode = "required"
+)
+
+func parseAuthMode(value string) (authMode, bool) {
+	switch mode := authMode(strings.ToLower(strings.TrimSpace(value))); mode {
+	case authOff, authRequired:
+		return mode, true
+	default:
+		return "", false
+	}
+}
+
+// authenticatedTenantKey is the context key of the tenant of the access key a request is signed with
+type authenticatedTenantKey struct{}
+
+// authenticatedTenant returns the tenant a request was authenticated for, if it was
+func authenticatedTenant(ctx context.Context) (string, bool) {
+	tenantID, ok := ctx.Value(authenticatedTenantKey{}).(string)
+	return tenantID, ok
+}
+
+func accessDeniedError(format string, args ...interface{}) error {
+	return errors.Errorf(format, args...).SetClass(errors.ClassUnauthorized)
+}
+
+// signature is the SigV4 authentication of a request, sent in its Authorization header or presigned in its query
+type signature struct {
+	accessKeyID   string
+	scopeDate     string
+	region        string
+	signedHeaders []string
+	value         string
+	// amzDate is the time the request was signed at, as sent
+	amzDate     string
+	signedAt    time.Time
+	payloadHash string
+	presigned   bool
+	expires     time.Duration
+}
+
+// parseSignature parses the SigV4 authentication of a request, it returns nil when the request isn't signed
+func parseSignature(r *http.Request) (*signature, error) {
+	authorization := r.Header.Get("Authorization")
+	query := r.URL.Query()
+	switch {
+	case strings.HasPrefix(authorization, sigV4Algorithm+" "):
+		return parseAuthorizationHeader(r, authorization)
+	case authorization != "":
+		return nil, accessDeniedError("unsupported authorization scheme")
+	case query.Has("X-Amz-Algorithm"):
+		return parsePresignedQuery(r, query)
+	default:
+		return nil, nil
+	}
+}
+
+// parseAuthorizationHeader parses the Authorization header of a header signed request
+func parseAuthorizationHeader(r *http.Request, authorization string) (*signature, error) {
+	sig := &signature{}
+	var credential, signedHeaders string
+	for _, component := range strings.Split(strings.TrimPrefix(authorization, sigV4Algorithm+" "), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(component), "=")
+		switch name {
+		case "Credential":
+			credential = value
+		case "SignedHeaders":
+			signedHeaders = value
+		case "Signature":
+			sig.value = value
+		}
+	}
+	if credential == "" || signedHeaders == "" || sig.value == "" {
+		return nil, invalidArgumentError("malformed authorization header")
+	}
+	if err := sig.parseCredential(credential, signedHeaders); err != nil {
+		return nil, err
+	}
+	sig.amzDate = r.Header.Get(amzDateHeader)
+	if sig.amzDate == "" {
+		return nil, invalidArgumentError("missing %v header", amzDateHeader)
+	}
+	sig.payloadHash = r.Header.Get(contentSHA256Header)
+	if sig.payloadHash == "" {
+		return nil, invalidArgumentError("missing %v header", contentSHA256Header)
+	}
+	for _, required := range []string{"host", strings.ToLower(amzDateHeader), contentSHA256Header} {
+		if !sig.signs(required) {
+			return nil, accessDeniedError("header %v must be signed", required)
+		}
+	}
+	return sig, sig.parseDate()
+}
+
+// parsePresignedQuery parses the query parameters of a presigned request
+func parsePresignedQuery(r *http.Request, query url.Values) (*signature, error) {
+	if algorithm := query.Get("X-Amz-Algorithm"); algorithm != sigV4Algorithm {
+		return nil, accessDeniedError("unsupported signing algorithm %q", algorithm)
+	}
+	sig := &signature{presigned: true, value: query.Get("X-Amz-Signature"), amzDate: query.Get("X-Amz-Date")}
+	if sig.value == "" || sig.amzDate == "" {
+		return nil, invalidArgumentError("malformed presigned query")
+	}
+	if err := sig.parseCredential(query.Get("X-Amz-Credential"), query.Get("X-Amz-SignedHeaders")); err != nil {
+		return nil, err
+	}
+	if !sig.signs("host") {
+		return nil, accessDeniedError("header host must be signed")
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires < 1 || time.Duration(expires)*time.Second > maxPresignedExpiry {
+		return nil, invalidArgumentError("invalid X-Amz-Expires %q", query.Get("X-Amz-Expires"))
+	}
+	sig.expires = time.Duration(expires) * time.Second
+	// the payload of a presigned request isn't signed, unless the client sent its digest along
+	sig.payloadHash = sigv4.UnsignedPayload
+	if value := r.Header.Get(contentSHA256Header); value != "" {
+		sig.payloadHash = value
+	}
+	return sig, sig.parseDate()
+}
+
+// parseCredential parses the credential scope and the signed headers of a signature
+func (s *signature) parseCredential(credential string, signedHeaders string) error {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[0] == "" {
+		return invalidArgumentError("malformed credential %q", credential)
+	}
+	if parts[3] != sigV4Service || parts[4] != sigV4Terminal {
+		return invalidArgumentError("invalid credential scope %q", credential)
+	}
+	s.accessKeyID, s.scopeDate, s.region = parts[0], parts[1], parts[2]
+	if signedHeaders == "" {
+		return invalidArgumentError("missing signed headers")
+	}
+	s.signedHeaders = strings.Split(signedHeaders, ";")
+	return nil
+}
+
+// parseDate parses the time the request was signed at, which must be the day of the credential scope
+func (s *signature) parseDate() error {
+	signedAt, err := time.Parse(amzDateFormat, s.amzDate)
+	if err != nil {
+		return invalidArgumentError("invalid %v %q", amzDateHeader, s.amzDate)
+	}
+	if s.amzDate[:8] != s.scopeDate {
+		return invalidArgumentError("credential scope date %v doesn't match %v", s.scopeDate, s.amzDate)
+	}
+	s.signedAt = signedAt
+	return nil
+}
+
+func (s *signature) signs(header string) bool {
+	for _, signed := range s.signedHeaders {
+		if signed == header {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTime checks that the request was signed recently enough, or that a presigned request hasn't expired
+func (s *signature) checkTime(now time.Time) error {
+	if s.signedAt.After(now.Add(maxRequestSkew)) || (!s.presigned && s.signedAt.Before(now.Add(-maxRequestSkew))) {
+		return errors.Errorf("request time %v is too far from the server time", s.amzDate).
+			SetClass(errors.ClassUnauthorized).SetLabel(models.ErrLabelRequestTimeSkewed)
+	}
+	if s.presigned && now.After(s.signedAt.Add(s.expires)) {
+		return accessDeniedError("presigned request expired at %v", s.signedAt.Add(s.expires))
+	}
+	return nil
+}
+
+// verify computes the signature of the request with secret and compares it with the signature it was sent with
+func (s *signature) verify(r *http.Request, secret string) error {
+	canonical := sigv4.CanonicalRequest(r, s.signedHeaders, s.payloadHash)
+	expected := sigv4.Signature(secret, s.amzDate, s.region, sigV4Service, canonical)
+	if !hmac.Equal([]byte(expected), []byte(s.value)) {
+		log.Debugf("signature mismatch, canonical request: %q", canonical)
+		return errors.Errorf("signature of access key %v doesn't match the request", s.accessKeyID).
+			SetClass(errors.ClassUnauthorized).SetLabel(models.ErrLabelSignatureMismatch)
+	}
+	return nil
+}
+
+// verifyPayload reads the body of a request into memory and checks it against its signed digest. the handlers
+// parse such bodies before reaching their end, so the digest can't be verified while they are read
+func verifyPayload(r *http.Request, digest []byte) error {
+	payload, err := io.ReadAll(io.LimitReader(r.Body, maxCompleteBodySize+1))
+	r.Body.Close()
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxCompleteBodySize {
+		return invalidArgumentError("request body exceeds %v bytes", maxCompleteBodySize)
+	}
+	if sum := sha256.Sum256(payload); !bytes.Equal(sum[:], digest) {
+		return errors.Errorf("payload doesn't match its signed %v", contentSHA256Header).
+			SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelBadDigest)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(payload))
+	return nil
+}
+
+// contentUpload is true for the requests whose content is verified against x-amz-content-sha256 by the storage,
+// see contentDigests
+func contentUpload(r *http.Request) bool {
+	return r.Method == http.MethodPut && !r.URL.Query().Has("tagging") && r.Header.Get(copySourceHeader) == ""
+}
+
+// authenticate is a middleware verifying the SigV4 signature of the requests. the tenant of a signed request is
+// the tenant of its access key, a different X-Tenant-Id header is rejected. the tenant is passed on in the request
+// context, where tenantID takes it from
+func (a *Adapter) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.authMode == authOff {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		sig, err := parseSignature(r)
+		if err == nil && sig == nil {
+			err = accessDeniedError("request is not signed")
+		}
+		var credential models.Credential
+		if err == nil {
+			credential, err = a.verifySignature(r, sig)
+		}
+		if err == nil && !contentUpload(r) {
+			if digest, decodeErr := hex.DecodeString(sig.payloadHash); decodeErr == nil {
+				err = verifyPayload(r, digest)
+			}
+		}
+		if err != nil {
+			log.WithContextAndEventID(ctx, "86e8a8dd-81df-493d-a4c2-52aa15844916").Warnf("failed to authenticate request. err: %v", err)
+			errorReturn(w, r, err)
+			return
+		}
+		log.WithContextAndEventID(ctx, "80b6d6db-5122-4e15-89c1-e0f8ba9d7d3a").Debugf(
+			"authenticated access key %v of tenant %v", credential.AccessKeyID, credential.TenantID,
+		)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, authenticatedTenantKey{}, credential.TenantID)))
+	})
+}
+
+// verifySignature verifies the signature of a request and returns the credential it was signed with
+func (a *Adapter) verifySignature(r *http.Request, sig *signature) (models.Credential, error) {
+	if a.authRegion != "" && sig.region != a.authRegion {
+		return models.Credential{}, invalidArgumentError("request signed for region %v instead of %v", sig.region, a.authRegion)
+	}
+	if strings.HasPrefix(sig.payloadHash, streamingPayloadPrefix) {
+		return models.Credential{}, invalidArgumentError("streaming payload signing %v is not supported", sig.payloadHash)
+	}
+	if err := sig.checkTime(time.Now()); err != nil {
+		return models.Credential{}, err
+	}
+	credential, ok := a.credentials.Lookup(sig.accessKeyID)
+	if !ok {
+		return models.Credential{}, errors.Errorf("unknown access key %v", sig.accessKeyID).
+			SetClass(errors.ClassUnauthorized).SetLabel(models.ErrLabelInvalidAccessKey)
+	}
+	if err := sig.verify(r, credential.SecretAccessKey); err != nil {
+		return models.Credential{}, err
+	}
+	if tenantID := r.Header.Get(tenantIDHeader); tenantID != "" && tenantID != credential.TenantID {
+		return models.Credential{}, accessDeniedError(
+			"access key %v doesn't grant access to tenant %v", credential.AccessKeyID, tenantID,
+		)
+	}
+	return credential, nil
+}
@@ -0,0 +1,167 @@
+package rest
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"openappsec.io/errors"
+	"openappsec.io/httputils/responses"
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+const (
+	// the tagging limits of S3
+	maxTags           = 10
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+
+	// maxTaggingBodySize caps the size of a tagging request body
+	maxTaggingBodySize = 64 << 10
+
+	// taggingCountHeader reports the number of tags of a file
+	taggingCountHeader = "x-amz-tagging-count"
+)
+
+type tag struct {
+	Key   string
+	Value string
+}
+
+type tagSet struct {
+	Tags []tag `xml:"Tag"`
+}
+
+type tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	TagSet  tagSet
+}
+
+func invalidTagError(format string, args ...interface{}) error {
+	return errors.Errorf(format, args...).SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidTag)
+}
+
+// validateTag checks a tag against the tagging limits
+func validateTag(key string, value string) error {
+	if key == "" || utf8.RuneCountInString(key) > maxTagKeyLength || !utf8.ValidString(key) {
+		return invalidTagError("invalid tag key %q", key)
+	}
+	if utf8.RuneCountInString(value) > maxTagValueLength || !utf8.ValidString(value) {
+		return invalidTagError("invalid value of tag %q", key)
+	}
+	return nil
+}
+
+// parseTagging decodes the tag set of a PutObjectTagging request body
+func parseTagging(body io.Reader) (map[string]string, error) {
+	var request tagging
+	if err := xml.NewDecoder(io.LimitReader(body, maxTaggingBodySize)).Decode(&request); err != nil {
+		return nil, invalidArgumentError("malformed tagging request. err: %v", err)
+	}
+	if len(request.TagSet.Tags) > maxTags {
+		return nil, invalidTagError("%v tags exceed the limit of %v", len(request.TagSet.Tags), maxTags)
+	}
+	tags := make(map[string]string, len(request.TagSet.Tags))
+	for _, t := range request.TagSet.Tags {
+		if err := validateTag(t.Key, t.Value); err != nil {
+			return nil, err
+		}
+		if _, ok := tags[t.Key]; ok {
+			return nil, invalidTagError("duplicate tag key %q", t.Key)
+		}
+		tags[t.Key] = t.Value
+	}
+	return tags, nil
+}
+
+// tagFilter parses the tag query parameters filtering a listing, each formatted as key=value
+func tagFilter(query url.Values) (map[string]string, error) {
+	values := query["tag"]
+	if len(values) == 0 {
+		return nil, nil
+	}
+	filter := make(map[string]string, len(values))
+	for _, value := range values {
+		key, tagValue, found := strings.Cut(value, "=")
+		if !found {
+			return nil, invalidArgumentError("invalid tag filter %q, expecting key=value", value)
+		}
+		if err := validateTag(key, tagValue); err != nil {
+			return nil, err
+		}
+		filter[key] = tagValue
+	}
+	return filter, nil
+}
+
+// PutFileTagging replaces the tags of the file with given path in uri, as in the S3 PutObjectTagging
+func (a *Adapter) PutFileTagging(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	path := strings.TrimPrefix(r.URL.Path, "/api/")
+	versionID := r.URL.Query().Get("versionId")
+	defer r.Body.Close()
+	tags, err := parseTagging(r.Body)
+	if err != nil {
+		log.WithContextAndEventID(ctx, "96d6b364-d2f6-46ff-8301-dcd72f29e566").Warnf("invalid put tagging request. err: %v", err)
+		errorReturn(w, r, err)
+		return
+	}
+	log.WithContextAndEventID(ctx, "a7ff3872-684e-485a-9f46-aadcef74211c").Infof("put tags of file: %v, tags: %v", path, tags)
+	a.setFileTags(w, r, path, versionID, tags, http.StatusOK)
+}
+
+// DeleteFileTagging removes the tags of the file with given path in uri, as in the S3 DeleteObjectTagging
+func (a *Adapter) DeleteFileTagging(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	path := strings.TrimPrefix(r.URL.Path, "/api/")
+	log.WithContextAndEventID(ctx, "0362bf52-dc16-4eb3-be30-7346b0f4cabf").Infof("delete tags of file: %v", path)
+	a.setFileTags(w, r, path, r.URL.Query().Get("versionId"), nil, http.StatusNoContent)
+}
+
+// setFileTags replaces the tags of a file and answers with code
+func (a *Adapter) setFileTags(w http.ResponseWriter, r *http.Request, path string, versionID string, tags map[string]string, code int) {
+	ctx := r.Context()
+	metadata, err := a.svc.SetFileTags(ctx, path, versionID, tags)
+	if err != nil {
+		if errors.IsClass(err, errors.ClassNotFound) {
+			log.WithContextAndEventID(ctx, "80bc6cea-2afb-4904-84b4-b00cf4219bcb").Infof("file %v not found", path)
+			errorReturn(w, r, err)
+			return
+		}
+		log.WithContextAndEventID(ctx, "898c788e-37c2-4427-b7be-07a2e55f2c73").Errorf("failed to set tags of file %v. err: %v", path, err)
+		errorReturn(w, r, err)
+		return
+	}
+	setVersionHeader(w, metadata)
+	responses.HTTPReturn(ctx, w, code, nil, true)
+}
+
+// GetFileTagging returns the tags of the file with given path in uri, as in the S3 GetObjectTagging
+func (a *Adapter) GetFileTagging(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	path := strings.TrimPrefix(r.URL.Path, "/api/")
+	log.WithContextAndEventID(ctx, "e2590c0f-6de5-4ef6-8d43-f134c49165ab").Infof("get tags of file: %v", path)
+	metadata, err := a.svc.StatFile(ctx, path, r.URL.Query().Get("versionId"))
+	if err != nil {
+		if errors.IsClass(err, errors.ClassNotFound) {
+			log.WithContextAndEventID(ctx, "bb5647d0-8064-4281-920e-f26b694ffe90").Infof("file %v not found", path)
+			errorReturn(w, r, err)
+			return
+		}
+		log.WithContextAndEventID(ctx, "5a2ef57f-331c-46f4-b924-21cbbcb0e107").Errorf("failed to get tags of file %v. err: %v", path, err)
+		errorReturn(w, r, err)
+		return
+	}
+	result := tagging{TagSet: tagSet{Tags: make([]tag, 0, len(metadata.Tags))}}
+	for key, value := range metadata.Tags {
+		result.TagSet.Tags = append(result.TagSet.Tags, tag{Key: key, Value: value})
+	}
+	sort.Slice(result.TagSet.Tags, func(i, j int) bool { return result.TagSet.Tags[i].Key < result.TagSet.Tags[j].Key })
+	setVersionHeader(w, metadata)
+	xmlReturn(w, r, http.StatusOK, result)
+}
@@ -0,0 +1,170 @@
+package rest
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// taggingBody returns a PutObjectTagging body of the key value pairs
+func taggingBody(pairs ...string) string {
+	body := "<Tagging><TagSet>"
+	for i := 0; i+1 < len(pairs); i += 2 {
+		body += "<Tag><Key>" + pairs[i] + "</Key><Value>" + pairs[i+1] + "</Value></Tag>"
+	}
+	return body + "</TagSet></Tagging>"
+}
+
+// fileTags returns the tags of the file with given path, as pairs sorted by key
+func fileTags(t *testing.T, a *Adapter, path string) []tag {
+	t.Helper()
+	w := serve(a.GetFile, http.MethodGet, path+"?tagging", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %v?tagging = %v, body: %s", path, w.Code, w.Body.String())
+	}
+	var result tagging
+	if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("failed to parse tagging %s: %v", w.Body.String(), err)
+	}
+	return result.TagSet.Tags
+}
+
+func TestFileTagging(t *testing.T) {
+	a := newTestAdapter(t, nil)
+	const path = "/api/ag/remote/file"
+	serve(a.PutFile, http.MethodPut, path, "content", nil)
+
+	if tags := fileTags(t, a, path); len(tags) != 0 {
+		t.Fatalf("tags of an untagged file = %v", tags)
+	}
+	w := serve(a.PutFile, http.MethodPut, path+"?tagging", taggingBody("stage", "prod", "owner", "agent"), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT ?tagging = %v, body: %s", w.Code, w.Body.String())
+	}
+	if tags := fileTags(t, a, path); !reflect.DeepEqual(tags, []tag{{"owner", "agent"}, {"stage", "prod"}}) {
+		t.Fatalf("tags = %v, want them sorted by key", tags)
+	}
+	if w := serve(a.HeadFile, http.MethodHead, path, "", nil); w.Header().Get(taggingCountHeader) != "2" {
+		t.Fatalf("HEAD reports %q tags, want 2", w.Header().Get(taggingCountHeader))
+	}
+	if w := serve(a.GetFile, http.MethodGet, path, "", nil); w.Body.String() != "content" {
+		t.Fatalf("content after tagging = %q", w.Body.String())
+	}
+
+	// a put replaces the whole tag set
+	serve(a.PutFile, http.MethodPut, path+"?tagging", taggingBody("stage", "dev"), nil)
+	if tags := fileTags(t, a, path); !reflect.DeepEqual(tags, []tag{{"stage", "dev"}}) {
+		t.Fatalf("tags after a second put = %v", tags)
+	}
+
+	w = serve(a.DeleteFile, http.MethodDelete, path+"?tagging", "", nil)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE ?tagging = %v, body: %s", w.Code, w.Body.String())
+	}
+	if tags := fileTags(t, a, path); len(tags) != 0 {
+		t.Fatalf("tags after delete = %v", tags)
+	}
+	if w := serve(a.GetFile, http.MethodGet, path, "", nil); w.Code != http.StatusOK {
+		t.Fatalf("deleting the tags deleted the file, GET = %v", w.Code)
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		handler := map[string]http.HandlerFunc{http.MethodGet: a.GetFile, http.MethodPut: a.PutFile, http.MethodDelete: a.DeleteFile}[method]
+		w := serve(handler, method, "/api/ag/remote/missing?tagging", taggingBody("k", "v"), withXMLErrors(nil))
+		if w.Code != http.StatusNotFound || errorCode(t, w) != "NoSuchKey" {
+			t.Fatalf("%v ?tagging of a missing file = %v %s, want 404 NoSuchKey", method, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestInvalidTagging(t *testing.T) {
+	a := newTestAdapter(t, nil)
+	const path = "/api/ag/remote/file"
+	serve(a.PutFile, http.MethodPut, path, "content", nil)
+	serve(a.PutFile, http.MethodPut, path+"?tagging", taggingBody("stage", "prod"), nil)
+
+	tooMany := []string{}
+	for i := 0; i <= maxTags; i++ {
+		tooMany = append(tooMany, "key"+strings.Repeat("k", i), "value")
+	}
+	tests := []struct {
+		name string
+		body string
+		code string
+	}{
+		{"malformed", "<Tagging><TagSet>", "InvalidArgument"},
+		{"too many tags", taggingBody(tooMany...), "InvalidTag"},
+		{"empty key", taggingBody("", "value"), "InvalidTag"},
+		{"long key", taggingBody(strings.Repeat("k", maxTagKeyLength+1), "value"), "InvalidTag"},
+		{"long value", taggingBody("key", strings.Repeat("v", maxTagValueLength+1)), "InvalidTag"},
+		{"duplicate key", taggingBody("key", "a", "key", "b"), "InvalidTag"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := serve(a.PutFile, http.MethodPut, path+"?tagging", test.body, withXMLErrors(nil))
+			if w.Code != http.StatusBadRequest || errorCode(t, w) != test.code {
+				t.Fatalf("PUT ?tagging = %v %s, want 400 %v", w.Code, w.Body.String(), test.code)
+			}
+			if tags := fileTags(t, a, path); !reflect.DeepEqual(tags, []tag{{"stage", "prod"}}) {
+				t.Fatalf("a rejected tag set changed the tags to %v", tags)
+			}
+		})
+	}
+}
+
+func TestListingTagFilter(t *testing.T) {
+	a := newTestAdapter(t, nil)
+	for name, tags := range map[string]string{
+		"a": taggingBody("stage", "prod", "owner", "agent"),
+		"b": taggingBody("stage", "prod"),
+		"c": taggingBody("stage", "dev", "owner", "agent"),
+		"d": "",
+	} {
+		serve(a.PutFile, http.MethodPut, "/api/ag/remote/"+name, name, nil)
+		if tags != "" {
+			serve(a.PutFile, http.MethodPut, "/api/ag/remote/"+name+"?tagging", tags, nil)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter []string
+		want   []string
+	}{
+		{"no filter", nil, []string{"ag/remote/a", "ag/remote/b", "ag/remote/c", "ag/remote/d"}},
+		{"single tag", []string{"stage=prod"}, []string{"ag/remote/a", "ag/remote/b"}},
+		{"all tags must match", []string{"stage=prod", "owner=agent"}, []string{"ag/remote/a"}},
+		{"empty value", []string{"stage="}, []string{}},
+		{"no match", []string{"stage=test"}, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page := listFiles(t, a, url.Values{"prefix": {"ag/remote/"}, "tag": test.filter})
+			if got := keys(page); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("keys = %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	// paging skips the files filtered out
+	query := url.Values{"prefix": {"ag/remote/"}, "tag": {"owner=agent"}, "max-keys": {"1"}}
+	page := listFiles(t, a, query)
+	if got := keys(page); !reflect.DeepEqual(got, []string{"ag/remote/a"}) || !page.IsTruncated {
+		t.Fatalf("first page = %v, truncated %v", got, page.IsTruncated)
+	}
+	query.Set("continuation-token", page.NextContinuationToken)
+	page = listFiles(t, a, query)
+	if got := keys(page); !reflect.DeepEqual(got, []string{"ag/remote/c"}) {
+		t.Fatalf("second page = %v", got)
+	}
+
+	for _, query := range []string{"tag=stage", "tag=prod&prefix=ag/remote/&delimiter=/"} {
+		w := serve(a.GetFilesList, http.MethodGet, "/api/?"+query, "", withXMLErrors(nil))
+		if w.Code != http.StatusBadRequest || errorCode(t, w) != "InvalidArgument" {
+			t.Fatalf("listing with %v = %v %s, want 400 InvalidArgument", query, w.Code, w.Body.String())
+		}
+	}
+}
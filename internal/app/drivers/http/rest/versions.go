@@ -0,0 +1,112 @@
+package rest
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+const (
+	versionIDHeader = "x-amz-version-id"
+	// maxListVersions caps the number of versions returned in a single listing page, as in S3
+	maxListVersions = 1000
+)
+
+type version struct {
+	Key          string
+	VersionID    string `xml:"VersionId"`
+	IsLatest     bool
+	LastModified string
+	ETag         string
+	Size         int64
+}
+
+type listVersionsResult struct {
+	XMLName             xml.Name `xml:"ListVersionsResult"`
+	Prefix              string
+	KeyMarker           string
+	VersionIDMarker     string `xml:"VersionIdMarker"`
+	NextKeyMarker       string `xml:",omitempty"`
+	NextVersionIDMarker string `xml:"NextVersionIdMarker,omitempty"`
+	MaxKeys             int
+	IsTruncated         bool
+	Versions            []version `xml:"Version"`
+}
+
+// setVersionHeader sets the version id of a stored file in the response, when it is versioned
+func setVersionHeader(w http.ResponseWriter, metadata models.FileMetadata) {
+	if metadata.VersionID != "" {
+		w.Header().Set(versionIDHeader, metadata.VersionID)
+	}
+}
+
+// listVersionsOptions parses the ListObjectVersions query parameters
+func listVersionsOptions(query url.Values) (models.ListVersionsOptions, error) {
+	options := models.ListVersionsOptions{
+		Prefix:          query.Get("prefix"),
+		KeyMarker:       query.Get("key-marker"),
+		VersionIDMarker: query.Get("version-id-marker"),
+		MaxKeys:         maxListVersions,
+	}
+	if maxKeys := query.Get("max-keys"); maxKeys != "" {
+		value, err := strconv.Atoi(maxKeys)
+		if err != nil || value < 1 {
+			return models.ListVersionsOptions{}, invalidArgumentError("invalid max-keys %q", maxKeys)
+		}
+		if value < maxListVersions {
+			options.MaxKeys = value
+		}
+	}
+	if options.VersionIDMarker != "" && options.KeyMarker == "" {
+		return models.ListVersionsOptions{}, invalidArgumentError("version-id-marker without key-marker")
+	}
+	return options, nil
+}
+
+// ListVersions lists the versions of the files with given prefix, as in the S3 ListObjectVersions (GET /?versions)
+func (a *Adapter) ListVersions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+	log.WithContextAndEventID(ctx, "b1f9d3f0-45f0-44ae-98a3-df2d9a3af27d").Infof("listing versions with query: %v", query)
+	options, err := listVersionsOptions(query)
+	if err != nil {
+		log.WithContextAndEventID(ctx, "79fa0a8a-76d0-4bc2-8ed5-da7bfb1d2eaa").Warnf("invalid list versions request. err: %v", err)
+		errorReturn(w, r, err)
+		return
+	}
+	list, err := a.svc.ListVersions(ctx, options)
+	if err != nil {
+		log.WithContextAndEventID(ctx, "c404d70d-822a-41f5-aa7f-6b96cf59adbf").Errorf("failed to list versions. err: %v", err)
+		errorReturn(w, r, err)
+		return
+	}
+	result := listVersionsResult{
+		Prefix:          options.Prefix,
+		KeyMarker:       options.KeyMarker,
+		VersionIDMarker: options.VersionIDMarker,
+		MaxKeys:         options.MaxKeys,
+		IsTruncated:     list.IsTruncated,
+		Versions:        make([]version, len(list.Versions)),
+	}
+	for i, fileVersion := range list.Versions {
+		result.Versions[i] = version{
+			Key:          fileVersion.Path,
+			VersionID:    fileVersion.VersionID,
+			IsLatest:     fileVersion.IsLatest,
+			LastModified: fileVersion.LastModified.UTC().Format(time.RFC3339),
+			ETag:         fileVersion.ETag,
+			Size:         fileVersion.Size,
+		}
+	}
+	if list.IsTruncated && len(result.Versions) > 0 {
+		last := result.Versions[len(result.Versions)-1]
+		result.NextKeyMarker = last.Key
+		result.NextVersionIDMarker = last.VersionID
+	}
+	xmlReturn(w, r, http.StatusOK, result)
+}
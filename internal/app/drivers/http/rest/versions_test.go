@@ -0,0 +1,160 @@
+package rest
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"openappsec.io/smartsync-shared-files/internal/pkg/testutil"
+)
+
+// newVersioningAdapter creates an adapter keeping the noncurrent versions of the files
+func newVersioningAdapter(t *testing.T) *Adapter {
+	return newTestAdapter(t, testutil.Configuration{"filesystem_db.versioning.enabled": true})
+}
+
+// putVersion stores content under path and returns its version id
+func putVersion(t *testing.T, a *Adapter, path string, content string) string {
+	t.Helper()
+	w := serve(a.PutFile, http.MethodPut, path, content, nil)
+	versionID := w.Header().Get(versionIDHeader)
+	if w.Code != http.StatusOK || versionID == "" {
+		t.Fatalf("PUT = %v, version id %q, body: %s", w.Code, versionID, w.Body.String())
+	}
+	return versionID
+}
+
+// listVersions returns the versions listing page of the query
+func listVersions(t *testing.T, a *Adapter, query url.Values) listVersionsResult {
+	t.Helper()
+	query.Set("versions", "")
+	w := serve(a.GetFilesList, http.MethodGet, "/api/?"+query.Encode(), "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("listing versions %v = %v, body: %s", query, w.Code, w.Body.String())
+	}
+	var list listVersionsResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("failed to parse versions listing %s: %v", w.Body.String(), err)
+	}
+	return list
+}
+
+func versionIDs(list listVersionsResult) []string {
+	ids := []string{}
+	for _, version := range list.Versions {
+		ids = append(ids, version.VersionID)
+	}
+	return ids
+}
+
+func TestVersionedReads(t *testing.T) {
+	a := newVersioningAdapter(t)
+	const path = "/api/ag/remote/file"
+	v1 := putVersion(t, a, path, "v1")
+	v2 := putVersion(t, a, path, "v2")
+
+	for versionID, content := range map[string]string{"": "v2", v1: "v1", v2: "v2"} {
+		w := serve(a.GetFile, http.MethodGet, path+"?versionId="+versionID, "", nil)
+		wantID := versionID
+		if wantID == "" {
+			wantID = v2
+		}
+		if w.Code != http.StatusOK || w.Body.String() != content || w.Header().Get(versionIDHeader) != wantID {
+			t.Fatalf("GET of version %q = %v %q, version id %q", versionID, w.Code, w.Body.String(), w.Header().Get(versionIDHeader))
+		}
+		w = serve(a.HeadFile, http.MethodHead, path+"?versionId="+versionID, "", nil)
+		if w.Code != http.StatusOK || w.Header().Get(versionIDHeader) != wantID {
+			t.Fatalf("HEAD of version %q = %v, version id %q", versionID, w.Code, w.Header().Get(versionIDHeader))
+		}
+	}
+	w := serve(a.GetFile, http.MethodGet, path+"?versionId=0123456789abcdef01234567", "", withXMLErrors(nil))
+	if w.Code != http.StatusNotFound || errorCode(t, w) != "NoSuchKey" {
+		t.Fatalf("GET of a missing version = %v %s, want 404 NoSuchKey", w.Code, w.Body.String())
+	}
+
+	// deleting the key keeps its versions readable
+	if w := serve(a.DeleteFile, http.MethodDelete, path, "", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE = %v", w.Code)
+	}
+	if w := serve(a.GetFile, http.MethodGet, path, "", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("GET after delete = %v, want 404", w.Code)
+	}
+	if w := serve(a.GetFile, http.MethodGet, path+"?versionId="+v1, "", nil); w.Body.String() != "v1" {
+		t.Fatalf("GET of version %v after delete = %v %q", v1, w.Code, w.Body.String())
+	}
+
+	// deleting a version removes it only
+	w = serve(a.DeleteFile, http.MethodDelete, path+"?versionId="+v1, "", nil)
+	if w.Code != http.StatusNoContent || w.Header().Get(versionIDHeader) != v1 {
+		t.Fatalf("DELETE of version %v = %v, version id %q", v1, w.Code, w.Header().Get(versionIDHeader))
+	}
+	if w := serve(a.GetFile, http.MethodGet, path+"?versionId="+v1, "", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("GET of deleted version %v = %v, want 404", v1, w.Code)
+	}
+	if w := serve(a.GetFile, http.MethodGet, path+"?versionId="+v2, "", nil); w.Body.String() != "v2" {
+		t.Fatalf("GET of version %v = %v %q", v2, w.Code, w.Body.String())
+	}
+}
+
+func TestListVersions(t *testing.T) {
+	a := newVersioningAdapter(t)
+	a1 := putVersion(t, a, "/api/ag/remote/a", "a1")
+	a2 := putVersion(t, a, "/api/ag/remote/a", "a2 longer")
+	b1 := putVersion(t, a, "/api/ag/remote/b", "b1")
+
+	list := listVersions(t, a, url.Values{"prefix": {"ag/remote/"}})
+	want := []version{
+		{Key: "ag/remote/a", VersionID: a2, IsLatest: true, Size: 9},
+		{Key: "ag/remote/a", VersionID: a1, IsLatest: false, Size: 2},
+		{Key: "ag/remote/b", VersionID: b1, IsLatest: true, Size: 2},
+	}
+	for i := range list.Versions {
+		if list.Versions[i].LastModified == "" || list.Versions[i].ETag == "" {
+			t.Fatalf("version %+v has no last modified time or entity tag", list.Versions[i])
+		}
+		list.Versions[i].LastModified, list.Versions[i].ETag = "", ""
+	}
+	if !reflect.DeepEqual(list.Versions, want) || list.IsTruncated || list.MaxKeys != maxListVersions {
+		t.Fatalf("versions = %+v, truncated %v, max keys %v, want %+v", list.Versions, list.IsTruncated, list.MaxKeys, want)
+	}
+
+	// pages resume after the key and version markers
+	query := url.Values{"prefix": {"ag/remote/"}, "max-keys": {"2"}}
+	page := listVersions(t, a, query)
+	if got := versionIDs(page); !reflect.DeepEqual(got, []string{a2, a1}) || !page.IsTruncated ||
+		page.NextKeyMarker != "ag/remote/a" || page.NextVersionIDMarker != a1 {
+		t.Fatalf("first page = %v, truncated %v, next markers %q %q", got, page.IsTruncated, page.NextKeyMarker, page.NextVersionIDMarker)
+	}
+	query.Set("key-marker", page.NextKeyMarker)
+	query.Set("version-id-marker", page.NextVersionIDMarker)
+	page = listVersions(t, a, query)
+	if got := versionIDs(page); !reflect.DeepEqual(got, []string{b1}) || page.IsTruncated || page.NextKeyMarker != "" {
+		t.Fatalf("second page = %v, truncated %v, next key marker %q", got, page.IsTruncated, page.NextKeyMarker)
+	}
+	// a key marker alone skips every version of the key
+	page = listVersions(t, a, url.Values{"prefix": {"ag/remote/"}, "key-marker": {"ag/remote/a"}})
+	if got := versionIDs(page); !reflect.DeepEqual(got, []string{b1}) {
+		t.Fatalf("versions after key marker = %v", got)
+	}
+
+	for _, query := range []string{"versions&max-keys=0", "versions&max-keys=x", "versions&version-id-marker=" + a1} {
+		w := serve(a.GetFilesList, http.MethodGet, "/api/?"+query, "", withXMLErrors(nil))
+		if w.Code != http.StatusBadRequest || errorCode(t, w) != "InvalidArgument" {
+			t.Fatalf("listing versions with %v = %v %s, want 400 InvalidArgument", query, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestUnversionedFiles(t *testing.T) {
+	a := newTestAdapter(t, nil)
+	const path = "/api/ag/remote/file"
+	if w := serve(a.PutFile, http.MethodPut, path, "v1", nil); w.Header().Get(versionIDHeader) != "" {
+		t.Fatalf("PUT without versioning returned version id %q", w.Header().Get(versionIDHeader))
+	}
+	serve(a.PutFile, http.MethodPut, path, "v2", nil)
+	if got := versionIDs(listVersions(t, a, url.Values{"prefix": {"ag/remote/"}})); len(got) != 1 {
+		t.Fatalf("versions without versioning = %v, want the single current one", got)
+	}
+}
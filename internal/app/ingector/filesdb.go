@@ -0,0 +1,79 @@
+package ingector
+
+import (
+	"openappsec.io/errors"
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/app"
+	"openappsec.io/smartsync-shared-files/internal/app/sharedfiles"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/filesystem"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/kv"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/memory"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/s3"
+)
+
+const (
+	fsConfigType = "filesystem_db.type"
+
+	fsTypeFilesystem = "filesystem"
+	fsTypeMemory     = "memory"
+	fsTypeS3         = "s3"
+	fsTypeKV         = "kv"
+)
+
+// FileSystem is a storage backend of the shared files, along with its control API
+type FileSystem interface {
+	sharedfiles.FileSystem
+	app.FileSystemDriven
+}
+
+// FileSystemConfiguration is the configuration of all the storage backends
+type FileSystemConfiguration interface {
+	filesystem.Configuration
+	memory.Configuration
+	s3.Configuration
+	kv.Configuration
+}
+
+// NewFileSystem creates the storage backend selected by filesystem_db.type, the filesystem backend by default.
+// each backend validates its own configuration, so a misconfigured backend fails the startup
+func NewFileSystem(conf FileSystemConfiguration) (FileSystem, error) {
+	fsType, err := conf.GetString(fsConfigType)
+	if err != nil {
+		if !errors.IsClass(err, errors.ClassNotFound) {
+			return nil, err
+		}
+		fsType = fsTypeFilesystem
+	}
+	log.Infof("using %v storage backend", fsType)
+	switch fsType {
+	case fsTypeFilesystem:
+		adapter, err := filesystem.NewAdapter(conf)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid filesystem backend configuration")
+		}
+		return adapter, nil
+	case fsTypeMemory:
+		adapter, err := memory.NewAdapter(conf)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid memory backend configuration")
+		}
+		return adapter, nil
+	case fsTypeS3:
+		adapter, err := s3.NewAdapter(conf)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid s3 backend configuration")
+		}
+		return adapter, nil
+	case fsTypeKV:
+		adapter, err := kv.NewAdapter(conf)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid kv backend configuration")
+		}
+		return adapter, nil
+	default:
+		return nil, errors.Errorf(
+			"invalid %v %q, expecting %v, %v, %v or %v",
+			fsConfigType, fsType, fsTypeFilesystem, fsTypeMemory, fsTypeS3, fsTypeKV,
+		).SetClass(errors.ClassBadInput)
+	}
+}
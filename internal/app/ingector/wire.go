@@ -12,7 +12,7 @@ import (
 	"openappsec.io/smartsync-shared-files/internal/app"
 	"openappsec.io/smartsync-shared-files/internal/app/drivers/http/rest"
 	"openappsec.io/smartsync-shared-files/internal/app/sharedfiles"
-	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/filesystem"
+	"openappsec.io/smartsync-shared-files/internal/pkg/credentials"
 	"openappsec.io/configuration"
 	"openappsec.io/configuration/viper"
 	"openappsec.io/health"
@@ -27,13 +27,18 @@ func InitializeApp(ctx context.Context) (*app.App, error) {
 		configuration.NewConfigurationService,
 		wire.Bind(new(rest.Configuration), new(*configuration.Service)),
 		wire.Bind(new(app.Configuration), new(*configuration.Service)),
-		wire.Bind(new(filesystem.Configuration), new(*configuration.Service)),
+		wire.Bind(new(FileSystemConfiguration), new(*configuration.Service)),
+		wire.Bind(new(credentials.Configuration), new(*configuration.Service)),
 
 		sharedfiles.NewSharedFilesService,
 		wire.Bind(new(rest.SharedFilesService), new(*sharedfiles.Service)),
 
-		filesystem.NewAdapter,
-		wire.Bind(new(sharedfiles.FileSystem), new(*filesystem.Adapter)),
+		NewFileSystem,
+		wire.Bind(new(sharedfiles.FileSystem), new(FileSystem)),
+		wire.Bind(new(app.FileSystemDriven), new(FileSystem)),
+
+		credentials.NewStore,
+		wire.Bind(new(rest.CredentialStore), new(*credentials.Store)),
 
 		health.NewService,
 		wire.Bind(new(rest.HealthService), new(*health.Service)),
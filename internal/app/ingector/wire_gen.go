@@ -10,7 +10,7 @@ import (
 	"openappsec.io/smartsync-shared-files/internal/app"
 	"openappsec.io/smartsync-shared-files/internal/app/drivers/http/rest"
 	"openappsec.io/smartsync-shared-files/internal/app/sharedfiles"
-	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/filesystem"
+	"openappsec.io/smartsync-shared-files/internal/pkg/credentials"
 	"openappsec.io/configuration"
 	"openappsec.io/configuration/viper"
 	"openappsec.io/health"
@@ -25,18 +25,22 @@ func InitializeApp(ctx context.Context) (*app.App, error) {
 		return nil, err
 	}
 	healthService := health.NewService()
-	filesystemAdapter, err := filesystem.NewAdapter(service)
+	fileSystem, err := NewFileSystem(service)
 	if err != nil {
 		return nil, err
 	}
-	sharedfilesService, err := sharedfiles.NewSharedFilesService(filesystemAdapter)
+	sharedfilesService, err := sharedfiles.NewSharedFilesService(fileSystem)
 	if err != nil {
 		return nil, err
 	}
-	restAdapter, err := rest.NewHTTPAdapter(ctx, service, healthService, sharedfilesService)
+	store, err := credentials.NewStore(service)
 	if err != nil {
 		return nil, err
 	}
-	appApp := app.NewApp(restAdapter, service, healthService)
+	restAdapter, err := rest.NewHTTPAdapter(ctx, service, healthService, sharedfilesService, store)
+	if err != nil {
+		return nil, err
+	}
+	appApp := app.NewApp(restAdapter, service, healthService, fileSystem)
 	return appApp, nil
 }
@@ -0,0 +1,76 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sharedfiles
+
+import (
+	"context"
+	"io"
+
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+//CreateMultipartUpload starts a multipart upload of a file in repo, and returns its id
+func (svc *Service) CreateMultipartUpload(ctx context.Context, path string, attributes models.ObjectAttributes) (string, error) {
+	namespace, err := tenantNamespace(ctx)
+	if err != nil {
+		return "", err
+	}
+	log.WithContext(ctx).Debugf("create multipart upload of file %v in storage", path)
+	return svc.fs.CreateMultipartUpload(ctx, namespace+path, attributes)
+}
+
+//UploadPart stores a part of a multipart upload streamed from content
+func (svc *Service) UploadPart(ctx context.Context, path string, uploadID string, partNumber int, content io.Reader, options models.PutOptions) (models.Part, error) {
+	namespace, err := tenantNamespace(ctx)
+	if err != nil {
+		return models.Part{}, err
+	}
+	return svc.fs.UploadPart(ctx, namespace+path, uploadID, partNumber, content, options)
+}
+
+//ListParts list a page of the parts uploaded to a multipart upload
+func (svc *Service) ListParts(ctx context.Context, path string, uploadID string, options models.ListPartsOptions) (models.PartsList, error) {
+	namespace, err := tenantNamespace(ctx)
+	if err != nil {
+		return models.PartsList{}, err
+	}
+	return svc.fs.ListParts(ctx, namespace+path, uploadID, options)
+}
+
+//CompleteMultipartUpload assembles a file in repo from the parts of a multipart upload, and returns its metadata
+func (svc *Service) CompleteMultipartUpload(ctx context.Context, path string, uploadID string, parts []models.CompletedPart, options models.PutOptions) (models.FileMetadata, error) {
+	namespace, err := tenantNamespace(ctx)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	isTemp := models.IsTempFile(path)
+	log.WithContext(ctx).Debugf("complete multipart upload of file %v in storage, is temp: %v", path, isTemp)
+	metadata, err := svc.fs.CompleteMultipartUpload(ctx, namespace+path, uploadID, parts, isTemp, options)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	metadata.Path = path
+	return metadata, nil
+}
+
+//AbortMultipartUpload removes a multipart upload along with its parts
+func (svc *Service) AbortMultipartUpload(ctx context.Context, path string, uploadID string) error {
+	namespace, err := tenantNamespace(ctx)
+	if err != nil {
+		return err
+	}
+	log.WithContext(ctx).Debugf("abort multipart upload of file %v in storage", path)
+	return svc.fs.AbortMultipartUpload(ctx, namespace+path, uploadID)
+}
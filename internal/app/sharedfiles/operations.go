@@ -2,24 +2,127 @@ package sharedfiles
 
 import (
 	"context"
+	"io"
+	"strings"
 
-	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/ctxutils"
 	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
 )
 
-//GetFilesList list files in repo
-func (svc *Service) GetFilesList(ctx context.Context, pathPrefix string) ([]models.FileMetadata, error) {
-	return svc.fs.GetFilesList(ctx, pathPrefix)
+// tenantNamespace returns the storage namespace of the tenant the request belongs to
+func tenantNamespace(ctx context.Context) (string, error) {
+	return models.TenantNamespace(ctxutils.ExtractString(ctx, ctxutils.ContextKeyTenantID))
+}
+
+//GetFilesList list a page of files in repo
+func (svc *Service) GetFilesList(ctx context.Context, options models.ListOptions) (models.FilesList, error) {
+	namespace, err := tenantNamespace(ctx)
+	if err != nil {
+		return models.FilesList{}, err
+	}
+	options.Prefix = namespace + options.Prefix
+	if options.StartAfter != "" {
+		options.StartAfter = namespace + options.StartAfter
+	}
+	list, err := svc.fs.GetFilesList(ctx, options)
+	if err != nil {
+		return models.FilesList{}, err
+	}
+	for i := range list.Files {
+		list.Files[i].Path = strings.TrimPrefix(list.Files[i].Path, namespace)
+	}
+	for i := range list.CommonPrefixes {
+		list.CommonPrefixes[i] = strings.TrimPrefix(list.CommonPrefixes[i], namespace)
+	}
+	return list, nil
+}
+
+//GetFile get a reader streaming file content, and file metadata from repo
+func (svc *Service) GetFile(ctx context.Context, path string, options models.GetOptions) (io.ReadCloser, models.FileMetadata, error) {
+	namespace, err := tenantNamespace(ctx)
+	if err != nil {
+		return nil, models.FileMetadata{}, err
+	}
+	content, metadata, err := svc.fs.GetFile(ctx, namespace+path, options)
+	if err != nil {
+		return nil, models.FileMetadata{}, err
+	}
+	metadata.Path = path
+	return content, metadata, nil
 }
 
-//GetFile get file content from repo
-func (svc *Service) GetFile(ctx context.Context, path string) ([]byte, error) {
-	return svc.fs.GetFile(ctx, path)
+//StatFile get file metadata from repo, or the metadata of one of its versions, without its content
+func (svc *Service) StatFile(ctx context.Context, path string, versionID string) (models.FileMetadata, error) {
+	namespace, err := tenantNamespace(ctx)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	metadata, err := svc.fs.StatFile(ctx, namespace+path, versionID)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	metadata.Path = path
+	return metadata, nil
 }
 
-//PutFile stores file in repo
-func (svc *Service) PutFile(ctx context.Context, path string, content []byte) error {
+//PutFile stores file streamed from content in repo, if its preconditions are met, and returns its metadata
+func (svc *Service) PutFile(ctx context.Context, path string, content io.Reader, options models.PutOptions) (models.FileMetadata, error) {
+	namespace, err := tenantNamespace(ctx)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
 	isTemp := models.IsTempFile(path)
 	log.WithContext(ctx).Debugf("put file %v in storage, is temp: %v", path, isTemp)
-	return svc.fs.PutFile(ctx, path, content, isTemp)
+	metadata, err := svc.fs.PutFile(ctx, namespace+path, content, isTemp, options)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	metadata.Path = path
+	return metadata, nil
+}
+
+//CopyFile copies a file in repo, the destination is classified as temp or persistent on its own,
+//whatever the classification of the source is
+func (svc *Service) CopyFile(ctx context.Context, srcPath string, dstPath string, options models.CopyOptions) (models.FileMetadata, error) {
+	namespace, err := tenantNamespace(ctx)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	isTemp := models.IsTempFile(dstPath)
+	log.WithContext(ctx).Debugf("copy file %v to %v in storage, is temp: %v", srcPath, dstPath, isTemp)
+	metadata, err := svc.fs.CopyFile(ctx, namespace+srcPath, namespace+dstPath, isTemp, options)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	metadata.Path = dstPath
+	return metadata, nil
+}
+
+//DeleteFile removes file, or one of its versions, from repo
+func (svc *Service) DeleteFile(ctx context.Context, path string, versionID string) error {
+	namespace, err := tenantNamespace(ctx)
+	if err != nil {
+		return err
+	}
+	log.WithContext(ctx).Debugf("delete file %v from storage, version: %v", path, versionID)
+	return svc.fs.DeleteFile(ctx, namespace+path, versionID)
+}
+
+//DeleteFiles removes a batch of files from repo, returning the outcome of each deletion
+func (svc *Service) DeleteFiles(ctx context.Context, paths []string) ([]models.DeleteResult, error) {
+	namespace, err := tenantNamespace(ctx)
+	if err != nil {
+		return []models.DeleteResult{}, err
+	}
+	storagePaths := make([]string, len(paths))
+	for i, path := range paths {
+		storagePaths[i] = namespace + path
+	}
+	log.WithContext(ctx).Debugf("delete %v files from storage", len(paths))
+	results := svc.fs.DeleteFiles(ctx, storagePaths)
+	for i := range results {
+		results[i].Path = strings.TrimPrefix(results[i].Path, namespace)
+	}
+	return results, nil
 }
@@ -0,0 +1,155 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sharedfiles
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"openappsec.io/ctxutils"
+	"openappsec.io/errors"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/filesystem"
+	"openappsec.io/smartsync-shared-files/internal/pkg/testutil"
+)
+
+// newService creates a service storing the files of every tenant under a new filesystem root
+func newService(t *testing.T, ttl time.Duration) *Service {
+	fs, err := filesystem.NewAdapter(testutil.Configuration{
+		"filesystem_db.root":           t.TempDir() + "/",
+		"filesystem_db.ttl":            ttl,
+		"filesystem_db.sweep_interval": 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewAdapter() failed: %v", err)
+	}
+	t.Cleanup(func() { fs.TearDown(context.Background()) })
+	svc, err := NewSharedFilesService(fs)
+	if err != nil {
+		t.Fatalf("NewSharedFilesService() failed: %v", err)
+	}
+	return svc
+}
+
+func tenantContext(tenantID string) context.Context {
+	return ctxutils.Insert(context.Background(), ctxutils.ContextKeyTenantID, tenantID)
+}
+
+func put(t *testing.T, svc *Service, tenantID string, path string, content string) {
+	t.Helper()
+	if _, err := svc.PutFile(tenantContext(tenantID), path, strings.NewReader(content), models.PutOptions{}); err != nil {
+		t.Fatalf("PutFile(%v) of tenant %v failed: %v", path, tenantID, err)
+	}
+}
+
+// read returns the content of path for the tenant, or false if it doesn't exist
+func read(t *testing.T, svc *Service, tenantID string, path string) (string, bool) {
+	t.Helper()
+	r, metadata, err := svc.GetFile(tenantContext(tenantID), path, models.GetOptions{})
+	if errors.IsClass(err, errors.ClassNotFound) {
+		return "", false
+	}
+	if err != nil {
+		t.Fatalf("GetFile(%v) of tenant %v failed: %v", path, tenantID, err)
+	}
+	defer r.Close()
+	if metadata.Path != path {
+		t.Fatalf("GetFile(%v) of tenant %v returned the path %v", path, tenantID, metadata.Path)
+	}
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to read %v of tenant %v: %v", path, tenantID, err)
+	}
+	return string(content), true
+}
+
+func TestTenantsShareNoKeys(t *testing.T) {
+	svc := newService(t, time.Hour)
+	put(t, svc, "t1", "ag/remote/file", "first tenant")
+	put(t, svc, "t2", "ag/remote/file", "second tenant")
+	put(t, svc, "t2", "ag/remote/other", "second tenant")
+
+	for tenantID, want := range map[string]string{"t1": "first tenant", "t2": "second tenant"} {
+		if content, _ := read(t, svc, tenantID, "ag/remote/file"); content != want {
+			t.Fatalf("content of tenant %v = %q, want %q", tenantID, content, want)
+		}
+	}
+
+	list, err := svc.GetFilesList(tenantContext("t1"), models.ListOptions{})
+	if err != nil {
+		t.Fatalf("GetFilesList() failed: %v", err)
+	}
+	var paths []string
+	for _, file := range list.Files {
+		paths = append(paths, file.Path)
+	}
+	if want := []string{"ag/remote/file"}; !reflect.DeepEqual(paths, want) {
+		t.Fatalf("files of tenant t1 = %v, want %v", paths, want)
+	}
+	list, err = svc.GetFilesList(tenantContext("t2"), models.ListOptions{Delimiter: "/"})
+	if err != nil {
+		t.Fatalf("GetFilesList() failed: %v", err)
+	}
+	if want := []string{"ag/"}; !reflect.DeepEqual(list.CommonPrefixes, want) {
+		t.Fatalf("common prefixes of tenant t2 = %v, want %v", list.CommonPrefixes, want)
+	}
+
+	if err := svc.DeleteFile(tenantContext("t1"), "ag/remote/file", ""); err != nil {
+		t.Fatalf("DeleteFile() failed: %v", err)
+	}
+	if _, ok := read(t, svc, "t1", "ag/remote/file"); ok {
+		t.Fatalf("file of tenant t1 wasn't deleted")
+	}
+	if _, ok := read(t, svc, "t2", "ag/remote/file"); !ok {
+		t.Fatalf("deleting the file of tenant t1 deleted the file of tenant t2")
+	}
+}
+
+func TestTenantSweep(t *testing.T) {
+	const ttl = 400 * time.Millisecond
+	svc := newService(t, ttl)
+	put(t, svc, "t1", "ag/tmp/file", "first tenant")
+	put(t, svc, "t1", "ag/remote/file", "first tenant")
+	time.Sleep(ttl / 2)
+	put(t, svc, "t2", "ag/tmp/file", "second tenant")
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, ok := read(t, svc, "t1", "ag/tmp/file"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("temp file of tenant t1 didn't expire")
+		}
+	}
+	if _, ok := read(t, svc, "t2", "ag/tmp/file"); !ok {
+		t.Fatalf("the expiration of the temp file of tenant t1 removed the temp file of tenant t2")
+	}
+	if _, ok := read(t, svc, "t1", "ag/remote/file"); !ok {
+		t.Fatalf("the expiration of a temp file removed a persistent file")
+	}
+}
+
+func TestInvalidTenant(t *testing.T) {
+	svc := newService(t, time.Hour)
+	for _, tenantID := range []string{"", "..", "t1/ag"} {
+		_, err := svc.GetFilesList(tenantContext(tenantID), models.ListOptions{})
+		if !errors.IsClass(err, errors.ClassBadInput) {
+			t.Fatalf("GetFilesList() of tenant %q failed with %v, want bad input", tenantID, err)
+		}
+	}
+}
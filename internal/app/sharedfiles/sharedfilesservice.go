@@ -15,6 +15,7 @@ package sharedfiles
 
 import (
 	"context"
+	"io"
 
 	"openappsec.io/smartsync-shared-files/internal/models"
 )
@@ -23,9 +24,20 @@ import (
 // To create mock for unittest please use this command
 // mockgen -destination mocks/mock_FileSystem.go -package mocks openappsec.io/smartsync-shared-files/internal/app/sharedfiles FileSystem
 type FileSystem interface {
-	GetFilesList(ctx context.Context, pathPrefix string) ([]models.FileMetadata, error)
-	GetFile(ctx context.Context, path string) ([]byte, error)
-	PutFile(ctx context.Context, path string, content []byte, isTemp bool) error
+	GetFilesList(ctx context.Context, options models.ListOptions) (models.FilesList, error)
+	GetFile(ctx context.Context, path string, options models.GetOptions) (io.ReadCloser, models.FileMetadata, error)
+	StatFile(ctx context.Context, path string, versionID string) (models.FileMetadata, error)
+	PutFile(ctx context.Context, path string, content io.Reader, isTemp bool, options models.PutOptions) (models.FileMetadata, error)
+	CopyFile(ctx context.Context, srcPath string, dstPath string, isTemp bool, options models.CopyOptions) (models.FileMetadata, error)
+	DeleteFile(ctx context.Context, path string, versionID string) error
+	DeleteFiles(ctx context.Context, paths []string) []models.DeleteResult
+	CreateMultipartUpload(ctx context.Context, path string, attributes models.ObjectAttributes) (string, error)
+	UploadPart(ctx context.Context, path string, uploadID string, partNumber int, content io.Reader, options models.PutOptions) (models.Part, error)
+	ListParts(ctx context.Context, path string, uploadID string, options models.ListPartsOptions) (models.PartsList, error)
+	CompleteMultipartUpload(ctx context.Context, path string, uploadID string, parts []models.CompletedPart, isTemp bool, options models.PutOptions) (models.FileMetadata, error)
+	AbortMultipartUpload(ctx context.Context, path string, uploadID string) error
+	ListVersions(ctx context.Context, options models.ListVersionsOptions) (models.VersionsList, error)
+	SetFileTags(ctx context.Context, path string, versionID string, tags map[string]string) (models.FileMetadata, error)
 }
 
 // Service struct
@@ -0,0 +1,36 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sharedfiles
+
+import (
+	"context"
+
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+//SetFileTags replaces the tags of a file, or of one of its versions, in repo. nil tags remove them
+func (svc *Service) SetFileTags(ctx context.Context, path string, versionID string, tags map[string]string) (models.FileMetadata, error) {
+	namespace, err := tenantNamespace(ctx)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	log.WithContext(ctx).Debugf("set %v tags of file %v in storage", len(tags), path)
+	metadata, err := svc.fs.SetFileTags(ctx, namespace+path, versionID, tags)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	metadata.Path = path
+	return metadata, nil
+}
@@ -0,0 +1,41 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sharedfiles
+
+import (
+	"context"
+	"strings"
+
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+//ListVersions list a page of the versions of files in repo
+func (svc *Service) ListVersions(ctx context.Context, options models.ListVersionsOptions) (models.VersionsList, error) {
+	namespace, err := tenantNamespace(ctx)
+	if err != nil {
+		return models.VersionsList{}, err
+	}
+	options.Prefix = namespace + options.Prefix
+	if options.KeyMarker != "" {
+		options.KeyMarker = namespace + options.KeyMarker
+	}
+	list, err := svc.fs.ListVersions(ctx, options)
+	if err != nil {
+		return models.VersionsList{}, err
+	}
+	for i := range list.Versions {
+		list.Versions[i].Path = strings.TrimPrefix(list.Versions[i].Path, namespace)
+	}
+	return list, nil
+}
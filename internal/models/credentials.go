@@ -0,0 +1,30 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+const (
+	// ErrLabelInvalidAccessKey labels errors caused by a request signed with an access key which doesn't exist
+	ErrLabelInvalidAccessKey = "invalid-access-key"
+	// ErrLabelSignatureMismatch labels errors caused by a request signature which doesn't match the request
+	ErrLabelSignatureMismatch = "signature-mismatch"
+	// ErrLabelRequestTimeSkewed labels errors caused by a signed request whose time is too far from the server time
+	ErrLabelRequestTimeSkewed = "request-time-skewed"
+)
+
+// Credential is an access key, the secret requests are signed with, and the tenant it grants access to
+type Credential struct {
+	AccessKeyID     string `json:"accessKeyId"`
+	SecretAccessKey string `json:"secretAccessKey"`
+	TenantID        string `json:"tenantId"`
+}
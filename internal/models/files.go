@@ -18,10 +18,251 @@ import (
 	"time"
 )
 
-// FileMetadata contains the path and last modified timestamp
+const (
+	// ErrLabelInvalidPath labels errors caused by a key which can't be safely mapped into the storage
+	ErrLabelInvalidPath = "invalid-path"
+	// ErrLabelInvalidArgument labels errors caused by a malformed request argument
+	ErrLabelInvalidArgument = "invalid-argument"
+	// ErrLabelInvalidRange labels errors caused by a byte range which can't be satisfied
+	ErrLabelInvalidRange = "invalid-range"
+	// ErrLabelPreconditionFailed labels errors caused by a conditional write whose precondition isn't met
+	ErrLabelPreconditionFailed = "precondition-failed"
+	// ErrLabelBadDigest labels errors caused by content which doesn't match the digest it was sent with
+	ErrLabelBadDigest = "bad-digest"
+	// ErrLabelNoSuchUpload labels errors caused by a multipart upload which doesn't exist, or was completed or aborted
+	ErrLabelNoSuchUpload = "no-such-upload"
+	// ErrLabelInvalidPart labels errors caused by completing a multipart upload with a part which wasn't uploaded
+	ErrLabelInvalidPart = "invalid-part"
+	// ErrLabelInvalidPartOrder labels errors caused by completing a multipart upload with parts out of order
+	ErrLabelInvalidPartOrder = "invalid-part-order"
+	// ErrLabelInvalidTag labels errors caused by a tag set which is malformed or exceeds the tagging limits
+	ErrLabelInvalidTag = "invalid-tag"
+	// ErrLabelEntityTooLarge labels errors caused by content exceeding the maximum object size
+	ErrLabelEntityTooLarge = "entity-too-large"
+	// ErrLabelRequestTimeout labels errors caused by a request body which stopped being sent
+	ErrLabelRequestTimeout = "request-timeout"
+	// ErrLabelServerTimeout labels errors caused by a request which wasn't handled within the server timeout
+	ErrLabelServerTimeout = "server-timeout"
+	// ErrLabelEntityTooSmall labels errors caused by completing a multipart upload with a part smaller than the minimum
+	ErrLabelEntityTooSmall = "entity-too-small"
+
+	// NullVersionID is the version id of content stored while versioning was off
+	NullVersionID = "null"
+	// MinPartSize is the minimum size of every part of a multipart upload but the last one, as in S3
+	MinPartSize = 5 << 20
+)
+
+// FileMetadata contains the path, last modified timestamp, size, entity tag, checksum and attributes of a file
 type FileMetadata struct {
 	Path         string
 	LastModified time.Time
+	Size         int64
+	ETag         string
+	// ChecksumSHA256 is the base64 encoded SHA-256 digest of the content
+	ChecksumSHA256 string
+	Attributes     ObjectAttributes
+	// VersionID identifies the version of the content, it is empty when the content isn't versioned
+	VersionID string
+	// Tags label the file, they can be replaced without rewriting its content
+	Tags map[string]string
+	// StoredSize is the size of the content at rest, it is smaller than Size when the content is stored compressed.
+	// zero when the storage doesn't report it
+	StoredSize int64
+	// StoredEncoding is the content coding the content is compressed with at rest, empty when it is stored as is
+	StoredEncoding string
+}
+
+// TagsMatch is true when tags holds every tag of filter with the same value
+func TagsMatch(tags map[string]string, filter map[string]string) bool {
+	for key, value := range filter {
+		if tagValue, ok := tags[key]; !ok || tagValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// ObjectAttributes are the representation headers and user metadata a file is stored with, and served with
+type ObjectAttributes struct {
+	ContentType     string `json:"contentType,omitempty"`
+	ContentEncoding string `json:"contentEncoding,omitempty"`
+	CacheControl    string `json:"cacheControl,omitempty"`
+	// UserMetadata maps the lowercase names of the x-amz-meta-* headers, without the prefix, to their values
+	UserMetadata map[string]string `json:"userMetadata,omitempty"`
+}
+
+// ListOptions defines which files to list and which page of the listing to return
+type ListOptions struct {
+	// Prefix limits the listing to keys starting with it
+	Prefix string
+	// StartAfter limits the listing to keys sorted after it
+	StartAfter string
+	// Delimiter rolls up the keys containing it after the prefix into common prefixes
+	Delimiter string
+	// MaxKeys limits the number of listed keys and common prefixes, zero means no limit
+	MaxKeys int
+	// Tags limits the listing to files labeled with all of them
+	Tags map[string]string
+}
+
+// FilesList is a single page of a listing, sorted by key
+type FilesList struct {
+	Files []FileMetadata
+	// CommonPrefixes are the prefixes keys were rolled up into when listing with a delimiter
+	CommonPrefixes []string
+	// IsTruncated is true when more keys are left after the last key of this page
+	IsTruncated bool
+}
+
+// LastKey returns the last key or common prefix of the page, the one sorted last
+func (l FilesList) LastKey() string {
+	last := ""
+	if len(l.Files) > 0 {
+		last = l.Files[len(l.Files)-1].Path
+	}
+	if len(l.CommonPrefixes) > 0 && l.CommonPrefixes[len(l.CommonPrefixes)-1] > last {
+		last = l.CommonPrefixes[len(l.CommonPrefixes)-1]
+	}
+	return last
+}
+
+// ByteRange is a single range of bytes of a file, as requested in a Range header
+type ByteRange struct {
+	// Start is the offset of the first byte, ignored for suffix ranges
+	Start int64
+	// End is the offset of the last byte, inclusive, or negative for an open ended range
+	End int64
+	// SuffixLength, when positive, makes the range the last SuffixLength bytes of the file
+	SuffixLength int64
+}
+
+// Resolve returns the offset and length of the range within a file of the given size,
+// ok is false when the range can't be satisfied
+func (r ByteRange) Resolve(size int64) (offset int64, length int64, ok bool) {
+	if r.SuffixLength > 0 {
+		if size == 0 {
+			return 0, 0, false
+		}
+		if r.SuffixLength > size {
+			return 0, size, true
+		}
+		return size - r.SuffixLength, r.SuffixLength, true
+	}
+	if r.Start >= size {
+		return 0, 0, false
+	}
+	end := r.End
+	if end < 0 || end >= size {
+		end = size - 1
+	}
+	return r.Start, end - r.Start + 1, true
+}
+
+// GetOptions defines which version of a file, and which part of it, to get
+type GetOptions struct {
+	// Range limits the content to a range of bytes, nil gets the whole file
+	Range *ByteRange
+	// VersionID, when set, gets a version of the file instead of the current one
+	VersionID string
+	// AcceptEncodings are the content codings the client accepts, "*" accepting any of them
+	AcceptEncodings []string
+}
+
+// ReturnsEncoded returns true if the content of a file stored compressed with encoding is returned as stored,
+// instead of decompressed. a range is always taken from the decompressed content
+func (o GetOptions) ReturnsEncoded(encoding string) bool {
+	if encoding == "" || o.Range != nil {
+		return false
+	}
+	for _, accepted := range o.AcceptEncodings {
+		if accepted == encoding || accepted == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// PutOptions defines the preconditions a write is conditioned on, the digests its content is verified with,
+// and the attributes it is stored with
+type PutOptions struct {
+	// IfMatch, when not empty, only writes the file if it exists and its entity tag is in this comma separated
+	// list of entity tags, or the list is "*"
+	IfMatch string
+	// IfNoneMatch only writes the file if it doesn't exist yet
+	IfNoneMatch bool
+	// ContentMD5, when set, is the MD5 digest the content must match
+	ContentMD5 []byte
+	// ChecksumSHA256, when set, is the SHA-256 digest the content must match
+	ChecksumSHA256 []byte
+	// Attributes are stored with the file
+	Attributes ObjectAttributes
+}
+
+// Satisfied returns true if a write with these options may replace the current file, exists is false when
+// there is no current file
+func (o PutOptions) Satisfied(etag string, exists bool) bool {
+	if o.IfNoneMatch && exists {
+		return false
+	}
+	if o.IfMatch != "" && (!exists || !ETagListMatches(o.IfMatch, etag, false)) {
+		return false
+	}
+	return true
+}
+
+// Conditional returns true if the write has any precondition
+func (o PutOptions) Conditional() bool {
+	return o.IfMatch != "" || o.IfNoneMatch
+}
+
+// ETagListMatches returns true if etag is in the comma separated list of entity tags, or the list is "*".
+// the weak comparison ignores the W/ prefix, the strong comparison never matches a weak entity tag
+func ETagListMatches(list string, etag string, weak bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+			continue
+		}
+		if candidate == etag && !strings.HasPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// EncodedETag returns the entity tag of a file returned compressed with encoding. the compressed bytes aren't
+// the content etag was computed over, so the representation gets a tag of its own, such as "<md5>-gzip"
+func EncodedETag(etag string, encoding string) string {
+	if etag == "" || encoding == "" {
+		return etag
+	}
+	if strings.HasSuffix(etag, "\"") {
+		return etag[:len(etag)-1] + "-" + encoding + "\""
+	}
+	return etag + "-" + encoding
+}
+
+// CopyOptions defines how a file is copied
+type CopyOptions struct {
+	// ReplaceMetadata gives the copy fresh metadata instead of the metadata of the source,
+	// the last modified time included
+	ReplaceMetadata bool
+	// Attributes are the metadata of the copy when ReplaceMetadata is set
+	Attributes ObjectAttributes
+	// SourceVersionID, when set, copies a version of the source instead of the current one
+	SourceVersionID string
+}
+
+// DeleteResult is the outcome of deleting a single file out of a batch
+type DeleteResult struct {
+	Path string
+	Err  error
 }
 
 // IsTempFile return true if a file is safe to delete
@@ -31,3 +272,58 @@ func IsTempFile(path string) bool {
 		strings.Contains(path, "/tuning") ||
 		strings.Contains(path, "attributes.data"))
 }
+
+// Part is a part of a multipart upload
+type Part struct {
+	PartNumber   int
+	LastModified time.Time
+	Size         int64
+	ETag         string
+}
+
+// ListPartsOptions defines which page of the parts of a multipart upload to list
+type ListPartsOptions struct {
+	// PartNumberMarker limits the listing to parts numbered after it
+	PartNumberMarker int
+	// MaxParts limits the number of listed parts, zero means no limit
+	MaxParts int
+}
+
+// PartsList is a single page of the parts of a multipart upload, sorted by part number
+type PartsList struct {
+	Parts []Part
+	// IsTruncated is true when more parts are left after the last part of this page
+	IsTruncated bool
+}
+
+// CompletedPart is a part of a multipart upload the file is assembled from, as listed by the client
+type CompletedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// ListVersionsOptions defines which versions to list and which page of the listing to return
+type ListVersionsOptions struct {
+	// Prefix limits the listing to keys starting with it
+	Prefix string
+	// KeyMarker limits the listing to keys sorted after it, or to versions of it older than VersionIDMarker
+	KeyMarker string
+	// VersionIDMarker limits the listing of KeyMarker to versions older than it
+	VersionIDMarker string
+	// MaxKeys limits the number of listed versions, zero means no limit
+	MaxKeys int
+}
+
+// FileVersion is a version of a file
+type FileVersion struct {
+	FileMetadata
+	// IsLatest is true for the current version of the file
+	IsLatest bool
+}
+
+// VersionsList is a single page of a versions listing, sorted by key and from the newest version to the oldest
+type VersionsList struct {
+	Versions []FileVersion
+	// IsTruncated is true when more versions are left after the last version of this page
+	IsTruncated bool
+}
@@ -0,0 +1,56 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"net/url"
+	"strings"
+
+	"openappsec.io/errors"
+)
+
+// InvalidPathError returns the error of a key which can't be safely mapped into the storage
+func InvalidPathError(key string, reason string) error {
+	return errors.Errorf("invalid path %q: %v", key, reason).
+		SetClass(errors.ClassBadInput).SetLabel(ErrLabelInvalidPath)
+}
+
+// KeySegments validates the segments of a storage key and returns them, unescaped once more.
+// a trailing slash is only allowed when the key is a prefix
+func KeySegments(key string, isPrefix bool) ([]string, error) {
+	if strings.ContainsAny(key, "\\\x00") {
+		return nil, InvalidPathError(key, "forbidden character")
+	}
+	if strings.HasPrefix(key, "/") {
+		return nil, InvalidPathError(key, "absolute path")
+	}
+	// the request path was already unescaped once, keys that unescape into traversal are rejected as well
+	unescaped, err := url.PathUnescape(key)
+	if err != nil {
+		unescaped = key
+	}
+	segments := strings.Split(unescaped, "/")
+	for i, segment := range segments {
+		switch {
+		case segment == "." || segment == "..":
+			return nil, InvalidPathError(key, "relative path segment")
+		case segment == "" && i == len(segments)-1 && isPrefix:
+		case segment == "" && len(segments) > 1:
+			return nil, InvalidPathError(key, "empty path segment")
+		case strings.ContainsAny(segment, "\\\x00"):
+			return nil, InvalidPathError(key, "forbidden character")
+		}
+	}
+	return segments, nil
+}
@@ -0,0 +1,51 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"strings"
+
+	"openappsec.io/errors"
+)
+
+const (
+	// TenantsDir is the storage prefix under which every tenant namespace is kept
+	TenantsDir = "tenants/"
+
+	// ErrLabelInvalidTenant labels errors caused by a missing or malformed tenant id
+	ErrLabelInvalidTenant = "invalid-tenant-id"
+	// ErrLabelMissingTenant labels errors caused by a request without a tenant id
+	ErrLabelMissingTenant = "missing-tenant-id"
+)
+
+// TenantNamespace returns the storage prefix isolating the keys of the given tenant
+func TenantNamespace(tenantID string) (string, error) {
+	if tenantID == "" || tenantID == "." || tenantID == ".." || strings.ContainsAny(tenantID, "/\\\x00") {
+		return "", errors.Errorf("invalid tenant id: %q", tenantID).
+			SetClass(errors.ClassBadInput).SetLabel(ErrLabelInvalidTenant)
+	}
+	return TenantsDir + tenantID + "/", nil
+}
+
+// SplitTenantNamespace splits a storage key into the tenant id and the key inside the tenant namespace
+func SplitTenantNamespace(storageKey string) (string, string, bool) {
+	if !strings.HasPrefix(storageKey, TenantsDir) {
+		return "", "", false
+	}
+	tenantID, path, found := strings.Cut(storageKey[len(TenantsDir):], "/")
+	if !found || tenantID == "" {
+		return "", "", false
+	}
+	return tenantID, path, true
+}
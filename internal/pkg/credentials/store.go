@@ -0,0 +1,105 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credentials
+
+import (
+	"encoding/json"
+	"os"
+
+	"openappsec.io/errors"
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+const (
+	credentialsBaseConfig = "auth"
+	// credentialsConfigFile is a JSON file holding a list of credentials, kept out of the configuration
+	credentialsConfigFile = credentialsBaseConfig + ".credentials_file"
+	// credentialsConfigList is a list of credentials in the configuration itself
+	credentialsConfigList = credentialsBaseConfig + ".credentials"
+)
+
+// Configuration service interface for fetching config
+type Configuration interface {
+	Get(key string) interface{}
+	GetString(key string) (string, error)
+	IsSet(key string) bool
+}
+
+// Store maps access keys to their credentials, it is loaded once and read only afterwards
+type Store struct {
+	credentials map[string]models.Credential
+}
+
+// NewStore loads the credentials from the credentials file and the configuration, both are optional
+func NewStore(conf Configuration) (*Store, error) {
+	s := &Store{credentials: map[string]models.Credential{}}
+	path, err := conf.GetString(credentialsConfigFile)
+	if err != nil && !errors.IsClass(err, errors.ClassNotFound) {
+		return nil, err
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read credentials file %v", path)
+		}
+		if err := s.add(data, path); err != nil {
+			return nil, err
+		}
+	}
+	if conf.IsSet(credentialsConfigList) {
+		// the configuration decodes the list into generic maps, re-encoding it decodes it into credentials
+		data, err := json.Marshal(conf.Get(credentialsConfigList))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %v", credentialsConfigList)
+		}
+		if err := s.add(data, credentialsConfigList); err != nil {
+			return nil, err
+		}
+	}
+	log.Infof("loaded %v credentials", len(s.credentials))
+	return s, nil
+}
+
+// add validates the JSON list of credentials in data, loaded from source, and adds them to the store
+func (s *Store) add(data []byte, source string) error {
+	var credentials []models.Credential
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return errors.Wrapf(err, "invalid credentials in %v", source)
+	}
+	for i, credential := range credentials {
+		if credential.AccessKeyID == "" || credential.SecretAccessKey == "" {
+			return errors.Errorf("credential %v in %v is missing its access key id or secret", i, source)
+		}
+		if _, err := models.TenantNamespace(credential.TenantID); err != nil {
+			return errors.Wrapf(err, "credential %v in %v", credential.AccessKeyID, source)
+		}
+		if _, ok := s.credentials[credential.AccessKeyID]; ok {
+			return errors.Errorf("duplicate access key id %v in %v", credential.AccessKeyID, source)
+		}
+		s.credentials[credential.AccessKeyID] = credential
+	}
+	return nil
+}
+
+// Lookup returns the credential of an access key, ok is false when there is none
+func (s *Store) Lookup(accessKeyID string) (models.Credential, bool) {
+	credential, ok := s.credentials[accessKeyID]
+	return credential, ok
+}
+
+// Len returns the number of credentials in the store
+func (s *Store) Len() int {
+	return len(s.credentials)
+}
@@ -0,0 +1,62 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"time"
+
+	"openappsec.io/errors"
+)
+
+// Configuration service interface for fetching durations from the config
+type Configuration interface {
+	GetDuration(key string) (time.Duration, error)
+}
+
+// SizeConfiguration service interface for fetching sizes from the config
+type SizeConfiguration interface {
+	GetInt(key string) (int, error)
+}
+
+// PositiveSize gets a size in bytes from the configuration, which must be positive. a missing key gets
+// defaultValue
+func PositiveSize(conf SizeConfiguration, key string, defaultValue int64) (int64, error) {
+	value, err := conf.GetInt(key)
+	if err != nil {
+		if !errors.IsClass(err, errors.ClassNotFound) {
+			return 0, err
+		}
+		return defaultValue, nil
+	}
+	if value <= 0 {
+		return 0, errors.Errorf("invalid %v %v, must be positive", key, value).SetClass(errors.ClassBadInput)
+	}
+	return int64(value), nil
+}
+
+// PositiveDuration gets a duration from the configuration, which must be positive. a missing key gets
+// defaultValue, unless it is zero and the key is mandatory
+func PositiveDuration(conf Configuration, key string, defaultValue time.Duration) (time.Duration, error) {
+	value, err := conf.GetDuration(key)
+	if err != nil {
+		if !errors.IsClass(err, errors.ClassNotFound) || defaultValue == 0 {
+			return 0, err
+		}
+		return defaultValue, nil
+	}
+	if value <= 0 {
+		return 0, errors.Errorf("invalid %v %v, must be positive", key, value).SetClass(errors.ClassBadInput)
+	}
+	return value, nil
+}
@@ -0,0 +1,124 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"openappsec.io/errors"
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+// ContentHasher computes the digests and the size of a content while it is streamed
+type ContentHasher struct {
+	md5    hash.Hash
+	sha256 hash.Hash
+	size   int64
+}
+
+// NewContentHasher returns a hasher of an empty content
+func NewContentHasher() *ContentHasher {
+	return &ContentHasher{md5: md5.New(), sha256: sha256.New()}
+}
+
+func (h *ContentHasher) Write(p []byte) (int, error) {
+	h.md5.Write(p)
+	h.sha256.Write(p)
+	h.size += int64(len(p))
+	return len(p), nil
+}
+
+// Size returns the number of bytes hashed
+func (h *ContentHasher) Size() int64 {
+	return h.size
+}
+
+// MD5 returns the MD5 digest of the content
+func (h *ContentHasher) MD5() []byte {
+	return h.md5.Sum(nil)
+}
+
+// SHA256 returns the SHA-256 digest of the content
+func (h *ContentHasher) SHA256() []byte {
+	return h.sha256.Sum(nil)
+}
+
+// ETag returns the entity tag of the content, its quoted hex MD5 digest as in S3
+func (h *ContentHasher) ETag() string {
+	return fmt.Sprintf("\"%x\"", h.MD5())
+}
+
+// ChecksumSHA256 returns the base64 encoded SHA-256 digest of the content
+func (h *ContentHasher) ChecksumSHA256() string {
+	return base64.StdEncoding.EncodeToString(h.SHA256())
+}
+
+// Metadata returns the metadata of the content, modified now
+func (h *ContentHasher) Metadata() models.FileMetadata {
+	return models.FileMetadata{
+		LastModified:   time.Now(),
+		Size:           h.size,
+		ETag:           h.ETag(),
+		ChecksumSHA256: h.ChecksumSHA256(),
+	}
+}
+
+// Verify checks the hashed content against the digests it was sent with
+func (h *ContentHasher) Verify(options models.PutOptions) error {
+	if len(options.ContentMD5) > 0 && !bytes.Equal(options.ContentMD5, h.MD5()) {
+		return errors.Errorf("content doesn't match its MD5 digest").
+			SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelBadDigest)
+	}
+	if len(options.ChecksumSHA256) > 0 && !bytes.Equal(options.ChecksumSHA256, h.SHA256()) {
+		return errors.Errorf("content doesn't match its SHA-256 checksum").
+			SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelBadDigest)
+	}
+	return nil
+}
+
+// readContent reads the whole content into memory and verifies it against the digests it was sent with
+func readContent(content io.Reader, options models.PutOptions) ([]byte, models.FileMetadata, error) {
+	h := NewContentHasher()
+	data, err := io.ReadAll(io.TeeReader(content, h))
+	if err != nil {
+		return nil, models.FileMetadata{}, err
+	}
+	if err := h.Verify(options); err != nil {
+		return nil, models.FileMetadata{}, err
+	}
+	return data, h.Metadata(), nil
+}
+
+// ReadLimitedContent reads the whole content into memory and verifies it against the digests it was sent with,
+// it fails once the content exceeds maxSize bytes rather than buffering it
+func ReadLimitedContent(content io.Reader, options models.PutOptions, maxSize int64) ([]byte, models.FileMetadata, error) {
+	data, metadata, err := readContent(io.LimitReader(content, maxSize+1), options)
+	if err == nil && int64(len(data)) > maxSize {
+		return nil, models.FileMetadata{}, EntityTooLargeError(maxSize)
+	}
+	return data, metadata, err
+}
+
+// EntityTooLargeError returns the error of a content exceeding maxSize bytes
+func EntityTooLargeError(maxSize int64) error {
+	return errors.Errorf("content exceeds the maximum size of %v bytes", maxSize).
+		SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelEntityTooLarge)
+}
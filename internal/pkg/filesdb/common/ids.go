@@ -0,0 +1,53 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"openappsec.io/errors"
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+const uploadIDSize = 16
+
+// NewUploadID returns a random multipart upload id
+func NewUploadID() (string, error) {
+	id := make([]byte, uploadIDSize)
+	if _, err := rand.Read(id); err != nil {
+		return "", errors.Wrap(err, "failed to generate upload id")
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// NewVersionID returns a version id sorted after the version ids generated before it, the hex creation time in
+// nanoseconds followed by random bytes
+func NewVersionID() (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", errors.Wrap(err, "failed to generate version id")
+	}
+	return fmt.Sprintf("%016x%s", time.Now().UnixNano(), hex.EncodeToString(suffix)), nil
+}
+
+// NewerVersion sorts version ids from the newest to the oldest, the null version predates all the others
+func NewerVersion(a string, b string) bool {
+	if a == models.NullVersionID || b == models.NullVersionID {
+		return b == models.NullVersionID && a != models.NullVersionID
+	}
+	return a > b
+}
@@ -0,0 +1,56 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"strings"
+
+	"openappsec.io/errors"
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+// ValidateKey checks that key is a valid storage key of a file
+func ValidateKey(key string) error {
+	if _, err := models.KeySegments(key, false); err != nil {
+		return err
+	}
+	if key == "" {
+		return models.InvalidPathError(key, "empty key")
+	}
+	return nil
+}
+
+// NotFoundError returns the error of a file which doesn't exist
+func NotFoundError(key string) error {
+	return errors.Errorf("file %v not found", key).SetClass(errors.ClassNotFound)
+}
+
+// IsTempKey is true when the storage key is a temp file
+func IsTempKey(storageKey string) bool {
+	_, key, ok := models.SplitTenantNamespace(storageKey)
+	return !ok || models.IsTempFile(key)
+}
+
+// CommonPrefix returns the prefix key is rolled up into when listing with prefix and delimiter,
+// or an empty string if key is listed as is
+func CommonPrefix(key string, prefix string, delimiter string) string {
+	if delimiter == "" || !strings.HasPrefix(key, prefix) {
+		return ""
+	}
+	i := strings.Index(key[len(prefix):], delimiter)
+	if i < 0 {
+		return ""
+	}
+	return key[:len(prefix)+i+len(delimiter)]
+}
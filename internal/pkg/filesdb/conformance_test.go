@@ -0,0 +1,354 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesdb_test
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"openappsec.io/errors"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/filesystem"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/kv"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/memory"
+	"openappsec.io/smartsync-shared-files/internal/pkg/testutil"
+)
+
+// storage is the part of the storage backends the conformance tests run against
+type storage interface {
+	GetFilesList(ctx context.Context, options models.ListOptions) (models.FilesList, error)
+	GetFile(ctx context.Context, path string, options models.GetOptions) (io.ReadCloser, models.FileMetadata, error)
+	StatFile(ctx context.Context, path string, versionID string) (models.FileMetadata, error)
+	PutFile(ctx context.Context, path string, content io.Reader, isTemp bool, options models.PutOptions) (models.FileMetadata, error)
+	DeleteFile(ctx context.Context, path string, versionID string) error
+	ListVersions(ctx context.Context, options models.ListVersionsOptions) (models.VersionsList, error)
+	TearDown(ctx context.Context) error
+}
+
+// backends create each storage backend with the configuration, on a fresh root
+var backends = []struct {
+	name  string
+	start func(conf testutil.Configuration) (storage, error)
+}{
+	{name: "memory", start: func(conf testutil.Configuration) (storage, error) { return memory.NewAdapter(conf) }},
+	{name: "kv", start: func(conf testutil.Configuration) (storage, error) { return kv.NewAdapter(conf) }},
+	{name: "filesystem", start: func(conf testutil.Configuration) (storage, error) { return filesystem.NewAdapter(conf) }},
+}
+
+const (
+	tempKey       = "tenants/t1/ag/tmp/file"
+	persistentKey = "tenants/t1/ag/remote/file"
+)
+
+var conformanceTests = []struct {
+	name       string
+	versioning bool
+	run        func(t *testing.T, s storage)
+}{
+	{name: "put get and stat", run: testPutGet},
+	{name: "range", run: testRange},
+	{name: "listing", run: testListing},
+	{name: "preconditions", run: testPreconditions},
+	{name: "ttl", run: testTTL},
+	{name: "versions", versioning: true, run: testVersions},
+}
+
+func TestConformance(t *testing.T) {
+	for _, backend := range backends {
+		for _, test := range conformanceTests {
+			backend, test := backend, test
+			t.Run(backend.name+"/"+test.name, func(t *testing.T) {
+				s, err := backend.start(testutil.Configuration{
+					"filesystem_db.root":               t.TempDir() + "/",
+					"filesystem_db.ttl":                100 * time.Millisecond,
+					"filesystem_db.sweep_interval":     20 * time.Millisecond,
+					"filesystem_db.versioning.enabled": test.versioning,
+				})
+				if err != nil {
+					t.Fatalf("failed to start the %v backend: %v", backend.name, err)
+				}
+				defer s.TearDown(context.Background())
+				test.run(t, s)
+			})
+		}
+	}
+}
+
+func put(t *testing.T, s storage, key string, content string, isTemp bool, options models.PutOptions) models.FileMetadata {
+	t.Helper()
+	metadata, err := s.PutFile(context.Background(), key, strings.NewReader(content), isTemp, options)
+	if err != nil {
+		t.Fatalf("PutFile(%v) failed: %v", key, err)
+	}
+	return metadata
+}
+
+func get(t *testing.T, s storage, key string, options models.GetOptions) (string, models.FileMetadata) {
+	t.Helper()
+	r, metadata, err := s.GetFile(context.Background(), key, options)
+	if err != nil {
+		t.Fatalf("GetFile(%v) failed: %v", key, err)
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to read %v: %v", key, err)
+	}
+	return string(content), metadata
+}
+
+func testPutGet(t *testing.T, s storage) {
+	attributes := models.ObjectAttributes{ContentType: "text/plain", UserMetadata: map[string]string{"owner": "agent"}}
+	stored := put(t, s, persistentKey, "content", false, models.PutOptions{Attributes: attributes})
+	etag := fmt.Sprintf("\"%x\"", md5.Sum([]byte("content")))
+	if stored.ETag != etag || stored.Size != int64(len("content")) {
+		t.Fatalf("PutFile() = %+v, want entity tag %v and size %v", stored, etag, len("content"))
+	}
+	content, metadata := get(t, s, persistentKey, models.GetOptions{})
+	if content != "content" || metadata.ETag != etag || !reflect.DeepEqual(metadata.Attributes, attributes) {
+		t.Fatalf("GetFile() = %q, %+v", content, metadata)
+	}
+	stat, err := s.StatFile(context.Background(), persistentKey, "")
+	if err != nil || stat.ETag != etag || stat.ChecksumSHA256 != stored.ChecksumSHA256 || stat.Path != persistentKey {
+		t.Fatalf("StatFile() = %+v, %v", stat, err)
+	}
+	if _, err := s.StatFile(context.Background(), persistentKey+"-missing", ""); !errors.IsClass(err, errors.ClassNotFound) {
+		t.Fatalf("StatFile() of a missing file failed with %v, want not found", err)
+	}
+	if err := s.DeleteFile(context.Background(), persistentKey, ""); err != nil {
+		t.Fatalf("DeleteFile() failed: %v", err)
+	}
+	if _, _, err := s.GetFile(context.Background(), persistentKey, models.GetOptions{}); !errors.IsClass(err, errors.ClassNotFound) {
+		t.Fatalf("GetFile() of a deleted file failed with %v, want not found", err)
+	}
+}
+
+func testRange(t *testing.T, s storage) {
+	put(t, s, persistentKey, "0123456789", false, models.PutOptions{})
+	ranges := []struct {
+		byteRange models.ByteRange
+		want      string
+	}{
+		{byteRange: models.ByteRange{Start: 2, End: 4}, want: "234"},
+		{byteRange: models.ByteRange{Start: 7, End: -1}, want: "789"},
+		{byteRange: models.ByteRange{SuffixLength: 2}, want: "89"},
+	}
+	for _, test := range ranges {
+		byteRange := test.byteRange
+		if content, _ := get(t, s, persistentKey, models.GetOptions{Range: &byteRange}); content != test.want {
+			t.Errorf("GetFile() of range %+v = %q, want %q", byteRange, content, test.want)
+		}
+	}
+	unsatisfiable := &models.ByteRange{Start: 10, End: -1}
+	if _, _, err := s.GetFile(context.Background(), persistentKey, models.GetOptions{Range: unsatisfiable}); !errors.IsLabel(err, models.ErrLabelInvalidRange) {
+		t.Fatalf("GetFile() of an unsatisfiable range failed with %v, want an invalid range", err)
+	}
+}
+
+func listedKeys(list models.FilesList) []string {
+	keys := []string{}
+	for _, file := range list.Files {
+		keys = append(keys, file.Path)
+	}
+	return keys
+}
+
+func testListing(t *testing.T, s storage) {
+	for _, key := range []string{"a/1", "a/2", "b/1", "c", "d/e/1"} {
+		put(t, s, "tenants/t1/"+key, key, false, models.PutOptions{})
+	}
+	put(t, s, "tenants/t2/a/1", "another tenant", false, models.PutOptions{})
+	tests := []struct {
+		name      string
+		options   models.ListOptions
+		files     []string
+		prefixes  []string
+		truncated bool
+	}{
+		{
+			name:    "prefix",
+			options: models.ListOptions{Prefix: "tenants/t1/a/"},
+			files:   []string{"tenants/t1/a/1", "tenants/t1/a/2"},
+		},
+		{
+			name:     "delimiter",
+			options:  models.ListOptions{Prefix: "tenants/t1/", Delimiter: "/"},
+			files:    []string{"tenants/t1/c"},
+			prefixes: []string{"tenants/t1/a/", "tenants/t1/b/", "tenants/t1/d/"},
+		},
+		{
+			name:      "first page",
+			options:   models.ListOptions{Prefix: "tenants/t1/", Delimiter: "/", MaxKeys: 2},
+			prefixes:  []string{"tenants/t1/a/", "tenants/t1/b/"},
+			truncated: true,
+		},
+		{
+			name:     "next page",
+			options:  models.ListOptions{Prefix: "tenants/t1/", Delimiter: "/", MaxKeys: 2, StartAfter: "tenants/t1/b/"},
+			files:    []string{"tenants/t1/c"},
+			prefixes: []string{"tenants/t1/d/"},
+		},
+		{
+			name:    "start after a key",
+			options: models.ListOptions{Prefix: "tenants/t1/", StartAfter: "tenants/t1/a/1"},
+			files:   []string{"tenants/t1/a/2", "tenants/t1/b/1", "tenants/t1/c", "tenants/t1/d/e/1"},
+		},
+		{
+			name:    "start after a key within a common prefix",
+			options: models.ListOptions{Prefix: "tenants/t1/", Delimiter: "/", StartAfter: "tenants/t1/a/1"},
+			files:   []string{"tenants/t1/c"},
+			// the rest of a/ is still rolled up, into the common prefix the page started in
+			prefixes: []string{"tenants/t1/a/", "tenants/t1/b/", "tenants/t1/d/"},
+		},
+		{
+			name:    "missing prefix",
+			options: models.ListOptions{Prefix: "tenants/t3/"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			list, err := s.GetFilesList(context.Background(), test.options)
+			if err != nil {
+				t.Fatalf("GetFilesList(%+v) failed: %v", test.options, err)
+			}
+			prefixes := list.CommonPrefixes
+			if test.prefixes == nil {
+				test.prefixes = []string{}
+			}
+			if test.files == nil {
+				test.files = []string{}
+			}
+			if !reflect.DeepEqual(listedKeys(list), test.files) || !reflect.DeepEqual(prefixes, test.prefixes) ||
+				list.IsTruncated != test.truncated {
+				t.Fatalf("GetFilesList(%+v) = %v, %v, truncated %v, want %v, %v, truncated %v", test.options,
+					listedKeys(list), prefixes, list.IsTruncated, test.files, test.prefixes, test.truncated)
+			}
+		})
+	}
+}
+
+func testPreconditions(t *testing.T, s storage) {
+	ctx := context.Background()
+	failed := func(err error) bool { return errors.IsLabel(err, models.ErrLabelPreconditionFailed) }
+	if _, err := s.PutFile(ctx, persistentKey, strings.NewReader("v1"), false, models.PutOptions{IfMatch: "*"}); !failed(err) {
+		t.Fatalf("PutFile() if matching a missing file failed with %v, want a failed precondition", err)
+	}
+	first := put(t, s, persistentKey, "v1", false, models.PutOptions{IfNoneMatch: true})
+	if _, err := s.PutFile(ctx, persistentKey, strings.NewReader("v2"), false, models.PutOptions{IfNoneMatch: true}); !failed(err) {
+		t.Fatalf("PutFile() if none matching an existing file failed with %v, want a failed precondition", err)
+	}
+	if _, err := s.PutFile(ctx, persistentKey, strings.NewReader("v2"), false, models.PutOptions{IfMatch: "\"other\""}); !failed(err) {
+		t.Fatalf("PutFile() if matching another entity tag failed with %v, want a failed precondition", err)
+	}
+	if content, _ := get(t, s, persistentKey, models.GetOptions{}); content != "v1" {
+		t.Fatalf("a failed precondition replaced the file with %q", content)
+	}
+	put(t, s, persistentKey, "v2", false, models.PutOptions{IfMatch: "\"other\", " + first.ETag})
+	if content, _ := get(t, s, persistentKey, models.GetOptions{}); content != "v2" {
+		t.Fatalf("GetFile() = %q after a satisfied precondition, want %q", content, "v2")
+	}
+}
+
+func testTTL(t *testing.T, s storage) {
+	put(t, s, tempKey, "temp", true, models.PutOptions{})
+	put(t, s, persistentKey, "persistent", false, models.PutOptions{})
+	if _, err := s.StatFile(context.Background(), tempKey, ""); err != nil {
+		t.Fatalf("StatFile() of a temp file before its ttl failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := s.StatFile(context.Background(), tempKey, "")
+		if errors.IsClass(err, errors.ClassNotFound) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("temp file wasn't removed after its ttl, StatFile() = %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	list, err := s.GetFilesList(context.Background(), models.ListOptions{Prefix: "tenants/t1/"})
+	if err != nil || !reflect.DeepEqual(listedKeys(list), []string{persistentKey}) {
+		t.Fatalf("GetFilesList() after the ttl = %v, %v, want only %v", listedKeys(list), err, persistentKey)
+	}
+}
+
+func testVersions(t *testing.T, s storage) {
+	ctx := context.Background()
+	first := put(t, s, persistentKey, "v1", false, models.PutOptions{})
+	second := put(t, s, persistentKey, "v2", false, models.PutOptions{})
+	if first.VersionID == "" || second.VersionID == "" || first.VersionID == second.VersionID {
+		t.Fatalf("PutFile() returned version ids %q and %q, want distinct ones", first.VersionID, second.VersionID)
+	}
+	versions := func() []models.FileVersion {
+		t.Helper()
+		list, err := s.ListVersions(ctx, models.ListVersionsOptions{Prefix: persistentKey})
+		if err != nil {
+			t.Fatalf("ListVersions() failed: %v", err)
+		}
+		return list.Versions
+	}
+	listed := versions()
+	if len(listed) != 2 || listed[0].VersionID != second.VersionID || !listed[0].IsLatest ||
+		listed[1].VersionID != first.VersionID || listed[1].IsLatest {
+		t.Fatalf("ListVersions() = %+v, want %v then %v", listed, second.VersionID, first.VersionID)
+	}
+	if content, metadata := get(t, s, persistentKey, models.GetOptions{VersionID: first.VersionID}); content != "v1" ||
+		metadata.VersionID != first.VersionID {
+		t.Fatalf("GetFile() of version %v = %q, %+v", first.VersionID, content, metadata)
+	}
+	page, err := s.ListVersions(ctx, models.ListVersionsOptions{Prefix: persistentKey, MaxKeys: 1})
+	if err != nil || len(page.Versions) != 1 || !page.IsTruncated {
+		t.Fatalf("ListVersions() of a page of 1 = %+v, %v", page, err)
+	}
+	page, err = s.ListVersions(ctx, models.ListVersionsOptions{
+		Prefix: persistentKey, KeyMarker: persistentKey, VersionIDMarker: second.VersionID,
+	})
+	if err != nil || len(page.Versions) != 1 || page.Versions[0].VersionID != first.VersionID || page.IsTruncated {
+		t.Fatalf("ListVersions() after version %v = %+v, %v", second.VersionID, page, err)
+	}
+
+	// deleting the current version keeps it as a noncurrent version
+	if err := s.DeleteFile(ctx, persistentKey, ""); err != nil {
+		t.Fatalf("DeleteFile() failed: %v", err)
+	}
+	if _, err := s.StatFile(ctx, persistentKey, ""); !errors.IsClass(err, errors.ClassNotFound) {
+		t.Fatalf("StatFile() of a deleted file failed with %v, want not found", err)
+	}
+	if listed := versions(); len(listed) != 2 || listed[0].IsLatest || listed[1].IsLatest {
+		t.Fatalf("ListVersions() after deleting the file = %+v, want 2 noncurrent versions", listed)
+	}
+	if err := s.DeleteFile(ctx, persistentKey, first.VersionID); err != nil {
+		t.Fatalf("DeleteFile() of version %v failed: %v", first.VersionID, err)
+	}
+	if listed := versions(); len(listed) != 1 || listed[0].VersionID != second.VersionID {
+		t.Fatalf("ListVersions() after deleting version %v = %+v", first.VersionID, listed)
+	}
+	if _, err := s.StatFile(ctx, persistentKey, first.VersionID); !errors.IsClass(err, errors.ClassNotFound) {
+		t.Fatalf("StatFile() of a deleted version failed with %v, want not found", err)
+	}
+
+	// temp files are never versioned
+	put(t, s, tempKey, "v1", true, models.PutOptions{})
+	put(t, s, tempKey, "v2", true, models.PutOptions{})
+	list, err := s.ListVersions(ctx, models.ListVersionsOptions{Prefix: tempKey})
+	if err != nil || len(list.Versions) != 1 {
+		t.Fatalf("ListVersions() of a temp file = %+v, %v, want only the current version", list, err)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"openappsec.io/errors"
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+const (
+	// gzipEncoding is the content coding compressed contents are stored with
+	gzipEncoding = "gzip"
+	// compressionMarker is the comment in the gzip header of compressed contents. it tells them apart from contents
+	// stored as is which happen to be gzip streams, when their metadata is recomputed from the content
+	compressionMarker = "smartsync-shared-files"
+)
+
+// compressionRules are the key prefixes, within the tenant namespace, under which contents are stored compressed.
+// a prefix segment is a path.Match pattern, so "*/remote/" matches the remote directory of every agent
+type compressionRules [][]string
+
+// parseCompressionRules parses a comma separated list of key prefixes
+func parseCompressionRules(value string) (compressionRules, error) {
+	var rules compressionRules
+	for _, prefix := range strings.Split(value, ",") {
+		prefix = strings.TrimSpace(prefix)
+		if prefix == "" {
+			continue
+		}
+		segments, err := models.KeySegments(prefix, true)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid compression prefix %q", prefix).SetClass(errors.ClassBadInput)
+		}
+		for _, segment := range segments {
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, errors.Wrapf(err, "invalid compression prefix %q", prefix).SetClass(errors.ClassBadInput)
+			}
+		}
+		rules = append(rules, segments)
+	}
+	return rules, nil
+}
+
+// encoding returns the content coding the content stored under storageKey with attributes is compressed with,
+// empty when it is stored as is. a content already encoded by the client is never compressed again
+func (r compressionRules) encoding(storageKey string, attributes models.ObjectAttributes) string {
+	if attributes.ContentEncoding != "" {
+		return ""
+	}
+	_, key, ok := models.SplitTenantNamespace(storageKey)
+	if !ok {
+		return ""
+	}
+	segments := strings.Split(key, "/")
+	for _, rule := range r {
+		if matchPrefix(rule, segments) {
+			return gzipEncoding
+		}
+	}
+	return ""
+}
+
+// matchPrefix returns true if the key segments start with the prefix segments. every prefix segment but the last
+// matches a whole key segment, the last one matches the start of a key segment
+func matchPrefix(prefix []string, segments []string) bool {
+	if len(segments) < len(prefix) {
+		return false
+	}
+	last := len(prefix) - 1
+	for i, pattern := range prefix {
+		if i == last {
+			pattern += "*"
+		}
+		if ok, _ := path.Match(pattern, segments[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// compressor wraps w with a writer compressing into it with encoding, or returns nil to write as is
+func compressor(w io.Writer, encoding string) io.WriteCloser {
+	if encoding != gzipEncoding {
+		return nil
+	}
+	zw := gzip.NewWriter(w)
+	zw.Comment = compressionMarker
+	return zw
+}
+
+// storedEncoding returns the content coding of the stored content, detected from its header
+func storedEncoding(content io.ReaderAt, size int64) string {
+	zr, err := gzip.NewReader(io.NewSectionReader(content, 0, size))
+	if err != nil || zr.Comment != compressionMarker {
+		return ""
+	}
+	return gzipEncoding
+}
+
+// decompressor returns a reader decompressing the stored content, compressed with encoding
+func decompressor(content io.Reader, encoding string) (io.Reader, error) {
+	if encoding == "" {
+		return content, nil
+	}
+	if encoding != gzipEncoding {
+		return nil, errors.Errorf("unsupported content coding %v", encoding)
+	}
+	return gzip.NewReader(content)
+}
+
+// computeObjectMeta reads the whole stored content described by info, decompressing it when it was stored
+// compressed, and computes its metadata
+func computeObjectMeta(content io.ReaderAt, info fs.FileInfo) (objectMeta, error) {
+	encoding := storedEncoding(content, info.Size())
+	r, err := decompressor(io.NewSectionReader(content, 0, info.Size()), encoding)
+	if err != nil {
+		return objectMeta{}, err
+	}
+	meta, err := computeMeta(r, info)
+	if err != nil {
+		return objectMeta{}, err
+	}
+	meta.Encoding = encoding
+	return meta, nil
+}
+
+// openContent returns a reader streaming length bytes, from offset, of the content stored in f with encoding.
+// a compressed content is decompressed up to offset, unless returned as stored
+func openContent(f io.ReaderAt, storedSize int64, encoding string, offset int64, length int64) (io.Reader, error) {
+	if encoding == "" {
+		return io.NewSectionReader(f, offset, length), nil
+	}
+	r, err := decompressor(io.NewSectionReader(f, 0, storedSize), encoding)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+		return nil, errors.Wrap(err, "failed to decompress content")
+	}
+	return io.LimitReader(r, length), nil
+}
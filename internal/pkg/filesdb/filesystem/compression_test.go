@@ -0,0 +1,106 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/testutil"
+)
+
+func TestCompressionSidecarLoss(t *testing.T) {
+	a, err := NewAdapter(testutil.Configuration{
+		fsConfigRoot:                t.TempDir() + "/",
+		fsConfigTTL:                 time.Hour,
+		fsConfigCompressionPrefixes: "*/remote/",
+	})
+	if err != nil {
+		t.Fatalf("NewAdapter() failed: %v", err)
+	}
+	defer a.TearDown(context.Background())
+	ctx := context.Background()
+	compressed := "tenants/t1/ag/remote/data.json"
+	// a content stored as is which happens to be a gzip stream isn't taken for a compressed one
+	plain := "tenants/t1/ag/tmp/data.json.gz"
+	content := strings.Repeat(`{"key": "value"} `, 256)
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	zw.Write([]byte(content))
+	zw.Close()
+
+	stored := map[string]models.FileMetadata{}
+	for key, data := range map[string]string{compressed: content, plain: gzipped.String()} {
+		metadata, err := a.PutFile(ctx, key, strings.NewReader(data), false, models.PutOptions{})
+		if err != nil {
+			t.Fatalf("PutFile(%v) failed: %v", key, err)
+		}
+		stored[key] = metadata
+	}
+	if stored[compressed].StoredEncoding != gzipEncoding || stored[compressed].StoredSize >= int64(len(content)) ||
+		stored[plain].StoredEncoding != "" {
+		t.Fatalf("PutFile() stored %+v and %+v", stored[compressed], stored[plain])
+	}
+
+	for key := range stored {
+		if err := os.Remove(a.metaPath(key)); err != nil {
+			t.Fatalf("failed to remove the sidecar of %v: %v", key, err)
+		}
+	}
+	for key, want := range map[string]string{compressed: content, plain: gzipped.String()} {
+		r, metadata, err := a.GetFile(ctx, key, models.GetOptions{})
+		if err != nil {
+			t.Fatalf("GetFile(%v) without its sidecar failed: %v", key, err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || string(data) != want {
+			t.Fatalf("GetFile(%v) without its sidecar read %v bytes, %v", key, len(data), err)
+		}
+		if metadata.ETag != stored[key].ETag || metadata.ChecksumSHA256 != stored[key].ChecksumSHA256 ||
+			metadata.Size != stored[key].Size || metadata.StoredEncoding != stored[key].StoredEncoding {
+			t.Fatalf("GetFile(%v) without its sidecar = %+v, want the metadata of %+v", key, metadata, stored[key])
+		}
+	}
+
+	// the recomputed metadata still serves the compressed content as stored, and ranges of the content
+	r, metadata, err := a.GetFile(ctx, compressed, models.GetOptions{AcceptEncodings: []string{gzipEncoding}})
+	if err != nil {
+		t.Fatalf("GetFile() accepting gzip failed: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if int64(len(data)) != metadata.StoredSize {
+		t.Fatalf("GetFile() accepting gzip read %v bytes, want the %v stored", len(data), metadata.StoredSize)
+	}
+	r, _, err = a.GetFile(ctx, compressed, models.GetOptions{
+		Range:           &models.ByteRange{Start: 17, End: 33},
+		AcceptEncodings: []string{gzipEncoding},
+	})
+	if err != nil {
+		t.Fatalf("GetFile() of a range failed: %v", err)
+	}
+	data, _ = io.ReadAll(r)
+	r.Close()
+	if string(data) != content[17:34] {
+		t.Fatalf("GetFile() of a range = %q, want %q", data, content[17:34])
+	}
+}
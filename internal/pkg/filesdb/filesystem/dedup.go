@@ -0,0 +1,144 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"openappsec.io/log"
+)
+
+const (
+	// objectsDir holds the deduplicated contents, named after their hex SHA-256 digest and spread over
+	// subdirectories named after the first byte of the digest. the objects are hard linked into place, so the
+	// link count of an object is the number of keys and noncurrent versions referencing it, plus one
+	objectsDir = systemDir + "objects/"
+	// linkSuffix is appended to the name of a staging file to link an object next to it
+	linkSuffix = ".link"
+)
+
+// objectPath returns the path of the object holding the content with the base64 SHA-256 digest checksum, stored
+// compressed with encoding. ok is false when the digest is malformed
+func (a *Adapter) objectPath(checksum string, encoding string) (string, bool) {
+	digest, err := base64.StdEncoding.DecodeString(checksum)
+	if err != nil || len(digest) != sha256.Size {
+		return "", false
+	}
+	name := hex.EncodeToString(digest)
+	if encoding != "" {
+		// the digest is the one of the decompressed content, the same content stored as is is another object
+		name += "." + encoding
+	}
+	return a.paths.root + objectsDir + name[:2] + "/" + name, true
+}
+
+// linkCount returns the number of hard links to the file described by info, zero when it is unknown
+func linkCount(info fs.FileInfo) uint64 {
+	if sys, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(sys.Nlink)
+	}
+	return 0
+}
+
+// intern deduplicates the staged content described by meta. when an object with the same digest is stored
+// already, the staged content is replaced with a link to it, otherwise the staged content is stored as the object.
+// the objects are shared, so their modification time isn't the last modified time of the keys linking them, which
+// is kept in the metadata instead
+func (a *Adapter) intern(staged *stagedFile, meta *objectMeta) error {
+	objectPath, ok := a.objectPath(meta.ChecksumSHA256, meta.Encoding)
+	if !ok {
+		return nil
+	}
+	meta.LastModified = staged.info.ModTime().UnixNano()
+	linkPath := staged.path + linkSuffix
+	for attempt := 0; attempt < 2; attempt++ {
+		err := os.Link(objectPath, linkPath)
+		if err == nil {
+			if err := os.Rename(linkPath, staged.path); err != nil {
+				os.Remove(linkPath)
+				return err
+			}
+			info, err := os.Stat(staged.path)
+			if err != nil {
+				return err
+			}
+			staged.info = info
+			meta.Stamp = stampOf(info)
+			return nil
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(objectPath), 0750); err != nil {
+			return err
+		}
+		err = os.Link(staged.path, objectPath)
+		if err == nil || !os.IsExist(err) {
+			return err
+		}
+		// the same content was stored concurrently, it is linked on the next attempt
+	}
+	log.Warnf("failed to deduplicate content %v, keeping a copy", meta.ChecksumSHA256)
+	return nil
+}
+
+// release removes the object holding the content described by meta once no key or noncurrent version references
+// it. an object linked again right before it is removed stays in place under the key linking it, only its next
+// copies aren't deduplicated against it
+func (a *Adapter) release(meta objectMeta) {
+	objectPath, ok := a.objectPath(meta.ChecksumSHA256, meta.Encoding)
+	if !ok {
+		return
+	}
+	info, err := os.Stat(objectPath)
+	if err != nil || linkCount(info) != 1 {
+		return
+	}
+	log.Debugf("removing unreferenced content: %v", objectPath)
+	if err := removeFile(objectPath, a.paths.root+objectsDir); err != nil {
+		log.Warnf("failed to remove unreferenced content %v. err: %v", objectPath, err)
+	}
+}
+
+// collectGarbage removes the objects no key or noncurrent version references, such as objects released right
+// before a crash
+func (a *Adapter) collectGarbage() {
+	root := a.paths.root + objectsDir
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || linkCount(info) != 1 {
+			return nil
+		}
+		log.Debugf("removing unreferenced content: %v", path)
+		return removeFile(path, root)
+	})
+	if err != nil {
+		log.Warnf("failed to remove unreferenced contents. err: %v", err)
+	}
+}
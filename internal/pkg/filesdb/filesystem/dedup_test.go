@@ -0,0 +1,258 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/testutil"
+)
+
+// dedupConfiguration is the configuration of an adapter deduplicating the contents stored under root
+func dedupConfiguration(root string, versioning bool) testutil.Configuration {
+	return testutil.Configuration{
+		fsConfigRoot:          root,
+		fsConfigTTL:           100 * time.Millisecond,
+		fsConfigSweepInterval: 20 * time.Millisecond,
+		fsConfigDedup:         true,
+		fsConfigVersioning:    versioning,
+	}
+}
+
+// newDedupAdapter creates an adapter deduplicating the contents stored under a new root
+func newDedupAdapter(t *testing.T, versioning bool) *Adapter {
+	a, err := NewAdapter(dedupConfiguration(t.TempDir()+"/", versioning))
+	if err != nil {
+		t.Fatalf("NewAdapter() failed: %v", err)
+	}
+	t.Cleanup(func() { a.TearDown(context.Background()) })
+	return a
+}
+
+// objects returns the paths of the deduplicated contents stored by the adapter
+func objects(t *testing.T, a *Adapter) []string {
+	t.Helper()
+	var paths []string
+	err := filepath.WalkDir(a.paths.root+objectsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to list the objects: %v", err)
+	}
+	return paths
+}
+
+// links returns the number of links to the object holding content
+func links(t *testing.T, a *Adapter, content string) uint64 {
+	t.Helper()
+	objectPath, _ := a.objectPath(sha256Base64(content), "")
+	info, err := os.Stat(objectPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0
+		}
+		t.Fatalf("failed to stat the object of %q: %v", content, err)
+	}
+	return linkCount(info)
+}
+
+func sha256Base64(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func putContent(t *testing.T, a *Adapter, key string, content string, isTemp bool) models.FileMetadata {
+	t.Helper()
+	metadata, err := a.PutFile(context.Background(), key, strings.NewReader(content), isTemp, models.PutOptions{})
+	if err != nil {
+		t.Fatalf("PutFile(%v) failed: %v", key, err)
+	}
+	return metadata
+}
+
+func readContent(t *testing.T, a *Adapter, key string, versionID string) string {
+	t.Helper()
+	r, _, err := a.GetFile(context.Background(), key, models.GetOptions{VersionID: versionID})
+	if err != nil {
+		t.Fatalf("GetFile(%v, %v) failed: %v", key, versionID, err)
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to read %v: %v", key, err)
+	}
+	return string(content)
+}
+
+// eventually polls condition until it holds, or fails the test after a few seconds
+func eventually(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !condition(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%v didn't happen", description)
+		}
+	}
+}
+
+func TestDedupSharedObject(t *testing.T) {
+	a := newDedupAdapter(t, false)
+	ctx := context.Background()
+	first := putContent(t, a, "tenants/t1/ag/remote/first", "shared", false)
+	second := putContent(t, a, "tenants/t2/ag/remote/second", "shared", false)
+	if first.ETag != second.ETag {
+		t.Fatalf("the keys sharing a content have the entity tags %v and %v", first.ETag, second.ETag)
+	}
+	if stored := objects(t, a); len(stored) != 1 || links(t, a, "shared") != 3 {
+		t.Fatalf("the content is stored as %v with %v links, want a single object linked by both keys", stored,
+			links(t, a, "shared"))
+	}
+
+	if err := a.DeleteFile(ctx, "tenants/t1/ag/remote/first", ""); err != nil {
+		t.Fatalf("DeleteFile() failed: %v", err)
+	}
+	if content := readContent(t, a, "tenants/t2/ag/remote/second", ""); content != "shared" {
+		t.Fatalf("the key left reads %q, want %q", content, "shared")
+	}
+	if links(t, a, "shared") != 2 {
+		t.Fatalf("the object has %v links after deleting a key, want 2", links(t, a, "shared"))
+	}
+	if err := a.DeleteFile(ctx, "tenants/t2/ag/remote/second", ""); err != nil {
+		t.Fatalf("DeleteFile() failed: %v", err)
+	}
+	if stored := objects(t, a); len(stored) != 0 {
+		t.Fatalf("objects %v are left after deleting their last key", stored)
+	}
+}
+
+func TestDedupOverwrite(t *testing.T) {
+	a := newDedupAdapter(t, false)
+	putContent(t, a, "tenants/t1/ag/remote/file", "v1", false)
+	putContent(t, a, "tenants/t1/ag/remote/file", "v1", false)
+	if links(t, a, "v1") != 2 {
+		t.Fatalf("the object has %v links after writing the same content again, want 2", links(t, a, "v1"))
+	}
+	putContent(t, a, "tenants/t1/ag/remote/file", "v2", false)
+	if links(t, a, "v1") != 0 || links(t, a, "v2") != 2 {
+		t.Fatalf("after an overwrite the objects have %v and %v links, want 0 and 2", links(t, a, "v1"), links(t, a, "v2"))
+	}
+}
+
+func TestDedupExpiry(t *testing.T) {
+	a := newDedupAdapter(t, false)
+	putContent(t, a, "tenants/t1/ag/tmp/shared", "shared", true)
+	putContent(t, a, "tenants/t1/ag/remote/shared", "shared", false)
+	putContent(t, a, "tenants/t1/ag/tmp/private", "private", true)
+	eventually(t, "expiry of the temp files", func() bool {
+		return links(t, a, "private") == 0 && links(t, a, "shared") == 2
+	})
+	if content := readContent(t, a, "tenants/t1/ag/remote/shared", ""); content != "shared" {
+		t.Fatalf("the persistent key sharing an expired content reads %q", content)
+	}
+}
+
+func TestDedupVersions(t *testing.T) {
+	a := newDedupAdapter(t, true)
+	ctx := context.Background()
+	key := "tenants/t1/ag/remote/file"
+	v1 := putContent(t, a, key, "v1", false)
+	v2 := putContent(t, a, key, "v2", false)
+	// the noncurrent version keeps referencing its content
+	if links(t, a, "v1") != 2 || links(t, a, "v2") != 2 {
+		t.Fatalf("the versions have %v and %v links, want 2 each", links(t, a, "v1"), links(t, a, "v2"))
+	}
+	if content := readContent(t, a, key, v1.VersionID); content != "v1" {
+		t.Fatalf("version %v reads %q, want %q", v1.VersionID, content, "v1")
+	}
+
+	// deleting the current version promotes the noncurrent one, whose content moves under the key
+	if err := a.DeleteFile(ctx, key, v2.VersionID); err != nil {
+		t.Fatalf("DeleteFile() of the current version failed: %v", err)
+	}
+	if links(t, a, "v2") != 0 || links(t, a, "v1") != 2 {
+		t.Fatalf("after promoting a version the objects have %v and %v links, want 0 and 2", links(t, a, "v2"),
+			links(t, a, "v1"))
+	}
+	if content := readContent(t, a, key, ""); content != "v1" {
+		t.Fatalf("the promoted version reads %q, want %q", content, "v1")
+	}
+
+	// deleting the key archives its content, deleting the archived version releases it
+	if err := a.DeleteFile(ctx, key, ""); err != nil {
+		t.Fatalf("DeleteFile() failed: %v", err)
+	}
+	if links(t, a, "v1") != 2 {
+		t.Fatalf("the archived content has %v links, want 2", links(t, a, "v1"))
+	}
+	if err := a.DeleteFile(ctx, key, v1.VersionID); err != nil {
+		t.Fatalf("DeleteFile() of a noncurrent version failed: %v", err)
+	}
+	if stored := objects(t, a); len(stored) != 0 {
+		t.Fatalf("objects %v are left after deleting every version", stored)
+	}
+}
+
+func TestDedupCollectGarbage(t *testing.T) {
+	root := t.TempDir() + "/"
+	a, err := NewAdapter(dedupConfiguration(root, false))
+	if err != nil {
+		t.Fatalf("NewAdapter() failed: %v", err)
+	}
+	putContent(t, a, "tenants/t1/ag/remote/file", "referenced", false)
+	if err := a.TearDown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// an object released right before a crash is left without references
+	orphanPath, _ := a.objectPath(sha256Base64("orphan"), "")
+	if err := os.MkdirAll(filepath.Dir(orphanPath), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(orphanPath, []byte("orphan"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, err := NewAdapter(dedupConfiguration(root, false))
+	if err != nil {
+		t.Fatalf("NewAdapter() failed: %v", err)
+	}
+	defer restarted.TearDown(context.Background())
+	eventually(t, "removal of the orphan object", func() bool {
+		_, err := os.Stat(orphanPath)
+		return os.IsNotExist(err)
+	})
+	if links(t, restarted, "referenced") != 2 {
+		t.Fatalf("the referenced object has %v links after the restart, want 2", links(t, restarted, "referenced"))
+	}
+	if content := readContent(t, restarted, "tenants/t1/ag/remote/file", ""); content != "referenced" {
+		t.Fatalf("the key reads %q after the restart, want %q", content, "referenced")
+	}
+}
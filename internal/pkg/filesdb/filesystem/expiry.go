@@ -0,0 +1,222 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"openappsec.io/errors"
+	"openappsec.io/log"
+)
+
+const (
+	// compactMinRecords is the minimal number of journal records before the journal is compacted
+	compactMinRecords = 1024
+)
+
+// expiryRecord is a single journal line, a zero deadline removes the key from the index
+type expiryRecord struct {
+	Key      string `json:"key"`
+	Deadline int64  `json:"deadline"`
+}
+
+// expiryIndex keeps the expiration deadline of every temp file.
+// the index is persisted in an append only journal which is replayed on startup, so pending
+// expirations survive restarts and crashes.
+type expiryIndex struct {
+	mu        sync.Mutex
+	path      string
+	journal   *os.File
+	deadlines map[string]time.Time
+	records   int
+}
+
+// openExpiryIndex loads the index from the journal in path and compacts it
+func openExpiryIndex(path string) (*expiryIndex, error) {
+	e := &expiryIndex{path: path, deadlines: make(map[string]time.Time)}
+	if err := e.load(); err != nil {
+		return nil, err
+	}
+	if err := e.compact(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *expiryIndex) load() error {
+	f, err := os.Open(e.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "failed to open expiry journal %v", e.path)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record expiryRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// a crash in the middle of an append leaves a partial last line behind
+			log.Warnf("skipping corrupted expiry journal record: %q", scanner.Text())
+			continue
+		}
+		if record.Deadline == 0 {
+			delete(e.deadlines, record.Key)
+		} else {
+			e.deadlines[record.Key] = time.Unix(0, record.Deadline)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrapf(err, "failed to read expiry journal %v", e.path)
+	}
+	return nil
+}
+
+// compact rewrites the journal with a single record per pending expiration and reopens it for appending
+func (e *expiryIndex) compact() error {
+	tmpPath := e.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create expiry journal %v", tmpPath)
+	}
+	w := bufio.NewWriter(f)
+	for key, deadline := range e.deadlines {
+		if err := writeRecord(w, expiryRecord{Key: key, Deadline: deadline.UnixNano()}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to write expiry journal")
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to sync expiry journal")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "failed to close expiry journal")
+	}
+	if err := os.Rename(tmpPath, e.path); err != nil {
+		return errors.Wrapf(err, "failed to replace expiry journal %v", e.path)
+	}
+	if e.journal != nil {
+		e.journal.Close()
+	}
+	e.journal, err = os.OpenFile(e.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open expiry journal %v", e.path)
+	}
+	e.records = len(e.deadlines)
+	return nil
+}
+
+func writeRecord(w io.Writer, record expiryRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal expiry record %+v", record)
+	}
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "failed to write expiry record")
+	}
+	return nil
+}
+
+// appendRecord persists a record in the journal, must be called while holding the lock
+func (e *expiryIndex) appendRecord(record expiryRecord) error {
+	if err := writeRecord(e.journal, record); err != nil {
+		return err
+	}
+	if err := e.journal.Sync(); err != nil {
+		return errors.Wrap(err, "failed to sync expiry journal")
+	}
+	e.records++
+	if e.records > compactMinRecords && e.records > 2*len(e.deadlines) {
+		if err := e.compact(); err != nil {
+			log.Warnf("failed to compact expiry journal. err: %v", err)
+		}
+	}
+	return nil
+}
+
+// set sets the expiration deadline of key, replacing any previous deadline
+func (e *expiryIndex) set(key string, deadline time.Time) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.deadlines[key] = deadline
+	return e.appendRecord(expiryRecord{Key: key, Deadline: deadline.UnixNano()})
+}
+
+// setIfAbsent sets the expiration deadline of key only if it doesn't have one yet
+func (e *expiryIndex) setIfAbsent(key string, deadline time.Time) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.deadlines[key]; ok {
+		return nil
+	}
+	e.deadlines[key] = deadline
+	return e.appendRecord(expiryRecord{Key: key, Deadline: deadline.UnixNano()})
+}
+
+// remove removes key from the index
+func (e *expiryIndex) remove(key string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.deadlines[key]; !ok {
+		return nil
+	}
+	delete(e.deadlines, key)
+	return e.appendRecord(expiryRecord{Key: key})
+}
+
+// expired returns the keys whose deadline passed
+func (e *expiryIndex) expired(now time.Time) []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var keys []string
+	for key, deadline := range e.deadlines {
+		if !deadline.After(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// expire calls remove for key if its deadline passed and drops it from the index.
+// the lock is held during the removal, so a concurrent write which resets the deadline is never lost.
+func (e *expiryIndex) expire(key string, now time.Time, remove func() error) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	deadline, ok := e.deadlines[key]
+	if !ok || deadline.After(now) {
+		return nil
+	}
+	if err := remove(); err != nil {
+		return err
+	}
+	delete(e.deadlines, key)
+	return e.appendRecord(expiryRecord{Key: key})
+}
+
+func (e *expiryIndex) close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.journal.Close()
+}
@@ -0,0 +1,178 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"openappsec.io/smartsync-shared-files/internal/pkg/testutil"
+)
+
+const expiringKey = "tenants/t1/ag/tmp/file"
+
+// newExpiryAdapter creates an adapter whose temp files expire in an hour, under root
+func newExpiryAdapter(t *testing.T, root string) *Adapter {
+	t.Helper()
+	a, err := NewAdapter(testutil.Configuration{fsConfigRoot: root, fsConfigTTL: time.Hour, fsConfigSweepInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("NewAdapter() failed: %v", err)
+	}
+	return a
+}
+
+func deadlineOf(t *testing.T, a *Adapter, key string) time.Time {
+	t.Helper()
+	a.expiry.mu.Lock()
+	defer a.expiry.mu.Unlock()
+	deadline, ok := a.expiry.deadlines[key]
+	if !ok {
+		t.Fatalf("%v has no expiration deadline", key)
+	}
+	return deadline
+}
+
+func exists(t *testing.T, a *Adapter, key string) bool {
+	t.Helper()
+	filePath, err := a.paths.resolveFile(key)
+	if err != nil {
+		t.Fatalf("failed to resolve %v: %v", key, err)
+	}
+	_, err = os.Stat(filePath)
+	return err == nil
+}
+
+func TestExpiryJournalReplay(t *testing.T) {
+	path := t.TempDir() + "/expiry"
+	e, err := openExpiryIndex(path)
+	if err != nil {
+		t.Fatalf("openExpiryIndex() failed: %v", err)
+	}
+	now := time.Now()
+	for key, deadline := range map[string]time.Time{"a": now, "b": now.Add(time.Hour), "c": now.Add(time.Minute)} {
+		if err := e.set(key, deadline); err != nil {
+			t.Fatalf("set(%v) failed: %v", key, err)
+		}
+	}
+	if err := e.remove("b"); err != nil {
+		t.Fatalf("remove() failed: %v", err)
+	}
+	if err := e.set("c", now.Add(2*time.Minute)); err != nil {
+		t.Fatalf("set() failed: %v", err)
+	}
+	if err := e.close(); err != nil {
+		t.Fatalf("close() failed: %v", err)
+	}
+	// a crash in the middle of an append leaves a partial record behind
+	journal, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatalf("failed to open the journal: %v", err)
+	}
+	journal.WriteString(`{"key":"d","dead`)
+	journal.Close()
+
+	e, err = openExpiryIndex(path)
+	if err != nil {
+		t.Fatalf("openExpiryIndex() failed on the replay: %v", err)
+	}
+	defer e.close()
+	want := map[string]time.Time{"a": time.Unix(0, now.UnixNano()), "c": time.Unix(0, now.Add(2*time.Minute).UnixNano())}
+	if !reflect.DeepEqual(e.deadlines, want) {
+		t.Fatalf("replayed deadlines = %v, want %v", e.deadlines, want)
+	}
+	if expired := e.expired(now); !reflect.DeepEqual(expired, []string{"a"}) {
+		t.Fatalf("expired() = %v, want [a]", expired)
+	}
+}
+
+func TestExpiryAfterRestart(t *testing.T) {
+	root := t.TempDir() + "/"
+	a := newExpiryAdapter(t, root)
+	putContent(t, a, expiringKey, "temp", true)
+	deadline := deadlineOf(t, a, expiringKey)
+	if err := a.TearDown(context.Background()); err != nil {
+		t.Fatalf("TearDown() failed: %v", err)
+	}
+
+	a = newExpiryAdapter(t, root)
+	defer a.TearDown(context.Background())
+	// the startup reconciliation only schedules files missing from the journal, it keeps the replayed deadline
+	if got := deadlineOf(t, a, expiringKey); !got.Equal(deadline) {
+		t.Fatalf("deadline after restart = %v, want %v", got, deadline)
+	}
+	a.sweep(deadline.Add(-time.Millisecond))
+	if !exists(t, a, expiringKey) {
+		t.Fatalf("temp file was removed before its deadline")
+	}
+	a.sweep(deadline)
+	if exists(t, a, expiringKey) {
+		t.Fatalf("temp file wasn't removed after a restart")
+	}
+}
+
+func TestExpiryResetByPut(t *testing.T) {
+	a := newExpiryAdapter(t, t.TempDir()+"/")
+	defer a.TearDown(context.Background())
+	putContent(t, a, expiringKey, "first", true)
+	first := deadlineOf(t, a, expiringKey)
+	time.Sleep(10 * time.Millisecond)
+	putContent(t, a, expiringKey, "second", true)
+	second := deadlineOf(t, a, expiringKey)
+	if !second.After(first) {
+		t.Fatalf("deadline after a put = %v, want after %v", second, first)
+	}
+	a.sweep(first)
+	if content := readContent(t, a, expiringKey, ""); content != "second" {
+		t.Fatalf("content after the first deadline = %q, want the content of the second put", content)
+	}
+	a.sweep(second)
+	if exists(t, a, expiringKey) {
+		t.Fatalf("temp file wasn't removed after its reset deadline")
+	}
+
+	// persisting a temp file drops its deadline
+	putContent(t, a, expiringKey, "third", true)
+	putContent(t, a, expiringKey, "fourth", false)
+	a.sweep(time.Now().Add(2 * time.Hour))
+	if !exists(t, a, expiringKey) {
+		t.Fatalf("file was removed after it was persisted")
+	}
+}
+
+func TestSweepRacingPut(t *testing.T) {
+	a := newExpiryAdapter(t, t.TempDir()+"/")
+	defer a.TearDown(context.Background())
+	putContent(t, a, expiringKey, "temp", true)
+	deadline := deadlineOf(t, a, expiringKey)
+
+	// a put holding the key lock resets the deadline after the sweep found the file expired
+	unlock := a.locks.lock(expiringKey)
+	swept := make(chan struct{})
+	go func() {
+		a.sweep(deadline)
+		close(swept)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := a.expiry.set(expiringKey, deadline.Add(time.Hour)); err != nil {
+		t.Fatalf("set() failed: %v", err)
+	}
+	unlock()
+	<-swept
+	if !exists(t, a, expiringKey) {
+		t.Fatalf("sweep removed a file whose deadline was reset under its key lock")
+	}
+}
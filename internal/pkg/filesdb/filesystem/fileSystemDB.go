@@ -15,32 +15,65 @@ package filesystem
 
 import (
 	"context"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"openappsec.io/errors"
 	"openappsec.io/log"
 	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/common"
 )
 
 const (
-	fsBaseConfig = "filesystem_db"
-	fsConfigRoot = fsBaseConfig + ".root"
-	fsConfigTTL  = fsBaseConfig + ".ttl"
+	fsBaseConfig          = "filesystem_db"
+	fsConfigRoot          = fsBaseConfig + ".root"
+	fsConfigTTL           = fsBaseConfig + ".ttl"
+	fsConfigSweepInterval = fsBaseConfig + ".sweep_interval"
+	// versioning is opt-in, noncurrent versions are kept for their own retention
+	fsConfigVersioning          = fsBaseConfig + ".versioning.enabled"
+	fsConfigNoncurrentRetention = fsBaseConfig + ".versioning.noncurrent_retention"
+	// dedup stores identical contents once, it is opt-in
+	fsConfigDedup = fsBaseConfig + ".dedup.enabled"
+	// compression is opt-in, for the keys under a comma separated list of prefixes
+	fsConfigCompressionPrefixes = fsBaseConfig + ".compression.prefixes"
+
+	defaultSweepInterval       = time.Minute
+	defaultNoncurrentRetention = 30 * 24 * time.Hour
+
+	// systemDir holds the adapter own bookkeeping files, it is never part of a tenant namespace
+	systemDir     = ".smartsync/"
+	expiryJournal = systemDir + "expiry.journal"
+
+	healthCheckName = "filesystem"
 )
 
 // Adapter for filesystem ops on local drive
 type Adapter struct {
-	root string
-	ttl  time.Duration
+	paths  *resolver
+	ttl    time.Duration
+	expiry *expiryIndex
+	locks  keyLocks
+	// versioning keeps the previous contents of persistent files as noncurrent versions
+	versioning          bool
+	noncurrentRetention time.Duration
+	// dedup links identical contents to a single content addressed object
+	dedup bool
+	// compression selects the keys whose content is stored compressed
+	compression compressionRules
+	// uploadLocks serializes the updates of a multipart upload, they are taken before the key locks
+	uploadLocks keyLocks
+	done        chan struct{}
 }
 
 // Configuration service interface for fetching config
 type Configuration interface {
 	GetDuration(key string) (time.Duration, error)
 	GetString(key string) (string, error)
+	GetBool(key string) (bool, error)
 }
 
 // NewAdapter creates new adapter
@@ -49,7 +82,34 @@ func NewAdapter(conf Configuration) (*Adapter, error) {
 	if err != nil {
 		return &Adapter{}, err
 	}
-	ttl, err := conf.GetDuration(fsConfigTTL)
+	if root == "" {
+		return &Adapter{}, errors.Errorf("missing %v", fsConfigRoot).SetClass(errors.ClassBadInput)
+	}
+	ttl, err := common.PositiveDuration(conf, fsConfigTTL, 0)
+	if err != nil {
+		return &Adapter{}, err
+	}
+	sweepInterval, err := common.PositiveDuration(conf, fsConfigSweepInterval, defaultSweepInterval)
+	if err != nil {
+		return &Adapter{}, err
+	}
+	versioning, err := conf.GetBool(fsConfigVersioning)
+	if err != nil && !errors.IsClass(err, errors.ClassNotFound) {
+		return &Adapter{}, err
+	}
+	noncurrentRetention, err := common.PositiveDuration(conf, fsConfigNoncurrentRetention, defaultNoncurrentRetention)
+	if err != nil {
+		return &Adapter{}, err
+	}
+	dedup, err := conf.GetBool(fsConfigDedup)
+	if err != nil && !errors.IsClass(err, errors.ClassNotFound) {
+		return &Adapter{}, err
+	}
+	compressionPrefixes, err := conf.GetString(fsConfigCompressionPrefixes)
+	if err != nil && !errors.IsClass(err, errors.ClassNotFound) {
+		return &Adapter{}, err
+	}
+	compression, err := parseCompressionRules(compressionPrefixes)
 	if err != nil {
 		return &Adapter{}, err
 	}
@@ -57,82 +117,248 @@ func NewAdapter(conf Configuration) (*Adapter, error) {
 	if err != nil {
 		return &Adapter{}, err
 	}
-	cleanup(root, ttl)
-	return &Adapter{root: root, ttl: ttl}, nil
+	paths, err := newResolver(root)
+	if err != nil {
+		return &Adapter{}, err
+	}
+	err = os.MkdirAll(paths.root+systemDir, 0750)
+	if err != nil {
+		return &Adapter{}, err
+	}
+	err = migrateLegacyLayout(paths.root)
+	if err != nil {
+		return &Adapter{}, err
+	}
+	expiry, err := openExpiryIndex(paths.root + expiryJournal)
+	if err != nil {
+		return &Adapter{}, err
+	}
+	a := &Adapter{
+		paths:               paths,
+		ttl:                 ttl,
+		expiry:              expiry,
+		versioning:          versioning,
+		noncurrentRetention: noncurrentRetention,
+		dedup:               dedup,
+		compression:         compression,
+		done:                make(chan struct{}),
+	}
+	go a.reconcileRoot(time.Now())
+	go a.sweeper(sweepInterval)
+	return a, nil
 }
 
-func cleanup(root string, ttl time.Duration) {
-	go func(root string, ttl time.Duration) {
-		log.Infof("clean up root directory")
-		tempFiles := make([]string, 0)
-		err := filepath.WalkDir(
-			root,
-			func(path string, d fs.DirEntry, err error) error {
-				if err != nil || d == nil {
-					log.Errorf("fail to cleanup. dirEntry: %+v, err: %v", d, err)
-					return err
-				}
-				if d.IsDir() {
+// reconcileRoot recovers the root directory from a previous run. it removes staging files left behind by
+// writes interrupted before started, and schedules the expiration of temp files, multipart uploads and noncurrent
+// versions missing from the expiry index, such as files written before the index existed or right before a crash
+func (a *Adapter) reconcileRoot(started time.Time) {
+	log.Infof("reconcile expiry index with root directory")
+	a.reconcileVersions(started)
+	a.collectGarbage()
+	deadline := started.Add(a.ttl)
+	uploads, err := os.ReadDir(a.paths.root + uploadsDir)
+	if err != nil && !os.IsNotExist(err) {
+		log.Warnf("failed to read multipart uploads. err: %v", err)
+	}
+	for _, upload := range uploads {
+		if err := a.expiry.setIfAbsent(uploadKey(upload.Name()), deadline); err != nil {
+			log.Warnf("failed to schedule expiration of multipart upload %v. err: %v", upload.Name(), err)
+		}
+	}
+	err = filepath.WalkDir(
+		a.paths.root+models.TenantsDir,
+		func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
 					return nil
 				}
-				log.Debugf("checking file: %v", path)
-				if models.IsTempFile(path) {
-					tempFiles = append(tempFiles, path)
-				}
+				log.Errorf("fail to reconcile expiry index. dirEntry: %+v, err: %v", d, err)
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			if isStagingFile(d.Name()) {
+				removeStaleStagingFile(path, d, started)
 				return nil
-			},
-		)
-		if err != nil {
-			log.Warnf("failed to cleanup old temp files")
-		}
-		log.Debug("cleanup list is ready")
-		time.Sleep(ttl)
-		log.Infof("removing files: %v", tempFiles)
-		for _, filePath := range tempFiles {
-			err := os.Remove(filePath)
-			if err != nil && !os.IsNotExist(err) {
-				log.Warnf("failed to cleanup file: %v", filePath)
 			}
+			storageKey := a.paths.key(path)
+			_, key, ok := models.SplitTenantNamespace(storageKey)
+			if ok && models.IsTempFile(key) {
+				log.Debugf("scheduling expiration of file: %v", storageKey)
+				return a.expiry.setIfAbsent(storageKey, deadline)
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		log.Warnf("failed to reconcile expiry index. err: %v", err)
+	}
+}
+
+// sweeper removes expired temp files every interval until the adapter is torn down
+func (a *Adapter) sweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		a.sweep(time.Now())
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
 		}
-	}(root, ttl)
+	}
+}
+
+func (a *Adapter) sweep(now time.Time) {
+	for _, key := range a.expiry.expired(now) {
+		log.Debugf("ttl expired for file: %v", key)
+		if err := a.expireKey(key, now); err != nil {
+			log.Warnf("failed to remove expired file %v. err: %v", key, err)
+		}
+	}
+}
+
+// expireKey removes the temp file, upload or version of an expiry index key if its deadline passed.
+// the deadline of a file is checked again under the key lock, and the deadline of an upload under the upload
+// lock, which their writes hold while resetting it
+func (a *Adapter) expireKey(key string, now time.Time) error {
+	if strings.HasPrefix(key, uploadsDir) {
+		uploadID := strings.TrimPrefix(key, uploadsDir)
+		unlock := a.uploadLocks.lock(uploadID)
+		defer unlock()
+		return a.expiry.expire(key, now, func() error { return a.removeUpload(uploadID) })
+	}
+	if strings.HasPrefix(key, versionsDir) {
+		objectKey, versionID, ok := splitVersionKey(key)
+		if !ok {
+			log.Warnf("dropping invalid version %v from expiry index", key)
+			return a.expiry.expire(key, now, func() error { return nil })
+		}
+		// the version may be promoted, or its tags rewritten, concurrently under the key lock
+		unlock := a.locks.lock(objectKey)
+		defer unlock()
+		return a.expiry.expire(key, now, func() error { return a.removeVersion(objectKey, versionID) })
+	}
+	filePath, err := a.paths.resolveFile(key)
+	if err != nil {
+		// an invalid key can't exist on disk, drop it from the index
+		log.Warnf("dropping invalid key %v from expiry index. err: %v", key, err)
+		return a.expiry.expire(key, now, func() error { return nil })
+	}
+	unlock := a.locks.lock(key)
+	defer unlock()
+	return a.expiry.expire(key, now, func() error { return a.removeObject(key, filePath) })
 }
 
-// GetFilesList return a list of files with their last modified time
-func (a *Adapter) GetFilesList(ctx context.Context, pathPrefix string) ([]models.FileMetadata, error) {
-	log.WithContext(ctx).Infof("list files with prefix: %v", pathPrefix)
-	matches, err := filepath.Glob(a.root + pathPrefix + "*")
+// HealthCheck checks that the root directory is accessible
+func (a *Adapter) HealthCheck(ctx context.Context) (string, error) {
+	if _, err := os.Stat(a.paths.root + systemDir); err != nil {
+		return healthCheckName, errors.Wrap(err, "root directory is not accessible")
+	}
+	return healthCheckName, nil
+}
+
+// TearDown stops the expiry sweeper and closes the expiry index
+func (a *Adapter) TearDown(ctx context.Context) error {
+	close(a.done)
+	return a.expiry.close()
+}
+
+// GetFilesList return a page of the files matching the options, sorted by key, with their metadata.
+// when a delimiter is given, keys sharing a common prefix are rolled up into it, and each common prefix counts
+// as a single key of the page.
+func (a *Adapter) GetFilesList(ctx context.Context, options models.ListOptions) (models.FilesList, error) {
+	log.WithContext(ctx).Infof("list files with options: %+v", options)
+	dir, namePrefix, err := a.paths.resolvePrefix(options.Prefix)
 	if err != nil {
-		return []models.FileMetadata{}, err
+		return models.FilesList{}, err
 	}
-	log.WithContext(ctx).Infof("matched: %v", matches)
-	var files []models.FileMetadata
-	for _, match := range matches {
-		log.WithContext(ctx).Debugf("walk dir: %v", match)
-		err = filepath.WalkDir(
-			match,
-			func(path string, d fs.DirEntry, err error) error {
-				if d.IsDir() {
+	list := models.FilesList{Files: []models.FileMetadata{}, CommonPrefixes: []string{}}
+	lastPrefix := ""
+	err = a.walkSorted(
+		dir, namePrefix, options.StartAfter,
+		func(key string, d fs.DirEntry) error {
+			if d.IsDir() {
+				// all the keys below a directory containing the delimiter share the same common prefix
+				if cp := common.CommonPrefix(key, options.Prefix, options.Delimiter); cp != "" {
+					if cp == lastPrefix || cp == options.StartAfter {
+						return fs.SkipDir
+					}
+					if options.MaxKeys > 0 && len(list.Files)+len(list.CommonPrefixes) == options.MaxKeys {
+						list.IsTruncated = true
+						return errStopWalk
+					}
+					list.CommonPrefixes = append(list.CommonPrefixes, cp)
+					lastPrefix = cp
+					return fs.SkipDir
+				}
+				return nil
+			}
+			cp := common.CommonPrefix(key, options.Prefix, options.Delimiter)
+			if cp != "" && (cp == lastPrefix || cp == options.StartAfter) {
+				return nil
+			}
+			if cp != "" {
+				if options.MaxKeys > 0 && len(list.Files)+len(list.CommonPrefixes) == options.MaxKeys {
+					list.IsTruncated = true
+					return errStopWalk
+				}
+				list.CommonPrefixes = append(list.CommonPrefixes, cp)
+				lastPrefix = cp
+				return nil
+			}
+			fileInfo, err := d.Info()
+			if err != nil {
+				if os.IsNotExist(err) {
+					// removed while listing
 					return nil
 				}
-				fileInfo, err := d.Info()
-				if err != nil {
-					return err
+				return err
+			}
+			metadata, err := a.statObject(key, a.paths.root+key, fileInfo)
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
 				}
-				log.WithContext(ctx).Debugf("adding file: %v to response", path)
-				path = path[len(a.root):]
-				log.WithContext(ctx).Infof("%v", path)
-				files = append(files, models.FileMetadata{Path: path, LastModified: fileInfo.ModTime()})
+				return err
+			}
+			if !models.TagsMatch(metadata.Tags, options.Tags) {
 				return nil
-			},
-		)
+			}
+			// checked once the file is known to match, so a page isn't reported truncated by files filtered out
+			if options.MaxKeys > 0 && len(list.Files)+len(list.CommonPrefixes) == options.MaxKeys {
+				list.IsTruncated = true
+				return errStopWalk
+			}
+			log.WithContext(ctx).Debugf("adding file: %v to response", key)
+			list.Files = append(list.Files, metadata)
+			return nil
+		},
+	)
+	if err != nil && err != errStopWalk {
+		log.WithContext(ctx).Errorf("failed to list files with options %+v. err: %v", options, err)
+		return models.FilesList{}, err
 	}
-	return files, nil
+	return list, nil
+}
+
+// fileReader streams a section of an open file and closes it when done
+type fileReader struct {
+	io.Reader
+	io.Closer
 }
 
-// GetFile return file content
-func (a *Adapter) GetFile(ctx context.Context, path string) ([]byte, error) {
-	log.WithContext(ctx).Debugf("get file: %v", path)
-	data, err := os.ReadFile(a.root + path)
+// GetFile return a reader streaming the file content and the file metadata, the caller must close the reader.
+// only the requested range of bytes of a content stored as is is read from disk. a content stored compressed is
+// decompressed up to the range, unless the client accepts its encoding and the whole content is requested
+func (a *Adapter) GetFile(ctx context.Context, path string, options models.GetOptions) (io.ReadCloser, models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("get file: %v, options: %+v", path, options)
+	filePath, err := a.paths.resolveFile(path)
+	if err != nil {
+		return nil, models.FileMetadata{}, err
+	}
+	f, fileInfo, meta, err := a.openObject(path, filePath, options.VersionID)
 	if err != nil {
 		if os.IsNotExist(err) {
 			log.WithContext(ctx).Warnf("file %v not found", path)
@@ -140,32 +366,313 @@ func (a *Adapter) GetFile(ctx context.Context, path string) ([]byte, error) {
 		} else {
 			log.WithContext(ctx).Errorf("failed to read file %v", path)
 		}
-		return []byte{}, err
+		return nil, models.FileMetadata{}, err
 	}
-	log.WithContext(ctx).Debugf("got file, file length %v", len(data))
-	return data, nil
+	metadata := fileMetadata(path, fileInfo, meta)
+	// the whole content as stored is the open file itself, which the http server sends with sendfile
+	if options.ReturnsEncoded(meta.Encoding) {
+		log.WithContext(ctx).Debugf("streaming file as stored, encoding %v", meta.Encoding)
+		return f, metadata, nil
+	}
+	offset, length := int64(0), metadata.Size
+	if options.Range != nil {
+		var ok bool
+		offset, length, ok = options.Range.Resolve(metadata.Size)
+		if !ok {
+			f.Close()
+			return nil, models.FileMetadata{}, errors.Errorf(
+				"range %+v not satisfiable for file %v of size %v", *options.Range, path, metadata.Size,
+			).SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidRange)
+		}
+	}
+	log.WithContext(ctx).Debugf("streaming file, offset %v, length %v", offset, length)
+	if meta.Encoding == "" && offset == 0 && length == fileInfo.Size() {
+		return f, metadata, nil
+	}
+	content, err := openContent(f, fileInfo.Size(), meta.Encoding, offset, length)
+	if err != nil {
+		f.Close()
+		log.WithContext(ctx).Errorf("failed to read file %v. err: %v", path, err)
+		return nil, models.FileMetadata{}, err
+	}
+	return fileReader{Reader: content, Closer: f}, metadata, nil
 }
 
-// PutFile write a file, set ttl if isTemp is true
-func (a *Adapter) PutFile(ctx context.Context, path string, content []byte, isTemp bool) error {
-	log.WithContext(ctx).Debugf("put file: %v, length: %v", path, len(content))
+// openFile opens the file in filePath for reading, a directory is reported as a file which doesn't exist
+func openFile(filePath string) (*os.File, fs.FileInfo, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	fileInfo, err := f.Stat()
+	if err == nil && fileInfo.IsDir() {
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, fileInfo, nil
+}
 
-	if err := os.MkdirAll(a.root+filepath.Dir(path), 0750); err != nil && !os.IsExist(err) {
-		return err
+// StatFile return file metadata without reading its content, or the metadata of one of its versions when
+// versionID is set
+func (a *Adapter) StatFile(ctx context.Context, path string, versionID string) (models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("stat file: %v, version: %v", path, versionID)
+	filePath, err := a.paths.resolveFile(path)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	if versionID != "" {
+		f, fileInfo, meta, err := a.openObject(path, filePath, versionID)
+		if err != nil {
+			if os.IsNotExist(err) {
+				log.WithContext(ctx).Debugf("version %v of file %v not found", versionID, path)
+				return models.FileMetadata{}, errors.Wrap(err, "file not found").SetClass(errors.ClassNotFound)
+			}
+			log.WithContext(ctx).Errorf("failed to stat file %v", path)
+			return models.FileMetadata{}, err
+		}
+		f.Close()
+		return fileMetadata(path, fileInfo, meta), nil
+	}
+	fileInfo, err := os.Stat(filePath)
+	if err == nil && fileInfo.IsDir() {
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.WithContext(ctx).Debugf("file %v not found", path)
+			return models.FileMetadata{}, errors.Wrap(err, "file not found").SetClass(errors.ClassNotFound)
+		}
+		log.WithContext(ctx).Errorf("failed to stat file %v", path)
+		return models.FileMetadata{}, err
 	}
-	if err := os.WriteFile(a.root+path, content, 0666); err != nil {
+	metadata, err := a.statObject(path, filePath, fileInfo)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.WithContext(ctx).Debugf("file %v not found", path)
+			return models.FileMetadata{}, errors.Wrap(err, "file not found").SetClass(errors.ClassNotFound)
+		}
+		log.WithContext(ctx).Errorf("failed to stat file %v", path)
+		return models.FileMetadata{}, err
+	}
+	return metadata, nil
+}
+
+// PutFile write a file streamed from content, set ttl if isTemp is true.
+// the content is staged and verified against the digests it was sent with before it replaces the file
+func (a *Adapter) PutFile(ctx context.Context, path string, content io.Reader, isTemp bool, options models.PutOptions) (models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("put file: %v, options: %+v", path, options)
+
+	filePath, err := a.paths.resolveFile(path)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	h := common.NewContentHasher()
+	encoding := a.compression.encoding(path, options.Attributes)
+	staged, err := stageEncodedFile(filePath, io.TeeReader(content, h), encoding)
+	if err != nil {
 		log.WithContext(ctx).Errorf("failed to put file: %v", err)
-		return err
+		return models.FileMetadata{}, err
+	}
+	if err := h.Verify(options); err != nil {
+		staged.discard()
+		log.WithContext(ctx).Warnf("rejecting corrupted content of file %v. err: %v", path, err)
+		return models.FileMetadata{}, err
 	}
+	meta := hashedMeta(h, staged.info)
+	meta.Attributes = options.Attributes
+	meta.Encoding = encoding
+	return a.commitObject(ctx, path, staged, meta, isTemp, options)
+}
+
+// CopyFile copies the file stored under srcPath to dstPath, set ttl if isTemp is true.
+// unless its metadata is replaced, the copy keeps the metadata of the source, the last modified time included
+func (a *Adapter) CopyFile(ctx context.Context, srcPath string, dstPath string, isTemp bool, options models.CopyOptions) (models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("copy file: %v to %v, options: %+v", srcPath, dstPath, options)
+	srcFilePath, err := a.paths.resolveFile(srcPath)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	dstFilePath, err := a.paths.resolveFile(dstPath)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	f, fileInfo, meta, err := a.openObject(srcPath, srcFilePath, options.SourceVersionID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.WithContext(ctx).Warnf("file %v not found", srcPath)
+			return models.FileMetadata{}, errors.Wrap(err, "file not found").SetClass(errors.ClassNotFound)
+		}
+		log.WithContext(ctx).Errorf("failed to read file %v", srcPath)
+		return models.FileMetadata{}, err
+	}
+	defer f.Close()
+	// the content is the same, so is its metadata. the copy is done by the kernel when it can, and keeps the
+	// encoding of the source whatever the compression rules of the destination are
+	staged, err := stageFile(dstFilePath, f)
+	if err != nil {
+		log.WithContext(ctx).Errorf("failed to copy file: %v", err)
+		return models.FileMetadata{}, err
+	}
+	if !options.ReplaceMetadata {
+		if err := staged.setModTime(fileMetadata(srcPath, fileInfo, meta).LastModified); err != nil {
+			staged.discard()
+			log.WithContext(ctx).Errorf("failed to copy file: %v", err)
+			return models.FileMetadata{}, err
+		}
+	}
+	meta.Stamp = stampOf(staged.info)
+	if options.ReplaceMetadata {
+		meta.Attributes = options.Attributes
+	}
+	return a.commitObject(ctx, dstPath, staged, meta, isTemp, models.PutOptions{})
+}
+
+// commitObject replaces the object stored under key with the staged content described by meta.
+// the preconditions are checked and the object replaced while holding the key lock, so a conditional write
+// never overwrites a concurrent one it didn't observe
+func (a *Adapter) commitObject(ctx context.Context, key string, staged *stagedFile, meta objectMeta, isTemp bool, options models.PutOptions) (models.FileMetadata, error) {
+	unlock := a.locks.lock(key)
+	defer unlock()
+	if options.Conditional() {
+		if err := a.checkPreconditions(key, staged.filePath, options); err != nil {
+			staged.discard()
+			return models.FileMetadata{}, err
+		}
+	}
+	// the deadline is persisted before the content, a crash in between at worst expires a file which wasn't written
+	var err error
 	if isTemp {
-		go func() {
-			time.Sleep(a.ttl)
-			log.WithContext(ctx).Debugf("ttl expired for file: %v", path)
-			err := os.Remove(a.root + path)
-			if err != nil {
-				log.WithContext(ctx).Warnf("failed to remove file %v. err: %v", path, err)
-			}
-		}()
+		err = a.expiry.set(key, time.Now().Add(a.ttl))
+	} else {
+		err = a.expiry.remove(key)
+	}
+	if err != nil {
+		staged.discard()
+		log.WithContext(ctx).Errorf("failed to update expiry index: %v", err)
+		return models.FileMetadata{}, err
+	}
+	meta.VersionID = ""
+	if a.versioned(isTemp) {
+		if meta.VersionID, err = common.NewVersionID(); err != nil {
+			staged.discard()
+			return models.FileMetadata{}, err
+		}
+		if err := a.archiveCurrent(key, staged.filePath); err != nil {
+			staged.discard()
+			log.WithContext(ctx).Errorf("failed to keep the previous version of file %v. err: %v", key, err)
+			return models.FileMetadata{}, err
+		}
+	}
+	// a private content is last modified when it was staged, a deduplicated one keeps its time in the metadata
+	meta.LastModified = 0
+	if a.dedup {
+		if err := a.intern(staged, &meta); err != nil {
+			staged.discard()
+			log.WithContext(ctx).Errorf("failed to deduplicate file %v. err: %v", key, err)
+			return models.FileMetadata{}, err
+		}
+	}
+	previous, _ := a.readMeta(key)
+	if err := staged.commit(); err != nil {
+		log.WithContext(ctx).Errorf("failed to put file: %v", err)
+		return models.FileMetadata{}, err
+	}
+	if err := a.writeMeta(key, meta); err != nil {
+		// the content is in place, its metadata is recomputed on the next read
+		log.WithContext(ctx).Warnf("failed to write metadata of file %v. err: %v", key, err)
+	}
+	if previous.ChecksumSHA256 != meta.ChecksumSHA256 || previous.Encoding != meta.Encoding {
+		a.release(previous)
+	}
+	return fileMetadata(key, staged.info, meta), nil
+}
+
+// checkPreconditions checks the preconditions of a write against the file currently stored under key,
+// must be called while holding the key lock
+func (a *Adapter) checkPreconditions(key string, filePath string, options models.PutOptions) error {
+	meta, exists, err := a.currentMeta(key, filePath)
+	if err != nil {
+		return err
+	}
+	if !options.Satisfied(meta.ETag, exists) {
+		return errors.Errorf("precondition %+v failed for file %v", options, key).
+			SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelPreconditionFailed)
+	}
+	return nil
+}
+
+// DeleteFile removes a file, or one of its versions when versionID is set. deleting a file which doesn't exist
+// succeeds. when versioning is on, the removed content of a persistent file is kept as a noncurrent version
+func (a *Adapter) DeleteFile(ctx context.Context, path string, versionID string) error {
+	log.WithContext(ctx).Debugf("delete file: %v, version: %v", path, versionID)
+	filePath, err := a.paths.resolveFile(path)
+	if err != nil {
+		return err
+	}
+	if versionID != "" {
+		if err := a.deleteVersion(path, filePath, versionID); err != nil {
+			log.WithContext(ctx).Errorf("failed to delete version %v of file %v. err: %v", versionID, path, err)
+			return err
+		}
+		return nil
+	}
+	// the expiry is dropped first, so a concurrent put is never left without its deadline
+	if err := a.expiry.remove(path); err != nil {
+		log.WithContext(ctx).Errorf("failed to update expiry index: %v", err)
+		return err
+	}
+	unlock := a.locks.lock(path)
+	defer unlock()
+	if a.versioned(common.IsTempKey(path)) {
+		if err := a.archiveCurrent(path, filePath); err != nil {
+			log.WithContext(ctx).Errorf("failed to keep the deleted version of file %v. err: %v", path, err)
+			return err
+		}
+	}
+	if err := a.removeObject(path, filePath); err != nil {
+		log.WithContext(ctx).Errorf("failed to delete file %v. err: %v", path, err)
+		return err
+	}
+	return nil
+}
+
+// DeleteFiles removes a batch of files, returning the outcome of each deletion
+func (a *Adapter) DeleteFiles(ctx context.Context, paths []string) []models.DeleteResult {
+	results := make([]models.DeleteResult, len(paths))
+	for i, path := range paths {
+		results[i] = models.DeleteResult{Path: path, Err: a.DeleteFile(ctx, path, "")}
+	}
+	return results
+}
+
+// removeObject removes the content and the sidecar of the object stored under key in filePath,
+// and the deduplicated content it was the last reference to
+func (a *Adapter) removeObject(key string, filePath string) error {
+	meta, _ := a.readMeta(key)
+	if err := removeFile(filePath, a.paths.root+models.TenantsDir); err != nil {
+		return err
+	}
+	if err := a.removeMeta(key); err != nil {
+		return err
+	}
+	a.release(meta)
+	return nil
+}
+
+// removeFile removes the file in filePath and prunes the parent directories it leaves empty up to stop,
+// so they don't show up as common prefixes in listings
+func removeFile(filePath string, stop string) error {
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := filepath.Dir(filePath); strings.HasPrefix(dir, stop) && len(dir) > len(stop); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			// not empty, or already removed
+			break
+		}
 	}
 	return nil
 }
@@ -0,0 +1,89 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+
+	"openappsec.io/errors"
+)
+
+// errStopWalk stops walkSorted without failing it
+var errStopWalk = errors.New("stop walk")
+
+// sortName returns the name an entry is sorted by. a directory is sorted as its name followed by a slash,
+// which is the common prefix of all the keys below it, so walking entries in this order visits keys sorted
+func sortName(entry fs.DirEntry) string {
+	if entry.IsDir() {
+		return entry.Name() + "/"
+	}
+	return entry.Name()
+}
+
+func sortedEntries(dir string) ([]fs.DirEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return sortName(entries[i]) < sortName(entries[j]) })
+	return entries, nil
+}
+
+// walkSorted calls fn for every entry below dir whose name starts with namePrefix, in lexicographic key order.
+// fn is called for a directory, with its key followed by a slash, before the entries below it, returning
+// fs.SkipDir skips them. files sorted before or at startAfter are skipped, as are whole directories whose
+// keys all sort before it. symlinks are never followed, their target may be outside of the root directory,
+// and staging files of writes in progress are not keys.
+func (a *Adapter) walkSorted(dir string, namePrefix string, startAfter string, fn func(key string, d fs.DirEntry) error) error {
+	entries, err := sortedEntries(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), namePrefix) || entry.Type()&fs.ModeSymlink != 0 || isStagingFile(entry.Name()) {
+			continue
+		}
+		path := dir + entry.Name()
+		key := a.paths.key(path)
+		if entry.IsDir() {
+			if !strings.HasPrefix(startAfter, key+"/") && key+"/" < startAfter {
+				continue
+			}
+			err := fn(key+"/", entry)
+			if err == fs.SkipDir {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := a.walkSorted(path+"/", "", startAfter, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if key <= startAfter {
+			continue
+		}
+		if err := fn(key, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
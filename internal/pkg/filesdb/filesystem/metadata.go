@@ -0,0 +1,266 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"bytes"
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"openappsec.io/errors"
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/common"
+)
+
+const (
+	// metaDir holds a sidecar file per object, describing its content. it mirrors the layout of the keys
+	metaDir    = systemDir + "meta/"
+	metaSuffix = ".meta"
+
+	keyLockStripes = 256
+)
+
+// keyLocks serializes the updates of a key, keys are spread over a fixed set of mutexes
+type keyLocks [keyLockStripes]sync.Mutex
+
+// lock locks key and returns the function unlocking it
+func (l *keyLocks) lock(key string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	mu := &l[h.Sum32()%keyLockStripes]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// contentStamp identifies a version of an object content on disk.
+// every write renames a new file into place, so the inode changes along with the modification time and size
+type contentStamp struct {
+	Inode   uint64 `json:"inode"`
+	ModTime int64  `json:"modTime"`
+	Size    int64  `json:"size"`
+}
+
+func stampOf(info fs.FileInfo) contentStamp {
+	stamp := contentStamp{ModTime: info.ModTime().UnixNano(), Size: info.Size()}
+	if sys, ok := info.Sys().(*syscall.Stat_t); ok {
+		stamp.Inode = sys.Ino
+	}
+	return stamp
+}
+
+// objectMeta is persisted in a sidecar file next to every object, and stamped with the content it describes.
+// a sidecar whose stamp doesn't match the content, left behind by a crash or read during a concurrent write,
+// is stale, and the metadata is recomputed from the content. the attributes and tags the object was stored with
+// can't be recomputed, a crash between the write of the content and the write of its sidecar loses them.
+type objectMeta struct {
+	Stamp          contentStamp            `json:"stamp"`
+	ETag           string                  `json:"etag"`
+	ChecksumSHA256 string                  `json:"checksumSHA256"`
+	Attributes     models.ObjectAttributes `json:"attributes"`
+	// VersionID is empty for content stored while versioning was off
+	VersionID string            `json:"versionId,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	// LastModified is set, in nanoseconds, for deduplicated content whose modification time is shared
+	LastModified int64 `json:"lastModified,omitempty"`
+	// Encoding is the content coding the content is compressed with at rest, Size is its decompressed size
+	Encoding string `json:"encoding,omitempty"`
+	Size     int64  `json:"size"`
+}
+
+// hashedMeta returns the metadata of the content hashed by h, described by info
+func hashedMeta(h *common.ContentHasher, info fs.FileInfo) objectMeta {
+	return objectMeta{
+		Stamp:          stampOf(info),
+		ETag:           h.ETag(),
+		ChecksumSHA256: h.ChecksumSHA256(),
+		Size:           h.Size(),
+	}
+}
+
+// computeMeta reads the whole content described by info and computes its metadata
+func computeMeta(content io.Reader, info fs.FileInfo) (objectMeta, error) {
+	h := common.NewContentHasher()
+	if _, err := io.Copy(h, content); err != nil {
+		return objectMeta{}, err
+	}
+	return hashedMeta(h, info), nil
+}
+
+func (a *Adapter) metaPath(key string) string {
+	return a.paths.root + metaDir + key + metaSuffix
+}
+
+// readJSON decodes the JSON file in path into v
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSON replaces the file in path with v encoded as JSON
+func writeJSON(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal %v", path)
+	}
+	return writeFile(path, bytes.NewReader(data))
+}
+
+// readMeta returns the sidecar in path, ok is false when it is missing or corrupted
+func readMeta(path string) (objectMeta, bool) {
+	var meta objectMeta
+	if err := readJSON(path, &meta); err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("ignoring unreadable metadata %v. err: %v", path, err)
+		}
+		return objectMeta{}, false
+	}
+	return meta, true
+}
+
+// readMeta returns the sidecar of key, ok is false when it is missing or corrupted
+func (a *Adapter) readMeta(key string) (objectMeta, bool) {
+	return readMeta(a.metaPath(key))
+}
+
+// writeMeta replaces the sidecar of key, must be called while holding the key lock
+func (a *Adapter) writeMeta(key string, meta objectMeta) error {
+	return writeJSON(a.metaPath(key), meta)
+}
+
+// removeMeta removes the sidecar of key, must be called while holding the key lock
+func (a *Adapter) removeMeta(key string) error {
+	return removeFile(a.metaPath(key), a.paths.root+metaDir+models.TenantsDir)
+}
+
+// contentMeta returns the metadata of the object stored under key, whose content is open in f.
+// the metadata is recomputed from f when the sidecar is missing or stale, recomputed is true in that case.
+func (a *Adapter) contentMeta(key string, f *os.File, info fs.FileInfo) (meta objectMeta, recomputed bool, err error) {
+	if meta, ok := a.readMeta(key); ok && meta.Stamp == stampOf(info) {
+		return meta, false, nil
+	}
+	log.Debugf("metadata of %v is missing or stale, computing it", key)
+	meta, err = computeObjectMeta(f, info)
+	if err != nil {
+		return objectMeta{}, false, errors.Wrapf(err, "failed to compute metadata of %v", key)
+	}
+	return meta, true, nil
+}
+
+// loadMeta returns the metadata of the object stored under key, whose content is open in f,
+// and repairs its sidecar when it is missing or stale
+func (a *Adapter) loadMeta(key string, filePath string, f *os.File, info fs.FileInfo) (objectMeta, error) {
+	meta, recomputed, err := a.contentMeta(key, f, info)
+	if err != nil {
+		return objectMeta{}, err
+	}
+	if recomputed {
+		a.repairMeta(key, filePath, meta)
+	}
+	return meta, nil
+}
+
+// currentMeta returns the metadata of the object currently stored under key, exists is false when there is none.
+// must be called while holding the key lock
+func (a *Adapter) currentMeta(key string, filePath string) (meta objectMeta, exists bool, err error) {
+	f, info, err := openFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return objectMeta{}, false, nil
+		}
+		return objectMeta{}, false, err
+	}
+	defer f.Close()
+	meta, _, err = a.contentMeta(key, f, info)
+	if err != nil {
+		return objectMeta{}, false, err
+	}
+	return meta, true, nil
+}
+
+// repairMeta replaces the stale sidecar of key with meta, unless the content was replaced in the meantime
+func (a *Adapter) repairMeta(key string, filePath string, meta objectMeta) {
+	unlock := a.locks.lock(key)
+	defer unlock()
+	info, err := os.Stat(filePath)
+	if err != nil || stampOf(info) != meta.Stamp {
+		return
+	}
+	if current, ok := a.readMeta(key); ok && current.Stamp == meta.Stamp {
+		return
+	}
+	if err := a.writeMeta(key, meta); err != nil {
+		log.Warnf("failed to repair metadata of %v. err: %v", key, err)
+	}
+}
+
+// statObject returns the metadata of the object stored under key in filePath, described by info.
+// the content is only read when the sidecar is missing or stale.
+func (a *Adapter) statObject(key string, filePath string, info fs.FileInfo) (models.FileMetadata, error) {
+	if meta, ok := a.readMeta(key); ok && meta.Stamp == stampOf(info) {
+		return fileMetadata(key, info, meta), nil
+	}
+	f, info, err := openFile(filePath)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	defer f.Close()
+	meta, err := a.loadMeta(key, filePath, f, info)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	return fileMetadata(key, info, meta), nil
+}
+
+// lastModified returns the last modified time of the object described by info and meta.
+// a deduplicated content is shared, its modification time is the time it was first stored
+func lastModified(info fs.FileInfo, meta objectMeta) time.Time {
+	if meta.LastModified != 0 {
+		return time.Unix(0, meta.LastModified)
+	}
+	return info.ModTime()
+}
+
+// contentSize returns the size of the content of the object described by info and meta, once decompressed
+func contentSize(info fs.FileInfo, meta objectMeta) int64 {
+	if meta.Encoding != "" {
+		return meta.Size
+	}
+	return info.Size()
+}
+
+// fileMetadata returns the metadata of the object stored under key
+func fileMetadata(key string, info fs.FileInfo, meta objectMeta) models.FileMetadata {
+	return models.FileMetadata{
+		Path:           key,
+		LastModified:   lastModified(info, meta),
+		Size:           contentSize(info, meta),
+		ETag:           meta.ETag,
+		ChecksumSHA256: meta.ChecksumSHA256,
+		Attributes:     meta.Attributes,
+		VersionID:      meta.VersionID,
+		Tags:           meta.Tags,
+		StoredSize:     info.Size(),
+		StoredEncoding: meta.Encoding,
+	}
+}
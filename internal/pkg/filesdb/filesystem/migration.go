@@ -0,0 +1,89 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"openappsec.io/errors"
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+// migrateLegacyLayout moves files stored before tenant namespaces were introduced into their tenant namespace.
+// smartsync keys are laid out as <tenant id>/<asset id>/..., so the first path segment of a legacy file is the
+// tenant owning it. the key itself is kept as is, so clients keep using the same paths after the migration.
+func migrateLegacyLayout(root string) error {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read root directory %v", root)
+	}
+	for _, entry := range entries {
+		if entry.Name()+"/" == models.TenantsDir || entry.Name()+"/" == systemDir {
+			continue
+		}
+		if !entry.IsDir() {
+			log.Warnf("legacy file %v is not owned by any tenant, skipping migration", entry.Name())
+			continue
+		}
+		namespace, err := models.TenantNamespace(entry.Name())
+		if err != nil {
+			log.Warnf("legacy directory %v is not a tenant directory, skipping migration", entry.Name())
+			continue
+		}
+		log.Infof("migrating legacy directory %v into namespace %v", entry.Name(), namespace)
+		if err := migrateLegacyDir(root, root+entry.Name(), root+namespace); err != nil {
+			return errors.Wrapf(err, "failed to migrate legacy directory %v", entry.Name())
+		}
+	}
+	return nil
+}
+
+// migrateLegacyDir moves every file under legacyDir into namespaceRoot and removes the emptied directories
+func migrateLegacyDir(root string, legacyDir string, namespaceRoot string) error {
+	var dirs []string
+	err := filepath.WalkDir(
+		legacyDir,
+		func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				dirs = append(dirs, path)
+				return nil
+			}
+			target := namespaceRoot + path[len(root):]
+			if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+				return err
+			}
+			log.Debugf("migrating file %v to %v", path, target)
+			return os.Rename(path, target)
+		},
+	)
+	if err != nil {
+		return err
+	}
+	// remove the deepest directories first so their parents are empty by the time they are removed
+	sort.Slice(dirs, func(i, j int) bool { return strings.Count(dirs[i], "/") > strings.Count(dirs[j], "/") })
+	for _, dir := range dirs {
+		if err := os.Remove(dir); err != nil && !os.IsNotExist(err) {
+			log.Warnf("failed to remove migrated directory %v. err: %v", dir, err)
+		}
+	}
+	return nil
+}
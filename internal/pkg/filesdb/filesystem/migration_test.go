@@ -0,0 +1,106 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"openappsec.io/smartsync-shared-files/internal/pkg/testutil"
+)
+
+// writeLegacyFile writes a file of the layout preceding the tenant namespaces
+func writeLegacyFile(t *testing.T, root string, key string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(root+key), 0750); err != nil {
+		t.Fatalf("failed to create the directory of %v: %v", key, err)
+	}
+	if err := os.WriteFile(root+key, []byte(content), 0640); err != nil {
+		t.Fatalf("failed to write %v: %v", key, err)
+	}
+}
+
+// rootFiles returns the files under root, outside of the system directory
+func rootFiles(t *testing.T, root string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		key := strings.TrimPrefix(path, root)
+		if d.IsDir() && key+"/" == systemDir {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() {
+			files = append(files, key)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk %v: %v", root, err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestMigrateLegacyLayout(t *testing.T) {
+	root := t.TempDir() + "/"
+	conf := testutil.Configuration{fsConfigRoot: root, fsConfigTTL: time.Hour}
+	writeLegacyFile(t, root, "t1/ag/remote/file", "legacy of t1")
+	writeLegacyFile(t, root, "t2/ag/remote/file", "legacy of t2")
+	writeLegacyFile(t, root, "stray", "owned by no tenant")
+	writeLegacyFile(t, root, "tenants/t1/ag/remote/file", "namespaced")
+	migrated := []string{
+		"stray",
+		"tenants/t1/ag/remote/file",
+		"tenants/t1/t1/ag/remote/file",
+		"tenants/t2/t2/ag/remote/file",
+	}
+
+	// the second start finds nothing left to migrate, the namespaces aren't taken for legacy directories
+	for start := 1; start <= 2; start++ {
+		a, err := NewAdapter(conf)
+		if err != nil {
+			t.Fatalf("NewAdapter() failed on start %v: %v", start, err)
+		}
+		if files := rootFiles(t, root); !reflect.DeepEqual(files, migrated) {
+			t.Fatalf("files after start %v = %v, want %v", start, files, migrated)
+		}
+		for key, want := range map[string]string{
+			"tenants/t1/t1/ag/remote/file": "legacy of t1",
+			"tenants/t2/t2/ag/remote/file": "legacy of t2",
+			"tenants/t1/ag/remote/file":    "namespaced",
+		} {
+			if content := readContent(t, a, key, ""); content != want {
+				t.Fatalf("content of %v after start %v = %q, want %q", key, start, content, want)
+			}
+		}
+		if err := a.TearDown(context.Background()); err != nil {
+			t.Fatalf("TearDown() failed: %v", err)
+		}
+	}
+	for _, dir := range []string{"t1", "t2"} {
+		if _, err := os.Stat(root + dir); !os.IsNotExist(err) {
+			t.Fatalf("legacy directory %v wasn't removed, err: %v", dir, err)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"openappsec.io/errors"
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/common"
+)
+
+const (
+	// uploadsDir holds a directory per multipart upload in progress, with the parts uploaded so far
+	uploadsDir     = systemDir + "uploads/"
+	uploadManifest = "upload.json"
+	uploadIDSize   = 16
+)
+
+// upload is the manifest of a multipart upload
+type upload struct {
+	Key       string `json:"key"`
+	Initiated int64  `json:"initiated"`
+	// Attributes are the attributes the assembled file is stored with
+	Attributes models.ObjectAttributes `json:"attributes"`
+}
+
+// uploadKey is the key of a multipart upload in the expiry index
+func uploadKey(uploadID string) string {
+	return uploadsDir + uploadID
+}
+
+// partName is the name of the file a part is stored in, padded so parts are sorted by number
+func partName(partNumber int) string {
+	return fmt.Sprintf("%05d", partNumber)
+}
+
+func noSuchUploadError(uploadID string) error {
+	return errors.Errorf("multipart upload %q not found", uploadID).
+		SetClass(errors.ClassNotFound).SetLabel(models.ErrLabelNoSuchUpload)
+}
+
+// uploadDir returns the directory of a multipart upload. the id is part of the path, so it is validated first
+func (a *Adapter) uploadDir(uploadID string) (string, error) {
+	if id, err := hex.DecodeString(uploadID); err != nil || len(id) != uploadIDSize {
+		return "", noSuchUploadError(uploadID)
+	}
+	return a.paths.root + uploadsDir + uploadID + "/", nil
+}
+
+// openUpload returns the directory and the manifest of the multipart upload of the file stored under key
+func (a *Adapter) openUpload(key string, uploadID string) (string, upload, error) {
+	dir, err := a.uploadDir(uploadID)
+	if err != nil {
+		return "", upload{}, err
+	}
+	var manifest upload
+	if err := readJSON(dir+uploadManifest, &manifest); err != nil {
+		if os.IsNotExist(err) {
+			return "", upload{}, noSuchUploadError(uploadID)
+		}
+		return "", upload{}, errors.Wrapf(err, "failed to read multipart upload %v", uploadID)
+	}
+	// an upload is only reachable through the key it was created for, which is in the caller tenant namespace
+	if manifest.Key != key {
+		return "", upload{}, noSuchUploadError(uploadID)
+	}
+	return dir, manifest, nil
+}
+
+// removeUpload removes a multipart upload along with its parts
+func (a *Adapter) removeUpload(uploadID string) error {
+	dir, err := a.uploadDir(uploadID)
+	if err != nil {
+		// an invalid id can't exist on disk
+		return nil
+	}
+	return os.RemoveAll(dir)
+}
+
+// CreateMultipartUpload starts a multipart upload of the file stored under path and returns its id.
+// an upload without activity for the ttl is abandoned, and removed as expired temp files are
+func (a *Adapter) CreateMultipartUpload(ctx context.Context, path string, attributes models.ObjectAttributes) (string, error) {
+	log.WithContext(ctx).Debugf("create multipart upload: %v", path)
+	if _, err := a.paths.resolveFile(path); err != nil {
+		return "", err
+	}
+	uploadID, err := common.NewUploadID()
+	if err != nil {
+		return "", err
+	}
+	// the deadline is persisted before the upload, a crash in between at worst expires an upload which doesn't exist
+	if err := a.expiry.set(uploadKey(uploadID), time.Now().Add(a.ttl)); err != nil {
+		log.WithContext(ctx).Errorf("failed to update expiry index: %v", err)
+		return "", err
+	}
+	err = writeJSON(a.paths.root+uploadsDir+uploadID+"/"+uploadManifest, upload{
+		Key:        path,
+		Initiated:  time.Now().UnixNano(),
+		Attributes: attributes,
+	})
+	if err != nil {
+		log.WithContext(ctx).Errorf("failed to create multipart upload of file %v. err: %v", path, err)
+		if err := a.removeUpload(uploadID); err != nil {
+			log.WithContext(ctx).Warnf("failed to remove multipart upload %v. err: %v", uploadID, err)
+		}
+		return "", err
+	}
+	return uploadID, nil
+}
+
+// UploadPart stores a part of a multipart upload streamed from content, replacing a previous part with the same
+// number. every part resets the expiration deadline of the upload.
+func (a *Adapter) UploadPart(ctx context.Context, path string, uploadID string, partNumber int, content io.Reader, options models.PutOptions) (models.Part, error) {
+	log.WithContext(ctx).Debugf("upload part %v of multipart upload %v of file %v", partNumber, uploadID, path)
+	// checked before staging the part, so unknown uploads don't leave directories behind
+	dir, _, err := a.openUpload(path, uploadID)
+	if err != nil {
+		return models.Part{}, err
+	}
+	partPath := dir + partName(partNumber)
+	h := common.NewContentHasher()
+	staged, err := stageFile(partPath, io.TeeReader(content, h))
+	if err != nil {
+		log.WithContext(ctx).Errorf("failed to upload part: %v", err)
+		return models.Part{}, err
+	}
+	if err := h.Verify(options); err != nil {
+		staged.discard()
+		log.WithContext(ctx).Warnf(
+			"rejecting corrupted part %v of multipart upload %v. err: %v", partNumber, uploadID, err,
+		)
+		return models.Part{}, err
+	}
+	unlock := a.uploadLocks.lock(uploadID)
+	defer unlock()
+	// the upload may have been completed or aborted while the part was staged
+	if _, _, err := a.openUpload(path, uploadID); err != nil {
+		staged.discard()
+		// staging the part recreated the directory the abort or the completion removed, it is empty once the
+		// part is discarded and os.Remove leaves it in place if another part was staged into it meanwhile
+		os.Remove(dir)
+		return models.Part{}, err
+	}
+	if err := a.expiry.set(uploadKey(uploadID), time.Now().Add(a.ttl)); err != nil {
+		staged.discard()
+		log.WithContext(ctx).Errorf("failed to update expiry index: %v", err)
+		return models.Part{}, err
+	}
+	if err := staged.commit(); err != nil {
+		log.WithContext(ctx).Errorf("failed to upload part: %v", err)
+		return models.Part{}, err
+	}
+	meta := hashedMeta(h, staged.info)
+	if err := writeJSON(partPath+metaSuffix, meta); err != nil {
+		// the part is in place, its metadata is recomputed when it is read
+		log.WithContext(ctx).Warnf("failed to write metadata of part %v. err: %v", partPath, err)
+	}
+	return models.Part{PartNumber: partNumber, LastModified: staged.info.ModTime(), Size: staged.info.Size(), ETag: meta.ETag}, nil
+}
+
+// readPart returns the part stored in partPath, recomputing its metadata if the sidecar is missing or stale
+func readPart(partPath string, partNumber int) (models.Part, error) {
+	f, info, err := openFile(partPath)
+	if err != nil {
+		return models.Part{}, err
+	}
+	defer f.Close()
+	meta, ok := readMeta(partPath + metaSuffix)
+	if !ok || meta.Stamp != stampOf(info) {
+		if meta, err = computeMeta(io.NewSectionReader(f, 0, info.Size()), info); err != nil {
+			return models.Part{}, errors.Wrapf(err, "failed to compute metadata of part %v", partPath)
+		}
+	}
+	return models.Part{PartNumber: partNumber, LastModified: info.ModTime(), Size: info.Size(), ETag: meta.ETag}, nil
+}
+
+// uploadedParts returns the parts uploaded into the upload directory, sorted by part number
+func uploadedParts(dir string) ([]models.Part, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var parts []models.Part
+	for _, entry := range entries {
+		// the manifest, the sidecars and the staging files aren't named after a part number
+		partNumber, err := strconv.Atoi(entry.Name())
+		if err != nil || entry.IsDir() {
+			continue
+		}
+		part, err := readPart(dir+entry.Name(), partNumber)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// ListParts return a page of the parts uploaded to a multipart upload, sorted by part number
+func (a *Adapter) ListParts(ctx context.Context, path string, uploadID string, options models.ListPartsOptions) (models.PartsList, error) {
+	log.WithContext(ctx).Debugf("list parts of multipart upload %v with options: %+v", uploadID, options)
+	unlock := a.uploadLocks.lock(uploadID)
+	defer unlock()
+	dir, _, err := a.openUpload(path, uploadID)
+	if err != nil {
+		return models.PartsList{}, err
+	}
+	parts, err := uploadedParts(dir)
+	if err != nil {
+		log.WithContext(ctx).Errorf("failed to list parts of multipart upload %v. err: %v", uploadID, err)
+		return models.PartsList{}, err
+	}
+	list := models.PartsList{Parts: []models.Part{}}
+	for _, part := range parts {
+		if part.PartNumber <= options.PartNumberMarker {
+			continue
+		}
+		if options.MaxParts > 0 && len(list.Parts) == options.MaxParts {
+			list.IsTruncated = true
+			break
+		}
+		list.Parts = append(list.Parts, part)
+	}
+	return list, nil
+}
+
+// partsReader streams the content of the parts in partPaths one after the other, the caller must close it
+func partsReader(partPaths []string) io.ReadCloser {
+	r, w := io.Pipe()
+	go func() {
+		for _, partPath := range partPaths {
+			f, err := os.Open(partPath)
+			if err != nil {
+				w.CloseWithError(err)
+				return
+			}
+			_, err = io.Copy(w, f)
+			f.Close()
+			if err != nil {
+				w.CloseWithError(err)
+				return
+			}
+		}
+		w.Close()
+	}()
+	return r
+}
+
+// CompleteMultipartUpload assembles the file stored under path from the listed parts of the upload, set ttl if
+// isTemp is true, and removes the upload. as in S3, the entity tag of the file is derived from the digests of
+// its parts.
+func (a *Adapter) CompleteMultipartUpload(ctx context.Context, path string, uploadID string, parts []models.CompletedPart, isTemp bool, options models.PutOptions) (models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("complete multipart upload %v of file %v with %v parts", uploadID, path, len(parts))
+	filePath, err := a.paths.resolveFile(path)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	unlock := a.uploadLocks.lock(uploadID)
+	defer unlock()
+	dir, manifest, err := a.openUpload(path, uploadID)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	uploaded, err := uploadedParts(dir)
+	if err != nil {
+		log.WithContext(ctx).Errorf("failed to list parts of multipart upload %v. err: %v", uploadID, err)
+		return models.FileMetadata{}, err
+	}
+	byNumber := make(map[int]models.Part, len(uploaded))
+	for _, part := range uploaded {
+		byNumber[part.PartNumber] = part
+	}
+	if len(parts) == 0 {
+		return models.FileMetadata{}, errors.Errorf("no parts listed for multipart upload %v", uploadID).
+			SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidPart)
+	}
+	partDigests := md5.New()
+	partPaths := make([]string, len(parts))
+	for i, part := range parts {
+		if i > 0 && part.PartNumber <= parts[i-1].PartNumber {
+			return models.FileMetadata{}, errors.Errorf("part %v listed out of order", part.PartNumber).
+				SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidPartOrder)
+		}
+		uploadedPart, ok := byNumber[part.PartNumber]
+		digest, err := hex.DecodeString(strings.Trim(uploadedPart.ETag, "\""))
+		if !ok || err != nil || strings.Trim(part.ETag, "\"") != strings.Trim(uploadedPart.ETag, "\"") {
+			return models.FileMetadata{}, errors.Errorf("part %v with entity tag %v wasn't uploaded", part.PartNumber, part.ETag).
+				SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidPart)
+		}
+		if i < len(parts)-1 && uploadedPart.Size < models.MinPartSize {
+			return models.FileMetadata{}, errors.Errorf("part %v is smaller than the minimum part size of %v bytes", part.PartNumber, models.MinPartSize).
+				SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelEntityTooSmall)
+		}
+		partDigests.Write(digest)
+		partPaths[i] = dir + partName(part.PartNumber)
+	}
+	content := partsReader(partPaths)
+	defer content.Close()
+	h := common.NewContentHasher()
+	encoding := a.compression.encoding(path, manifest.Attributes)
+	staged, err := stageEncodedFile(filePath, io.TeeReader(content, h), encoding)
+	if err != nil {
+		log.WithContext(ctx).Errorf("failed to assemble file %v from its parts. err: %v", path, err)
+		return models.FileMetadata{}, err
+	}
+	meta := hashedMeta(h, staged.info)
+	meta.ETag = fmt.Sprintf("\"%x-%d\"", partDigests.Sum(nil), len(parts))
+	meta.Attributes = manifest.Attributes
+	meta.Encoding = encoding
+	metadata, err := a.commitObject(ctx, path, staged, meta, isTemp, options)
+	if err != nil {
+		// the upload is kept, so the client may retry completing it
+		return models.FileMetadata{}, err
+	}
+	if err := a.expiry.remove(uploadKey(uploadID)); err != nil {
+		log.WithContext(ctx).Warnf("failed to update expiry index: %v", err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.WithContext(ctx).Warnf("failed to remove completed multipart upload %v. err: %v", uploadID, err)
+	}
+	return metadata, nil
+}
+
+// AbortMultipartUpload removes a multipart upload along with the parts uploaded so far
+func (a *Adapter) AbortMultipartUpload(ctx context.Context, path string, uploadID string) error {
+	log.WithContext(ctx).Debugf("abort multipart upload %v of file %v", uploadID, path)
+	unlock := a.uploadLocks.lock(uploadID)
+	defer unlock()
+	if _, _, err := a.openUpload(path, uploadID); err != nil {
+		return err
+	}
+	if err := a.expiry.remove(uploadKey(uploadID)); err != nil {
+		log.WithContext(ctx).Errorf("failed to update expiry index: %v", err)
+		return err
+	}
+	if err := a.removeUpload(uploadID); err != nil {
+		log.WithContext(ctx).Errorf("failed to remove multipart upload %v. err: %v", uploadID, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"openappsec.io/errors"
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+const multipartKey = "tenants/t1/ag/remote/assembled"
+
+func createUpload(t *testing.T, a *Adapter) string {
+	t.Helper()
+	uploadID, err := a.CreateMultipartUpload(context.Background(), multipartKey, models.ObjectAttributes{})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload() failed: %v", err)
+	}
+	return uploadID
+}
+
+func uploadPart(t *testing.T, a *Adapter, uploadID string, partNumber int, content string) models.CompletedPart {
+	t.Helper()
+	part, err := a.UploadPart(context.Background(), multipartKey, uploadID, partNumber, strings.NewReader(content), models.PutOptions{})
+	if err != nil {
+		t.Fatalf("UploadPart(%v) failed: %v", partNumber, err)
+	}
+	if want := fmt.Sprintf("\"%x\"", md5.Sum([]byte(content))); part.ETag != want || part.Size != int64(len(content)) {
+		t.Fatalf("part %v = %+v, want entity tag %v and size %v", partNumber, part, want, len(content))
+	}
+	return models.CompletedPart{PartNumber: partNumber, ETag: part.ETag}
+}
+
+func partNumbers(list models.PartsList) []int {
+	numbers := []int{}
+	for _, part := range list.Parts {
+		numbers = append(numbers, part.PartNumber)
+	}
+	return numbers
+}
+
+func complete(a *Adapter, uploadID string, parts ...models.CompletedPart) (models.FileMetadata, error) {
+	return a.CompleteMultipartUpload(context.Background(), multipartKey, uploadID, parts, false, models.PutOptions{})
+}
+
+func TestMultipartUpload(t *testing.T) {
+	a := newExpiryAdapter(t, t.TempDir()+"/")
+	defer a.TearDown(context.Background())
+	ctx := context.Background()
+	uploadID := createUpload(t, a)
+	first := strings.Repeat("a", models.MinPartSize)
+	third := uploadPart(t, a, uploadID, 3, "last")
+	firstPart := uploadPart(t, a, uploadID, 1, first)
+	// a part uploaded again replaces the previous one
+	uploadPart(t, a, uploadID, 2, "replaced")
+	second := strings.Repeat("b", models.MinPartSize)
+	secondPart := uploadPart(t, a, uploadID, 2, second)
+
+	list, err := a.ListParts(ctx, multipartKey, uploadID, models.ListPartsOptions{})
+	if err != nil {
+		t.Fatalf("ListParts() failed: %v", err)
+	}
+	if numbers := partNumbers(list); !reflect.DeepEqual(numbers, []int{1, 2, 3}) || list.IsTruncated {
+		t.Fatalf("parts = %v, truncated %v, want [1 2 3]", numbers, list.IsTruncated)
+	}
+	if list.Parts[1].ETag != secondPart.ETag {
+		t.Fatalf("part 2 has entity tag %v, want the one of its last upload %v", list.Parts[1].ETag, secondPart.ETag)
+	}
+	list, err = a.ListParts(ctx, multipartKey, uploadID, models.ListPartsOptions{PartNumberMarker: 1, MaxParts: 1})
+	if err != nil {
+		t.Fatalf("ListParts() failed: %v", err)
+	}
+	if numbers := partNumbers(list); !reflect.DeepEqual(numbers, []int{2}) || !list.IsTruncated {
+		t.Fatalf("page after part 1 = %v, truncated %v, want [2] truncated", numbers, list.IsTruncated)
+	}
+	if _, err := a.ListParts(ctx, "tenants/t2/ag/remote/assembled", uploadID, models.ListPartsOptions{}); !errors.IsLabel(err, models.ErrLabelNoSuchUpload) {
+		t.Fatalf("ListParts() of another key failed with %v, want no such upload", err)
+	}
+
+	metadata, err := complete(a, uploadID, firstPart, secondPart, third)
+	if err != nil {
+		t.Fatalf("CompleteMultipartUpload() failed: %v", err)
+	}
+	digests := md5.New()
+	for _, content := range []string{first, second, "last"} {
+		digest := md5.Sum([]byte(content))
+		digests.Write(digest[:])
+	}
+	if want := fmt.Sprintf("\"%x-3\"", digests.Sum(nil)); metadata.ETag != want {
+		t.Fatalf("entity tag = %v, want %v", metadata.ETag, want)
+	}
+	if content := readContent(t, a, multipartKey, ""); content != first+second+"last" {
+		t.Fatalf("assembled content of %v bytes, want the parts in order", len(content))
+	}
+	if _, err := a.ListParts(ctx, multipartKey, uploadID, models.ListPartsOptions{}); !errors.IsLabel(err, models.ErrLabelNoSuchUpload) {
+		t.Fatalf("ListParts() of a completed upload failed with %v, want no such upload", err)
+	}
+	if expired := a.expiry.expired(time.Now().Add(2 * time.Hour)); len(expired) != 0 {
+		t.Fatalf("completed upload is still scheduled to expire: %v", expired)
+	}
+}
+
+func TestMultipartInvalidCompletion(t *testing.T) {
+	a := newExpiryAdapter(t, t.TempDir()+"/")
+	defer a.TearDown(context.Background())
+	uploadID := createUpload(t, a)
+	small := uploadPart(t, a, uploadID, 1, "small")
+	large := uploadPart(t, a, uploadID, 2, strings.Repeat("a", models.MinPartSize))
+	last := uploadPart(t, a, uploadID, 3, "last")
+
+	tests := []struct {
+		name  string
+		parts []models.CompletedPart
+		label string
+	}{
+		{name: "non-final part too small", parts: []models.CompletedPart{small, last}, label: models.ErrLabelEntityTooSmall},
+		{name: "parts out of order", parts: []models.CompletedPart{large, small}, label: models.ErrLabelInvalidPartOrder},
+		{name: "part listed twice", parts: []models.CompletedPart{large, large}, label: models.ErrLabelInvalidPartOrder},
+		{name: "part not uploaded", parts: []models.CompletedPart{large, {PartNumber: 4, ETag: last.ETag}}, label: models.ErrLabelInvalidPart},
+		{name: "entity tag mismatch", parts: []models.CompletedPart{large, {PartNumber: 3, ETag: small.ETag}}, label: models.ErrLabelInvalidPart},
+		{name: "no parts", label: models.ErrLabelInvalidPart},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := complete(a, uploadID, test.parts...)
+			if !errors.IsClass(err, errors.ClassBadInput) || !errors.IsLabel(err, test.label) {
+				t.Fatalf("CompleteMultipartUpload() failed with %v, want %v", err, test.label)
+			}
+		})
+	}
+
+	// the upload is kept after a failed completion, so the client may retry it
+	if _, err := complete(a, uploadID, large, last); err != nil {
+		t.Fatalf("CompleteMultipartUpload() failed after the invalid completions: %v", err)
+	}
+}
+
+func TestMultipartAbort(t *testing.T) {
+	a := newExpiryAdapter(t, t.TempDir()+"/")
+	defer a.TearDown(context.Background())
+	ctx := context.Background()
+	uploadID := createUpload(t, a)
+	part := uploadPart(t, a, uploadID, 1, "aborted")
+	if err := a.AbortMultipartUpload(ctx, "tenants/t2/ag/remote/assembled", uploadID); !errors.IsLabel(err, models.ErrLabelNoSuchUpload) {
+		t.Fatalf("AbortMultipartUpload() of another key failed with %v, want no such upload", err)
+	}
+	if err := a.AbortMultipartUpload(ctx, multipartKey, uploadID); err != nil {
+		t.Fatalf("AbortMultipartUpload() failed: %v", err)
+	}
+	if _, err := os.Stat(a.paths.root + uploadsDir + uploadID); !os.IsNotExist(err) {
+		t.Fatalf("parts of the aborted upload weren't removed, err: %v", err)
+	}
+	if _, err := complete(a, uploadID, part); !errors.IsLabel(err, models.ErrLabelNoSuchUpload) {
+		t.Fatalf("CompleteMultipartUpload() of an aborted upload failed with %v, want no such upload", err)
+	}
+	_, err := a.UploadPart(ctx, multipartKey, uploadID, 2, strings.NewReader("late"), models.PutOptions{})
+	if !errors.IsLabel(err, models.ErrLabelNoSuchUpload) {
+		t.Fatalf("UploadPart() to an aborted upload failed with %v, want no such upload", err)
+	}
+	if err := a.AbortMultipartUpload(ctx, multipartKey, uploadID); !errors.IsLabel(err, models.ErrLabelNoSuchUpload) {
+		t.Fatalf("AbortMultipartUpload() twice failed with %v, want no such upload", err)
+	}
+}
+
+func TestMultipartExpiry(t *testing.T) {
+	a := newExpiryAdapter(t, t.TempDir()+"/")
+	defer a.TearDown(context.Background())
+	uploadID := createUpload(t, a)
+	created := deadlineOf(t, a, uploadKey(uploadID))
+	time.Sleep(10 * time.Millisecond)
+	uploadPart(t, a, uploadID, 1, "abandoned")
+	deadline := deadlineOf(t, a, uploadKey(uploadID))
+	if !deadline.After(created) {
+		t.Fatalf("deadline after a part upload = %v, want after %v", deadline, created)
+	}
+
+	a.sweep(created)
+	if _, err := os.Stat(a.paths.root + uploadsDir + uploadID); err != nil {
+		t.Fatalf("upload was removed before the deadline its last part reset: %v", err)
+	}
+	a.sweep(deadline)
+	if _, err := os.Stat(a.paths.root + uploadsDir + uploadID); !os.IsNotExist(err) {
+		t.Fatalf("abandoned upload wasn't removed, err: %v", err)
+	}
+	_, err := a.ListParts(context.Background(), multipartKey, uploadID, models.ListPartsOptions{})
+	if !errors.IsLabel(err, models.ErrLabelNoSuchUpload) {
+		t.Fatalf("ListParts() of an expired upload failed with %v, want no such upload", err)
+	}
+}
+
+func TestSweepRacingUploadPart(t *testing.T) {
+	a := newExpiryAdapter(t, t.TempDir()+"/")
+	defer a.TearDown(context.Background())
+	uploadID := createUpload(t, a)
+	deadline := deadlineOf(t, a, uploadKey(uploadID))
+
+	// a part upload holding the upload lock resets the deadline after the sweep found the upload expired
+	unlock := a.uploadLocks.lock(uploadID)
+	swept := make(chan struct{})
+	go func() {
+		a.sweep(deadline)
+		close(swept)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := a.expiry.set(uploadKey(uploadID), deadline.Add(time.Hour)); err != nil {
+		t.Fatalf("set() failed: %v", err)
+	}
+	unlock()
+	<-swept
+	if _, err := os.Stat(a.paths.root + uploadsDir + uploadID); err != nil {
+		t.Fatalf("sweep removed an upload whose deadline was reset under its upload lock: %v", err)
+	}
+}
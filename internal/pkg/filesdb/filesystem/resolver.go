@@ -0,0 +1,116 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"openappsec.io/errors"
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+// resolver maps storage keys to locations on disk, making sure they never leave the root directory
+type resolver struct {
+	// root is the configured root directory, always ending with a slash
+	root string
+}
+
+func newResolver(root string) (*resolver, error) {
+	root = strings.TrimSuffix(filepath.Clean(root), "/") + "/"
+	if _, err := os.Stat(root); err != nil {
+		return nil, errors.Wrapf(err, "failed to evaluate root directory %v", root)
+	}
+	return &resolver{root: root}, nil
+}
+
+// canonicalKey validates the segments of a key, the names the adapter keeps for itself are reserved
+func canonicalKey(key string, isPrefix bool) (string, error) {
+	segments, err := models.KeySegments(key, isPrefix)
+	if err != nil {
+		return "", err
+	}
+	for _, segment := range segments {
+		if isStagingFile(segment) || strings.HasSuffix(segment, versionsSuffix) {
+			return "", models.InvalidPathError(key, "reserved name")
+		}
+	}
+	return key, nil
+}
+
+// confine makes sure path does not resolve outside the root through symlinks. the adapter never creates
+// symlinks, so any symlink below the root is rejected, dangling ones included, since writing through them would
+// create their target wherever it is. the root itself may be a symlink
+func (r *resolver) confine(key string, path string) error {
+	existing := strings.TrimSuffix(r.root, "/")
+	for _, segment := range strings.Split(strings.TrimPrefix(path, r.root), "/") {
+		if segment == "" {
+			continue
+		}
+		existing += "/" + segment
+		info, err := os.Lstat(existing)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// nothing below can exist either
+				return nil
+			}
+			return errors.Wrapf(err, "failed to evaluate path %v", existing)
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return models.InvalidPathError(key, "path escapes the root directory through a symlink")
+		}
+	}
+	return nil
+}
+
+// resolveFile returns the location on disk of the object stored under key
+func (r *resolver) resolveFile(key string) (string, error) {
+	key, err := canonicalKey(key, false)
+	if err != nil {
+		return "", err
+	}
+	if key == "" {
+		return "", models.InvalidPathError(key, "empty key")
+	}
+	path := r.root + key
+	if err := r.confine(key, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// resolvePrefix returns the directory on disk holding the keys starting with prefix,
+// and the prefix the names of the entries in that directory should match
+func (r *resolver) resolvePrefix(prefix string) (string, string, error) {
+	prefix, err := canonicalKey(prefix, true)
+	if err != nil {
+		return "", "", err
+	}
+	dir := r.root
+	namePrefix := prefix
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = r.root + prefix[:i+1]
+		namePrefix = prefix[i+1:]
+	}
+	if err := r.confine(prefix, dir); err != nil {
+		return "", "", err
+	}
+	return dir, namePrefix, nil
+}
+
+// key returns the storage key of a location on disk
+func (r *resolver) key(path string) string {
+	return strings.TrimPrefix(path, r.root)
+}
@@ -0,0 +1,111 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"openappsec.io/errors"
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+func TestResolveFile(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tenantDir := filepath.Join(root, "tenants", "t1")
+	if err := os.MkdirAll(tenantDir, 0750); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"dir-link":      outside,
+		"file-link":     filepath.Join(outside, "secret"),
+		"dangling-link": filepath.Join(outside, "missing"),
+		"inner-link":    tenantDir,
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(tenantDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := newResolver(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		invalid bool
+	}{
+		{name: "plain key", key: "tenants/t1/ag/remote/data.json"},
+		{name: "key under a missing directory", key: "tenants/t1/new/dir/file"},
+		{name: "parent segment", key: "tenants/t1/../../etc/passwd", invalid: true},
+		{name: "escaped parent segment", key: "tenants/t1/%2e%2e/%2e%2e/etc/passwd", invalid: true},
+		{name: "current segment", key: "tenants/t1/./file", invalid: true},
+		{name: "absolute path", key: "/etc/passwd", invalid: true},
+		{name: "empty segment", key: "tenants/t1//file", invalid: true},
+		{name: "backslash", key: "tenants/t1/..\\file", invalid: true},
+		{name: "symlinked directory", key: "tenants/t1/dir-link/secret", invalid: true},
+		{name: "symlinked file", key: "tenants/t1/file-link", invalid: true},
+		{name: "dangling symlink", key: "tenants/t1/dangling-link", invalid: true},
+		{name: "below a dangling symlink", key: "tenants/t1/dangling-link/file", invalid: true},
+		{name: "symlink staying inside the root", key: "tenants/t1/inner-link/file", invalid: true},
+		{name: "reserved staging name", key: "tenants/t1/" + stagingPrefix + "x", invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, err := r.resolveFile(test.key)
+			if !test.invalid {
+				if err != nil {
+					t.Fatalf("resolveFile(%q) failed: %v", test.key, err)
+				}
+				if path != r.root+test.key {
+					t.Fatalf("resolveFile(%q) = %q, want %q", test.key, path, r.root+test.key)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("resolveFile(%q) = %q, want an invalid path error", test.key, path)
+			}
+			if !errors.IsLabel(err, models.ErrLabelInvalidPath) {
+				t.Fatalf("resolveFile(%q) failed with %v, want an invalid path error", test.key, err)
+			}
+		})
+	}
+}
+
+func TestResolvePrefixThroughSymlink(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "tenants"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(t.TempDir(), filepath.Join(root, "tenants", "t1")); err != nil {
+		t.Fatal(err)
+	}
+	r, err := newResolver(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.resolvePrefix("tenants/t1/ag/"); !errors.IsLabel(err, models.ErrLabelInvalidPath) {
+		t.Fatalf("resolvePrefix through a symlink failed with %v, want an invalid path error", err)
+	}
+	if _, _, err := r.resolvePrefix("tenants/t2/ag/"); err != nil {
+		t.Fatalf("resolvePrefix of a missing prefix failed: %v", err)
+	}
+}
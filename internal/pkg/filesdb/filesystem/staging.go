@@ -0,0 +1,167 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"openappsec.io/log"
+)
+
+const (
+	// stagingPrefix prefixes the names of the files writes are staged in, keys can't use it
+	stagingPrefix = ".smartsync-staging-"
+	filePerm      = 0644
+	// staleStagingMargin is how long before the start a staging file must have been modified to be stale. file
+	// times come from the coarse clock of the kernel, which lags behind time.Now, so a write staged right after
+	// the start may look older than the start
+	staleStagingMargin = time.Second
+)
+
+func isStagingFile(name string) bool {
+	return strings.HasPrefix(name, stagingPrefix)
+}
+
+// stagedFile is content written and synced next to its destination, waiting to be renamed into place
+type stagedFile struct {
+	path     string
+	filePath string
+	// info describes the staged content, the rename keeps it as is
+	info fs.FileInfo
+}
+
+// stageFile streams content into a staging file next to filePath and syncs it
+func stageFile(filePath string, content io.Reader) (*stagedFile, error) {
+	return stageEncodedFile(filePath, content, "")
+}
+
+// stageEncodedFile streams content into a staging file next to filePath, compressed with encoding unless it is
+// empty, and syncs it. the staging file creation is retried once if a concurrent delete pruned the parent
+// directory right after it was created.
+func stageEncodedFile(filePath string, content io.Reader, encoding string) (*stagedFile, error) {
+	dir := filepath.Dir(filePath)
+	var f *os.File
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = os.MkdirAll(dir, 0750); err != nil && !os.IsExist(err) {
+			return nil, err
+		}
+		if f, err = os.CreateTemp(dir, stagingPrefix+"*"); err == nil || !os.IsNotExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := copyToStaging(f, content, encoding)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &stagedFile{path: f.Name(), filePath: filePath, info: info}, nil
+}
+
+// copyToStaging streams content into the staging file f, compressed with encoding, syncs and closes it
+func copyToStaging(f *os.File, content io.Reader, encoding string) (fs.FileInfo, error) {
+	if zw := compressor(f, encoding); zw != nil {
+		if _, err := io.Copy(zw, content); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	} else if _, err := io.Copy(f, content); err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(filePerm); err != nil {
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return info, f.Close()
+}
+
+// commit renames the staged content into place, so readers only ever see the previous content or the new one,
+// and a crash never leaves a partial file
+func (s *stagedFile) commit() error {
+	if err := os.Rename(s.path, s.filePath); err != nil {
+		s.discard()
+		return err
+	}
+	// renaming a link over another link to the same deduplicated content does nothing, the staged link is left
+	s.discard()
+	return syncDir(filepath.Dir(s.filePath))
+}
+
+// setModTime sets the modification time of the staged content
+func (s *stagedFile) setModTime(modTime time.Time) error {
+	if err := os.Chtimes(s.path, modTime, modTime); err != nil {
+		return err
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.info = info
+	return nil
+}
+
+// discard removes the staged content
+func (s *stagedFile) discard() {
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		log.Warnf("failed to remove staging file %v. err: %v", s.path, err)
+	}
+}
+
+// writeFile stages content and renames it into filePath
+func writeFile(filePath string, content io.Reader) error {
+	staged, err := stageFile(filePath, content)
+	if err != nil {
+		return err
+	}
+	return staged.commit()
+}
+
+// syncDir persists the entries of dir, such as a file renamed into it
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// removeStaleStagingFile removes a staging file left behind by a write interrupted before started
+func removeStaleStagingFile(path string, d fs.DirEntry, started time.Time) {
+	info, err := d.Info()
+	if err != nil || !info.ModTime().Before(started.Add(-staleStagingMargin)) {
+		return
+	}
+	log.Infof("removing stale staging file: %v", path)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Warnf("failed to remove stale staging file %v. err: %v", path, err)
+	}
+}
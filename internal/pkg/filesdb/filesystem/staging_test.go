@@ -0,0 +1,91 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/testutil"
+)
+
+// writeStagingFile writes a staging file under root last modified at modified
+func writeStagingFile(t *testing.T, root string, path string, modified time.Time) string {
+	t.Helper()
+	writeLegacyFile(t, root, path, "partial content")
+	if err := os.Chtimes(root+path, modified, modified); err != nil {
+		t.Fatalf("failed to set the modification time of %v: %v", path, err)
+	}
+	return root + path
+}
+
+func TestStartupRemovesStaleStagingFiles(t *testing.T) {
+	root := t.TempDir() + "/"
+	conf := testutil.Configuration{fsConfigRoot: root, fsConfigTTL: time.Hour, fsConfigSweepInterval: time.Hour}
+	a, err := NewAdapter(conf)
+	if err != nil {
+		t.Fatalf("NewAdapter() failed: %v", err)
+	}
+	putContent(t, a, "tenants/t1/ag/remote/file", "content", false)
+	if err := a.TearDown(context.Background()); err != nil {
+		t.Fatalf("TearDown() failed: %v", err)
+	}
+
+	// the staging files of writes interrupted by the restart, in the tenant and in the versions directories
+	old := time.Now().Add(-time.Minute)
+	staleVersion := writeStagingFile(t, root, versionsDir+"tenants/t1/ag/remote/file/"+stagingPrefix+"1", old)
+	staleNested := writeStagingFile(t, root, "tenants/t1/ag/remote/"+stagingPrefix+"1", old)
+	// a write in progress while the adapter starts, sorted before the last stale file of its directory
+	inProgress := writeStagingFile(t, root, "tenants/t1/ag/"+stagingPrefix+"1", time.Now().Add(time.Minute))
+	staleLast := writeStagingFile(t, root, "tenants/t1/ag/"+stagingPrefix+"2", old)
+
+	a, err = NewAdapter(conf)
+	if err != nil {
+		t.Fatalf("NewAdapter() failed: %v", err)
+	}
+	defer a.TearDown(context.Background())
+	for _, path := range []string{staleVersion, staleNested, staleLast} {
+		eventually(t, "stale staging file "+filepath.Base(path)+" removed", func() bool {
+			_, err := os.Stat(path)
+			return os.IsNotExist(err)
+		})
+	}
+	if _, err := os.Stat(inProgress); err != nil {
+		t.Fatalf("the staging file of a write in progress was removed: %v", err)
+	}
+
+	// staging files are neither keys nor readable
+	list, err := a.GetFilesList(context.Background(), models.ListOptions{Prefix: "tenants/t1/"})
+	if err != nil {
+		t.Fatalf("GetFilesList() failed: %v", err)
+	}
+	var keys []string
+	for _, file := range list.Files {
+		keys = append(keys, file.Path)
+	}
+	if !reflect.DeepEqual(keys, []string{"tenants/t1/ag/remote/file"}) {
+		t.Fatalf("keys = %v, want the stored file only", keys)
+	}
+	if _, _, err := a.GetFile(context.Background(), "tenants/t1/ag/"+stagingPrefix+"1", models.GetOptions{}); err == nil {
+		t.Fatalf("GetFile() of a staging file succeeded")
+	}
+	if content := readContent(t, a, "tenants/t1/ag/remote/file", ""); content != "content" {
+		t.Fatalf("content after restart = %q", content)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"context"
+	"os"
+
+	"openappsec.io/errors"
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+)
+
+// SetFileTags replaces the tags of a file, or of one of its versions when versionID is set, nil tags remove them.
+// only the sidecar is rewritten, the content and its last modified time are left as is
+func (a *Adapter) SetFileTags(ctx context.Context, path string, versionID string, tags map[string]string) (models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("set tags of file: %v, version: %v, tags: %v", path, versionID, tags)
+	filePath, err := a.paths.resolveFile(path)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	unlock := a.locks.lock(path)
+	defer unlock()
+	metadata, err := a.setTags(path, filePath, versionID, tags)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.WithContext(ctx).Warnf("file %v not found", path)
+			return models.FileMetadata{}, errors.Wrap(err, "file not found").SetClass(errors.ClassNotFound)
+		}
+		log.WithContext(ctx).Errorf("failed to set tags of file %v. err: %v", path, err)
+		return models.FileMetadata{}, err
+	}
+	return metadata, nil
+}
+
+// setTags replaces the tags in the sidecar of the object stored under key, or of one of its noncurrent versions.
+// must be called while holding the key lock
+func (a *Adapter) setTags(key string, filePath string, versionID string, tags map[string]string) (models.FileMetadata, error) {
+	f, info, err := openFile(filePath)
+	if err == nil {
+		meta, _, err := a.contentMeta(key, f, info)
+		f.Close()
+		if err != nil {
+			return models.FileMetadata{}, err
+		}
+		if versionID == "" || versionID == meta.versionID() {
+			meta.Tags = tags
+			if err := a.writeMeta(key, meta); err != nil {
+				return models.FileMetadata{}, err
+			}
+			return fileMetadata(key, info, meta), nil
+		}
+	} else if !os.IsNotExist(err) || versionID == "" {
+		return models.FileMetadata{}, err
+	}
+	if !validVersionID(versionID) {
+		return models.FileMetadata{}, os.ErrNotExist
+	}
+	versionPath := a.versionDir(key) + versionID
+	f, info, err = openFile(versionPath)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	meta, err := versionMeta(versionPath, versionID, f, info)
+	f.Close()
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	meta.Tags = tags
+	if err := writeJSON(versionPath+metaSuffix, meta); err != nil {
+		return models.FileMetadata{}, err
+	}
+	// the retention of the version may have expired meanwhile, its sidecar must not outlive it
+	if _, err := os.Stat(versionPath); os.IsNotExist(err) {
+		if err := a.removeVersion(key, versionID); err != nil {
+			return models.FileMetadata{}, err
+		}
+		return models.FileMetadata{}, os.ErrNotExist
+	}
+	return fileMetadata(key, info, meta), nil
+}
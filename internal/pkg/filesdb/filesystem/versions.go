@@ -0,0 +1,444 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"context"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"openappsec.io/errors"
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/common"
+)
+
+const (
+	// versionsDir holds the noncurrent versions of the objects, in a directory per key mirroring the layout of
+	// the keys. the content of a version is a hard link to the content the object had, next to its sidecar
+	versionsDir = systemDir + "versions/"
+	// versionsSuffix is appended to the key a directory of versions belongs to, key segments can't end with it
+	versionsSuffix = "~versions"
+
+	// versionIDSize is the length of a version id, the hex creation time in nanoseconds followed by random bytes
+	versionIDSize = 24
+)
+
+// validVersionID checks a version id from a request before it is used as a file name
+func validVersionID(versionID string) bool {
+	if versionID == models.NullVersionID {
+		return true
+	}
+	if len(versionID) != versionIDSize {
+		return false
+	}
+	_, err := hex.DecodeString(versionID)
+	return err == nil
+}
+
+// versionID returns the version id of the content meta describes
+func (m objectMeta) versionID() string {
+	if m.VersionID == "" {
+		return models.NullVersionID
+	}
+	return m.VersionID
+}
+
+// versioned is true when overwriting or deleting an object keeps its content as a noncurrent version.
+// temp files are never versioned
+func (a *Adapter) versioned(isTemp bool) bool {
+	return a.versioning && !isTemp
+}
+
+func (a *Adapter) versionDir(key string) string {
+	return a.paths.root + versionsDir + key + versionsSuffix + "/"
+}
+
+// versionKey is the key of a noncurrent version in the expiry index
+func versionKey(key string, versionID string) string {
+	return versionsDir + key + versionsSuffix + "/" + versionID
+}
+
+// splitVersionKey returns the key and the version id of a noncurrent version from its expiry index key
+func splitVersionKey(expiryKey string) (string, string, bool) {
+	dir, versionID, found := cutLast(strings.TrimPrefix(expiryKey, versionsDir), "/")
+	if !found || !strings.HasSuffix(dir, versionsSuffix) || !validVersionID(versionID) {
+		return "", "", false
+	}
+	return strings.TrimSuffix(dir, versionsSuffix), versionID, true
+}
+
+func cutLast(s string, sep string) (string, string, bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
+
+// archiveCurrent keeps the content of the object stored under key as a noncurrent version, before it is
+// replaced or removed. the content is hard linked, so readers of the object never miss it.
+// must be called while holding the key lock
+func (a *Adapter) archiveCurrent(key string, filePath string) error {
+	f, info, err := openFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	meta, _, err := a.contentMeta(key, f, info)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	versionID := meta.versionID()
+	dir := a.versionDir(key)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	versionPath := dir + versionID
+	// a null version archived before versioning was turned back on is replaced, as in S3
+	if err := os.Remove(versionPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(filePath, versionPath); err != nil {
+		return errors.Wrapf(err, "failed to archive version %v of %v", versionID, key)
+	}
+	if err := writeJSON(versionPath+metaSuffix, meta); err != nil {
+		// the content is archived, its metadata is recomputed when it is read
+		log.Warnf("failed to write metadata of version %v of %v. err: %v", versionID, key, err)
+	}
+	// a crash before the deadline is persisted is recovered when the root directory is reconciled
+	return a.expiry.set(versionKey(key, versionID), time.Now().Add(a.noncurrentRetention))
+}
+
+// noncurrentVersions returns the ids of the noncurrent versions of key, from the newest to the oldest
+func (a *Adapter) noncurrentVersions(key string) ([]string, error) {
+	entries, err := os.ReadDir(a.versionDir(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var versionIDs []string
+	for _, entry := range entries {
+		if !entry.IsDir() && validVersionID(entry.Name()) {
+			versionIDs = append(versionIDs, entry.Name())
+		}
+	}
+	sort.Slice(versionIDs, func(i, j int) bool { return common.NewerVersion(versionIDs[i], versionIDs[j]) })
+	return versionIDs, nil
+}
+
+// versionMeta returns the metadata of a noncurrent version of key, whose content is open in f.
+// the metadata is recomputed from f when the sidecar is missing or stale
+func versionMeta(versionPath string, versionID string, f *os.File, info fs.FileInfo) (objectMeta, error) {
+	if meta, ok := readMeta(versionPath + metaSuffix); ok && meta.Stamp == stampOf(info) {
+		return meta, nil
+	}
+	meta, err := computeObjectMeta(f, info)
+	if err != nil {
+		return objectMeta{}, errors.Wrapf(err, "failed to compute metadata of %v", versionPath)
+	}
+	if versionID != models.NullVersionID {
+		meta.VersionID = versionID
+	}
+	return meta, nil
+}
+
+// openObject opens the content of the object stored under key in filePath, or of one of its noncurrent versions
+// when versionID is set, and loads its metadata. the caller must close the file
+func (a *Adapter) openObject(key string, filePath string, versionID string) (*os.File, fs.FileInfo, objectMeta, error) {
+	f, info, err := openFile(filePath)
+	if err == nil {
+		meta, err := a.loadMeta(key, filePath, f, info)
+		if err != nil {
+			f.Close()
+			return nil, nil, objectMeta{}, err
+		}
+		if versionID == "" || versionID == meta.versionID() {
+			return f, info, meta, nil
+		}
+		f.Close()
+	} else if !os.IsNotExist(err) || versionID == "" {
+		return nil, nil, objectMeta{}, err
+	}
+	if !validVersionID(versionID) {
+		return nil, nil, objectMeta{}, os.ErrNotExist
+	}
+	versionPath := a.versionDir(key) + versionID
+	f, info, err = openFile(versionPath)
+	if err != nil {
+		return nil, nil, objectMeta{}, err
+	}
+	meta, err := versionMeta(versionPath, versionID, f, info)
+	if err != nil {
+		f.Close()
+		return nil, nil, objectMeta{}, err
+	}
+	return f, info, meta, nil
+}
+
+// removeVersion removes a noncurrent version of key and prunes the directories it leaves empty
+func (a *Adapter) removeVersion(key string, versionID string) error {
+	versionPath := a.versionDir(key) + versionID
+	meta, _ := readMeta(versionPath + metaSuffix)
+	if err := os.Remove(versionPath + metaSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := removeFile(versionPath, a.paths.root+versionsDir+models.TenantsDir); err != nil {
+		return err
+	}
+	a.release(meta)
+	return nil
+}
+
+// promoteNewest makes the newest noncurrent version of key its current content, after the current version was
+// deleted. must be called while holding the key lock
+func (a *Adapter) promoteNewest(key string, filePath string) error {
+	versionIDs, err := a.noncurrentVersions(key)
+	if err != nil || len(versionIDs) == 0 {
+		return err
+	}
+	versionID := versionIDs[0]
+	versionPath := a.versionDir(key) + versionID
+	f, info, err := openFile(versionPath)
+	if err != nil {
+		return err
+	}
+	meta, err := versionMeta(versionPath, versionID, f, info)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if err := a.expiry.remove(versionKey(key, versionID)); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0750); err != nil {
+		return err
+	}
+	if err := os.Rename(versionPath, filePath); err != nil {
+		return errors.Wrapf(err, "failed to promote version %v of %v", versionID, key)
+	}
+	if err := syncDir(filepath.Dir(filePath)); err != nil {
+		return err
+	}
+	if err := a.writeMeta(key, meta); err != nil {
+		log.Warnf("failed to write metadata of file %v. err: %v", key, err)
+	}
+	return a.removeVersion(key, versionID)
+}
+
+// deleteVersion removes a version of the object stored under key. deleting the current version makes the newest
+// noncurrent version current, and deleting a version which doesn't exist succeeds
+func (a *Adapter) deleteVersion(key string, filePath string, versionID string) error {
+	if !validVersionID(versionID) {
+		return nil
+	}
+	unlock := a.locks.lock(key)
+	defer unlock()
+	meta, exists, err := a.currentMeta(key, filePath)
+	if err != nil {
+		return err
+	}
+	if exists && meta.versionID() == versionID {
+		if err := a.expiry.remove(key); err != nil {
+			return err
+		}
+		if err := a.removeObject(key, filePath); err != nil {
+			return err
+		}
+		return a.promoteNewest(key, filePath)
+	}
+	if err := a.expiry.remove(versionKey(key, versionID)); err != nil {
+		return err
+	}
+	return a.removeVersion(key, versionID)
+}
+
+// reconcileVersions schedules the expiration of noncurrent versions missing from the expiry index
+func (a *Adapter) reconcileVersions(started time.Time) {
+	deadline := started.Add(a.noncurrentRetention)
+	root := a.paths.root + versionsDir
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if isStagingFile(d.Name()) {
+			removeStaleStagingFile(path, d, started)
+			return nil
+		}
+		if !validVersionID(d.Name()) {
+			return nil
+		}
+		return a.expiry.setIfAbsent(versionsDir+strings.TrimPrefix(path, root), deadline)
+	})
+	if err != nil {
+		log.Warnf("failed to reconcile noncurrent versions. err: %v", err)
+	}
+}
+
+// versionedKeys returns the sorted keys starting with prefix that have noncurrent versions
+func (a *Adapter) versionedKeys(prefix string) ([]string, error) {
+	dir, _, err := a.paths.resolvePrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	root := a.paths.root + versionsDir
+	var keys []string
+	err = filepath.WalkDir(root+a.paths.key(dir), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() || !strings.HasSuffix(d.Name(), versionsSuffix) {
+			return nil
+		}
+		if key := strings.TrimSuffix(strings.TrimPrefix(path, root), versionsSuffix); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return fs.SkipDir
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// currentKeys returns the sorted keys starting with prefix that have a current version
+func (a *Adapter) currentKeys(prefix string) ([]string, error) {
+	dir, namePrefix, err := a.paths.resolvePrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	err = a.walkSorted(dir, namePrefix, "", func(key string, d fs.DirEntry) error {
+		if !d.IsDir() {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// mergeKeys merges two sorted lists of keys, dropping duplicates
+func mergeKeys(a []string, b []string) []string {
+	merged := make([]string, 0, len(a)+len(b))
+	for len(a) > 0 || len(b) > 0 {
+		switch {
+		case len(b) == 0 || (len(a) > 0 && a[0] < b[0]):
+			merged, a = append(merged, a[0]), a[1:]
+		case len(a) == 0 || b[0] < a[0]:
+			merged, b = append(merged, b[0]), b[1:]
+		default:
+			merged, a, b = append(merged, a[0]), a[1:], b[1:]
+		}
+	}
+	return merged
+}
+
+// keyVersions returns the versions of key, from the newest to the oldest
+func (a *Adapter) keyVersions(key string) ([]models.FileVersion, error) {
+	var versions []models.FileVersion
+	filePath := a.paths.root + key
+	if info, err := os.Stat(filePath); err == nil && !info.IsDir() {
+		metadata, err := a.statObject(key, filePath, info)
+		if err == nil {
+			if metadata.VersionID == "" {
+				metadata.VersionID = models.NullVersionID
+			}
+			versions = append(versions, models.FileVersion{FileMetadata: metadata, IsLatest: true})
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	versionIDs, err := a.noncurrentVersions(key)
+	if err != nil {
+		return nil, err
+	}
+	for _, versionID := range versionIDs {
+		versionPath := a.versionDir(key) + versionID
+		f, info, err := openFile(versionPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// expired while listing
+				continue
+			}
+			return nil, err
+		}
+		meta, err := versionMeta(versionPath, versionID, f, info)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		metadata := fileMetadata(key, info, meta)
+		metadata.VersionID = versionID
+		versions = append(versions, models.FileVersion{FileMetadata: metadata})
+	}
+	return versions, nil
+}
+
+// ListVersions returns a page of the versions of the files matching the options, sorted by key and from the
+// newest version to the oldest
+func (a *Adapter) ListVersions(ctx context.Context, options models.ListVersionsOptions) (models.VersionsList, error) {
+	log.WithContext(ctx).Infof("list versions with options: %+v", options)
+	current, err := a.currentKeys(options.Prefix)
+	if err != nil {
+		log.WithContext(ctx).Errorf("failed to list versions with options %+v. err: %v", options, err)
+		return models.VersionsList{}, err
+	}
+	noncurrent, err := a.versionedKeys(options.Prefix)
+	if err != nil {
+		log.WithContext(ctx).Errorf("failed to list versions with options %+v. err: %v", options, err)
+		return models.VersionsList{}, err
+	}
+	list := models.VersionsList{Versions: []models.FileVersion{}}
+	for _, key := range mergeKeys(current, noncurrent) {
+		if key < options.KeyMarker || (key == options.KeyMarker && options.VersionIDMarker == "") {
+			continue
+		}
+		versions, err := a.keyVersions(key)
+		if err != nil {
+			log.WithContext(ctx).Errorf("failed to list versions of %v. err: %v", key, err)
+			return models.VersionsList{}, err
+		}
+		for _, version := range versions {
+			if key == options.KeyMarker && !common.NewerVersion(options.VersionIDMarker, version.VersionID) {
+				continue
+			}
+			if options.MaxKeys > 0 && len(list.Versions) == options.MaxKeys {
+				list.IsTruncated = true
+				return list, nil
+			}
+			list.Versions = append(list.Versions, version)
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,114 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"openappsec.io/errors"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/testutil"
+)
+
+const versionedKey = "tenants/t1/ag/remote/file"
+
+// newVersioningAdapter creates an adapter keeping noncurrent versions for retention, sweeping every interval
+func newVersioningAdapter(t *testing.T, retention time.Duration, interval time.Duration) *Adapter {
+	t.Helper()
+	a, err := NewAdapter(testutil.Configuration{
+		fsConfigRoot:                t.TempDir() + "/",
+		fsConfigTTL:                 time.Hour,
+		fsConfigSweepInterval:       interval,
+		fsConfigVersioning:          true,
+		fsConfigNoncurrentRetention: retention,
+	})
+	if err != nil {
+		t.Fatalf("NewAdapter() failed: %v", err)
+	}
+	t.Cleanup(func() { a.TearDown(context.Background()) })
+	return a
+}
+
+// versionIDs returns the version ids of key, newest first
+func versionIDs(t *testing.T, a *Adapter, key string) []string {
+	t.Helper()
+	list, err := a.ListVersions(context.Background(), models.ListVersionsOptions{Prefix: key, MaxKeys: 1000})
+	if err != nil {
+		t.Fatalf("ListVersions() failed: %v", err)
+	}
+	ids := []string{}
+	for _, version := range list.Versions {
+		ids = append(ids, version.VersionID)
+	}
+	return ids
+}
+
+func TestNoncurrentRetention(t *testing.T) {
+	a := newVersioningAdapter(t, 300*time.Millisecond, 20*time.Millisecond)
+	v1 := putContent(t, a, versionedKey, "v1", false)
+	v2 := putContent(t, a, versionedKey, "v2", false)
+	v3 := putContent(t, a, versionedKey, "v3", false)
+	if ids := versionIDs(t, a, versionedKey); len(ids) != 3 {
+		t.Fatalf("versions = %v, want 3", ids)
+	}
+
+	// a version is retained from the time it became noncurrent, the current version is kept
+	eventually(t, "noncurrent versions expire", func() bool { return len(versionIDs(t, a, versionedKey)) == 1 })
+	if ids := versionIDs(t, a, versionedKey); ids[0] != v3.VersionID {
+		t.Fatalf("versions after retention = %v, want the current %v", ids, v3.VersionID)
+	}
+	for _, version := range []models.FileMetadata{v1, v2} {
+		if _, err := a.StatFile(context.Background(), versionedKey, version.VersionID); !errors.IsClass(err, errors.ClassNotFound) {
+			t.Fatalf("StatFile() of expired version %v = %v, want not found", version.VersionID, err)
+		}
+	}
+	if content := readContent(t, a, versionedKey, ""); content != "v3" {
+		t.Fatalf("current content = %q, want %q", content, "v3")
+	}
+
+	// deleting the key makes its content a noncurrent version, which expires as well
+	if err := a.DeleteFile(context.Background(), versionedKey, ""); err != nil {
+		t.Fatalf("DeleteFile() failed: %v", err)
+	}
+	if ids := versionIDs(t, a, versionedKey); len(ids) != 1 || ids[0] != v3.VersionID {
+		t.Fatalf("versions after delete = %v, want the archived %v", ids, v3.VersionID)
+	}
+	eventually(t, "archived version expires", func() bool { return len(versionIDs(t, a, versionedKey)) == 0 })
+}
+
+func TestSweepRacingVersionPromotion(t *testing.T) {
+	a := newVersioningAdapter(t, time.Hour, time.Hour)
+	v1 := putContent(t, a, versionedKey, "v1", false)
+	putContent(t, a, versionedKey, "v2", false)
+	deadline := deadlineOf(t, a, versionKey(versionedKey, v1.VersionID))
+
+	// a promotion holding the key lock drops the version from the index after the sweep found it expired
+	unlock := a.locks.lock(versionedKey)
+	swept := make(chan struct{})
+	go func() {
+		a.sweep(deadline)
+		close(swept)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := a.expiry.remove(versionKey(versionedKey, v1.VersionID)); err != nil {
+		t.Fatalf("remove() failed: %v", err)
+	}
+	unlock()
+	<-swept
+	if content := readContent(t, a, versionedKey, v1.VersionID); content != "v1" {
+		t.Fatalf("version %v reads %q after the sweep, want %q", v1.VersionID, content, "v1")
+	}
+}
@@ -0,0 +1,717 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"openappsec.io/errors"
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/common"
+)
+
+const (
+	fsBaseConfig          = "filesystem_db"
+	fsConfigRoot          = fsBaseConfig + ".root"
+	fsConfigTTL           = fsBaseConfig + ".ttl"
+	fsConfigSweepInterval = fsBaseConfig + ".sweep_interval"
+	// versioning is opt-in, noncurrent versions are kept for their own retention
+	fsConfigVersioning          = fsBaseConfig + ".versioning.enabled"
+	fsConfigNoncurrentRetention = fsBaseConfig + ".versioning.noncurrent_retention"
+	// the database is a single file under the root
+	kvConfigFile = fsBaseConfig + ".kv.file"
+	// the values are read into memory before they are written, and when they are read, each of them is capped
+	kvConfigMaxValueSize = fsBaseConfig + ".kv.max_value_size"
+
+	defaultSweepInterval       = time.Minute
+	defaultNoncurrentRetention = 30 * 24 * time.Hour
+	defaultFile                = "shared-files.db"
+	defaultMaxValueSize        = 64 << 20
+
+	// openTimeout bounds the wait for the lock of a database file opened by another process
+	openTimeout = 5 * time.Second
+	// maxSweepBatch is the number of expired records removed in a single transaction, so the sweeper doesn't
+	// block the writers for long
+	maxSweepBatch = 1000
+	contentIDSize = 16
+
+	healthCheckName = "kv"
+)
+
+var (
+	// filesBucket maps the keys to the records of the current objects
+	filesBucket = []byte("files")
+	// versionsBucket maps the keys and version ids of the noncurrent versions to their records
+	versionsBucket = []byte("versions")
+	// contentsBucket maps the content ids to the contents, so listings don't read them
+	contentsBucket = []byte("contents")
+	// expiryBucket indexes the records with a deadline by their deadline, so the sweeper doesn't scan the others
+	expiryBucket = []byte("expiry")
+	// uploadsBucket maps the upload ids of the multipart uploads in progress to their records
+	uploadsBucket = []byte("uploads")
+	// partsBucket maps the upload ids and part numbers of the uploaded parts to their records
+	partsBucket = []byte("parts")
+
+	buckets = [][]byte{filesBucket, versionsBucket, contentsBucket, expiryBucket, uploadsBucket, partsBucket}
+)
+
+// separator joins the parts of the composite keys, it is forbidden in storage keys
+const separator = "\x00"
+
+// record is a version of a file, the current one or a noncurrent one. its content is stored apart from it
+type record struct {
+	Metadata  models.FileMetadata `json:"metadata"`
+	ContentID string              `json:"contentId"`
+	// Expires is the deadline of a temp file or of a noncurrent version, zero when it doesn't expire
+	Expires time.Time `json:"expires"`
+}
+
+// versionID returns the version id of the record
+func (r *record) versionID() string {
+	if r.Metadata.VersionID == "" {
+		return models.NullVersionID
+	}
+	return r.Metadata.VersionID
+}
+
+// Adapter keeps the files in an embedded transactional key value database, a single file under the root.
+// it has the ttl, listing and versioning semantics of the filesystem adapter, without a file per key
+type Adapter struct {
+	db  *bolt.DB
+	ttl time.Duration
+	// versioning keeps the previous contents of persistent files as noncurrent versions
+	versioning          bool
+	noncurrentRetention time.Duration
+	// maxValueSize caps the size of a file, and of a part of a multipart upload
+	maxValueSize int64
+	done         chan struct{}
+}
+
+// Configuration service interface for fetching config
+type Configuration interface {
+	GetString(key string) (string, error)
+	GetDuration(key string) (time.Duration, error)
+	GetBool(key string) (bool, error)
+	GetInt(key string) (int, error)
+}
+
+// NewAdapter creates new adapter, opening the database or creating it
+func NewAdapter(conf Configuration) (*Adapter, error) {
+	root, err := conf.GetString(fsConfigRoot)
+	if err != nil {
+		return &Adapter{}, err
+	}
+	if root == "" {
+		return &Adapter{}, errors.Errorf("missing %v", fsConfigRoot).SetClass(errors.ClassBadInput)
+	}
+	file, err := conf.GetString(kvConfigFile)
+	if err != nil && !errors.IsClass(err, errors.ClassNotFound) {
+		return &Adapter{}, err
+	}
+	if file == "" {
+		file = defaultFile
+	}
+	if strings.ContainsAny(file, "/\\") {
+		return &Adapter{}, errors.Errorf("invalid %v %q, must be a file name", kvConfigFile, file).
+			SetClass(errors.ClassBadInput)
+	}
+	ttl, err := common.PositiveDuration(conf, fsConfigTTL, 0)
+	if err != nil {
+		return &Adapter{}, err
+	}
+	sweepInterval, err := common.PositiveDuration(conf, fsConfigSweepInterval, defaultSweepInterval)
+	if err != nil {
+		return &Adapter{}, err
+	}
+	versioning, err := conf.GetBool(fsConfigVersioning)
+	if err != nil && !errors.IsClass(err, errors.ClassNotFound) {
+		return &Adapter{}, err
+	}
+	noncurrentRetention, err := common.PositiveDuration(conf, fsConfigNoncurrentRetention, defaultNoncurrentRetention)
+	if err != nil {
+		return &Adapter{}, err
+	}
+	maxValueSize, err := common.PositiveSize(conf, kvConfigMaxValueSize, defaultMaxValueSize)
+	if err != nil {
+		return &Adapter{}, err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return &Adapter{}, errors.Wrapf(err, "failed to create root %v", root)
+	}
+	db, err := bolt.Open(filepath.Join(root, file), 0640, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return &Adapter{}, errors.Wrapf(err, "failed to open database %v", filepath.Join(root, file))
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return &Adapter{}, errors.Wrap(err, "failed to create database buckets")
+	}
+	a := &Adapter{
+		db:                  db,
+		ttl:                 ttl,
+		versioning:          versioning,
+		noncurrentRetention: noncurrentRetention,
+		maxValueSize:        maxValueSize,
+		done:                make(chan struct{}),
+	}
+	go a.sweeper(sweepInterval)
+	return a, nil
+}
+
+// HealthCheck checks that the database can be read
+func (a *Adapter) HealthCheck(ctx context.Context) (string, error) {
+	err := a.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(filesBucket) == nil {
+			return errors.New("missing files bucket")
+		}
+		return nil
+	})
+	if err != nil {
+		return healthCheckName, errors.Wrap(err, "database is unavailable")
+	}
+	return healthCheckName, nil
+}
+
+// TearDown stops the expiry sweeper and closes the database
+func (a *Adapter) TearDown(ctx context.Context) error {
+	close(a.done)
+	return a.db.Close()
+}
+
+func newContentID() (string, error) {
+	id := make([]byte, contentIDSize)
+	if _, err := rand.Read(id); err != nil {
+		return "", errors.Wrap(err, "failed to generate content id")
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// decodeRecord decodes the record stored in value, nil when there is none. the value is only valid during the
+// transaction, the record is decoded into memory owned by the caller
+func decodeRecord(key string, value []byte) (*record, error) {
+	if value == nil {
+		return nil, nil
+	}
+	r := &record{}
+	if err := json.Unmarshal(value, r); err != nil {
+		return nil, errors.Wrapf(err, "corrupted record of file %v", key)
+	}
+	return r, nil
+}
+
+// putJSON stores v encoded as JSON under key of bucket b
+func putJSON(b *bolt.Bucket, key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), value)
+}
+
+// versionKey is the key of a noncurrent version in the versions bucket
+func versionKey(key string, versionID string) string {
+	return key + separator + versionID
+}
+
+// expiry references are the kinds of records indexed by their deadline
+const (
+	fileRef    = "f"
+	versionRef = "v"
+	uploadRef  = "u"
+)
+
+// expiryKey is the key of a record in the expiry index, sorted by the deadline of the record
+func expiryKey(expires time.Time, kind string, id string) []byte {
+	return []byte(fmt.Sprintf("%016x%s%s%s%s", expires.UnixNano(), separator, kind, separator, id))
+}
+
+// indexExpiry adds a record with a deadline to the expiry index, records without one aren't indexed
+func indexExpiry(tx *bolt.Tx, expires time.Time, kind string, id string) error {
+	if expires.IsZero() {
+		return nil
+	}
+	return tx.Bucket(expiryBucket).Put(expiryKey(expires, kind, id), nil)
+}
+
+// unindexExpiry removes a record from the expiry index
+func unindexExpiry(tx *bolt.Tx, expires time.Time, kind string, id string) error {
+	if expires.IsZero() {
+		return nil
+	}
+	return tx.Bucket(expiryBucket).Delete(expiryKey(expires, kind, id))
+}
+
+// current returns the record of the current object stored under key, nil when there is none
+func current(tx *bolt.Tx, key string) (*record, error) {
+	return decodeRecord(key, tx.Bucket(filesBucket).Get([]byte(key)))
+}
+
+// lookup returns the record of the object stored under key, or of one of its versions when versionID is set,
+// nil when there is none. isCurrent is true when the record is the current object
+func lookup(tx *bolt.Tx, key string, versionID string) (r *record, isCurrent bool, err error) {
+	r, err = current(tx, key)
+	if err != nil {
+		return nil, false, err
+	}
+	if r != nil && (versionID == "" || versionID == r.versionID()) {
+		return r, true, nil
+	}
+	if versionID == "" {
+		return nil, false, nil
+	}
+	r, err = decodeRecord(key, tx.Bucket(versionsBucket).Get([]byte(versionKey(key, versionID))))
+	return r, false, err
+}
+
+// putCurrent stores r as the current object of key, the record it replaces must have been removed
+func putCurrent(tx *bolt.Tx, key string, r *record) error {
+	if err := putJSON(tx.Bucket(filesBucket), key, r); err != nil {
+		return err
+	}
+	return indexExpiry(tx, r.Expires, fileRef, key)
+}
+
+// removeCurrent removes the current object of key along with its content
+func removeCurrent(tx *bolt.Tx, key string, r *record) error {
+	if err := tx.Bucket(filesBucket).Delete([]byte(key)); err != nil {
+		return err
+	}
+	if err := unindexExpiry(tx, r.Expires, fileRef, key); err != nil {
+		return err
+	}
+	return tx.Bucket(contentsBucket).Delete([]byte(r.ContentID))
+}
+
+// fileMetadata returns the metadata of a record stored under key
+func fileMetadata(key string, r *record) models.FileMetadata {
+	metadata := r.Metadata
+	metadata.Path = key
+	return metadata
+}
+
+// prefixEnd returns the first key sorted after all the keys starting with prefix, nil when there is none
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// GetFilesList return a page of the files matching the options, sorted by key, with their metadata.
+// when a delimiter is given, keys sharing a common prefix are rolled up into it, and each common prefix counts
+// as a single key of the page. the keys rolled up into a common prefix are skipped without reading them
+func (a *Adapter) GetFilesList(ctx context.Context, options models.ListOptions) (models.FilesList, error) {
+	log.WithContext(ctx).Infof("list files with options: %+v", options)
+	if _, err := models.KeySegments(options.Prefix, true); err != nil {
+		return models.FilesList{}, err
+	}
+	list := models.FilesList{Files: []models.FileMetadata{}, CommonPrefixes: []string{}}
+	full := func() bool {
+		return options.MaxKeys > 0 && len(list.Files)+len(list.CommonPrefixes) == options.MaxKeys
+	}
+	start := options.Prefix
+	if options.StartAfter > start {
+		start = options.StartAfter
+	}
+	err := a.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(filesBucket).Cursor()
+		k, v := c.Seek([]byte(start))
+		for k != nil && strings.HasPrefix(string(k), options.Prefix) {
+			key := string(k)
+			if key <= options.StartAfter {
+				k, v = c.Next()
+				continue
+			}
+			if cp := common.CommonPrefix(key, options.Prefix, options.Delimiter); cp != "" {
+				if cp != options.StartAfter {
+					if full() {
+						list.IsTruncated = true
+						return nil
+					}
+					list.CommonPrefixes = append(list.CommonPrefixes, cp)
+				}
+				end := prefixEnd(cp)
+				if end == nil {
+					return nil
+				}
+				k, v = c.Seek(end)
+				continue
+			}
+			r, err := decodeRecord(key, v)
+			if err != nil {
+				return err
+			}
+			if models.TagsMatch(r.Metadata.Tags, options.Tags) {
+				// checked once the file is known to match, so a page isn't reported truncated by files filtered out
+				if full() {
+					list.IsTruncated = true
+					return nil
+				}
+				list.Files = append(list.Files, fileMetadata(key, r))
+			}
+			k, v = c.Next()
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithContext(ctx).Errorf("failed to list files. err: %v", err)
+		return models.FilesList{}, err
+	}
+	return list, nil
+}
+
+// GetFile return a reader streaming the file content and the file metadata, the caller must close the reader
+func (a *Adapter) GetFile(ctx context.Context, path string, options models.GetOptions) (io.ReadCloser, models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("get file: %v, options: %+v", path, options)
+	if err := common.ValidateKey(path); err != nil {
+		return nil, models.FileMetadata{}, err
+	}
+	var content []byte
+	var metadata models.FileMetadata
+	err := a.db.View(func(tx *bolt.Tx) error {
+		r, _, err := lookup(tx, path, options.VersionID)
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			return common.NotFoundError(path)
+		}
+		metadata = fileMetadata(path, r)
+		stored := tx.Bucket(contentsBucket).Get([]byte(r.ContentID))
+		offset, length := int64(0), int64(len(stored))
+		if options.Range != nil {
+			var ok bool
+			offset, length, ok = options.Range.Resolve(int64(len(stored)))
+			if !ok {
+				return errors.Errorf(
+					"range %+v not satisfiable for file %v of size %v", *options.Range, path, len(stored),
+				).SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidRange)
+			}
+		}
+		// the stored content is only valid during the transaction, it is copied out of it. it is bounded by the
+		// maximum value size the content was written with
+		content = append([]byte(nil), stored[offset:offset+length]...)
+		return nil
+	})
+	if err != nil {
+		log.WithContext(ctx).Warnf("failed to get file %v. err: %v", path, err)
+		return nil, models.FileMetadata{}, err
+	}
+	return io.NopCloser(bytes.NewReader(content)), metadata, nil
+}
+
+// StatFile return file metadata, or the metadata of one of its versions when versionID is set
+func (a *Adapter) StatFile(ctx context.Context, path string, versionID string) (models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("stat file: %v, version: %v", path, versionID)
+	if err := common.ValidateKey(path); err != nil {
+		return models.FileMetadata{}, err
+	}
+	var metadata models.FileMetadata
+	err := a.db.View(func(tx *bolt.Tx) error {
+		r, _, err := lookup(tx, path, versionID)
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			return common.NotFoundError(path)
+		}
+		metadata = fileMetadata(path, r)
+		return nil
+	})
+	if err != nil {
+		log.WithContext(ctx).Debugf("failed to stat file %v. err: %v", path, err)
+		return models.FileMetadata{}, err
+	}
+	return metadata, nil
+}
+
+// PutFile stores a file streamed from content, set ttl if isTemp is true.
+// the content is verified against the digests it was sent with before it replaces the file
+func (a *Adapter) PutFile(ctx context.Context, path string, content io.Reader, isTemp bool, options models.PutOptions) (models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("put file: %v, options: %+v", path, options)
+	if err := common.ValidateKey(path); err != nil {
+		return models.FileMetadata{}, err
+	}
+	data, metadata, err := common.ReadLimitedContent(content, options, a.maxValueSize)
+	if err != nil {
+		log.WithContext(ctx).Warnf("failed to read content of file %v. err: %v", path, err)
+		return models.FileMetadata{}, err
+	}
+	metadata.Attributes = options.Attributes
+	var result models.FileMetadata
+	err = a.db.Update(func(tx *bolt.Tx) error {
+		contentID, err := storeContent(tx, data)
+		if err != nil {
+			return err
+		}
+		result, err = a.commitRecord(tx, path, &record{Metadata: metadata, ContentID: contentID}, isTemp, options)
+		return err
+	})
+	if err != nil {
+		log.WithContext(ctx).Warnf("failed to put file %v. err: %v", path, err)
+		return models.FileMetadata{}, err
+	}
+	return result, nil
+}
+
+// storeContent stores a content under a new content id
+func storeContent(tx *bolt.Tx, data []byte) (string, error) {
+	contentID, err := newContentID()
+	if err != nil {
+		return "", err
+	}
+	return contentID, tx.Bucket(contentsBucket).Put([]byte(contentID), data)
+}
+
+// CopyFile copies the file stored under srcPath to dstPath, set ttl if isTemp is true.
+// unless its metadata is replaced, the copy keeps the metadata of the source, the last modified time included
+func (a *Adapter) CopyFile(ctx context.Context, srcPath string, dstPath string, isTemp bool, options models.CopyOptions) (models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("copy file: %v to %v, options: %+v", srcPath, dstPath, options)
+	if err := common.ValidateKey(srcPath); err != nil {
+		return models.FileMetadata{}, err
+	}
+	if err := common.ValidateKey(dstPath); err != nil {
+		return models.FileMetadata{}, err
+	}
+	var result models.FileMetadata
+	err := a.db.Update(func(tx *bolt.Tx) error {
+		src, _, err := lookup(tx, srcPath, options.SourceVersionID)
+		if err != nil {
+			return err
+		}
+		if src == nil {
+			return common.NotFoundError(srcPath)
+		}
+		// the copy gets its own content, a content is removed along with the single record referencing it
+		contentID, err := storeContent(tx, tx.Bucket(contentsBucket).Get([]byte(src.ContentID)))
+		if err != nil {
+			return err
+		}
+		dst := &record{Metadata: src.Metadata, ContentID: contentID}
+		if options.ReplaceMetadata {
+			dst.Metadata.LastModified = time.Now()
+			dst.Metadata.Attributes = options.Attributes
+		}
+		result, err = a.commitRecord(tx, dstPath, dst, isTemp, models.PutOptions{})
+		return err
+	})
+	if err != nil {
+		log.WithContext(ctx).Warnf("failed to copy file %v to %v. err: %v", srcPath, dstPath, err)
+		return models.FileMetadata{}, err
+	}
+	return result, nil
+}
+
+// commitRecord replaces the current object stored under key with r, if the preconditions of the write are met.
+// the content of r must be stored already, it is rolled back along with the transaction when the write fails
+func (a *Adapter) commitRecord(tx *bolt.Tx, key string, r *record, isTemp bool, options models.PutOptions) (models.FileMetadata, error) {
+	cur, err := current(tx, key)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	if options.Conditional() {
+		exists, etag := cur != nil, ""
+		if exists {
+			etag = cur.Metadata.ETag
+		}
+		if !options.Satisfied(etag, exists) {
+			return models.FileMetadata{}, errors.Errorf("precondition %+v failed for file %v", options, key).
+				SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelPreconditionFailed)
+		}
+	}
+	now := time.Now()
+	r.Metadata.VersionID = ""
+	r.Expires = time.Time{}
+	if isTemp {
+		r.Expires = now.Add(a.ttl)
+	}
+	if a.versioned(isTemp) {
+		versionID, err := common.NewVersionID()
+		if err != nil {
+			return models.FileMetadata{}, err
+		}
+		r.Metadata.VersionID = versionID
+		if err := a.archiveCurrent(tx, key, cur, now); err != nil {
+			return models.FileMetadata{}, err
+		}
+	} else if cur != nil {
+		if err := removeCurrent(tx, key, cur); err != nil {
+			return models.FileMetadata{}, err
+		}
+	}
+	if err := putCurrent(tx, key, r); err != nil {
+		return models.FileMetadata{}, err
+	}
+	return fileMetadata(key, r), nil
+}
+
+// DeleteFile removes a file, or one of its versions when versionID is set. deleting a file which doesn't exist
+// succeeds. when versioning is on, the removed content of a persistent file is kept as a noncurrent version
+func (a *Adapter) DeleteFile(ctx context.Context, path string, versionID string) error {
+	log.WithContext(ctx).Debugf("delete file: %v, version: %v", path, versionID)
+	if err := common.ValidateKey(path); err != nil {
+		return err
+	}
+	err := a.db.Update(func(tx *bolt.Tx) error {
+		return a.deleteFile(tx, path, versionID)
+	})
+	if err != nil {
+		log.WithContext(ctx).Warnf("failed to delete file %v. err: %v", path, err)
+	}
+	return err
+}
+
+// deleteFile removes a file, or one of its versions when versionID is set
+func (a *Adapter) deleteFile(tx *bolt.Tx, key string, versionID string) error {
+	if versionID != "" {
+		return deleteVersion(tx, key, versionID)
+	}
+	cur, err := current(tx, key)
+	if err != nil || cur == nil {
+		return err
+	}
+	if a.versioned(common.IsTempKey(key)) {
+		if err := a.archiveCurrent(tx, key, cur, time.Now()); err != nil {
+			return err
+		}
+		return tx.Bucket(filesBucket).Delete([]byte(key))
+	}
+	return removeCurrent(tx, key, cur)
+}
+
+// DeleteFiles removes a batch of files in a single transaction, returning the outcome of each deletion
+func (a *Adapter) DeleteFiles(ctx context.Context, paths []string) []models.DeleteResult {
+	results := make([]models.DeleteResult, len(paths))
+	err := a.db.Update(func(tx *bolt.Tx) error {
+		for i, path := range paths {
+			results[i].Path = path
+			if err := common.ValidateKey(path); err != nil {
+				results[i].Err = err
+				continue
+			}
+			// a failed deletion fails the transaction, a partially applied batch isn't committed
+			if err := a.deleteFile(tx, path, ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithContext(ctx).Warnf("failed to delete files. err: %v", err)
+		for i := range results {
+			if results[i].Err == nil {
+				results[i].Err = err
+			}
+		}
+	}
+	return results
+}
+
+// sweeper removes expired temp files every interval until the adapter is torn down
+func (a *Adapter) sweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := a.sweep(time.Now()); err != nil {
+			log.Errorf("failed to remove expired files. err: %v", err)
+		}
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep removes the expired temp files, noncurrent versions and multipart uploads, following the expiry index
+// in batches of maxSweepBatch records
+func (a *Adapter) sweep(now time.Time) error {
+	for {
+		swept := 0
+		err := a.db.Update(func(tx *bolt.Tx) error {
+			var expired [][]byte
+			c := tx.Bucket(expiryBucket).Cursor()
+			deadline := []byte(fmt.Sprintf("%016x", now.UnixNano()))
+			for k, _ := c.First(); k != nil && len(expired) < maxSweepBatch; k, _ = c.Next() {
+				if bytes.Compare(k[:len(deadline)], deadline) > 0 {
+					break
+				}
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			for _, k := range expired {
+				if err := a.expire(tx, string(k)); err != nil {
+					return err
+				}
+			}
+			swept = len(expired)
+			return nil
+		})
+		if err != nil || swept < maxSweepBatch {
+			return err
+		}
+	}
+}
+
+// expire removes the record of an entry of the expiry index, along with the entry
+func (a *Adapter) expire(tx *bolt.Tx, indexKey string) error {
+	if err := tx.Bucket(expiryBucket).Delete([]byte(indexKey)); err != nil {
+		return err
+	}
+	fields := strings.SplitN(indexKey, separator, 3)
+	if len(fields) != 3 {
+		return nil
+	}
+	switch kind, id := fields[1], fields[2]; kind {
+	case fileRef:
+		r, err := current(tx, id)
+		// the file may have been replaced since it was indexed
+		if err != nil || r == nil || string(expiryKey(r.Expires, fileRef, id)) != indexKey {
+			return err
+		}
+		log.Debugf("ttl expired for file: %v", id)
+		return removeCurrent(tx, id, r)
+	case versionRef:
+		key, versionID, _ := strings.Cut(id, separator)
+		log.Debugf("noncurrent retention expired for version %v of file: %v", versionID, key)
+		return removeVersion(tx, key, versionID)
+	case uploadRef:
+		log.Debugf("ttl expired for multipart upload: %v", id)
+		return removeUpload(tx, id)
+	}
+	return nil
+}
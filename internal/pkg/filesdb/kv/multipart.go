@@ -0,0 +1,309 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"openappsec.io/errors"
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/common"
+)
+
+// upload is a multipart upload in progress, its parts are stored apart from it
+type upload struct {
+	Key string `json:"key"`
+	// Attributes are the attributes the assembled file is stored with
+	Attributes models.ObjectAttributes `json:"attributes"`
+	// Expires is reset by every part uploaded, an upload without activity for the ttl is abandoned
+	Expires time.Time `json:"expires"`
+}
+
+type part struct {
+	Metadata  models.Part `json:"metadata"`
+	ContentID string      `json:"contentId"`
+}
+
+func noSuchUploadError(uploadID string) error {
+	return errors.Errorf("multipart upload %q not found", uploadID).
+		SetClass(errors.ClassNotFound).SetLabel(models.ErrLabelNoSuchUpload)
+}
+
+// partKey is the key of a part in the parts bucket, the part numbers are padded so the parts are sorted by number
+func partKey(uploadID string, partNumber int) string {
+	return fmt.Sprintf("%s%s%05d", uploadID, separator, partNumber)
+}
+
+// openUpload returns the multipart upload of the file stored under key
+func openUpload(tx *bolt.Tx, key string, uploadID string) (*upload, error) {
+	value := tx.Bucket(uploadsBucket).Get([]byte(uploadID))
+	if value == nil {
+		return nil, noSuchUploadError(uploadID)
+	}
+	u := &upload{}
+	if err := json.Unmarshal(value, u); err != nil {
+		return nil, errors.Wrapf(err, "corrupted multipart upload %v", uploadID)
+	}
+	// an upload is only reachable through the key it was created for, which is in the caller tenant namespace
+	if u.Key != key {
+		return nil, noSuchUploadError(uploadID)
+	}
+	return u, nil
+}
+
+// putUpload stores an upload, indexing it by its new deadline
+func putUpload(tx *bolt.Tx, uploadID string, u *upload) error {
+	if err := putJSON(tx.Bucket(uploadsBucket), uploadID, u); err != nil {
+		return err
+	}
+	return indexExpiry(tx, u.Expires, uploadRef, uploadID)
+}
+
+// uploadParts returns the parts uploaded to an upload, sorted by part number
+func uploadParts(tx *bolt.Tx, uploadID string) ([]part, error) {
+	var parts []part
+	prefix := uploadID + separator
+	c := tx.Bucket(partsBucket).Cursor()
+	for k, v := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, v = c.Next() {
+		var p part
+		if err := json.Unmarshal(v, &p); err != nil {
+			return nil, errors.Wrapf(err, "corrupted part %v of multipart upload %v", string(k[len(prefix):]), uploadID)
+		}
+		parts = append(parts, p)
+	}
+	return parts, nil
+}
+
+// removeUpload removes an upload along with its parts and their contents, if there is one
+func removeUpload(tx *bolt.Tx, uploadID string) error {
+	value := tx.Bucket(uploadsBucket).Get([]byte(uploadID))
+	if value == nil {
+		return nil
+	}
+	var u upload
+	if err := json.Unmarshal(value, &u); err != nil {
+		return errors.Wrapf(err, "corrupted multipart upload %v", uploadID)
+	}
+	parts, err := uploadParts(tx, uploadID)
+	if err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if err := tx.Bucket(partsBucket).Delete([]byte(partKey(uploadID, p.Metadata.PartNumber))); err != nil {
+			return err
+		}
+		if err := tx.Bucket(contentsBucket).Delete([]byte(p.ContentID)); err != nil {
+			return err
+		}
+	}
+	if err := unindexExpiry(tx, u.Expires, uploadRef, uploadID); err != nil {
+		return err
+	}
+	return tx.Bucket(uploadsBucket).Delete([]byte(uploadID))
+}
+
+// CreateMultipartUpload starts a multipart upload of the file stored under path and returns its id.
+// an upload without activity for the ttl is abandoned, and removed as expired temp files are
+func (a *Adapter) CreateMultipartUpload(ctx context.Context, path string, attributes models.ObjectAttributes) (string, error) {
+	log.WithContext(ctx).Debugf("create multipart upload: %v", path)
+	if err := common.ValidateKey(path); err != nil {
+		return "", err
+	}
+	uploadID, err := common.NewUploadID()
+	if err != nil {
+		return "", err
+	}
+	err = a.db.Update(func(tx *bolt.Tx) error {
+		return putUpload(tx, uploadID, &upload{Key: path, Attributes: attributes, Expires: time.Now().Add(a.ttl)})
+	})
+	if err != nil {
+		log.WithContext(ctx).Warnf("failed to create multipart upload of file %v. err: %v", path, err)
+		return "", err
+	}
+	return uploadID, nil
+}
+
+// UploadPart stores a part of a multipart upload streamed from content, replacing a previous part with the same
+// number. every part resets the expiration deadline of the upload.
+func (a *Adapter) UploadPart(ctx context.Context, path string, uploadID string, partNumber int, content io.Reader, options models.PutOptions) (models.Part, error) {
+	log.WithContext(ctx).Debugf("upload part %v of multipart upload %v of file %v", partNumber, uploadID, path)
+	// checked before reading the part, so parts of unknown uploads aren't buffered
+	err := a.db.View(func(tx *bolt.Tx) error {
+		_, err := openUpload(tx, path, uploadID)
+		return err
+	})
+	if err != nil {
+		return models.Part{}, err
+	}
+	data, metadata, err := common.ReadLimitedContent(content, options, a.maxValueSize)
+	if err != nil {
+		log.WithContext(ctx).Warnf("failed to read part %v of multipart upload %v. err: %v", partNumber, uploadID, err)
+		return models.Part{}, err
+	}
+	uploaded := models.Part{PartNumber: partNumber, LastModified: metadata.LastModified, Size: metadata.Size, ETag: metadata.ETag}
+	err = a.db.Update(func(tx *bolt.Tx) error {
+		// the upload may have been completed or aborted while the part was read
+		u, err := openUpload(tx, path, uploadID)
+		if err != nil {
+			return err
+		}
+		parts := tx.Bucket(partsBucket)
+		if value := parts.Get([]byte(partKey(uploadID, partNumber))); value != nil {
+			var replaced part
+			if err := json.Unmarshal(value, &replaced); err != nil {
+				return errors.Wrapf(err, "corrupted part %v of multipart upload %v", partNumber, uploadID)
+			}
+			if err := tx.Bucket(contentsBucket).Delete([]byte(replaced.ContentID)); err != nil {
+				return err
+			}
+		}
+		contentID, err := storeContent(tx, data)
+		if err != nil {
+			return err
+		}
+		if err := putJSON(parts, partKey(uploadID, partNumber), &part{Metadata: uploaded, ContentID: contentID}); err != nil {
+			return err
+		}
+		if err := unindexExpiry(tx, u.Expires, uploadRef, uploadID); err != nil {
+			return err
+		}
+		u.Expires = time.Now().Add(a.ttl)
+		return putUpload(tx, uploadID, u)
+	})
+	if err != nil {
+		log.WithContext(ctx).Warnf("failed to store part %v of multipart upload %v. err: %v", partNumber, uploadID, err)
+		return models.Part{}, err
+	}
+	return uploaded, nil
+}
+
+// ListParts return a page of the parts uploaded to a multipart upload, sorted by part number
+func (a *Adapter) ListParts(ctx context.Context, path string, uploadID string, options models.ListPartsOptions) (models.PartsList, error) {
+	log.WithContext(ctx).Debugf("list parts of multipart upload %v with options: %+v", uploadID, options)
+	list := models.PartsList{Parts: []models.Part{}}
+	err := a.db.View(func(tx *bolt.Tx) error {
+		if _, err := openUpload(tx, path, uploadID); err != nil {
+			return err
+		}
+		c := tx.Bucket(partsBucket).Cursor()
+		prefix := uploadID + separator
+		for k, v := c.Seek([]byte(partKey(uploadID, options.PartNumberMarker+1))); k != nil && strings.HasPrefix(string(k), prefix); k, v = c.Next() {
+			if options.MaxParts > 0 && len(list.Parts) == options.MaxParts {
+				list.IsTruncated = true
+				return nil
+			}
+			var p part
+			if err := json.Unmarshal(v, &p); err != nil {
+				return errors.Wrapf(err, "corrupted part %v of multipart upload %v", string(k[len(prefix):]), uploadID)
+			}
+			list.Parts = append(list.Parts, p.Metadata)
+		}
+		return nil
+	})
+	if err != nil {
+		return models.PartsList{}, err
+	}
+	return list, nil
+}
+
+// CompleteMultipartUpload assembles the file stored under path from the listed parts of the upload, set ttl if
+// isTemp is true, and removes the upload. as in S3, the entity tag of the file is derived from the digests of
+// its parts.
+func (a *Adapter) CompleteMultipartUpload(ctx context.Context, path string, uploadID string, parts []models.CompletedPart, isTemp bool, options models.PutOptions) (models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("complete multipart upload %v of file %v with %v parts", uploadID, path, len(parts))
+	if err := common.ValidateKey(path); err != nil {
+		return models.FileMetadata{}, err
+	}
+	if len(parts) == 0 {
+		return models.FileMetadata{}, errors.Errorf("no parts listed for multipart upload %v", uploadID).
+			SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidPart)
+	}
+	var result models.FileMetadata
+	err := a.db.Update(func(tx *bolt.Tx) error {
+		u, err := openUpload(tx, path, uploadID)
+		if err != nil {
+			return err
+		}
+		uploaded, err := uploadParts(tx, uploadID)
+		if err != nil {
+			return err
+		}
+		byNumber := make(map[int]part, len(uploaded))
+		for _, p := range uploaded {
+			byNumber[p.Metadata.PartNumber] = p
+		}
+		partDigests := md5.New()
+		h := common.NewContentHasher()
+		var content []byte
+		for i, completed := range parts {
+			if i > 0 && completed.PartNumber <= parts[i-1].PartNumber {
+				return errors.Errorf("part %v listed out of order", completed.PartNumber).
+					SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidPartOrder)
+			}
+			p, ok := byNumber[completed.PartNumber]
+			if !ok || strings.Trim(completed.ETag, "\"") != strings.Trim(p.Metadata.ETag, "\"") {
+				return errors.Errorf("part %v with entity tag %v wasn't uploaded", completed.PartNumber, completed.ETag).
+					SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidPart)
+			}
+			if i < len(parts)-1 && p.Metadata.Size < models.MinPartSize {
+				return errors.Errorf("part %v is smaller than the minimum part size of %v bytes", completed.PartNumber, models.MinPartSize).
+					SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelEntityTooSmall)
+			}
+			digest, _ := hex.DecodeString(strings.Trim(p.Metadata.ETag, "\""))
+			partDigests.Write(digest)
+			if int64(len(content))+p.Metadata.Size > a.maxValueSize {
+				return common.EntityTooLargeError(a.maxValueSize)
+			}
+			content = append(content, tx.Bucket(contentsBucket).Get([]byte(p.ContentID))...)
+		}
+		h.Write(content)
+		metadata := h.Metadata()
+		metadata.ETag = fmt.Sprintf("\"%x-%d\"", partDigests.Sum(nil), len(parts))
+		metadata.Attributes = u.Attributes
+		contentID, err := storeContent(tx, content)
+		if err != nil {
+			return err
+		}
+		// a failed precondition rolls back the transaction, the upload is kept so the client may retry completing it
+		if result, err = a.commitRecord(tx, path, &record{Metadata: metadata, ContentID: contentID}, isTemp, options); err != nil {
+			return err
+		}
+		return removeUpload(tx, uploadID)
+	})
+	if err != nil {
+		log.WithContext(ctx).Warnf("failed to complete multipart upload %v of file %v. err: %v", uploadID, path, err)
+		return models.FileMetadata{}, err
+	}
+	return result, nil
+}
+
+// AbortMultipartUpload removes a multipart upload along with the parts uploaded so far
+func (a *Adapter) AbortMultipartUpload(ctx context.Context, path string, uploadID string) error {
+	log.WithContext(ctx).Debugf("abort multipart upload %v of file %v", uploadID, path)
+	return a.db.Update(func(tx *bolt.Tx) error {
+		if _, err := openUpload(tx, path, uploadID); err != nil {
+			return err
+		}
+		return removeUpload(tx, uploadID)
+	})
+}
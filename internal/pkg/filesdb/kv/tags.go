@@ -0,0 +1,56 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+import (
+	"context"
+
+	bolt "go.etcd.io/bbolt"
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/common"
+)
+
+// SetFileTags replaces the tags of a file, or of one of its versions when versionID is set, nil tags remove them.
+// the content and its last modified time are left as is
+func (a *Adapter) SetFileTags(ctx context.Context, path string, versionID string, tags map[string]string) (models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("set tags of file: %v, version: %v, tags: %v", path, versionID, tags)
+	if err := common.ValidateKey(path); err != nil {
+		return models.FileMetadata{}, err
+	}
+	var metadata models.FileMetadata
+	err := a.db.Update(func(tx *bolt.Tx) error {
+		r, isCurrent, err := lookup(tx, path, versionID)
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			return common.NotFoundError(path)
+		}
+		r.Metadata.Tags = tags
+		// the record is rewritten in place, its deadline and so its expiry index entry are left as is
+		if isCurrent {
+			err = putJSON(tx.Bucket(filesBucket), path, r)
+		} else {
+			err = putJSON(tx.Bucket(versionsBucket), versionKey(path, versionID), r)
+		}
+		metadata = fileMetadata(path, r)
+		return err
+	})
+	if err != nil {
+		log.WithContext(ctx).Warnf("failed to set tags of file %v. err: %v", path, err)
+		return models.FileMetadata{}, err
+	}
+	return metadata, nil
+}
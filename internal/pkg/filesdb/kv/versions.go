@@ -0,0 +1,237 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/common"
+)
+
+// versioned is true when overwriting or deleting an object keeps its content as a noncurrent version.
+// temp files are never versioned
+func (a *Adapter) versioned(isTemp bool) bool {
+	return a.versioning && !isTemp
+}
+
+// archiveCurrent keeps the current object of key as a noncurrent version, before it is replaced or removed.
+// the caller removes the current object, or replaces it
+func (a *Adapter) archiveCurrent(tx *bolt.Tx, key string, cur *record, now time.Time) error {
+	if cur == nil {
+		return nil
+	}
+	if err := unindexExpiry(tx, cur.Expires, fileRef, key); err != nil {
+		return err
+	}
+	versionID := cur.versionID()
+	// a null version archived before versioning was turned back on is replaced, as in S3
+	if err := removeVersion(tx, key, versionID); err != nil {
+		return err
+	}
+	archived := *cur
+	archived.Expires = now.Add(a.noncurrentRetention)
+	if err := putJSON(tx.Bucket(versionsBucket), versionKey(key, versionID), &archived); err != nil {
+		return err
+	}
+	return indexExpiry(tx, archived.Expires, versionRef, versionKey(key, versionID))
+}
+
+// removeVersion removes a noncurrent version of key along with its content, if there is one
+func removeVersion(tx *bolt.Tx, key string, versionID string) error {
+	b := tx.Bucket(versionsBucket)
+	r, err := decodeRecord(key, b.Get([]byte(versionKey(key, versionID))))
+	if err != nil || r == nil {
+		return err
+	}
+	if err := b.Delete([]byte(versionKey(key, versionID))); err != nil {
+		return err
+	}
+	if err := unindexExpiry(tx, r.Expires, versionRef, versionKey(key, versionID)); err != nil {
+		return err
+	}
+	return tx.Bucket(contentsBucket).Delete([]byte(r.ContentID))
+}
+
+// noncurrentVersions returns the noncurrent versions of key, from the newest to the oldest
+func noncurrentVersions(tx *bolt.Tx, key string) ([]*record, error) {
+	var versions []*record
+	prefix := []byte(key + separator)
+	c := tx.Bucket(versionsBucket).Cursor()
+	for k, v := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, v = c.Next() {
+		r, err := decodeRecord(key, v)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, r)
+	}
+	sort.Slice(versions, func(i, j int) bool { return common.NewerVersion(versions[i].versionID(), versions[j].versionID()) })
+	return versions, nil
+}
+
+// deleteVersion removes a version of the object of key. deleting the current version makes the newest
+// noncurrent version current, and deleting a version which doesn't exist succeeds
+func deleteVersion(tx *bolt.Tx, key string, versionID string) error {
+	cur, err := current(tx, key)
+	if err != nil {
+		return err
+	}
+	if cur == nil || cur.versionID() != versionID {
+		return removeVersion(tx, key, versionID)
+	}
+	if err := removeCurrent(tx, key, cur); err != nil {
+		return err
+	}
+	versions, err := noncurrentVersions(tx, key)
+	if err != nil || len(versions) == 0 {
+		return err
+	}
+	promoted := *versions[0]
+	promotedKey := versionKey(key, promoted.versionID())
+	if err := tx.Bucket(versionsBucket).Delete([]byte(promotedKey)); err != nil {
+		return err
+	}
+	if err := unindexExpiry(tx, promoted.Expires, versionRef, promotedKey); err != nil {
+		return err
+	}
+	promoted.Expires = time.Time{}
+	return putCurrent(tx, key, &promoted)
+}
+
+// keyVersions returns the versions of the object stored under key, from the newest to the oldest
+func keyVersions(tx *bolt.Tx, key string) ([]models.FileVersion, error) {
+	cur, err := current(tx, key)
+	if err != nil {
+		return nil, err
+	}
+	noncurrent, err := noncurrentVersions(tx, key)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]models.FileVersion, 0, len(noncurrent)+1)
+	if cur != nil {
+		metadata := fileMetadata(key, cur)
+		metadata.VersionID = cur.versionID()
+		versions = append(versions, models.FileVersion{FileMetadata: metadata, IsLatest: true})
+	}
+	for _, version := range noncurrent {
+		metadata := fileMetadata(key, version)
+		metadata.VersionID = version.versionID()
+		versions = append(versions, models.FileVersion{FileMetadata: metadata})
+	}
+	return versions, nil
+}
+
+// versionedKeys iterates the keys of the current objects and of the noncurrent versions starting with prefix,
+// from start, in key order
+type versionedKeys struct {
+	files    *bolt.Cursor
+	versions *bolt.Cursor
+	prefix   string
+	file     string
+	version  string
+}
+
+func newVersionedKeys(tx *bolt.Tx, prefix string, start string) *versionedKeys {
+	it := &versionedKeys{
+		files:    tx.Bucket(filesBucket).Cursor(),
+		versions: tx.Bucket(versionsBucket).Cursor(),
+		prefix:   prefix,
+	}
+	k, _ := it.files.Seek([]byte(start))
+	it.file = it.matching(k, false)
+	k, _ = it.versions.Seek([]byte(start))
+	it.version = it.matching(k, true)
+	return it
+}
+
+// matching returns the key a cursor is positioned at, empty when it is out of the prefix.
+// the keys of noncurrent versions are stripped of their version id
+func (it *versionedKeys) matching(k []byte, isVersion bool) string {
+	key := string(k)
+	if isVersion {
+		key, _, _ = strings.Cut(key, separator)
+	}
+	if k == nil || !strings.HasPrefix(key, it.prefix) {
+		return ""
+	}
+	return key
+}
+
+// next returns the next key, empty when no key is left
+func (it *versionedKeys) next() string {
+	key := it.file
+	if key == "" || (it.version != "" && it.version < key) {
+		key = it.version
+	}
+	if key == "" {
+		return ""
+	}
+	if it.file == key {
+		k, _ := it.files.Next()
+		it.file = it.matching(k, false)
+	}
+	for it.version == key {
+		k, _ := it.versions.Next()
+		it.version = it.matching(k, true)
+	}
+	return key
+}
+
+// ListVersions returns a page of the versions of the files matching the options, sorted by key and from the
+// newest version to the oldest
+func (a *Adapter) ListVersions(ctx context.Context, options models.ListVersionsOptions) (models.VersionsList, error) {
+	log.WithContext(ctx).Infof("list versions with options: %+v", options)
+	if _, err := models.KeySegments(options.Prefix, true); err != nil {
+		return models.VersionsList{}, err
+	}
+	list := models.VersionsList{Versions: []models.FileVersion{}}
+	start := options.Prefix
+	if options.KeyMarker > start {
+		start = options.KeyMarker
+	}
+	err := a.db.View(func(tx *bolt.Tx) error {
+		it := newVersionedKeys(tx, options.Prefix, start)
+		for key := it.next(); key != ""; key = it.next() {
+			if key == options.KeyMarker && options.VersionIDMarker == "" {
+				continue
+			}
+			versions, err := keyVersions(tx, key)
+			if err != nil {
+				return err
+			}
+			for _, version := range versions {
+				if key == options.KeyMarker && !common.NewerVersion(options.VersionIDMarker, version.VersionID) {
+					continue
+				}
+				if options.MaxKeys > 0 && len(list.Versions) == options.MaxKeys {
+					list.IsTruncated = true
+					return nil
+				}
+				list.Versions = append(list.Versions, version)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithContext(ctx).Errorf("failed to list versions. err: %v", err)
+		return models.VersionsList{}, err
+	}
+	return list, nil
+}
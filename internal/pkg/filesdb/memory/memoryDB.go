@@ -0,0 +1,449 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"openappsec.io/errors"
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/common"
+)
+
+const (
+	fsBaseConfig          = "filesystem_db"
+	fsConfigTTL           = fsBaseConfig + ".ttl"
+	fsConfigSweepInterval = fsBaseConfig + ".sweep_interval"
+	// versioning is opt-in, noncurrent versions are kept for their own retention
+	fsConfigVersioning          = fsBaseConfig + ".versioning.enabled"
+	fsConfigNoncurrentRetention = fsBaseConfig + ".versioning.noncurrent_retention"
+	// the files are kept in memory, each of them is capped
+	memoryConfigMaxObjectSize = fsBaseConfig + ".memory.max_object_size"
+
+	defaultSweepInterval       = time.Minute
+	defaultNoncurrentRetention = 30 * 24 * time.Hour
+	defaultMaxObjectSize       = 64 << 20
+
+	healthCheckName = "memory"
+)
+
+// object is a version of the content stored under a key. the content is never modified once stored, so readers
+// stream it without holding the adapter lock
+type object struct {
+	content  []byte
+	metadata models.FileMetadata
+	// expires is the deadline of a temp file or of a noncurrent version, zero when it doesn't expire
+	expires time.Time
+}
+
+// versionID returns the version id of the object
+func (o *object) versionID() string {
+	if o.metadata.VersionID == "" {
+		return models.NullVersionID
+	}
+	return o.metadata.VersionID
+}
+
+// entry holds the current object stored under a key and its noncurrent versions
+type entry struct {
+	// current is nil when only noncurrent versions of the key are left
+	current *object
+	// versions are the noncurrent versions, from the newest to the oldest
+	versions []*object
+}
+
+// Adapter keeps the files in memory, they are lost when the process exits.
+// it has the ttl, listing and versioning semantics of the filesystem adapter, and is meant for tests and
+// ephemeral deployments
+type Adapter struct {
+	ttl time.Duration
+	// versioning keeps the previous contents of persistent files as noncurrent versions
+	versioning          bool
+	noncurrentRetention time.Duration
+	// maxObjectSize caps the size of a file, and of a part of a multipart upload
+	maxObjectSize int64
+	// mu guards the entries, the keys and the uploads
+	mu      sync.RWMutex
+	entries map[string]*entry
+	// keys are the keys of the entries, sorted so they are listed without sorting them
+	keys    []string
+	uploads map[string]*upload
+	done    chan struct{}
+}
+
+// Configuration service interface for fetching config
+type Configuration interface {
+	GetDuration(key string) (time.Duration, error)
+	GetBool(key string) (bool, error)
+	GetInt(key string) (int, error)
+}
+
+// NewAdapter creates new adapter
+func NewAdapter(conf Configuration) (*Adapter, error) {
+	ttl, err := common.PositiveDuration(conf, fsConfigTTL, 0)
+	if err != nil {
+		return &Adapter{}, err
+	}
+	sweepInterval, err := common.PositiveDuration(conf, fsConfigSweepInterval, defaultSweepInterval)
+	if err != nil {
+		return &Adapter{}, err
+	}
+	versioning, err := conf.GetBool(fsConfigVersioning)
+	if err != nil && !errors.IsClass(err, errors.ClassNotFound) {
+		return &Adapter{}, err
+	}
+	noncurrentRetention, err := common.PositiveDuration(conf, fsConfigNoncurrentRetention, defaultNoncurrentRetention)
+	if err != nil {
+		return &Adapter{}, err
+	}
+	maxObjectSize, err := common.PositiveSize(conf, memoryConfigMaxObjectSize, defaultMaxObjectSize)
+	if err != nil {
+		return &Adapter{}, err
+	}
+	a := &Adapter{
+		ttl:                 ttl,
+		versioning:          versioning,
+		noncurrentRetention: noncurrentRetention,
+		maxObjectSize:       maxObjectSize,
+		entries:             make(map[string]*entry),
+		uploads:             make(map[string]*upload),
+		done:                make(chan struct{}),
+	}
+	go a.sweeper(sweepInterval)
+	return a, nil
+}
+
+// sweeper removes expired temp files every interval until the adapter is torn down
+func (a *Adapter) sweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		a.sweep(time.Now())
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep removes the expired temp files, noncurrent versions and multipart uploads
+func (a *Adapter) sweep(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key, e := range a.entries {
+		if e.current != nil && expired(e.current.expires, now) {
+			log.Debugf("ttl expired for file: %v", key)
+			e.current = nil
+		}
+		versions := e.versions[:0]
+		for _, version := range e.versions {
+			if !expired(version.expires, now) {
+				versions = append(versions, version)
+			}
+		}
+		e.versions = versions
+		a.pruneEntry(key, e)
+	}
+	for uploadID, u := range a.uploads {
+		if expired(u.expires, now) {
+			log.Debugf("ttl expired for multipart upload: %v", uploadID)
+			delete(a.uploads, uploadID)
+		}
+	}
+}
+
+func expired(deadline time.Time, now time.Time) bool {
+	return !deadline.IsZero() && !deadline.After(now)
+}
+
+// HealthCheck always succeeds, the memory is always accessible
+func (a *Adapter) HealthCheck(ctx context.Context) (string, error) {
+	return healthCheckName, nil
+}
+
+// TearDown stops the expiry sweeper
+func (a *Adapter) TearDown(ctx context.Context) error {
+	close(a.done)
+	return nil
+}
+
+// entry returns the entry of key, creating it when create is true. must be called while holding the lock
+func (a *Adapter) entry(key string, create bool) *entry {
+	e, ok := a.entries[key]
+	if ok || !create {
+		return e
+	}
+	e = &entry{}
+	a.entries[key] = e
+	i := sort.SearchStrings(a.keys, key)
+	a.keys = append(a.keys, "")
+	copy(a.keys[i+1:], a.keys[i:])
+	a.keys[i] = key
+	return e
+}
+
+// pruneEntry removes the entry of key once it holds no object. must be called while holding the lock
+func (a *Adapter) pruneEntry(key string, e *entry) {
+	if e.current != nil || len(e.versions) > 0 {
+		return
+	}
+	delete(a.entries, key)
+	if i := sort.SearchStrings(a.keys, key); i < len(a.keys) && a.keys[i] == key {
+		a.keys = append(a.keys[:i], a.keys[i+1:]...)
+	}
+}
+
+// lookup returns the object stored under key, or one of its versions when versionID is set, nil when there is none.
+// must be called while holding the lock
+func (a *Adapter) lookup(key string, versionID string) *object {
+	e := a.entries[key]
+	if e == nil {
+		return nil
+	}
+	if e.current != nil && (versionID == "" || versionID == e.current.versionID()) {
+		return e.current
+	}
+	if versionID == "" {
+		return nil
+	}
+	for _, version := range e.versions {
+		if version.versionID() == versionID {
+			return version
+		}
+	}
+	return nil
+}
+
+// fileMetadata returns the metadata of an object stored under key
+func fileMetadata(key string, o *object) models.FileMetadata {
+	metadata := o.metadata
+	metadata.Path = key
+	return metadata
+}
+
+// GetFilesList return a page of the files matching the options, sorted by key, with their metadata.
+// when a delimiter is given, keys sharing a common prefix are rolled up into it, and each common prefix counts
+// as a single key of the page.
+func (a *Adapter) GetFilesList(ctx context.Context, options models.ListOptions) (models.FilesList, error) {
+	log.WithContext(ctx).Infof("list files with options: %+v", options)
+	if _, err := models.KeySegments(options.Prefix, true); err != nil {
+		return models.FilesList{}, err
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	list := models.FilesList{Files: []models.FileMetadata{}, CommonPrefixes: []string{}}
+	lastPrefix := ""
+	full := func() bool {
+		return options.MaxKeys > 0 && len(list.Files)+len(list.CommonPrefixes) == options.MaxKeys
+	}
+	start := options.Prefix
+	if options.StartAfter > start {
+		start = options.StartAfter
+	}
+	for i := sort.SearchStrings(a.keys, start); i < len(a.keys) && strings.HasPrefix(a.keys[i], options.Prefix); i++ {
+		key := a.keys[i]
+		current := a.entries[key].current
+		if current == nil || key <= options.StartAfter {
+			continue
+		}
+		if cp := common.CommonPrefix(key, options.Prefix, options.Delimiter); cp != "" {
+			if cp == lastPrefix || cp == options.StartAfter {
+				continue
+			}
+			if full() {
+				list.IsTruncated = true
+				break
+			}
+			list.CommonPrefixes = append(list.CommonPrefixes, cp)
+			lastPrefix = cp
+			continue
+		}
+		if !models.TagsMatch(current.metadata.Tags, options.Tags) {
+			continue
+		}
+		// checked once the file is known to match, so a page isn't reported truncated by files filtered out
+		if full() {
+			list.IsTruncated = true
+			break
+		}
+		list.Files = append(list.Files, fileMetadata(key, current))
+	}
+	return list, nil
+}
+
+// GetFile return a reader streaming the file content and the file metadata, the caller must close the reader
+func (a *Adapter) GetFile(ctx context.Context, path string, options models.GetOptions) (io.ReadCloser, models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("get file: %v, options: %+v", path, options)
+	if err := common.ValidateKey(path); err != nil {
+		return nil, models.FileMetadata{}, err
+	}
+	a.mu.RLock()
+	o := a.lookup(path, options.VersionID)
+	var metadata models.FileMetadata
+	if o != nil {
+		// the metadata may be updated in place, the content never is
+		metadata = fileMetadata(path, o)
+	}
+	a.mu.RUnlock()
+	if o == nil {
+		log.WithContext(ctx).Warnf("file %v not found", path)
+		return nil, models.FileMetadata{}, common.NotFoundError(path)
+	}
+	size := int64(len(o.content))
+	offset, length := int64(0), size
+	if options.Range != nil {
+		var ok bool
+		offset, length, ok = options.Range.Resolve(size)
+		if !ok {
+			return nil, models.FileMetadata{}, errors.Errorf(
+				"range %+v not satisfiable for file %v of size %v", *options.Range, path, size,
+			).SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidRange)
+		}
+	}
+	return io.NopCloser(bytes.NewReader(o.content[offset : offset+length])), metadata, nil
+}
+
+// StatFile return file metadata, or the metadata of one of its versions when versionID is set
+func (a *Adapter) StatFile(ctx context.Context, path string, versionID string) (models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("stat file: %v, version: %v", path, versionID)
+	if err := common.ValidateKey(path); err != nil {
+		return models.FileMetadata{}, err
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	o := a.lookup(path, versionID)
+	if o == nil {
+		log.WithContext(ctx).Debugf("file %v not found", path)
+		return models.FileMetadata{}, common.NotFoundError(path)
+	}
+	return fileMetadata(path, o), nil
+}
+
+// PutFile stores a file streamed from content, set ttl if isTemp is true.
+// the content is verified against the digests it was sent with before it replaces the file
+func (a *Adapter) PutFile(ctx context.Context, path string, content io.Reader, isTemp bool, options models.PutOptions) (models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("put file: %v, options: %+v", path, options)
+	if err := common.ValidateKey(path); err != nil {
+		return models.FileMetadata{}, err
+	}
+	data, metadata, err := common.ReadLimitedContent(content, options, a.maxObjectSize)
+	if err != nil {
+		log.WithContext(ctx).Warnf("failed to read content of file %v. err: %v", path, err)
+		return models.FileMetadata{}, err
+	}
+	metadata.Attributes = options.Attributes
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.commitObject(path, &object{content: data, metadata: metadata}, isTemp, options)
+}
+
+// CopyFile copies the file stored under srcPath to dstPath, set ttl if isTemp is true.
+// unless its metadata is replaced, the copy keeps the metadata of the source, the last modified time included
+func (a *Adapter) CopyFile(ctx context.Context, srcPath string, dstPath string, isTemp bool, options models.CopyOptions) (models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("copy file: %v to %v, options: %+v", srcPath, dstPath, options)
+	if err := common.ValidateKey(srcPath); err != nil {
+		return models.FileMetadata{}, err
+	}
+	if err := common.ValidateKey(dstPath); err != nil {
+		return models.FileMetadata{}, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	src := a.lookup(srcPath, options.SourceVersionID)
+	if src == nil {
+		log.WithContext(ctx).Warnf("file %v not found", srcPath)
+		return models.FileMetadata{}, common.NotFoundError(srcPath)
+	}
+	dst := &object{content: src.content, metadata: src.metadata}
+	if options.ReplaceMetadata {
+		dst.metadata.LastModified = time.Now()
+		dst.metadata.Attributes = options.Attributes
+	}
+	return a.commitObject(dstPath, dst, isTemp, models.PutOptions{})
+}
+
+// commitObject replaces the object stored under key with o, if the preconditions of the write are met.
+// must be called while holding the lock
+func (a *Adapter) commitObject(key string, o *object, isTemp bool, options models.PutOptions) (models.FileMetadata, error) {
+	e := a.entry(key, false)
+	if options.Conditional() {
+		exists, etag := e != nil && e.current != nil, ""
+		if exists {
+			etag = e.current.metadata.ETag
+		}
+		if !options.Satisfied(etag, exists) {
+			return models.FileMetadata{}, errors.Errorf("precondition %+v failed for file %v", options, key).
+				SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelPreconditionFailed)
+		}
+	}
+	now := time.Now()
+	o.metadata.VersionID = ""
+	o.expires = time.Time{}
+	if isTemp {
+		o.expires = now.Add(a.ttl)
+	}
+	if a.versioned(isTemp) {
+		versionID, err := common.NewVersionID()
+		if err != nil {
+			return models.FileMetadata{}, err
+		}
+		o.metadata.VersionID = versionID
+		a.archiveCurrent(e, now)
+	}
+	e = a.entry(key, true)
+	e.current = o
+	return fileMetadata(key, o), nil
+}
+
+// DeleteFile removes a file, or one of its versions when versionID is set. deleting a file which doesn't exist
+// succeeds. when versioning is on, the removed content of a persistent file is kept as a noncurrent version
+func (a *Adapter) DeleteFile(ctx context.Context, path string, versionID string) error {
+	log.WithContext(ctx).Debugf("delete file: %v, version: %v", path, versionID)
+	if err := common.ValidateKey(path); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e := a.entry(path, false)
+	if e == nil {
+		return nil
+	}
+	if versionID != "" {
+		a.deleteVersion(e, versionID)
+	} else if e.current != nil {
+		if a.versioned(common.IsTempKey(path)) {
+			a.archiveCurrent(e, time.Now())
+		}
+		e.current = nil
+	}
+	a.pruneEntry(path, e)
+	return nil
+}
+
+// DeleteFiles removes a batch of files, returning the outcome of each deletion
+func (a *Adapter) DeleteFiles(ctx context.Context, paths []string) []models.DeleteResult {
+	results := make([]models.DeleteResult, len(paths))
+	for i, path := range paths {
+		results[i] = models.DeleteResult{Path: path, Err: a.DeleteFile(ctx, path, "")}
+	}
+	return results
+}
@@ -0,0 +1,215 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"openappsec.io/errors"
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/common"
+)
+
+// upload is a multipart upload in progress, with the parts uploaded so far
+type upload struct {
+	key string
+	// attributes are the attributes the assembled file is stored with
+	attributes models.ObjectAttributes
+	parts      map[int]*part
+	// expires is reset by every part uploaded, an upload without activity for the ttl is abandoned
+	expires time.Time
+}
+
+type part struct {
+	content  []byte
+	metadata models.Part
+}
+
+func noSuchUploadError(uploadID string) error {
+	return errors.Errorf("multipart upload %q not found", uploadID).
+		SetClass(errors.ClassNotFound).SetLabel(models.ErrLabelNoSuchUpload)
+}
+
+// openUpload returns the multipart upload of the file stored under key. must be called while holding the lock
+func (a *Adapter) openUpload(key string, uploadID string) (*upload, error) {
+	u, ok := a.uploads[uploadID]
+	// an upload is only reachable through the key it was created for, which is in the caller tenant namespace
+	if !ok || u.key != key {
+		return nil, noSuchUploadError(uploadID)
+	}
+	return u, nil
+}
+
+// CreateMultipartUpload starts a multipart upload of the file stored under path and returns its id.
+// an upload without activity for the ttl is abandoned, and removed as expired temp files are
+func (a *Adapter) CreateMultipartUpload(ctx context.Context, path string, attributes models.ObjectAttributes) (string, error) {
+	log.WithContext(ctx).Debugf("create multipart upload: %v", path)
+	if err := common.ValidateKey(path); err != nil {
+		return "", err
+	}
+	uploadID, err := common.NewUploadID()
+	if err != nil {
+		return "", err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.uploads[uploadID] = &upload{
+		key:        path,
+		attributes: attributes,
+		parts:      make(map[int]*part),
+		expires:    time.Now().Add(a.ttl),
+	}
+	return uploadID, nil
+}
+
+// UploadPart stores a part of a multipart upload streamed from content, replacing a previous part with the same
+// number. every part resets the expiration deadline of the upload.
+func (a *Adapter) UploadPart(ctx context.Context, path string, uploadID string, partNumber int, content io.Reader, options models.PutOptions) (models.Part, error) {
+	log.WithContext(ctx).Debugf("upload part %v of multipart upload %v of file %v", partNumber, uploadID, path)
+	// checked before reading the part, so parts of unknown uploads aren't buffered
+	a.mu.RLock()
+	_, err := a.openUpload(path, uploadID)
+	a.mu.RUnlock()
+	if err != nil {
+		return models.Part{}, err
+	}
+	data, metadata, err := common.ReadLimitedContent(content, options, a.maxObjectSize)
+	if err != nil {
+		log.WithContext(ctx).Warnf("failed to read part %v of multipart upload %v. err: %v", partNumber, uploadID, err)
+		return models.Part{}, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// the upload may have been completed or aborted while the part was read
+	u, err := a.openUpload(path, uploadID)
+	if err != nil {
+		return models.Part{}, err
+	}
+	uploaded := models.Part{PartNumber: partNumber, LastModified: metadata.LastModified, Size: metadata.Size, ETag: metadata.ETag}
+	u.parts[partNumber] = &part{content: data, metadata: uploaded}
+	u.expires = time.Now().Add(a.ttl)
+	return uploaded, nil
+}
+
+// sortedParts returns the parts uploaded to u, sorted by part number
+func sortedParts(u *upload) []models.Part {
+	parts := make([]models.Part, 0, len(u.parts))
+	for _, p := range u.parts {
+		parts = append(parts, p.metadata)
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts
+}
+
+// ListParts return a page of the parts uploaded to a multipart upload, sorted by part number
+func (a *Adapter) ListParts(ctx context.Context, path string, uploadID string, options models.ListPartsOptions) (models.PartsList, error) {
+	log.WithContext(ctx).Debugf("list parts of multipart upload %v with options: %+v", uploadID, options)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	u, err := a.openUpload(path, uploadID)
+	if err != nil {
+		return models.PartsList{}, err
+	}
+	list := models.PartsList{Parts: []models.Part{}}
+	for _, uploaded := range sortedParts(u) {
+		if uploaded.PartNumber <= options.PartNumberMarker {
+			continue
+		}
+		if options.MaxParts > 0 && len(list.Parts) == options.MaxParts {
+			list.IsTruncated = true
+			break
+		}
+		list.Parts = append(list.Parts, uploaded)
+	}
+	return list, nil
+}
+
+// CompleteMultipartUpload assembles the file stored under path from the listed parts of the upload, set ttl if
+// isTemp is true, and removes the upload. as in S3, the entity tag of the file is derived from the digests of
+// its parts.
+func (a *Adapter) CompleteMultipartUpload(ctx context.Context, path string, uploadID string, parts []models.CompletedPart, isTemp bool, options models.PutOptions) (models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("complete multipart upload %v of file %v with %v parts", uploadID, path, len(parts))
+	if err := common.ValidateKey(path); err != nil {
+		return models.FileMetadata{}, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u, err := a.openUpload(path, uploadID)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	if len(parts) == 0 {
+		return models.FileMetadata{}, errors.Errorf("no parts listed for multipart upload %v", uploadID).
+			SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidPart)
+	}
+	partDigests := md5.New()
+	size := 0
+	for i, completed := range parts {
+		if i > 0 && completed.PartNumber <= parts[i-1].PartNumber {
+			return models.FileMetadata{}, errors.Errorf("part %v listed out of order", completed.PartNumber).
+				SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidPartOrder)
+		}
+		uploaded, ok := u.parts[completed.PartNumber]
+		if !ok || strings.Trim(completed.ETag, "\"") != strings.Trim(uploaded.metadata.ETag, "\"") {
+			return models.FileMetadata{}, errors.Errorf("part %v with entity tag %v wasn't uploaded", completed.PartNumber, completed.ETag).
+				SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidPart)
+		}
+		if i < len(parts)-1 && len(uploaded.content) < models.MinPartSize {
+			return models.FileMetadata{}, errors.Errorf("part %v is smaller than the minimum part size of %v bytes", completed.PartNumber, models.MinPartSize).
+				SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelEntityTooSmall)
+		}
+		digest, _ := hex.DecodeString(strings.Trim(uploaded.metadata.ETag, "\""))
+		partDigests.Write(digest)
+		size += len(uploaded.content)
+	}
+	if int64(size) > a.maxObjectSize {
+		return models.FileMetadata{}, common.EntityTooLargeError(a.maxObjectSize)
+	}
+	h := common.NewContentHasher()
+	content := make([]byte, 0, size)
+	for _, completed := range parts {
+		content = append(content, u.parts[completed.PartNumber].content...)
+	}
+	h.Write(content)
+	metadata := h.Metadata()
+	metadata.ETag = fmt.Sprintf("\"%x-%d\"", partDigests.Sum(nil), len(parts))
+	metadata.Attributes = u.attributes
+	result, err := a.commitObject(path, &object{content: content, metadata: metadata}, isTemp, options)
+	if err != nil {
+		// the upload is kept, so the client may retry completing it
+		return models.FileMetadata{}, err
+	}
+	delete(a.uploads, uploadID)
+	return result, nil
+}
+
+// AbortMultipartUpload removes a multipart upload along with the parts uploaded so far
+func (a *Adapter) AbortMultipartUpload(ctx context.Context, path string, uploadID string) error {
+	log.WithContext(ctx).Debugf("abort multipart upload %v of file %v", uploadID, path)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.openUpload(path, uploadID); err != nil {
+		return err
+	}
+	delete(a.uploads, uploadID)
+	return nil
+}
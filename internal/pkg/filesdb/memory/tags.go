@@ -0,0 +1,40 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/common"
+)
+
+// SetFileTags replaces the tags of a file, or of one of its versions when versionID is set, nil tags remove them.
+// the content and its last modified time are left as is
+func (a *Adapter) SetFileTags(ctx context.Context, path string, versionID string, tags map[string]string) (models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("set tags of file: %v, version: %v, tags: %v", path, versionID, tags)
+	if err := common.ValidateKey(path); err != nil {
+		return models.FileMetadata{}, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	o := a.lookup(path, versionID)
+	if o == nil {
+		log.WithContext(ctx).Warnf("file %v not found", path)
+		return models.FileMetadata{}, common.NotFoundError(path)
+	}
+	o.metadata.Tags = tags
+	return fileMetadata(path, o), nil
+}
@@ -0,0 +1,124 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/common"
+)
+
+// versioned is true when overwriting or deleting an object keeps its content as a noncurrent version.
+// temp files are never versioned
+func (a *Adapter) versioned(isTemp bool) bool {
+	return a.versioning && !isTemp
+}
+
+// archiveCurrent keeps the current object of e as a noncurrent version, before it is replaced or removed.
+// must be called while holding the lock
+func (a *Adapter) archiveCurrent(e *entry, now time.Time) {
+	if e == nil || e.current == nil {
+		return
+	}
+	archived := *e.current
+	archived.expires = now.Add(a.noncurrentRetention)
+	versionID := archived.versionID()
+	versions := make([]*object, 0, len(e.versions)+1)
+	for _, version := range e.versions {
+		// a null version archived before versioning was turned back on is replaced, as in S3
+		if version.versionID() != versionID {
+			versions = append(versions, version)
+		}
+	}
+	i := sort.Search(len(versions), func(i int) bool { return common.NewerVersion(versionID, versions[i].versionID()) })
+	versions = append(versions, nil)
+	copy(versions[i+1:], versions[i:])
+	versions[i] = &archived
+	e.versions = versions
+}
+
+// deleteVersion removes a version of the object of e. deleting the current version makes the newest noncurrent
+// version current, and deleting a version which doesn't exist succeeds. must be called while holding the lock
+func (a *Adapter) deleteVersion(e *entry, versionID string) {
+	if e.current != nil && e.current.versionID() == versionID {
+		e.current = nil
+		if len(e.versions) > 0 {
+			promoted := *e.versions[0]
+			promoted.expires = time.Time{}
+			e.current, e.versions = &promoted, e.versions[1:]
+		}
+		return
+	}
+	for i, version := range e.versions {
+		if version.versionID() == versionID {
+			e.versions = append(e.versions[:i:i], e.versions[i+1:]...)
+			return
+		}
+	}
+}
+
+// keyVersions returns the versions of the object of e stored under key, from the newest to the oldest.
+// must be called while holding the lock
+func keyVersions(key string, e *entry) []models.FileVersion {
+	versions := make([]models.FileVersion, 0, len(e.versions)+1)
+	if e.current != nil {
+		metadata := fileMetadata(key, e.current)
+		metadata.VersionID = e.current.versionID()
+		versions = append(versions, models.FileVersion{FileMetadata: metadata, IsLatest: true})
+	}
+	for _, version := range e.versions {
+		metadata := fileMetadata(key, version)
+		metadata.VersionID = version.versionID()
+		versions = append(versions, models.FileVersion{FileMetadata: metadata})
+	}
+	return versions
+}
+
+// ListVersions returns a page of the versions of the files matching the options, sorted by key and from the
+// newest version to the oldest
+func (a *Adapter) ListVersions(ctx context.Context, options models.ListVersionsOptions) (models.VersionsList, error) {
+	log.WithContext(ctx).Infof("list versions with options: %+v", options)
+	if _, err := models.KeySegments(options.Prefix, true); err != nil {
+		return models.VersionsList{}, err
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	list := models.VersionsList{Versions: []models.FileVersion{}}
+	start := options.Prefix
+	if options.KeyMarker > start {
+		start = options.KeyMarker
+	}
+	for i := sort.SearchStrings(a.keys, start); i < len(a.keys) && strings.HasPrefix(a.keys[i], options.Prefix); i++ {
+		key := a.keys[i]
+		if key == options.KeyMarker && options.VersionIDMarker == "" {
+			continue
+		}
+		for _, version := range keyVersions(key, a.entries[key]) {
+			if key == options.KeyMarker && !common.NewerVersion(options.VersionIDMarker, version.VersionID) {
+				continue
+			}
+			if options.MaxKeys > 0 && len(list.Versions) == options.MaxKeys {
+				list.IsTruncated = true
+				return list, nil
+			}
+			list.Versions = append(list.Versions, version)
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,266 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"openappsec.io/errors"
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/sigv4"
+)
+
+const (
+	// maxResponseSize caps the size of the upstream responses read into memory, listings included
+	maxResponseSize = 16 << 20
+	service         = "s3"
+)
+
+// client sends signed requests to the upstream bucket, and retries the requests which failed transiently
+type client struct {
+	http     *http.Client
+	endpoint *url.URL
+	bucket   string
+	// pathStyle addresses the bucket in the path instead of the host name
+	pathStyle       bool
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	// timeout bounds every attempt of a request, a streamed response body is read without a deadline
+	timeout      time.Duration
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+func newClient(endpoint *url.URL, timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.ResponseHeaderTimeout = timeout
+	transport.MaxIdleConnsPerHost = 64
+	return &http.Client{Transport: transport}
+}
+
+// request describes a request to the upstream bucket
+type request struct {
+	method string
+	// key is the object key, empty for the requests to the bucket
+	key    string
+	query  url.Values
+	header http.Header
+	// body is read from its start on every attempt, nil for requests without one
+	body io.ReadSeeker
+	size int64
+	// payloadHash is the hex SHA-256 digest of the body, the payload is unsigned when it is empty
+	payloadHash string
+	// stream leaves the response body to the caller, who must close it
+	stream bool
+}
+
+// s3Error is the error body of the upstream responses
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string
+	Message string
+	Key     string
+}
+
+// url returns the url of an object, or of the bucket when key is empty
+func (c *client) url(key string, query url.Values) *url.URL {
+	u := *c.endpoint
+	path := strings.TrimSuffix(u.Path, "/")
+	if c.pathStyle {
+		path += "/" + c.bucket
+	} else {
+		u.Host = c.bucket + "." + u.Host
+	}
+	u.Path = path + "/" + key
+	// the path is sent encoded as it is signed
+	u.RawPath = sigv4.URIEncode(u.Path, false)
+	u.RawQuery = sigv4.CanonicalQuery(query)
+	return &u
+}
+
+// newRequest returns a signed http request
+func (c *client) newRequest(ctx context.Context, req request) (*http.Request, error) {
+	var body io.Reader
+	if req.body != nil {
+		if _, err := req.body.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		// the body is owned by the caller, it is read again when the request is retried
+		body = io.NopCloser(req.body)
+	}
+	r, err := http.NewRequestWithContext(ctx, req.method, c.url(req.key, req.query).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	r.ContentLength = req.size
+	for name, values := range req.header {
+		r.Header[name] = append([]string(nil), values...)
+	}
+	payloadHash := req.payloadHash
+	if payloadHash == "" {
+		payloadHash = sigv4.UnsignedPayload
+	}
+	sigv4.Sign(r, c.accessKeyID, c.secretAccessKey, c.region, service, payloadHash, time.Now())
+	return r, nil
+}
+
+// send makes a single attempt of a request. unless the response is streamed to the caller, its body is read
+// before the attempt deadline
+func (c *client) send(ctx context.Context, req request) (*http.Response, error) {
+	if !req.stream {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	r, err := c.newRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	if req.stream && resp.StatusCode < http.StatusMultipleChoices {
+		return resp, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// retryable is true for the responses of requests which may succeed when retried
+func retryable(resp *http.Response) bool {
+	return resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// do sends a request to the upstream bucket, retrying it with an exponential backoff when it fails transiently.
+// a response with an error status is translated into an error, the caller must close the body of a response
+func (c *client) do(ctx context.Context, req request) (*http.Response, error) {
+	backoff := c.retryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, req)
+		if err == nil && !retryable(resp) {
+			if resp.StatusCode >= http.StatusMultipleChoices {
+				defer resp.Body.Close()
+				return nil, upstreamError(req, resp.StatusCode, readError(resp))
+			}
+			return resp, nil
+		}
+		if err == nil {
+			resp.Body.Close()
+			err = upstreamError(req, resp.StatusCode, readError(resp))
+		} else if ctx.Err() != nil {
+			return nil, errors.Wrapf(err, "upstream %v %v canceled", req.method, req.key).SetClass(errors.ClassBadGateway)
+		} else {
+			err = errors.Wrapf(err, "upstream %v %v failed", req.method, req.key).SetClass(errors.ClassBadGateway)
+		}
+		if attempt == c.maxRetries {
+			return nil, err
+		}
+		log.WithContext(ctx).Debugf("retrying upstream request in %v. err: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// doXML sends a request and decodes the XML body of its response into v
+func (c *client) doXML(ctx context.Context, req request, v interface{}) (http.Header, error) {
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	// a request processed for a while, such as a copy, may fail after its success status was sent
+	var embedded s3Error
+	if xml.Unmarshal(body, &embedded) == nil {
+		return nil, upstreamError(req, http.StatusInternalServerError, embedded)
+	}
+	if v != nil {
+		if err := xml.Unmarshal(body, v); err != nil {
+			return nil, errors.Wrapf(err, "invalid upstream response to %v %v", req.method, req.key).
+				SetClass(errors.ClassBadGateway)
+		}
+	}
+	return resp.Header, nil
+}
+
+// readError decodes the error body of a response, responses to HEAD requests have none
+func readError(resp *http.Response) s3Error {
+	var body s3Error
+	if data, err := io.ReadAll(resp.Body); err == nil {
+		_ = xml.Unmarshal(data, &body)
+	}
+	if body.Code == "" {
+		body.Code = strings.ReplaceAll(http.StatusText(resp.StatusCode), " ", "")
+	}
+	return body
+}
+
+// upstreamError translates an upstream error into the error classes and labels of the storage backends
+func upstreamError(req request, status int, body s3Error) error {
+	err := errors.Errorf("upstream %v %v failed with %v %v: %v", req.method, req.key, status, body.Code, body.Message)
+	switch {
+	case body.Code == "NoSuchUpload":
+		return err.SetClass(errors.ClassNotFound).SetLabel(models.ErrLabelNoSuchUpload)
+	case body.Code == "NoSuchBucket":
+		// the bucket is part of the configuration, it isn't a file the client asked for
+		return err.SetClass(errors.ClassInternal)
+	case status == http.StatusNotFound || body.Code == "NoSuchKey" || body.Code == "NoSuchVersion":
+		return err.SetClass(errors.ClassNotFound)
+	case status == http.StatusPreconditionFailed || status == http.StatusConflict:
+		return err.SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelPreconditionFailed)
+	case status == http.StatusRequestedRangeNotSatisfiable || body.Code == "InvalidRange":
+		return err.SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidRange)
+	case body.Code == "BadDigest" || body.Code == "InvalidDigest" || body.Code == "XAmzContentSHA256Mismatch":
+		return err.SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelBadDigest)
+	case body.Code == "InvalidPart":
+		return err.SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidPart)
+	case body.Code == "InvalidPartOrder":
+		return err.SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidPartOrder)
+	case body.Code == "EntityTooSmall":
+		return err.SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelEntityTooSmall)
+	case body.Code == "InvalidTag":
+		return err.SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidTag)
+	case status == http.StatusUnauthorized || status == http.StatusForbidden || body.Code == "AccessDenied":
+		// the upstream credentials are part of the configuration, the client isn't denied access
+		return err.SetClass(errors.ClassInternal)
+	case status >= http.StatusBadRequest && status < http.StatusInternalServerError:
+		return err.SetClass(errors.ClassBadInput).SetLabel(models.ErrLabelInvalidArgument)
+	default:
+		return err.SetClass(errors.ClassBadGateway)
+	}
+}
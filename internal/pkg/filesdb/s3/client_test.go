@@ -0,0 +1,184 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"openappsec.io/errors"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/testutil"
+)
+
+func TestDoRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+		class    errors.Class
+	}{
+		{name: "success", statuses: nil, attempts: 1},
+		{name: "server error", statuses: []int{http.StatusServiceUnavailable}, attempts: 2},
+		{name: "throttled", statuses: []int{http.StatusTooManyRequests, http.StatusInternalServerError}, attempts: 3},
+		{
+			name:     "retries exhausted",
+			statuses: []int{500, 500, 500, 500},
+			attempts: 4,
+			class:    errors.ClassBadGateway,
+		},
+		{name: "client error", statuses: []int{http.StatusBadRequest}, attempts: 1, class: errors.ClassBadInput},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, a := newFakeUpstream(t, testutil.Configuration{s3ConfigMaxRetries: 3})
+			var bodies []string
+			f.intercept = func(w http.ResponseWriter, r *http.Request, body []byte) bool {
+				bodies = append(bodies, string(body))
+				if len(bodies) > len(test.statuses) {
+					return false
+				}
+				writeError(w, test.statuses[len(bodies)-1], "Failure")
+				return true
+			}
+			content := "the content of the file"
+			sum := sha256.Sum256([]byte(content))
+			resp, err := a.client.do(context.Background(), request{
+				method:      http.MethodPut,
+				key:         "tenants/t1/file",
+				body:        strings.NewReader(content),
+				size:        int64(len(content)),
+				payloadHash: hex.EncodeToString(sum[:]),
+			})
+			if err == nil {
+				resp.Body.Close()
+			}
+			if len(bodies) != test.attempts {
+				t.Fatalf("do() made %v attempts, want %v", len(bodies), test.attempts)
+			}
+			// the body is sent again from its start on every attempt
+			for i, body := range bodies {
+				if body != content {
+					t.Fatalf("attempt %v sent %q, want %q", i+1, body, content)
+				}
+			}
+			if test.class == errors.ClassUnknown {
+				if err != nil {
+					t.Fatalf("do() failed: %v", err)
+				}
+				if object := f.object("tenants/t1/file"); object == nil || string(object.content) != content {
+					t.Fatalf("the upstream stored %+v, want %q", object, content)
+				}
+				return
+			}
+			if !errors.IsClass(err, test.class) {
+				t.Fatalf("do() failed with %v, want class %v", err, test.class)
+			}
+		})
+	}
+}
+
+func TestDoCanceled(t *testing.T) {
+	f, a := newFakeUpstream(t, testutil.Configuration{s3ConfigMaxRetries: 3})
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	f.intercept = func(w http.ResponseWriter, r *http.Request, body []byte) bool {
+		attempts++
+		cancel()
+		writeError(w, http.StatusServiceUnavailable, "SlowDown")
+		return true
+	}
+	if _, err := a.client.do(ctx, request{method: http.MethodGet, key: "tenants/t1/file"}); err == nil {
+		t.Fatal("do() of a canceled request succeeded")
+	}
+	if attempts != 1 {
+		t.Fatalf("do() of a canceled request made %v attempts, want 1", attempts)
+	}
+}
+
+func TestUpstreamError(t *testing.T) {
+	tests := []struct {
+		status int
+		code   string
+		class  errors.Class
+		label  string
+	}{
+		{status: http.StatusNotFound, code: "NoSuchKey", class: errors.ClassNotFound},
+		{status: http.StatusNotFound, code: "NotFound", class: errors.ClassNotFound},
+		{status: http.StatusNotFound, code: "NoSuchVersion", class: errors.ClassNotFound},
+		{status: http.StatusNotFound, code: "NoSuchUpload", class: errors.ClassNotFound, label: models.ErrLabelNoSuchUpload},
+		{status: http.StatusNotFound, code: "NoSuchBucket", class: errors.ClassInternal},
+		{status: http.StatusPreconditionFailed, code: "PreconditionFailed", class: errors.ClassBadInput, label: models.ErrLabelPreconditionFailed},
+		{status: http.StatusConflict, code: "ConditionalRequestConflict", class: errors.ClassBadInput, label: models.ErrLabelPreconditionFailed},
+		{status: http.StatusRequestedRangeNotSatisfiable, code: "InvalidRange", class: errors.ClassBadInput, label: models.ErrLabelInvalidRange},
+		{status: http.StatusBadRequest, code: "BadDigest", class: errors.ClassBadInput, label: models.ErrLabelBadDigest},
+		{status: http.StatusBadRequest, code: "InvalidPart", class: errors.ClassBadInput, label: models.ErrLabelInvalidPart},
+		{status: http.StatusBadRequest, code: "InvalidPartOrder", class: errors.ClassBadInput, label: models.ErrLabelInvalidPartOrder},
+		{status: http.StatusBadRequest, code: "EntityTooSmall", class: errors.ClassBadInput, label: models.ErrLabelEntityTooSmall},
+		{status: http.StatusBadRequest, code: "InvalidTag", class: errors.ClassBadInput, label: models.ErrLabelInvalidTag},
+		{status: http.StatusBadRequest, code: "InvalidArgument", class: errors.ClassBadInput, label: models.ErrLabelInvalidArgument},
+		{status: http.StatusForbidden, code: "AccessDenied", class: errors.ClassInternal},
+		{status: http.StatusOK, code: "AccessDenied", class: errors.ClassInternal},
+		{status: http.StatusOK, code: "InternalError", class: errors.ClassBadGateway},
+	}
+	for _, test := range tests {
+		t.Run(test.code, func(t *testing.T) {
+			err := upstreamError(request{method: http.MethodGet}, test.status, s3Error{Code: test.code})
+			if !errors.IsClass(err, test.class) {
+				t.Fatalf("upstreamError(%v, %v) = %v, want class %v", test.status, test.code, err, test.class)
+			}
+			if test.label != "" && !errors.IsLabel(err, test.label) {
+				t.Fatalf("upstreamError(%v, %v) = %v, want label %v", test.status, test.code, err, test.label)
+			}
+		})
+	}
+}
+
+func TestReadErrorOfHead(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(bytes.NewReader(nil))}
+	if body := readError(resp); body.Code != "NotFound" {
+		t.Fatalf("readError() of a response without a body = %+v, want code NotFound", body)
+	}
+}
+
+func TestDoXMLErrorBody(t *testing.T) {
+	f, a := newFakeUpstream(t, nil)
+	f.put("tenants/t1/src", "content", time.Now())
+	// a copy fails after its success status was sent
+	f.intercept = func(w http.ResponseWriter, r *http.Request, body []byte) bool {
+		if r.Method != http.MethodPut {
+			return false
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`<Error><Code>InternalError</Code><Message>We encountered an internal error.</Message></Error>`))
+		return true
+	}
+	_, err := a.CopyFile(context.Background(), "tenants/t1/src", "tenants/t1/dst", false, models.CopyOptions{})
+	if !errors.IsClass(err, errors.ClassBadGateway) {
+		t.Fatalf("CopyFile() answered with an error body failed with %v, want a bad gateway error", err)
+	}
+	if f.object("tenants/t1/dst") != nil {
+		t.Fatal("CopyFile() answered with an error body stored the copy")
+	}
+	if f.count(http.MethodPut, "") != 1 {
+		t.Fatalf("CopyFile() sent %v copies, want 1", f.count(http.MethodPut, ""))
+	}
+}
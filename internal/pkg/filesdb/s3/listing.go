@@ -0,0 +1,311 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"openappsec.io/errors"
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/common"
+)
+
+const (
+	// maxUpstreamKeys is the number of keys the upstream lists in a single page
+	maxUpstreamKeys = 1000
+)
+
+type listedObject struct {
+	Key          string
+	LastModified time.Time
+	ETag         string
+	Size         int64
+}
+
+type listedPrefix struct {
+	Prefix string
+}
+
+// listBucketResult is the body of the response to a ListObjectsV2 request
+type listBucketResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []listedObject
+	CommonPrefixes        []listedPrefix
+}
+
+type listedVersion struct {
+	Key          string
+	VersionID    string `xml:"VersionId"`
+	IsLatest     bool
+	LastModified time.Time
+	ETag         string
+	Size         int64
+}
+
+// listVersionsResult is the body of the response to a ListObjectVersions request, delete markers aren't decoded
+type listVersionsResult struct {
+	XMLName             xml.Name `xml:"ListVersionsResult"`
+	IsTruncated         bool
+	NextKeyMarker       string
+	NextVersionIDMarker string          `xml:"NextVersionIdMarker"`
+	Versions            []listedVersion `xml:"Version"`
+}
+
+// listItem is a file or a common prefix listed by the upstream
+type listItem struct {
+	models.FileMetadata
+	isPrefix bool
+}
+
+// listPage lists a page of the upstream bucket, sorted by key with the common prefixes merged into the files
+func (a *Adapter) listPage(ctx context.Context, query url.Values) ([]listItem, bool, string, error) {
+	var result listBucketResult
+	if _, err := a.client.doXML(ctx, request{method: http.MethodGet, query: query}, &result); err != nil {
+		return nil, false, "", err
+	}
+	items := make([]listItem, 0, len(result.Contents)+len(result.CommonPrefixes))
+	objects, prefixes := result.Contents, result.CommonPrefixes
+	for len(objects) > 0 || len(prefixes) > 0 {
+		if len(prefixes) == 0 || (len(objects) > 0 && objects[0].Key < prefixes[0].Prefix) {
+			items = append(items, listItem{FileMetadata: models.FileMetadata{
+				Path:         strings.TrimPrefix(objects[0].Key, a.keyPrefix),
+				LastModified: objects[0].LastModified,
+				ETag:         objects[0].ETag,
+				Size:         objects[0].Size,
+			}})
+			objects = objects[1:]
+			continue
+		}
+		items = append(items, listItem{
+			FileMetadata: models.FileMetadata{Path: strings.TrimPrefix(prefixes[0].Prefix, a.keyPrefix)},
+			isPrefix:     true,
+		})
+		prefixes = prefixes[1:]
+	}
+	return items, result.IsTruncated, result.NextContinuationToken, nil
+}
+
+// GetFilesList return a page of the files matching the options, sorted by key, with their metadata.
+// when a delimiter is given, keys sharing a common prefix are rolled up into it, and each common prefix counts
+// as a single key of the page. filtering by tags gets the tags of every listed file from the upstream
+func (a *Adapter) GetFilesList(ctx context.Context, options models.ListOptions) (models.FilesList, error) {
+	log.WithContext(ctx).Infof("list files with options: %+v", options)
+	if _, err := models.KeySegments(options.Prefix, true); err != nil {
+		return models.FilesList{}, err
+	}
+	list := models.FilesList{Files: []models.FileMetadata{}, CommonPrefixes: []string{}}
+	full := func() bool {
+		return options.MaxKeys > 0 && len(list.Files)+len(list.CommonPrefixes) == options.MaxKeys
+	}
+	maxKeys := maxUpstreamKeys
+	if options.MaxKeys > 0 && options.MaxKeys < maxKeys {
+		// one more key tells whether the page is truncated
+		maxKeys = options.MaxKeys + 1
+	}
+	query := url.Values{
+		"list-type": {"2"},
+		"prefix":    {a.upstreamKey(options.Prefix)},
+		"max-keys":  {strconv.Itoa(maxKeys)},
+	}
+	if options.Delimiter != "" {
+		query.Set("delimiter", options.Delimiter)
+	}
+	if options.StartAfter != "" {
+		query.Set("start-after", a.upstreamKey(options.StartAfter))
+	}
+	for {
+		items, truncated, token, err := a.listPage(ctx, query)
+		if err != nil {
+			log.WithContext(ctx).Warnf("failed to list files. err: %v", err)
+			return models.FilesList{}, err
+		}
+		for _, item := range items {
+			// a common prefix the listing started after is listed again by the upstream
+			if item.Path <= options.StartAfter {
+				continue
+			}
+			if !item.isPrefix && len(options.Tags) > 0 {
+				if item.Tags, err = a.getTags(ctx, item.Path, ""); err != nil {
+					if errors.IsClass(err, errors.ClassNotFound) {
+						// removed since it was listed
+						continue
+					}
+					return models.FilesList{}, err
+				}
+				if !models.TagsMatch(item.Tags, options.Tags) {
+					continue
+				}
+			}
+			// checked once the file is known to match, so a page isn't reported truncated by files filtered out
+			if full() {
+				list.IsTruncated = true
+				return list, nil
+			}
+			if item.isPrefix {
+				list.CommonPrefixes = append(list.CommonPrefixes, item.Path)
+			} else {
+				list.Files = append(list.Files, item.FileMetadata)
+			}
+		}
+		if !truncated || token == "" {
+			return list, nil
+		}
+		query.Set("continuation-token", token)
+	}
+}
+
+// ListVersions return a page of the versions of the files matching the options, sorted by key and from the
+// newest version to the oldest. the upstream bucket keeps the versions when its versioning is enabled
+func (a *Adapter) ListVersions(ctx context.Context, options models.ListVersionsOptions) (models.VersionsList, error) {
+	log.WithContext(ctx).Debugf("list versions with options: %+v", options)
+	if _, err := models.KeySegments(options.Prefix, true); err != nil {
+		return models.VersionsList{}, err
+	}
+	list := models.VersionsList{Versions: []models.FileVersion{}}
+	query := url.Values{"versions": {""}, "prefix": {a.upstreamKey(options.Prefix)}}
+	if options.KeyMarker != "" {
+		query.Set("key-marker", a.upstreamKey(options.KeyMarker))
+		if options.VersionIDMarker != "" {
+			query.Set("version-id-marker", options.VersionIDMarker)
+		}
+	}
+	for {
+		maxKeys := maxUpstreamKeys
+		if options.MaxKeys > 0 && options.MaxKeys-len(list.Versions) < maxKeys {
+			maxKeys = options.MaxKeys - len(list.Versions)
+		}
+		query.Set("max-keys", strconv.Itoa(maxKeys))
+		var result listVersionsResult
+		if _, err := a.client.doXML(ctx, request{method: http.MethodGet, query: query}, &result); err != nil {
+			log.WithContext(ctx).Warnf("failed to list versions. err: %v", err)
+			return models.VersionsList{}, err
+		}
+		for _, v := range result.Versions {
+			versionID := v.VersionID
+			if versionID == nullVersionID {
+				versionID = ""
+			}
+			list.Versions = append(list.Versions, models.FileVersion{
+				FileMetadata: models.FileMetadata{
+					Path:         strings.TrimPrefix(v.Key, a.keyPrefix),
+					LastModified: v.LastModified,
+					Size:         v.Size,
+					ETag:         v.ETag,
+					VersionID:    versionID,
+				},
+				IsLatest: v.IsLatest,
+			})
+		}
+		if !result.IsTruncated || result.NextKeyMarker == "" {
+			return list, nil
+		}
+		// the versions left may all be delete markers, the page is reported truncated as the upstream reports it
+		if options.MaxKeys > 0 && len(list.Versions) >= options.MaxKeys {
+			list.IsTruncated = true
+			return list, nil
+		}
+		query.Set("key-marker", result.NextKeyMarker)
+		query.Set("version-id-marker", result.NextVersionIDMarker)
+	}
+}
+
+// sweeper removes expired temp files every interval until the adapter is torn down
+func (a *Adapter) sweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		a.sweep(context.Background(), time.Now())
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep removes the temp files and aborts the multipart uploads whose ttl passed. the upstream is swept again
+// on the next interval when it fails
+func (a *Adapter) sweep(ctx context.Context, now time.Time) {
+	query := url.Values{
+		"list-type": {"2"},
+		"prefix":    {a.upstreamKey(models.TenantsDir)},
+		"max-keys":  {strconv.Itoa(maxUpstreamKeys)},
+	}
+	for {
+		items, truncated, token, err := a.listPage(ctx, query)
+		if err != nil {
+			log.Warnf("failed to list files to sweep. err: %v", err)
+			return
+		}
+		for _, item := range items {
+			if !item.isPrefix && common.IsTempKey(item.Path) && a.expired(item.LastModified, now) {
+				if err := a.removeExpired(ctx, item.FileMetadata, now); err != nil {
+					log.Warnf("failed to remove expired file %v. err: %v", item.Path, err)
+				}
+			}
+		}
+		if !truncated || token == "" {
+			break
+		}
+		query.Set("continuation-token", token)
+	}
+	a.sweepUploads(ctx, now)
+}
+
+// expired is true when the ttl of a file modified at lastModified passed
+func (a *Adapter) expired(lastModified time.Time, now time.Time) bool {
+	return !lastModified.Add(a.ttl).After(now)
+}
+
+// removeExpired removes a listed temp file, unless it was written again since it was listed. the file is checked
+// again, and deleted only if it still has the entity tag it was checked with, so an upstream which supports
+// conditional deletes never removes a newer file
+func (a *Adapter) removeExpired(ctx context.Context, listed models.FileMetadata, now time.Time) error {
+	resp, err := a.client.do(ctx, request{method: http.MethodHead, key: a.upstreamKey(listed.Path)})
+	if err != nil {
+		if errors.IsClass(err, errors.ClassNotFound) {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	current := responseMetadata(listed.Path, resp)
+	if current.ETag != listed.ETag || !a.expired(current.LastModified, now) {
+		log.Debugf("file %v was written since it was listed, not removing it", listed.Path)
+		return nil
+	}
+	log.Debugf("ttl expired for file: %v", listed.Path)
+	resp, err = a.client.do(ctx, request{
+		method: http.MethodDelete,
+		key:    a.upstreamKey(listed.Path),
+		header: http.Header{"If-Match": {current.ETag}},
+	})
+	if err != nil {
+		if errors.IsClass(err, errors.ClassNotFound) || errors.IsLabel(err, models.ErrLabelPreconditionFailed) {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,248 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/common"
+)
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type listedPart struct {
+	PartNumber   int
+	LastModified time.Time
+	Size         int64
+	ETag         string
+}
+
+type listPartsResult struct {
+	XMLName              xml.Name `xml:"ListPartsResult"`
+	NextPartNumberMarker int
+	IsTruncated          bool
+	Parts                []listedPart `xml:"Part"`
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+	ETag    string
+}
+
+type listedUpload struct {
+	Key       string
+	UploadID  string `xml:"UploadId"`
+	Initiated time.Time
+}
+
+// listMultipartUploadsResult is the body of the response to a ListMultipartUploads request
+type listMultipartUploadsResult struct {
+	XMLName            xml.Name `xml:"ListMultipartUploadsResult"`
+	IsTruncated        bool
+	NextKeyMarker      string
+	NextUploadIDMarker string         `xml:"NextUploadIdMarker"`
+	Uploads            []listedUpload `xml:"Upload"`
+}
+
+// uploadQuery returns the query selecting a multipart upload
+func uploadQuery(uploadID string) url.Values {
+	return url.Values{"uploadId": {uploadID}}
+}
+
+// CreateMultipartUpload starts a multipart upload of a file, and returns its upload id
+func (a *Adapter) CreateMultipartUpload(ctx context.Context, path string, attributes models.ObjectAttributes) (string, error) {
+	log.WithContext(ctx).Debugf("create multipart upload: %v, attributes: %+v", path, attributes)
+	if err := common.ValidateKey(path); err != nil {
+		return "", err
+	}
+	var result initiateMultipartUploadResult
+	req := request{
+		method: http.MethodPost,
+		key:    a.upstreamKey(path),
+		query:  url.Values{"uploads": {""}},
+		header: attributesHeader(attributes),
+	}
+	if _, err := a.client.doXML(ctx, req, &result); err != nil {
+		log.WithContext(ctx).Warnf("failed to create multipart upload of file %v. err: %v", path, err)
+		return "", err
+	}
+	return result.UploadID, nil
+}
+
+// UploadPart stores a part of a multipart upload, replacing the part with the same number.
+// the content is verified against the digests it was sent with before it is sent
+func (a *Adapter) UploadPart(ctx context.Context, path string, uploadID string, partNumber int, content io.Reader, options models.PutOptions) (models.Part, error) {
+	log.WithContext(ctx).Debugf("upload part %v of multipart upload %v of file %v", partNumber, uploadID, path)
+	if err := common.ValidateKey(path); err != nil {
+		return models.Part{}, err
+	}
+	spooled, err := a.spool(content, options)
+	if err != nil {
+		log.WithContext(ctx).Warnf("failed to read part %v of multipart upload %v. err: %v", partNumber, uploadID, err)
+		return models.Part{}, err
+	}
+	defer spooled.Close()
+	query := uploadQuery(uploadID)
+	query.Set("partNumber", strconv.Itoa(partNumber))
+	resp, err := a.client.do(ctx, spooled.request(http.MethodPut, a.upstreamKey(path), query, nil))
+	if err != nil {
+		log.WithContext(ctx).Warnf("failed to upload part %v of multipart upload %v. err: %v", partNumber, uploadID, err)
+		return models.Part{}, err
+	}
+	resp.Body.Close()
+	return models.Part{
+		PartNumber:   partNumber,
+		LastModified: time.Now(),
+		Size:         spooled.size,
+		ETag:         resp.Header.Get("ETag"),
+	}, nil
+}
+
+// ListParts returns a page of the uploaded parts of a multipart upload, sorted by part number
+func (a *Adapter) ListParts(ctx context.Context, path string, uploadID string, options models.ListPartsOptions) (models.PartsList, error) {
+	log.WithContext(ctx).Debugf("list parts of multipart upload %v of file %v, options: %+v", uploadID, path, options)
+	if err := common.ValidateKey(path); err != nil {
+		return models.PartsList{}, err
+	}
+	list := models.PartsList{Parts: []models.Part{}}
+	query := uploadQuery(uploadID)
+	marker := options.PartNumberMarker
+	for {
+		if marker > 0 {
+			query.Set("part-number-marker", strconv.Itoa(marker))
+		}
+		if options.MaxParts > 0 {
+			query.Set("max-parts", strconv.Itoa(options.MaxParts-len(list.Parts)))
+		}
+		var result listPartsResult
+		req := request{method: http.MethodGet, key: a.upstreamKey(path), query: query}
+		if _, err := a.client.doXML(ctx, req, &result); err != nil {
+			log.WithContext(ctx).Warnf("failed to list parts of multipart upload %v. err: %v", uploadID, err)
+			return models.PartsList{}, err
+		}
+		for _, p := range result.Parts {
+			list.Parts = append(list.Parts, models.Part(p))
+		}
+		if !result.IsTruncated || result.NextPartNumberMarker <= marker {
+			return list, nil
+		}
+		if options.MaxParts > 0 && len(list.Parts) >= options.MaxParts {
+			list.IsTruncated = true
+			return list, nil
+		}
+		marker = result.NextPartNumberMarker
+	}
+}
+
+// CompleteMultipartUpload assembles the file from the listed parts, if the preconditions of the write are met.
+// the upstream doesn't expire the file, the sweeper removes temp files once their ttl passes
+func (a *Adapter) CompleteMultipartUpload(ctx context.Context, path string, uploadID string, parts []models.CompletedPart, isTemp bool, options models.PutOptions) (models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("complete multipart upload %v of file %v, parts: %+v", uploadID, path, parts)
+	if err := common.ValidateKey(path); err != nil {
+		return models.FileMetadata{}, err
+	}
+	body := completeMultipartUpload{Parts: make([]completedPart, len(parts))}
+	for i, p := range parts {
+		body.Parts[i] = completedPart(p)
+	}
+	data, err := xml.Marshal(body)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	header := http.Header{}
+	conditionHeader(header, options)
+	sha256Sum := sha256.Sum256(data)
+	req := request{
+		method:      http.MethodPost,
+		key:         a.upstreamKey(path),
+		query:       uploadQuery(uploadID),
+		header:      header,
+		body:        bytes.NewReader(data),
+		size:        int64(len(data)),
+		payloadHash: hex.EncodeToString(sha256Sum[:]),
+	}
+	var result completeMultipartUploadResult
+	respHeader, err := a.client.doXML(ctx, req, &result)
+	if err != nil {
+		log.WithContext(ctx).Warnf("failed to complete multipart upload %v of file %v. err: %v", uploadID, path, err)
+		return models.FileMetadata{}, err
+	}
+	return a.headObject(ctx, path, responseVersionID(respHeader))
+}
+
+// AbortMultipartUpload discards a multipart upload and its parts
+func (a *Adapter) AbortMultipartUpload(ctx context.Context, path string, uploadID string) error {
+	log.WithContext(ctx).Debugf("abort multipart upload %v of file %v", uploadID, path)
+	if err := common.ValidateKey(path); err != nil {
+		return err
+	}
+	resp, err := a.client.do(ctx, request{method: http.MethodDelete, key: a.upstreamKey(path), query: uploadQuery(uploadID)})
+	if err != nil {
+		log.WithContext(ctx).Warnf("failed to abort multipart upload %v of file %v. err: %v", uploadID, path, err)
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// sweepUploads aborts the multipart uploads started before their ttl passed
+func (a *Adapter) sweepUploads(ctx context.Context, now time.Time) {
+	query := url.Values{"uploads": {""}, "prefix": {a.upstreamKey(models.TenantsDir)}}
+	for {
+		var result listMultipartUploadsResult
+		if _, err := a.client.doXML(ctx, request{method: http.MethodGet, query: query}, &result); err != nil {
+			log.Warnf("failed to list multipart uploads to sweep. err: %v", err)
+			return
+		}
+		for _, u := range result.Uploads {
+			if u.Initiated.Add(a.ttl).After(now) {
+				continue
+			}
+			log.Debugf("ttl expired for multipart upload: %v", u.UploadID)
+			if err := a.AbortMultipartUpload(ctx, strings.TrimPrefix(u.Key, a.keyPrefix), u.UploadID); err != nil {
+				log.Warnf("failed to abort expired multipart upload %v. err: %v", u.UploadID, err)
+			}
+		}
+		if !result.IsTruncated || result.NextKeyMarker == "" {
+			return
+		}
+		query.Set("key-marker", result.NextKeyMarker)
+		query.Set("upload-id-marker", result.NextUploadIDMarker)
+	}
+}
@@ -0,0 +1,637 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"openappsec.io/errors"
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/common"
+)
+
+const (
+	fsBaseConfig = "filesystem_db"
+	fsConfigTTL  = fsBaseConfig + ".ttl"
+	// the upstream bucket and the credentials the requests to it are signed with
+	s3BaseConfig            = fsBaseConfig + ".s3"
+	s3ConfigEndpoint        = s3BaseConfig + ".endpoint"
+	s3ConfigBucket          = s3BaseConfig + ".bucket"
+	s3ConfigRegion          = s3BaseConfig + ".region"
+	s3ConfigAccessKeyID     = s3BaseConfig + ".access_key_id"
+	s3ConfigSecretAccessKey = s3BaseConfig + ".secret_access_key"
+	s3ConfigPathStyle       = s3BaseConfig + ".path_style"
+	// keys are stored under the key prefix, so a bucket may be shared with other applications
+	s3ConfigKeyPrefix    = s3BaseConfig + ".key_prefix"
+	s3ConfigTimeout      = s3BaseConfig + ".timeout"
+	s3ConfigMaxRetries   = s3BaseConfig + ".max_retries"
+	s3ConfigRetryBackoff = s3BaseConfig + ".retry_backoff"
+	// uploaded contents are spooled to the spool dir, so they are hashed before they are sent
+	s3ConfigSpoolDir = s3BaseConfig + ".spool_dir"
+	// sweeping lists the whole bucket, it may be left to a single replica or to the lifecycle rules of the bucket
+	s3ConfigSweepEnabled  = s3BaseConfig + ".sweep_enabled"
+	s3ConfigSweepInterval = s3BaseConfig + ".sweep_interval"
+
+	defaultSweepInterval = time.Hour
+	defaultRegion        = "us-east-1"
+	defaultTimeout       = 30 * time.Second
+	defaultMaxRetries    = 3
+	defaultRetryBackoff  = 100 * time.Millisecond
+
+	metadataHeaderPrefix = "X-Amz-Meta-"
+	nullVersionID        = "null"
+	// maxDeleteBatch is the number of keys the upstream deletes in a single batch delete request
+	maxDeleteBatch = 1000
+
+	healthCheckName = "s3"
+)
+
+// Adapter stores the files in a bucket of an S3 compatible object store. the upstream keeps the contents and
+// their versions, the adapter expires the temp files and the stale multipart uploads
+type Adapter struct {
+	client    *client
+	keyPrefix string
+	spoolDir  string
+	ttl       time.Duration
+	done      chan struct{}
+}
+
+// Configuration service interface for fetching config
+type Configuration interface {
+	GetString(key string) (string, error)
+	GetDuration(key string) (time.Duration, error)
+	GetBool(key string) (bool, error)
+	GetInt(key string) (int, error)
+}
+
+// requiredString gets a string from the configuration, which must not be empty
+func requiredString(conf Configuration, key string) (string, error) {
+	value, err := conf.GetString(key)
+	if err != nil {
+		return "", err
+	}
+	if value == "" {
+		return "", errors.Errorf("missing %v", key).SetClass(errors.ClassBadInput)
+	}
+	return value, nil
+}
+
+// optionalString gets a string from the configuration, defaultValue when it is missing or empty
+func optionalString(conf Configuration, key string, defaultValue string) (string, error) {
+	value, err := conf.GetString(key)
+	if err != nil && !errors.IsClass(err, errors.ClassNotFound) {
+		return "", err
+	}
+	if value == "" {
+		return defaultValue, nil
+	}
+	return value, nil
+}
+
+// NewAdapter creates new adapter
+func NewAdapter(conf Configuration) (*Adapter, error) {
+	ttl, err := common.PositiveDuration(conf, fsConfigTTL, 0)
+	if err != nil {
+		return &Adapter{}, err
+	}
+	sweepEnabled, err := conf.GetBool(s3ConfigSweepEnabled)
+	if err != nil {
+		if !errors.IsClass(err, errors.ClassNotFound) {
+			return &Adapter{}, err
+		}
+		sweepEnabled = true
+	}
+	sweepInterval, err := common.PositiveDuration(conf, s3ConfigSweepInterval, defaultSweepInterval)
+	if err != nil {
+		return &Adapter{}, err
+	}
+	c, err := newClientFromConfiguration(conf)
+	if err != nil {
+		return &Adapter{}, err
+	}
+	keyPrefix, err := optionalString(conf, s3ConfigKeyPrefix, "")
+	if err != nil {
+		return &Adapter{}, err
+	}
+	if keyPrefix != "" {
+		if _, err := models.KeySegments(keyPrefix, true); err != nil {
+			return &Adapter{}, errors.Wrapf(err, "invalid %v", s3ConfigKeyPrefix).SetClass(errors.ClassBadInput)
+		}
+		keyPrefix = strings.TrimSuffix(keyPrefix, "/") + "/"
+	}
+	spoolDir, err := optionalString(conf, s3ConfigSpoolDir, os.TempDir())
+	if err != nil {
+		return &Adapter{}, err
+	}
+	a := &Adapter{
+		client:    c,
+		keyPrefix: keyPrefix,
+		spoolDir:  spoolDir,
+		ttl:       ttl,
+		done:      make(chan struct{}),
+	}
+	if sweepEnabled {
+		go a.sweeper(sweepInterval)
+	}
+	return a, nil
+}
+
+// newClientFromConfiguration creates the client of the upstream bucket
+func newClientFromConfiguration(conf Configuration) (*client, error) {
+	endpoint, err := requiredString(conf, s3ConfigEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || (endpointURL.Scheme != "http" && endpointURL.Scheme != "https") || endpointURL.Host == "" {
+		return nil, errors.Errorf("invalid %v %q, must be an http or https url", s3ConfigEndpoint, endpoint).
+			SetClass(errors.ClassBadInput)
+	}
+	bucket, err := requiredString(conf, s3ConfigBucket)
+	if err != nil {
+		return nil, err
+	}
+	region, err := optionalString(conf, s3ConfigRegion, defaultRegion)
+	if err != nil {
+		return nil, err
+	}
+	accessKeyID, err := requiredString(conf, s3ConfigAccessKeyID)
+	if err != nil {
+		return nil, err
+	}
+	secretAccessKey, err := requiredString(conf, s3ConfigSecretAccessKey)
+	if err != nil {
+		return nil, err
+	}
+	pathStyle, err := conf.GetBool(s3ConfigPathStyle)
+	if err != nil {
+		if !errors.IsClass(err, errors.ClassNotFound) {
+			return nil, err
+		}
+		pathStyle = true
+	}
+	timeout, err := common.PositiveDuration(conf, s3ConfigTimeout, defaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+	maxRetries, err := conf.GetInt(s3ConfigMaxRetries)
+	if err != nil {
+		if !errors.IsClass(err, errors.ClassNotFound) {
+			return nil, err
+		}
+		maxRetries = defaultMaxRetries
+	}
+	if maxRetries < 0 {
+		return nil, errors.Errorf("invalid %v %v, must not be negative", s3ConfigMaxRetries, maxRetries).
+			SetClass(errors.ClassBadInput)
+	}
+	retryBackoff, err := common.PositiveDuration(conf, s3ConfigRetryBackoff, defaultRetryBackoff)
+	if err != nil {
+		return nil, err
+	}
+	return &client{
+		http:            newClient(endpointURL, timeout),
+		endpoint:        endpointURL,
+		bucket:          bucket,
+		pathStyle:       pathStyle,
+		region:          region,
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		timeout:         timeout,
+		maxRetries:      maxRetries,
+		retryBackoff:    retryBackoff,
+	}, nil
+}
+
+// HealthCheck checks that the upstream bucket can be listed
+func (a *Adapter) HealthCheck(ctx context.Context) (string, error) {
+	query := url.Values{"list-type": {"2"}, "max-keys": {"1"}, "prefix": {a.keyPrefix}}
+	if _, err := a.client.doXML(ctx, request{method: http.MethodGet, query: query}, nil); err != nil {
+		return healthCheckName, errors.Wrap(err, "upstream bucket is unavailable")
+	}
+	return healthCheckName, nil
+}
+
+// TearDown stops the expiry sweeper and closes the idle connections to the upstream
+func (a *Adapter) TearDown(ctx context.Context) error {
+	close(a.done)
+	a.client.http.CloseIdleConnections()
+	return nil
+}
+
+// upstreamKey returns the key a storage key is stored under in the upstream bucket
+func (a *Adapter) upstreamKey(key string) string {
+	return a.keyPrefix + key
+}
+
+// versionQuery returns the query selecting a version of an object, or none when versionID is empty
+func versionQuery(versionID string) url.Values {
+	query := url.Values{}
+	if versionID != "" {
+		query.Set("versionId", versionID)
+	}
+	return query
+}
+
+// attributesHeader returns the headers an object is stored with to keep its attributes
+func attributesHeader(attributes models.ObjectAttributes) http.Header {
+	header := http.Header{}
+	if attributes.ContentType != "" {
+		header.Set("Content-Type", attributes.ContentType)
+	}
+	if attributes.ContentEncoding != "" {
+		header.Set("Content-Encoding", attributes.ContentEncoding)
+	}
+	if attributes.CacheControl != "" {
+		header.Set("Cache-Control", attributes.CacheControl)
+	}
+	for name, value := range attributes.UserMetadata {
+		header.Set(metadataHeaderPrefix+name, value)
+	}
+	return header
+}
+
+// conditionHeader adds the preconditions of a write to its headers
+func conditionHeader(header http.Header, options models.PutOptions) {
+	if options.IfMatch != "" {
+		header.Set("If-Match", options.IfMatch)
+	}
+	if options.IfNoneMatch {
+		header.Set("If-None-Match", "*")
+	}
+}
+
+// responseVersionID returns the version id of the object a response is about, empty when it isn't versioned
+func responseVersionID(header http.Header) string {
+	if versionID := header.Get("X-Amz-Version-Id"); versionID != nullVersionID {
+		return versionID
+	}
+	return ""
+}
+
+// responseMetadata returns the metadata of the object a response to a GET or a HEAD request is about
+func responseMetadata(key string, resp *http.Response) models.FileMetadata {
+	metadata := models.FileMetadata{
+		Path:           key,
+		Size:           resp.ContentLength,
+		ETag:           resp.Header.Get("ETag"),
+		ChecksumSHA256: resp.Header.Get("X-Amz-Checksum-Sha256"),
+		VersionID:      responseVersionID(resp.Header),
+		Attributes: models.ObjectAttributes{
+			ContentType:     resp.Header.Get("Content-Type"),
+			ContentEncoding: resp.Header.Get("Content-Encoding"),
+			CacheControl:    resp.Header.Get("Cache-Control"),
+		},
+	}
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		metadata.LastModified = lastModified
+	}
+	// the size of a range is the size of the part of the content, the content range holds the size of the file
+	if contentRange := resp.Header.Get("Content-Range"); contentRange != "" {
+		if i := strings.LastIndex(contentRange, "/"); i >= 0 {
+			if size, err := strconv.ParseInt(contentRange[i+1:], 10, 64); err == nil {
+				metadata.Size = size
+			}
+		}
+	}
+	for name, values := range resp.Header {
+		if strings.HasPrefix(name, metadataHeaderPrefix) && len(values) > 0 {
+			if metadata.Attributes.UserMetadata == nil {
+				metadata.Attributes.UserMetadata = make(map[string]string)
+			}
+			metadata.Attributes.UserMetadata[strings.ToLower(name[len(metadataHeaderPrefix):])] = values[0]
+		}
+	}
+	return metadata
+}
+
+// rangeHeader returns the value of the Range header requesting a range of bytes
+func rangeHeader(r models.ByteRange) string {
+	if r.SuffixLength > 0 {
+		return fmt.Sprintf("bytes=-%d", r.SuffixLength)
+	}
+	if r.End < 0 {
+		return fmt.Sprintf("bytes=%d-", r.Start)
+	}
+	return fmt.Sprintf("bytes=%d-%d", r.Start, r.End)
+}
+
+// GetFile return a reader streaming the file content and the file metadata, the caller must close the reader
+func (a *Adapter) GetFile(ctx context.Context, path string, options models.GetOptions) (io.ReadCloser, models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("get file: %v, options: %+v", path, options)
+	if err := common.ValidateKey(path); err != nil {
+		return nil, models.FileMetadata{}, err
+	}
+	header := http.Header{"X-Amz-Checksum-Mode": {"ENABLED"}}
+	if options.Range != nil {
+		header.Set("Range", rangeHeader(*options.Range))
+	}
+	resp, err := a.client.do(ctx, request{
+		method: http.MethodGet,
+		key:    a.upstreamKey(path),
+		query:  versionQuery(options.VersionID),
+		header: header,
+		stream: true,
+	})
+	if err != nil {
+		log.WithContext(ctx).Warnf("failed to get file %v. err: %v", path, err)
+		return nil, models.FileMetadata{}, err
+	}
+	metadata := responseMetadata(path, resp)
+	if resp.Header.Get("X-Amz-Tagging-Count") != "" {
+		if metadata.Tags, err = a.getTags(ctx, path, metadata.VersionID); err != nil {
+			resp.Body.Close()
+			return nil, models.FileMetadata{}, err
+		}
+	}
+	return resp.Body, metadata, nil
+}
+
+// StatFile return file metadata, or the metadata of one of its versions when versionID is set
+func (a *Adapter) StatFile(ctx context.Context, path string, versionID string) (models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("stat file: %v, version: %v", path, versionID)
+	if err := common.ValidateKey(path); err != nil {
+		return models.FileMetadata{}, err
+	}
+	return a.headObject(ctx, path, versionID)
+}
+
+// headObject returns the metadata of an object, tags included
+func (a *Adapter) headObject(ctx context.Context, path string, versionID string) (models.FileMetadata, error) {
+	resp, err := a.client.do(ctx, request{
+		method: http.MethodHead,
+		key:    a.upstreamKey(path),
+		query:  versionQuery(versionID),
+		header: http.Header{"X-Amz-Checksum-Mode": {"ENABLED"}},
+	})
+	if err != nil {
+		log.WithContext(ctx).Debugf("failed to stat file %v. err: %v", path, err)
+		return models.FileMetadata{}, err
+	}
+	resp.Body.Close()
+	metadata := responseMetadata(path, resp)
+	if metadata.Tags, err = a.getTags(ctx, path, metadata.VersionID); err != nil {
+		return models.FileMetadata{}, err
+	}
+	return metadata, nil
+}
+
+// spooledContent is a content spooled to a temp file, so its digests are known before it is sent, and it can be
+// sent again when a request is retried
+type spooledContent struct {
+	file   *os.File
+	size   int64
+	md5    []byte
+	sha256 []byte
+}
+
+// spool copies content to a temp file while hashing it, and verifies it against the digests it was sent with.
+// the caller must close the spooled content
+func (a *Adapter) spool(content io.Reader, options models.PutOptions) (*spooledContent, error) {
+	file, err := os.CreateTemp(a.spoolDir, "spool-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create spool file")
+	}
+	// the spool file is only reachable through its descriptor
+	_ = os.Remove(file.Name())
+	h := common.NewContentHasher()
+	if _, err := io.Copy(io.MultiWriter(file, h), content); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := h.Verify(options); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &spooledContent{file: file, size: h.Size(), md5: h.MD5(), sha256: h.SHA256()}, nil
+}
+
+// request returns a request sending the spooled content, with its digests
+func (s *spooledContent) request(method string, key string, query url.Values, header http.Header) request {
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Content-MD5", base64.StdEncoding.EncodeToString(s.md5))
+	return request{
+		method:      method,
+		key:         key,
+		query:       query,
+		header:      header,
+		body:        s.file,
+		size:        s.size,
+		payloadHash: hex.EncodeToString(s.sha256),
+	}
+}
+
+// Close removes the spooled content
+func (s *spooledContent) Close() error {
+	return s.file.Close()
+}
+
+// PutFile stores a file streamed from content. the upstream doesn't expire the file, the sweeper removes temp
+// files once their ttl passes. the content is verified against the digests it was sent with before it is sent
+func (a *Adapter) PutFile(ctx context.Context, path string, content io.Reader, isTemp bool, options models.PutOptions) (models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("put file: %v, options: %+v", path, options)
+	if err := common.ValidateKey(path); err != nil {
+		return models.FileMetadata{}, err
+	}
+	spooled, err := a.spool(content, options)
+	if err != nil {
+		log.WithContext(ctx).Warnf("failed to read content of file %v. err: %v", path, err)
+		return models.FileMetadata{}, err
+	}
+	defer spooled.Close()
+	header := attributesHeader(options.Attributes)
+	conditionHeader(header, options)
+	header.Set("X-Amz-Checksum-Sha256", base64.StdEncoding.EncodeToString(spooled.sha256))
+	resp, err := a.client.do(ctx, spooled.request(http.MethodPut, a.upstreamKey(path), nil, header))
+	if err != nil {
+		log.WithContext(ctx).Warnf("failed to put file %v. err: %v", path, err)
+		return models.FileMetadata{}, err
+	}
+	resp.Body.Close()
+	return models.FileMetadata{
+		Path:           path,
+		LastModified:   time.Now(),
+		Size:           spooled.size,
+		ETag:           resp.Header.Get("ETag"),
+		ChecksumSHA256: base64.StdEncoding.EncodeToString(spooled.sha256),
+		Attributes:     options.Attributes,
+		VersionID:      responseVersionID(resp.Header),
+	}, nil
+}
+
+// copyObjectResult is the body of the response to a copy
+type copyObjectResult struct {
+	ETag         string
+	LastModified time.Time
+}
+
+// CopyFile copies the file stored under srcPath to dstPath within the upstream bucket.
+// unless its metadata is replaced, the copy keeps the metadata of the source
+func (a *Adapter) CopyFile(ctx context.Context, srcPath string, dstPath string, isTemp bool, options models.CopyOptions) (models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("copy file: %v to %v, options: %+v", srcPath, dstPath, options)
+	if err := common.ValidateKey(srcPath); err != nil {
+		return models.FileMetadata{}, err
+	}
+	if err := common.ValidateKey(dstPath); err != nil {
+		return models.FileMetadata{}, err
+	}
+	header := http.Header{}
+	if options.ReplaceMetadata {
+		header = attributesHeader(options.Attributes)
+		header.Set("X-Amz-Metadata-Directive", "REPLACE")
+	}
+	source := url.URL{Path: "/" + a.client.bucket + "/" + a.upstreamKey(srcPath)}
+	copySource := source.EscapedPath()
+	if options.SourceVersionID != "" {
+		copySource += "?versionId=" + url.QueryEscape(options.SourceVersionID)
+	}
+	header.Set("X-Amz-Copy-Source", copySource)
+	req := request{method: http.MethodPut, key: a.upstreamKey(dstPath), header: header}
+	if _, err := a.client.doXML(ctx, req, &copyObjectResult{}); err != nil {
+		log.WithContext(ctx).Warnf("failed to copy file %v to %v. err: %v", srcPath, dstPath, err)
+		return models.FileMetadata{}, err
+	}
+	return a.headObject(ctx, dstPath, "")
+}
+
+// DeleteFile removes a file, or one of its versions when versionID is set. deleting a file which doesn't exist
+// succeeds
+func (a *Adapter) DeleteFile(ctx context.Context, path string, versionID string) error {
+	log.WithContext(ctx).Debugf("delete file: %v, version: %v", path, versionID)
+	if err := common.ValidateKey(path); err != nil {
+		return err
+	}
+	resp, err := a.client.do(ctx, request{method: http.MethodDelete, key: a.upstreamKey(path), query: versionQuery(versionID)})
+	if err != nil {
+		if errors.IsClass(err, errors.ClassNotFound) {
+			return nil
+		}
+		log.WithContext(ctx).Warnf("failed to delete file %v. err: %v", path, err)
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// deleteObjects is the body of a batch delete request
+type deleteObjects struct {
+	XMLName xml.Name         `xml:"Delete"`
+	Quiet   bool             `xml:"Quiet"`
+	Objects []objectToDelete `xml:"Object"`
+}
+
+type objectToDelete struct {
+	Key string `xml:"Key"`
+}
+
+// deleteResult is the body of the response to a batch delete request, only the failures are listed in quiet mode
+type deleteResult struct {
+	Errors []struct {
+		Key     string
+		Code    string
+		Message string
+	} `xml:"Error"`
+}
+
+// DeleteFiles removes a batch of files, the upstream is sent a batch delete request per maxDeleteBatch files.
+// each path gets its own result, a failure doesn't stop the removal of the other files
+func (a *Adapter) DeleteFiles(ctx context.Context, paths []string) []models.DeleteResult {
+	log.WithContext(ctx).Debugf("delete files: %v", paths)
+	results := make([]models.DeleteResult, len(paths))
+	// indexes maps the upstream keys of the valid paths to their results
+	indexes := make(map[string][]int)
+	var keys []string
+	for i, path := range paths {
+		results[i].Path = path
+		if err := common.ValidateKey(path); err != nil {
+			results[i].Err = err
+			continue
+		}
+		key := a.upstreamKey(path)
+		if _, ok := indexes[key]; !ok {
+			keys = append(keys, key)
+		}
+		indexes[key] = append(indexes[key], i)
+	}
+	for start := 0; start < len(keys); start += maxDeleteBatch {
+		end := start + maxDeleteBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		failures, err := a.deleteBatch(ctx, keys[start:end])
+		for _, key := range keys[start:end] {
+			keyErr := err
+			if keyErr == nil {
+				keyErr = failures[key]
+			}
+			for _, i := range indexes[key] {
+				results[i].Err = keyErr
+			}
+		}
+	}
+	return results
+}
+
+// deleteBatch sends a batch delete request, and returns the errors of the keys which weren't deleted
+func (a *Adapter) deleteBatch(ctx context.Context, keys []string) (map[string]error, error) {
+	body := deleteObjects{Quiet: true, Objects: make([]objectToDelete, len(keys))}
+	for i, key := range keys {
+		body.Objects[i].Key = key
+	}
+	data, err := xml.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	md5Sum, sha256Sum := md5.Sum(data), sha256.Sum256(data)
+	req := request{
+		method:      http.MethodPost,
+		query:       url.Values{"delete": {""}},
+		header:      http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(md5Sum[:])}},
+		body:        bytes.NewReader(data),
+		size:        int64(len(data)),
+		payloadHash: hex.EncodeToString(sha256Sum[:]),
+	}
+	var result deleteResult
+	resp, err := a.client.do(ctx, req)
+	if err != nil {
+		log.WithContext(ctx).Warnf("failed to delete files. err: %v", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, errors.Wrap(err, "invalid upstream response to batch delete").SetClass(errors.ClassBadGateway)
+	}
+	failures := make(map[string]error)
+	for _, failure := range result.Errors {
+		failures[failure.Key] = upstreamError(req, http.StatusOK, s3Error{Code: failure.Code, Message: failure.Message})
+	}
+	return failures, nil
+}
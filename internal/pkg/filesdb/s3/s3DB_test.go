@@ -0,0 +1,377 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"openappsec.io/errors"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/testutil"
+)
+
+func TestListPage(t *testing.T) {
+	f, a := newFakeUpstream(t, testutil.Configuration{s3ConfigKeyPrefix: "app"})
+	for _, key := range []string{"tenants/t1/a/1", "tenants/t1/a/2", "tenants/t1/b", "tenants/t1/c/1"} {
+		f.put("app/"+key, key, time.Now())
+	}
+	query := url.Values{"list-type": {"2"}, "prefix": {"app/tenants/t1/"}, "delimiter": {"/"}}
+	items, truncated, _, err := a.listPage(context.Background(), query)
+	if err != nil {
+		t.Fatalf("listPage() failed: %v", err)
+	}
+	var listed []string
+	for _, item := range items {
+		listed = append(listed, item.Path+":"+strconv.FormatBool(item.isPrefix))
+	}
+	// the common prefixes are merged into the files by key, without the key prefix
+	want := []string{"tenants/t1/a/:true", "tenants/t1/b:false", "tenants/t1/c/:true"}
+	if !reflect.DeepEqual(listed, want) || truncated {
+		t.Fatalf("listPage() = %v, truncated %v, want %v", listed, truncated, want)
+	}
+	if items[1].ETag != f.object("app/tenants/t1/b").etag || items[1].Size != int64(len("tenants/t1/b")) {
+		t.Fatalf("listPage() listed %+v", items[1].FileMetadata)
+	}
+}
+
+func TestGetFilesListPaging(t *testing.T) {
+	f, a := newFakeUpstream(t, nil)
+	// the upstream lists 2 keys a page, so a page of the listing spans several upstream pages
+	f.maxKeys = 2
+	for _, key := range []string{"a/1", "a/2", "a/3", "b", "c/1", "c/2", "d", "e"} {
+		f.put("tenants/t1/"+key, key, time.Now())
+	}
+	f.put("tenants/t2/a", "another tenant", time.Now())
+	tests := []struct {
+		name      string
+		options   models.ListOptions
+		want      []string
+		truncated bool
+	}{
+		{
+			name:    "all",
+			options: models.ListOptions{Prefix: "tenants/t1/"},
+			want:    []string{"a/1", "a/2", "a/3", "b", "c/1", "c/2", "d", "e"},
+		},
+		{
+			name:    "delimiter",
+			options: models.ListOptions{Prefix: "tenants/t1/", Delimiter: "/"},
+			want:    []string{"a/", "b", "c/", "d", "e"},
+		},
+		{
+			name:      "first page",
+			options:   models.ListOptions{Prefix: "tenants/t1/", Delimiter: "/", MaxKeys: 3},
+			want:      []string{"a/", "b", "c/"},
+			truncated: true,
+		},
+		{
+			name:    "next page",
+			options: models.ListOptions{Prefix: "tenants/t1/", Delimiter: "/", MaxKeys: 3, StartAfter: "tenants/t1/c/"},
+			want:    []string{"d", "e"},
+		},
+		{
+			name:      "start after a key",
+			options:   models.ListOptions{Prefix: "tenants/t1/", MaxKeys: 4, StartAfter: "tenants/t1/a/2"},
+			want:      []string{"a/3", "b", "c/1", "c/2"},
+			truncated: true,
+		},
+		{
+			name:      "exactly a page",
+			options:   models.ListOptions{Prefix: "tenants/t1/a/", MaxKeys: 3},
+			want:      []string{"a/1", "a/2", "a/3"},
+			truncated: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			list, err := a.GetFilesList(context.Background(), test.options)
+			if err != nil {
+				t.Fatalf("GetFilesList() failed: %v", err)
+			}
+			listed := []string{}
+			for _, file := range list.Files {
+				listed = append(listed, file.Path)
+			}
+			listed = append(listed, list.CommonPrefixes...)
+			for i := range listed {
+				listed[i] = strings.TrimPrefix(listed[i], "tenants/t1/")
+			}
+			sort.Strings(listed)
+			if !reflect.DeepEqual(listed, test.want) || list.IsTruncated != test.truncated {
+				t.Fatalf("GetFilesList(%+v) = %v, truncated %v, want %v, truncated %v", test.options, listed,
+					list.IsTruncated, test.want, test.truncated)
+			}
+		})
+	}
+}
+
+func TestGetFileNotFound(t *testing.T) {
+	_, a := newFakeUpstream(t, nil)
+	if _, _, err := a.GetFile(context.Background(), "tenants/t1/missing", models.GetOptions{}); !errors.IsClass(err, errors.ClassNotFound) {
+		t.Fatalf("GetFile() of a missing file failed with %v, want not found", err)
+	}
+	// the response to a HEAD request has no error body
+	if _, err := a.StatFile(context.Background(), "tenants/t1/missing", ""); !errors.IsClass(err, errors.ClassNotFound) {
+		t.Fatalf("StatFile() of a missing file failed with %v, want not found", err)
+	}
+}
+
+func TestPutFile(t *testing.T) {
+	f, a := newFakeUpstream(t, nil)
+	ctx := context.Background()
+	content := "the content of the file"
+	sha256Sum := sha256.Sum256([]byte(content))
+	md5Sum := md5.Sum([]byte(content))
+	attributes := models.ObjectAttributes{ContentType: "text/plain", UserMetadata: map[string]string{"owner": "agent"}}
+	metadata, err := a.PutFile(ctx, "tenants/t1/file", strings.NewReader(content), false, models.PutOptions{
+		ContentMD5: md5Sum[:],
+		Attributes: attributes,
+	})
+	if err != nil {
+		t.Fatalf("PutFile() failed: %v", err)
+	}
+	object := f.object("tenants/t1/file")
+	if object == nil || string(object.content) != content {
+		t.Fatalf("the upstream stored %+v, want %q", object, content)
+	}
+	if metadata.ETag != object.etag || metadata.Size != int64(len(content)) ||
+		metadata.ChecksumSHA256 != base64.StdEncoding.EncodeToString(sha256Sum[:]) {
+		t.Fatalf("PutFile() = %+v", metadata)
+	}
+	r, stored, err := a.GetFile(ctx, "tenants/t1/file", models.GetOptions{Range: &models.ByteRange{Start: 4, End: 10}})
+	if err != nil {
+		t.Fatalf("GetFile() failed: %v", err)
+	}
+	defer r.Close()
+	part, _ := io.ReadAll(r)
+	if string(part) != content[4:11] || stored.Size != int64(len(content)) || stored.ChecksumSHA256 != metadata.ChecksumSHA256 ||
+		!reflect.DeepEqual(stored.Attributes, attributes) {
+		t.Fatalf("GetFile() of a range = %q, %+v", part, stored)
+	}
+
+	// a content which doesn't match its digest is never sent
+	puts := f.count(http.MethodPut, "")
+	_, err = a.PutFile(ctx, "tenants/t1/file", strings.NewReader("other content"), false, models.PutOptions{
+		ContentMD5: md5Sum[:],
+	})
+	if !errors.IsLabel(err, models.ErrLabelBadDigest) || f.count(http.MethodPut, "") != puts {
+		t.Fatalf("PutFile() of a content not matching its digest failed with %v", err)
+	}
+	_, err = a.PutFile(ctx, "tenants/t1/file", strings.NewReader("v2"), false, models.PutOptions{IfNoneMatch: true})
+	if !errors.IsLabel(err, models.ErrLabelPreconditionFailed) {
+		t.Fatalf("PutFile() if none matching an existing file failed with %v, want a failed precondition", err)
+	}
+	if _, err := a.PutFile(ctx, "tenants/t1/file", strings.NewReader("v2"), false, models.PutOptions{IfMatch: metadata.ETag}); err != nil {
+		t.Fatalf("PutFile() if matching the current entity tag failed: %v", err)
+	}
+
+	// the spooled contents are removed
+	entries, err := os.ReadDir(a.spoolDir)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("spool dir holds %v, %v, want no files", entries, err)
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	f, a := newFakeUpstream(t, nil)
+	ctx := context.Background()
+	attributes := models.ObjectAttributes{ContentType: "text/plain"}
+	source, err := a.PutFile(ctx, "tenants/t1/src file", strings.NewReader("content"), false, models.PutOptions{Attributes: attributes})
+	if err != nil {
+		t.Fatalf("PutFile() failed: %v", err)
+	}
+	copied, err := a.CopyFile(ctx, "tenants/t1/src file", "tenants/t1/dst", false, models.CopyOptions{})
+	if err != nil {
+		t.Fatalf("CopyFile() failed: %v", err)
+	}
+	if copied.Path != "tenants/t1/dst" || copied.ETag != source.ETag || copied.ChecksumSHA256 != source.ChecksumSHA256 ||
+		!reflect.DeepEqual(copied.Attributes, attributes) {
+		t.Fatalf("CopyFile() = %+v, want the metadata of %+v", copied, source)
+	}
+	replaced := models.ObjectAttributes{ContentType: "application/json", UserMetadata: map[string]string{"owner": "agent"}}
+	copied, err = a.CopyFile(ctx, "tenants/t1/src file", "tenants/t1/dst", false, models.CopyOptions{
+		ReplaceMetadata: true,
+		Attributes:      replaced,
+	})
+	if err != nil || !reflect.DeepEqual(copied.Attributes, replaced) {
+		t.Fatalf("CopyFile() replacing the metadata = %+v, %v", copied, err)
+	}
+	if object := f.object("tenants/t1/dst"); object == nil || string(object.content) != "content" {
+		t.Fatalf("the upstream stored the copy %+v", object)
+	}
+	if _, err := a.CopyFile(ctx, "tenants/t1/missing", "tenants/t1/dst", false, models.CopyOptions{}); !errors.IsClass(err, errors.ClassNotFound) {
+		t.Fatalf("CopyFile() of a missing file failed with %v, want not found", err)
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	f, a := newFakeUpstream(t, nil)
+	ctx := context.Background()
+	path := "tenants/t1/file"
+	uploadID, err := a.CreateMultipartUpload(ctx, path, models.ObjectAttributes{ContentType: "text/plain"})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload() failed: %v", err)
+	}
+	var completed []models.CompletedPart
+	for i, content := range []string{"first part ", "second part"} {
+		part, err := a.UploadPart(ctx, path, uploadID, i+1, strings.NewReader(content), models.PutOptions{})
+		if err != nil {
+			t.Fatalf("UploadPart(%v) failed: %v", i+1, err)
+		}
+		if part.Size != int64(len(content)) {
+			t.Fatalf("UploadPart(%v) = %+v", i+1, part)
+		}
+		completed = append(completed, models.CompletedPart{PartNumber: part.PartNumber, ETag: part.ETag})
+	}
+	page, err := a.ListParts(ctx, path, uploadID, models.ListPartsOptions{MaxParts: 1})
+	if err != nil || len(page.Parts) != 1 || page.Parts[0].PartNumber != 1 || !page.IsTruncated {
+		t.Fatalf("ListParts() of a page of 1 = %+v, %v", page, err)
+	}
+	page, err = a.ListParts(ctx, path, uploadID, models.ListPartsOptions{PartNumberMarker: 1})
+	if err != nil || len(page.Parts) != 1 || page.Parts[0].PartNumber != 2 || page.IsTruncated {
+		t.Fatalf("ListParts() after part 1 = %+v, %v", page, err)
+	}
+	reversed := []models.CompletedPart{completed[1], completed[0]}
+	if _, err := a.CompleteMultipartUpload(ctx, path, uploadID, reversed, false, models.PutOptions{}); !errors.IsLabel(err, models.ErrLabelInvalidPartOrder) {
+		t.Fatalf("CompleteMultipartUpload() of parts out of order failed with %v, want an invalid part order", err)
+	}
+	metadata, err := a.CompleteMultipartUpload(ctx, path, uploadID, completed, false, models.PutOptions{})
+	if err != nil {
+		t.Fatalf("CompleteMultipartUpload() failed: %v", err)
+	}
+	if object := f.object(path); object == nil || string(object.content) != "first part second part" ||
+		metadata.ETag != object.etag || metadata.Attributes.ContentType != "text/plain" {
+		t.Fatalf("CompleteMultipartUpload() = %+v, the upstream stored %+v", metadata, object)
+	}
+	if _, err := a.ListParts(ctx, path, uploadID, models.ListPartsOptions{}); !errors.IsLabel(err, models.ErrLabelNoSuchUpload) {
+		t.Fatalf("ListParts() of a completed upload failed with %v, want no such upload", err)
+	}
+
+	uploadID, err = a.CreateMultipartUpload(ctx, path, models.ObjectAttributes{})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload() failed: %v", err)
+	}
+	if err := a.AbortMultipartUpload(ctx, path, uploadID); err != nil {
+		t.Fatalf("AbortMultipartUpload() failed: %v", err)
+	}
+	if _, err := a.UploadPart(ctx, path, uploadID, 1, strings.NewReader("part"), models.PutOptions{}); !errors.IsLabel(err, models.ErrLabelNoSuchUpload) {
+		t.Fatalf("UploadPart() of an aborted upload failed with %v, want no such upload", err)
+	}
+}
+
+func TestDeleteFiles(t *testing.T) {
+	f, a := newFakeUpstream(t, nil)
+	// more keys than a batch holds, a path listed twice, an invalid path and a key the upstream fails to delete
+	var paths []string
+	for i := 0; i < maxDeleteBatch+1; i++ {
+		path := fmt.Sprintf("tenants/t1/file-%04d", i)
+		f.put(path, "content", time.Now())
+		paths = append(paths, path)
+	}
+	f.put("tenants/t1/locked", "content", time.Now())
+	paths = append(paths, paths[0], "tenants/t1/../t2/file", "tenants/t1/locked", "tenants/t1/missing")
+	results := a.DeleteFiles(context.Background(), paths)
+	if len(results) != len(paths) {
+		t.Fatalf("DeleteFiles() returned %v results, want %v", len(results), len(paths))
+	}
+	if batches := f.count(http.MethodPost, "delete"); batches != 2 {
+		t.Fatalf("DeleteFiles() sent %v batches, want 2", batches)
+	}
+	for i, result := range results {
+		if result.Path != paths[i] {
+			t.Fatalf("result %v is of %v, want %v", i, result.Path, paths[i])
+		}
+		switch result.Path {
+		case "tenants/t1/../t2/file":
+			if !errors.IsLabel(result.Err, models.ErrLabelInvalidPath) {
+				t.Fatalf("DeleteFiles() of an invalid path failed with %v, want an invalid path", result.Err)
+			}
+		case "tenants/t1/locked":
+			if !errors.IsClass(result.Err, errors.ClassInternal) || f.object(result.Path) == nil {
+				t.Fatalf("DeleteFiles() of a locked file failed with %v", result.Err)
+			}
+		default:
+			if result.Err != nil || f.object(result.Path) != nil {
+				t.Fatalf("DeleteFiles() of %v failed: %v", result.Path, result.Err)
+			}
+		}
+	}
+
+	// a batch which fails altogether fails each of its paths
+	f.intercept = func(w http.ResponseWriter, r *http.Request, body []byte) bool {
+		writeError(w, http.StatusForbidden, "AccessDenied")
+		return true
+	}
+	for _, result := range a.DeleteFiles(context.Background(), []string{"tenants/t1/a", "tenants/t1/b"}) {
+		if !errors.IsClass(result.Err, errors.ClassInternal) {
+			t.Fatalf("DeleteFiles() of %v in a failed batch failed with %v", result.Path, result.Err)
+		}
+	}
+}
+
+func TestSweep(t *testing.T) {
+	f, a := newFakeUpstream(t, nil)
+	now := time.Now()
+	expired := now.Add(-2 * a.ttl)
+	f.put("tenants/t1/ag/tmp/expired", "expired", expired)
+	f.put("tenants/t1/ag/tmp/fresh", "fresh", now)
+	f.put("tenants/t1/ag/remote/persistent", "persistent", expired)
+	f.put("tenants/t1/ag/tmp/overwritten", "old", expired)
+	f.put("tenants/t1/ag/tmp/changed", "old", expired)
+	f.uploads["stale"] = &fakeUpload{key: "tenants/t1/ag/tmp/upload", parts: map[int][]byte{}, initiated: expired}
+	f.uploads["active"] = &fakeUpload{key: "tenants/t1/ag/tmp/upload", parts: map[int][]byte{}, initiated: now}
+	f.intercept = func(w http.ResponseWriter, r *http.Request, body []byte) bool {
+		switch {
+		// written again between the listing and the check
+		case r.Method == http.MethodHead && strings.HasSuffix(r.URL.Path, "/overwritten"):
+			f.put("tenants/t1/ag/tmp/overwritten", "new", now)
+		// written again between the check and the delete
+		case r.Method == http.MethodDelete && strings.HasSuffix(r.URL.Path, "/changed"):
+			f.put("tenants/t1/ag/tmp/changed", "new", expired)
+		}
+		return false
+	}
+	a.sweep(context.Background(), now)
+	for key, removed := range map[string]bool{
+		"tenants/t1/ag/tmp/expired":       true,
+		"tenants/t1/ag/tmp/fresh":         false,
+		"tenants/t1/ag/remote/persistent": false,
+		"tenants/t1/ag/tmp/overwritten":   false,
+		"tenants/t1/ag/tmp/changed":       false,
+	} {
+		if (f.object(key) == nil) != removed {
+			t.Errorf("sweep() removed %v: %v, want %v", key, f.object(key) == nil, removed)
+		}
+	}
+	if _, ok := f.uploads["stale"]; ok {
+		t.Error("sweep() kept a stale multipart upload")
+	}
+	if _, ok := f.uploads["active"]; !ok {
+		t.Error("sweep() aborted an active multipart upload")
+	}
+}
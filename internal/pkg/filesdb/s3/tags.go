@@ -0,0 +1,95 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+
+	"openappsec.io/log"
+	"openappsec.io/smartsync-shared-files/internal/models"
+	"openappsec.io/smartsync-shared-files/internal/pkg/filesdb/common"
+)
+
+type tag struct {
+	Key   string
+	Value string
+}
+
+type tagSet struct {
+	Tags []tag `xml:"Tag"`
+}
+
+type tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	TagSet  tagSet
+}
+
+// getTags returns the tags of a file, or of one of its versions when versionID is set
+func (a *Adapter) getTags(ctx context.Context, path string, versionID string) (map[string]string, error) {
+	query := versionQuery(versionID)
+	query.Set("tagging", "")
+	var result tagging
+	if _, err := a.client.doXML(ctx, request{method: http.MethodGet, key: a.upstreamKey(path), query: query}, &result); err != nil {
+		return nil, err
+	}
+	if len(result.TagSet.Tags) == 0 {
+		return nil, nil
+	}
+	tags := make(map[string]string, len(result.TagSet.Tags))
+	for _, t := range result.TagSet.Tags {
+		tags[t.Key] = t.Value
+	}
+	return tags, nil
+}
+
+// SetFileTags replaces the tags of a file, or of one of its versions when versionID is set, nil tags remove them
+func (a *Adapter) SetFileTags(ctx context.Context, path string, versionID string, tags map[string]string) (models.FileMetadata, error) {
+	log.WithContext(ctx).Debugf("set tags of file: %v, version: %v, tags: %v", path, versionID, tags)
+	if err := common.ValidateKey(path); err != nil {
+		return models.FileMetadata{}, err
+	}
+	query := versionQuery(versionID)
+	query.Set("tagging", "")
+	req := request{method: http.MethodDelete, key: a.upstreamKey(path), query: query}
+	if tags != nil {
+		body := tagging{TagSet: tagSet{Tags: make([]tag, 0, len(tags))}}
+		for key, value := range tags {
+			body.TagSet.Tags = append(body.TagSet.Tags, tag{Key: key, Value: value})
+		}
+		data, err := xml.Marshal(body)
+		if err != nil {
+			return models.FileMetadata{}, err
+		}
+		md5Sum, sha256Sum := md5.Sum(data), sha256.Sum256(data)
+		req.method = http.MethodPut
+		req.header = http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(md5Sum[:])}}
+		req.body = bytes.NewReader(data)
+		req.size = int64(len(data))
+		req.payloadHash = hex.EncodeToString(sha256Sum[:])
+	}
+	resp, err := a.client.do(ctx, req)
+	if err != nil {
+		log.WithContext(ctx).Warnf("failed to set tags of file %v. err: %v", path, err)
+		return models.FileMetadata{}, err
+	}
+	resp.Body.Close()
+	return a.headObject(ctx, path, versionID)
+}
@@ -0,0 +1,498 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"openappsec.io/smartsync-shared-files/internal/pkg/sigv4"
+	"openappsec.io/smartsync-shared-files/internal/pkg/testutil"
+)
+
+const (
+	testBucket      = "bucket"
+	testAccessKeyID = "AKIDEXAMPLE"
+)
+
+type fakeObject struct {
+	content      []byte
+	etag         string
+	lastModified time.Time
+	// header holds the attributes and the checksum the object was stored with
+	header http.Header
+}
+
+type fakeUpload struct {
+	key       string
+	header    http.Header
+	parts     map[int][]byte
+	initiated time.Time
+}
+
+// fakeUpstream is an S3 compatible bucket held in memory, it implements the requests the adapter sends
+type fakeUpstream struct {
+	*httptest.Server
+	mu      sync.Mutex
+	objects map[string]*fakeObject
+	uploads map[string]*fakeUpload
+	// maxKeys caps the number of keys listed in a page, so the listings are paged
+	maxKeys int
+	// requests counts the requests received, by method and query, such as "GET ?list-type"
+	requests map[string]int
+	nextID   int
+	// intercept, when set, may respond to a request before the bucket does, it returns true when it did
+	intercept func(w http.ResponseWriter, r *http.Request, body []byte) bool
+}
+
+// newFakeUpstream starts a fake upstream, and an adapter sending its requests to it
+func newFakeUpstream(t *testing.T, conf testutil.Configuration) (*fakeUpstream, *Adapter) {
+	f := &fakeUpstream{
+		objects:  make(map[string]*fakeObject),
+		uploads:  make(map[string]*fakeUpload),
+		maxKeys:  maxUpstreamKeys,
+		requests: make(map[string]int),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	base := testutil.Configuration{
+		s3ConfigEndpoint:        f.URL,
+		s3ConfigBucket:          testBucket,
+		s3ConfigAccessKeyID:     testAccessKeyID,
+		s3ConfigSecretAccessKey: "secret",
+		s3ConfigRetryBackoff:    time.Millisecond,
+		s3ConfigSpoolDir:        t.TempDir(),
+		s3ConfigSweepEnabled:    false,
+		fsConfigTTL:             time.Hour,
+	}
+	for key, value := range conf {
+		base[key] = value
+	}
+	a, err := NewAdapter(base)
+	if err != nil {
+		t.Fatalf("NewAdapter() failed: %v", err)
+	}
+	t.Cleanup(func() { a.TearDown(context.Background()) })
+	return f, a
+}
+
+// put stores an object in the bucket directly
+func (f *fakeUpstream) put(key string, content string, lastModified time.Time) *fakeObject {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sum := md5.Sum([]byte(content))
+	object := &fakeObject{
+		content:      []byte(content),
+		etag:         "\"" + hex.EncodeToString(sum[:]) + "\"",
+		lastModified: lastModified.UTC().Truncate(time.Second),
+		header:       http.Header{},
+	}
+	f.objects[key] = object
+	return object
+}
+
+// object returns an object of the bucket, nil when it is missing
+func (f *fakeUpstream) object(key string) *fakeObject {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.objects[key]
+}
+
+// count returns the number of requests received with a method and a query parameter, any when it is empty
+func (f *fakeUpstream) count(method string, param string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[method+" ?"+param]
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	data, _ := xml.Marshal(s3Error{Code: code, Message: code})
+	w.Write(data)
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "InternalError")
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(data)
+}
+
+// etagMatches is true when an If-Match header lists the entity tag
+func etagMatches(ifMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		if candidate = strings.TrimSpace(candidate); candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeUpstream) serve(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	query := r.URL.Query()
+	f.mu.Lock()
+	params := make([]string, 0, len(query))
+	for param := range query {
+		params = append(params, param)
+	}
+	f.requests[r.Method+" ?"]++
+	for _, param := range params {
+		f.requests[r.Method+" ?"+param]++
+	}
+	f.mu.Unlock()
+	if f.intercept != nil && f.intercept(w, r, body) {
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), sigv4.Algorithm+" Credential="+testAccessKeyID+"/") {
+		writeError(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	// the body of a retried request must be sent again from its start
+	if payloadHash := r.Header.Get("X-Amz-Content-Sha256"); payloadHash != sigv4.UnsignedPayload {
+		if sum := sha256.Sum256(body); payloadHash != hex.EncodeToString(sum[:]) {
+			writeError(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch")
+			return
+		}
+	}
+	if contentMD5 := r.Header.Get("Content-MD5"); contentMD5 != "" {
+		if sum := md5.Sum(body); contentMD5 != base64.StdEncoding.EncodeToString(sum[:]) {
+			writeError(w, http.StatusBadRequest, "BadDigest")
+			return
+		}
+	}
+	if !strings.HasPrefix(r.URL.Path, "/"+testBucket+"/") {
+		writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/"+testBucket+"/")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case key == "" && r.Method == http.MethodGet && query.Has("uploads"):
+		f.listUploads(w, query)
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, query)
+	case key == "" && r.Method == http.MethodPost && query.Has("delete"):
+		f.deleteObjects(w, body)
+	case query.Has("tagging") && r.Method == http.MethodGet:
+		if f.objects[key] == nil {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		writeXML(w, tagging{})
+	case query.Has("uploads") && r.Method == http.MethodPost:
+		f.nextID++
+		uploadID := "upload-" + strconv.Itoa(f.nextID)
+		f.uploads[uploadID] = &fakeUpload{key: key, header: r.Header, parts: make(map[int][]byte), initiated: time.Now()}
+		writeXML(w, initiateMultipartUploadResult{UploadID: uploadID})
+	case query.Has("uploadId"):
+		f.serveUpload(w, r, key, body)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		f.getObject(w, r, key)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		f.copyObject(w, r, key)
+	case r.Method == http.MethodPut:
+		object := f.objects[key]
+		if r.Header.Get("If-None-Match") == "*" && object != nil {
+			writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && (object == nil || !etagMatches(ifMatch, object.etag)) {
+			writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		w.Header().Set("ETag", f.store(key, body, r.Header).etag)
+	case r.Method == http.MethodDelete:
+		object := f.objects[key]
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && object != nil && !etagMatches(ifMatch, object.etag) {
+			writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// store stores an object with the attributes of the headers it was sent with
+func (f *fakeUpstream) store(key string, content []byte, header http.Header) *fakeObject {
+	sum := md5.Sum(content)
+	object := &fakeObject{
+		content:      content,
+		etag:         "\"" + hex.EncodeToString(sum[:]) + "\"",
+		lastModified: time.Now().UTC().Truncate(time.Second),
+		header:       http.Header{},
+	}
+	for name, values := range header {
+		if name == "Content-Type" || name == "Cache-Control" || name == "X-Amz-Checksum-Sha256" ||
+			strings.HasPrefix(name, metadataHeaderPrefix) {
+			object.header[name] = values
+		}
+	}
+	f.objects[key] = object
+	return object
+}
+
+func (f *fakeUpstream) getObject(w http.ResponseWriter, r *http.Request, key string) {
+	object := f.objects[key]
+	if object == nil {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	for name, values := range object.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("ETag", object.etag)
+	w.Header().Set("Last-Modified", object.lastModified.Format(http.TimeFormat))
+	content := object.content
+	status := http.StatusOK
+	if byteRange := r.Header.Get("Range"); byteRange != "" {
+		var start, end int
+		if _, err := fmt.Sscanf(byteRange, "bytes=%d-%d", &start, &end); err != nil || start > end || end >= len(content) {
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		content = content[start : end+1]
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		w.Write(content)
+	}
+}
+
+func (f *fakeUpstream) copyObject(w http.ResponseWriter, r *http.Request, key string) {
+	source, err := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil || !strings.HasPrefix(source, "/"+testBucket+"/") {
+		writeError(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+	object := f.objects[strings.TrimPrefix(source, "/"+testBucket+"/")]
+	if object == nil {
+		writeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	header := object.header
+	if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+		header = r.Header
+	}
+	copied := f.store(key, object.content, header)
+	copied.header.Set("X-Amz-Checksum-Sha256", object.header.Get("X-Amz-Checksum-Sha256"))
+	writeXML(w, copyObjectResult{ETag: copied.etag, LastModified: copied.lastModified})
+}
+
+// list lists the keys of the bucket as ListObjectsV2 does, the continuation token is the last listed key or
+// common prefix
+func (f *fakeUpstream) list(w http.ResponseWriter, query url.Values) {
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	after := query.Get("start-after")
+	if token := query.Get("continuation-token"); token != "" {
+		decoded, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidArgument")
+			return
+		}
+		after = string(decoded)
+	}
+	maxKeys, err := strconv.Atoi(query.Get("max-keys"))
+	if err != nil || maxKeys > f.maxKeys {
+		maxKeys = f.maxKeys
+	}
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var result listBucketResult
+	last := ""
+	for _, key := range keys {
+		commonPrefix := ""
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				commonPrefix = key[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		if commonPrefix != "" && commonPrefix == last {
+			continue
+		}
+		if len(result.Contents)+len(result.CommonPrefixes) == maxKeys {
+			result.IsTruncated = true
+			// the keys rolled up into the last common prefix are skipped by the next page
+			token := last
+			if strings.HasSuffix(token, delimiter) && delimiter != "" {
+				token += "\xff"
+			}
+			result.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(token))
+			break
+		}
+		if commonPrefix != "" {
+			result.CommonPrefixes = append(result.CommonPrefixes, listedPrefix{Prefix: commonPrefix})
+			last = commonPrefix
+			continue
+		}
+		object := f.objects[key]
+		result.Contents = append(result.Contents, listedObject{
+			Key: key, LastModified: object.lastModified, ETag: object.etag, Size: int64(len(object.content)),
+		})
+		last = key
+	}
+	writeXML(w, result)
+}
+
+func (f *fakeUpstream) deleteObjects(w http.ResponseWriter, body []byte) {
+	var request deleteObjects
+	if err := xml.Unmarshal(body, &request); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	if len(request.Objects) > maxDeleteBatch {
+		writeError(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	var result deleteResult
+	for _, object := range request.Objects {
+		// locked keys fail, so the failures of a batch can be tested
+		if strings.HasSuffix(object.Key, "/locked") {
+			result.Errors = append(result.Errors, struct {
+				Key     string
+				Code    string
+				Message string
+			}{Key: object.Key, Code: "AccessDenied", Message: "Access Denied"})
+			continue
+		}
+		delete(f.objects, object.Key)
+	}
+	writeXML(w, result)
+}
+
+func (f *fakeUpstream) serveUpload(w http.ResponseWriter, r *http.Request, key string, body []byte) {
+	uploadID := r.URL.Query().Get("uploadId")
+	upload := f.uploads[uploadID]
+	if upload == nil || upload.key != key {
+		writeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		partNumber, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+		if err != nil || partNumber < 1 {
+			writeError(w, http.StatusBadRequest, "InvalidArgument")
+			return
+		}
+		upload.parts[partNumber] = body
+		sum := md5.Sum(body)
+		w.Header().Set("ETag", "\""+hex.EncodeToString(sum[:])+"\"")
+	case http.MethodGet:
+		marker, _ := strconv.Atoi(r.URL.Query().Get("part-number-marker"))
+		maxParts, err := strconv.Atoi(r.URL.Query().Get("max-parts"))
+		if err != nil {
+			maxParts = maxUpstreamKeys
+		}
+		numbers := make([]int, 0, len(upload.parts))
+		for number := range upload.parts {
+			if number > marker {
+				numbers = append(numbers, number)
+			}
+		}
+		sort.Ints(numbers)
+		var result listPartsResult
+		for _, number := range numbers {
+			if len(result.Parts) == maxParts {
+				result.IsTruncated = true
+				break
+			}
+			sum := md5.Sum(upload.parts[number])
+			result.Parts = append(result.Parts, listedPart{
+				PartNumber: number, Size: int64(len(upload.parts[number])), ETag: "\"" + hex.EncodeToString(sum[:]) + "\"",
+			})
+			result.NextPartNumberMarker = number
+		}
+		writeXML(w, result)
+	case http.MethodPost:
+		var request completeMultipartUpload
+		if err := xml.Unmarshal(body, &request); err != nil || len(request.Parts) == 0 {
+			writeError(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		var content bytes.Buffer
+		for i, part := range request.Parts {
+			data, ok := upload.parts[part.PartNumber]
+			sum := md5.Sum(data)
+			if !ok || part.ETag != "\""+hex.EncodeToString(sum[:])+"\"" {
+				writeError(w, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			if i > 0 && part.PartNumber <= request.Parts[i-1].PartNumber {
+				writeError(w, http.StatusBadRequest, "InvalidPartOrder")
+				return
+			}
+			content.Write(data)
+		}
+		if object := f.objects[key]; r.Header.Get("If-None-Match") == "*" && object != nil {
+			writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		delete(f.uploads, uploadID)
+		object := f.store(key, content.Bytes(), upload.header)
+		writeXML(w, completeMultipartUploadResult{ETag: object.etag})
+	case http.MethodDelete:
+		delete(f.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeUpstream) listUploads(w http.ResponseWriter, query url.Values) {
+	var result listMultipartUploadsResult
+	for uploadID, upload := range f.uploads {
+		if strings.HasPrefix(upload.key, query.Get("prefix")) {
+			result.Uploads = append(result.Uploads, listedUpload{Key: upload.key, UploadID: uploadID, Initiated: upload.initiated})
+		}
+	}
+	sort.Slice(result.Uploads, func(i, j int) bool { return result.Uploads[i].UploadID < result.Uploads[j].UploadID })
+	writeXML(w, result)
+}
@@ -0,0 +1,157 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Algorithm is the signing algorithm of AWS Signature Version 4
+	Algorithm = "AWS4-HMAC-SHA256"
+	// DateFormat is the format of the time a request is signed at
+	DateFormat = "20060102T150405Z"
+	// UnsignedPayload is the payload hash of a request whose payload isn't signed
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+	terminal = "aws4_request"
+)
+
+// Scope returns the credential scope of a signature made on date, formatted as yyyymmdd
+func Scope(date string, region string, service string) string {
+	return date + "/" + region + "/" + service + "/" + terminal
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Signature computes the signature of a canonical request signed at amzDate with secret
+func Signature(secret string, amzDate string, region string, service string, canonicalRequest string) string {
+	date := amzDate[:8]
+	digest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := Algorithm + "\n" + amzDate + "\n" + Scope(date, region, service) + "\n" + hex.EncodeToString(digest[:])
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, terminal)
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// CanonicalRequest returns the canonical form of the request the signature is computed over
+func CanonicalRequest(r *http.Request, signedHeaders []string, payloadHash string) string {
+	var b strings.Builder
+	b.WriteString(r.Method + "\n")
+	path := r.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	b.WriteString(URIEncode(path, false) + "\n")
+	b.WriteString(CanonicalQuery(r.URL.Query()) + "\n")
+	for _, name := range signedHeaders {
+		b.WriteString(name + ":" + canonicalHeaderValue(r, name) + "\n")
+	}
+	b.WriteString("\n" + strings.Join(signedHeaders, ";") + "\n")
+	b.WriteString(payloadHash)
+	return b.String()
+}
+
+// CanonicalQuery returns the query parameters sorted and encoded, without the signature of a presigned request
+func CanonicalQuery(query url.Values) string {
+	var params []string
+	for name, values := range query {
+		if name == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range values {
+			params = append(params, URIEncode(name, true)+"="+URIEncode(value, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// canonicalHeaderValue returns the values of a header trimmed, with their inner spaces collapsed
+func canonicalHeaderValue(r *http.Request, name string) string {
+	var values []string
+	switch name {
+	case "host":
+		host := r.Host
+		if host == "" {
+			host = r.URL.Host
+		}
+		values = []string{host}
+	case "content-length":
+		values = r.Header.Values(name)
+		if len(values) == 0 && r.ContentLength >= 0 {
+			values = []string{strconv.FormatInt(r.ContentLength, 10)}
+		}
+	default:
+		values = r.Header.Values(name)
+	}
+	canonical := make([]string, len(values))
+	for i, value := range values {
+		canonical[i] = strings.Join(strings.Fields(value), " ")
+	}
+	return strings.Join(canonical, ",")
+}
+
+// URIEncode encodes every byte but the unreserved characters, as SigV4 defines it
+func URIEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+	return b.String()
+}
+
+// Sign signs a request with the Authorization header, along with the host, the content headers and the x-amz-*
+// headers it has. payloadHash is the hex SHA-256 digest of the body, or UnsignedPayload
+func Sign(r *http.Request, accessKeyID string, secret string, region string, service string, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(DateFormat)
+	r.Header.Set("X-Amz-Date", amzDate)
+	r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signedHeaders := []string{"host"}
+	for name := range r.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-md5" || name == "content-type" {
+			signedHeaders = append(signedHeaders, name)
+		}
+	}
+	sort.Strings(signedHeaders)
+	signature := Signature(secret, amzDate, region, service, CanonicalRequest(r, signedHeaders, payloadHash))
+	r.Header.Set("Authorization", Algorithm+" Credential="+accessKeyID+"/"+Scope(amzDate[:8], region, service)+
+		", SignedHeaders="+strings.Join(signedHeaders, ";")+", Signature="+signature)
+}
@@ -0,0 +1,68 @@
+// Copyright (C) 2022 Check Point Software Technologies Ltd. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"time"
+
+	"openappsec.io/errors"
+)
+
+// Configuration is a configuration held in a map, for the tests of the packages configured by key.
+// as with the configuration service, a missing key isn't found
+type Configuration map[string]interface{}
+
+func (c Configuration) get(key string) (interface{}, error) {
+	value, ok := c[key]
+	if !ok {
+		return nil, errors.Errorf("key %v not found", key).SetClass(errors.ClassNotFound)
+	}
+	return value, nil
+}
+
+// GetString returns the string value of key
+func (c Configuration) GetString(key string) (string, error) {
+	value, err := c.get(key)
+	if err != nil {
+		return "", err
+	}
+	return value.(string), nil
+}
+
+// GetDuration returns the duration value of key
+func (c Configuration) GetDuration(key string) (time.Duration, error) {
+	value, err := c.get(key)
+	if err != nil {
+		return 0, err
+	}
+	return value.(time.Duration), nil
+}
+
+// GetBool returns the boolean value of key
+func (c Configuration) GetBool(key string) (bool, error) {
+	value, err := c.get(key)
+	if err != nil {
+		return false, err
+	}
+	return value.(bool), nil
+}
+
+// GetInt returns the integer value of key
+func (c Configuration) GetInt(key string) (int, error) {
+	value, err := c.get(key)
+	if err != nil {
+		return 0, err
+	}
+	return value.(int), nil
+}